@@ -0,0 +1,190 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Message is what a Broker carries. Key picks the partition on brokers that
+// partition, like Kafka, and is ignored by NATS.
+type Message struct {
+	Key     string
+	Headers map[string]string
+	Data    []byte
+}
+
+// MessageHandler processes a message a Broker delivered. ctx carries the
+// message's trace headers.
+type MessageHandler func(ctx context.Context, message Message) error
+
+// Unsubscriber ends a Broker subscription
+type Unsubscriber interface {
+	Unsubscribe() error
+}
+
+// Broker publishes and subscribes the same way whichever broker runs
+// underneath, so a service can be pointed at NATS, Kafka or both by
+// configuration. A topic is a NATS subject or a Kafka topic, and the
+// subject naming of Subject suits both. Both put topics under
+// MESSAGING_SUBJECT_PREFIX.
+type Broker interface {
+	// Publish sends a message to topic, with the trace headers of ctx
+	Publish(ctx context.Context, topic string, message Message) error
+	// Subscribe hands the messages of topic to handler. Subscribers sharing
+	// a group split the messages between them.
+	Subscribe(topic, group string, handler MessageHandler) (Unsubscriber, error)
+	Close() error
+}
+
+// NewBrokerFromEnv returns the brokers MESSAGING_BROKERS names, "nats" by
+// default. "nats,kafka" mirrors every message to both; subscriptions use
+// the first one.
+func NewBrokerFromEnv() (Broker, error) {
+	names := splitList(getEnv("MESSAGING_BROKERS", "nats"))
+	if len(names) == 0 {
+		return nil, fmt.Errorf("MESSAGING_BROKERS names no broker")
+	}
+
+	var brokers []Broker
+	for _, name := range names {
+		broker, err := newBroker(name)
+		if err != nil {
+			for _, opened := range brokers {
+				opened.Close()
+			}
+			return nil, err
+		}
+		brokers = append(brokers, broker)
+	}
+
+	if len(brokers) == 1 {
+		return brokers[0], nil
+	}
+	return &mirroredBroker{brokers: brokers}, nil
+}
+
+func newBroker(name string) (Broker, error) {
+	switch strings.ToLower(name) {
+	case "nats":
+		if err := ConnectNats(); err != nil {
+			return nil, err
+		}
+		return natsBroker{}, nil
+	case "kafka":
+		config, err := KafkaConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewKafkaBroker(config)
+	default:
+		return nil, fmt.Errorf("unknown broker %q in MESSAGING_BROKERS", name)
+	}
+}
+
+// messageHeaders are the headers of a published message: its own, plus the
+// trace of ctx unless it carries one already
+func messageHeaders(ctx context.Context, message Message) nats.Header {
+	header := nats.Header{}
+	for name, value := range message.Headers {
+		header.Set(name, value)
+	}
+	if header.Get(traceparentHeader) == "" {
+		for name, values := range outgoingTraceHeaders(ctx) {
+			header[name] = values
+		}
+	}
+	return header
+}
+
+// natsBroker is a Broker on the shared NATS connection, see ConnectNats
+type natsBroker struct{}
+
+func (natsBroker) Publish(ctx context.Context, topic string, message Message) error {
+	if nc == nil || !nc.IsConnected() {
+		log.Println("❌ NATS connection is closed or not established.")
+		return nats.ErrConnectionClosed
+	}
+
+	msg := nats.NewMsg(prefixSubject(subjectPrefix, topic))
+	msg.Data = message.Data
+	msg.Header = messageHeaders(ctx, message)
+	if err := nc.PublishMsg(msg); err != nil {
+		log.Printf("❌ Failed to publish message to %s: %v", topic, err)
+		return err
+	}
+	return nil
+}
+
+func (natsBroker) Subscribe(topic, group string, handler MessageHandler) (Unsubscriber, error) {
+	if nc == nil || !nc.IsConnected() {
+		log.Println("❌ NATS connection is closed or not established.")
+		return nil, nats.ErrConnectionClosed
+	}
+
+	callback := func(msg *nats.Msg) {
+		message := Message{Headers: make(map[string]string, len(msg.Header)), Data: msg.Data}
+		for name := range msg.Header {
+			message.Headers[name] = msg.Header.Get(name)
+		}
+
+		ctx := ContextWithTraceHeaders(context.Background(), msg.Header)
+		if err := handler(ctx, message); err != nil {
+			log.Printf("❌ Failed to handle message on %s (trace %s): %v", msg.Subject, TraceID(ctx), err)
+		}
+	}
+
+	return subscribe(topic, group, callback)
+}
+
+func (natsBroker) Close() error {
+	CloseNats()
+	return nil
+}
+
+// mirroredBroker publishes to several brokers, so consumers on either see
+// every message, and subscribes on the first
+type mirroredBroker struct {
+	brokers []Broker
+}
+
+func (m *mirroredBroker) Publish(ctx context.Context, topic string, message Message) error {
+	// One trace for every copy, so the consumers on each broker join it
+	if _, ok := message.Headers[traceparentHeader]; !ok {
+		headers := make(map[string]string, len(message.Headers)+2)
+		for name, value := range message.Headers {
+			headers[name] = value
+		}
+		trace := outgoingTraceHeaders(ctx)
+		for name := range trace {
+			headers[name] = trace.Get(name)
+		}
+		message.Headers = headers
+	}
+
+	var errs []error
+	for _, broker := range m.brokers {
+		if err := broker.Publish(ctx, topic, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *mirroredBroker) Subscribe(topic, group string, handler MessageHandler) (Unsubscriber, error) {
+	return m.brokers[0].Subscribe(topic, group, handler)
+}
+
+func (m *mirroredBroker) Close() error {
+	var errs []error
+	for _, broker := range m.brokers {
+		if err := broker.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -2,33 +2,59 @@ package messaging
 
 import (
 	"log"
+	"strings"
+
 	"github.com/nats-io/nats.go"
 )
 
 var nc *nats.Conn
 
-// ConnectNats establishes a NATS connection and keeps it open for future use.
+// subjectPrefix is the SubjectPrefix of the connection's configuration
+var subjectPrefix string
+
+// ConnectNats establishes a NATS connection, configured by the NATS_*
+// environment variables (see ConfigFromEnv), and keeps it open for future use.
 func ConnectNats() error {
-	var err error
+	config, err := ConfigFromEnv()
+	if err != nil {
+		log.Println("❌ Invalid NATS configuration:", err)
+		return err
+	}
+	return ConnectNatsWithConfig(config)
+}
+
+// ConnectNatsWithConfig establishes a NATS connection as config describes and
+// keeps it open for future use.
+func ConnectNatsWithConfig(config Config) error {
 	// Check if the connection is already established
 	if nc != nil && nc.IsConnected() {
 		log.Println("✅ NATS already connected.")
 		return nil
 	}
 
-	// Establish the NATS connection
-	nc, err = nats.Connect(nats.DefaultURL)
+	options, err := config.Options()
+	if err != nil {
+		log.Println("❌ Invalid NATS configuration:", err)
+		return err
+	}
+
+	// Establish the NATS connection, to any server of the cluster
+	nc, err = nats.Connect(strings.Join(config.URLs, ","), options...)
 	if err != nil {
 		log.Fatal("❌ Failed to connect to NATS:", err)
 		return err
 	}
 
-	log.Println("✅ Connected to NATS.")
+	subjectPrefix = config.SubjectPrefix
+	startWatchdog(config.WatchdogInterval)
+	checkSubscriptions() // Subscriptions made on a previous connection
+	log.Printf("✅ Connected to NATS at %s.", nc.ConnectedUrl())
 	return nil
 }
 
 // CloseNats closes the NATS connection gracefully.
 func CloseNats() {
+	stopWatchdog()
 	if nc != nil && nc.IsConnected() {
 		nc.Close()
 		log.Println("✅ NATS connection closed.")
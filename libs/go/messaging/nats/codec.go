@@ -0,0 +1,66 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec turns message values into payloads and back. The content type it
+// names travels in the Content-Type header, so a subscriber decodes a
+// message the way it was encoded.
+type Codec interface {
+	ContentType() string
+	Encode(value any) ([]byte, error)
+	Decode(data []byte, value any) error
+}
+
+// JSONCodec encodes values as JSON, the default for every service
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return "application/json" }
+
+func (JSONCodec) Encode(value any) ([]byte, error) { return json.Marshal(value) }
+
+func (JSONCodec) Decode(data []byte, value any) error { return json.Unmarshal(data, value) }
+
+// ProtoCodec encodes protobuf messages in their binary wire format. Values
+// have to be proto.Message, a pointer to a generated message type.
+type ProtoCodec struct{}
+
+func (ProtoCodec) ContentType() string { return "application/protobuf" }
+
+func (ProtoCodec) Encode(value any) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protobuf message", value)
+	}
+	return proto.Marshal(message)
+}
+
+func (ProtoCodec) Decode(data []byte, value any) error {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a protobuf message", value)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// codecs are the codecs a subscriber picks from by the Content-Type header
+var codecs = map[string]Codec{
+	JSONCodec{}.ContentType():  JSONCodec{},
+	ProtoCodec{}.ContentType(): ProtoCodec{},
+}
+
+// codecFor returns the codec of a content type, JSON when none is given
+func codecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSONCodec{}, nil
+	}
+	codec, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	return codec, nil
+}
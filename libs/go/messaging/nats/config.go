@@ -0,0 +1,217 @@
+package messaging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Config describes how to reach NATS. ConfigFromEnv fills it from the
+// environment, so every service configures its connection the same way.
+type Config struct {
+	URLs          []string // Servers of the cluster, tried in turn
+	Name          string   // Client name the server shows in its monitoring
+	User          string   // User and password, when the server uses them
+	Password      string
+	Token         string // Auth token, when the server uses one
+	NKeySeedFile  string // File holding an NKey seed to sign the server's nonce with
+	CredsFile     string // Decentralized auth: a .creds file with the user JWT and seed
+	TLSCAFile     string // CA that signed the server's certificate
+	TLSCertFile   string // Client certificate, for servers verifying clients
+	TLSKeyFile    string
+	TLSRequired   bool          // Refuse plain connections even without TLS files
+	ConnectWait   time.Duration // Timeout of each connection attempt
+	ReconnectWait time.Duration // Wait between reconnect attempts
+	MaxReconnects int           // Attempts before giving up, -1 for no limit
+	// WatchdogInterval is how often subscriptions are checked and made
+	// again when they stopped consuming, 0 to check after reconnects only
+	WatchdogInterval time.Duration
+	// SubjectPrefix namespaces every subject published to and subscribed
+	// on, as in staging or prod.acme, so environments and tenants can share
+	// a cluster
+	SubjectPrefix string
+}
+
+// ConfigFromEnv reads the NATS_* variables and MESSAGING_SUBJECT_PREFIX.
+// NATS_URL may list the servers of a cluster separated by commas, and
+// defaults to nats://localhost:4222.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		URLs:             splitList(getEnv("NATS_URL", nats.DefaultURL)),
+		Name:             getEnv("NATS_CLIENT_NAME", ""),
+		User:             os.Getenv("NATS_USER"),
+		Password:         os.Getenv("NATS_PASSWORD"),
+		Token:            os.Getenv("NATS_TOKEN"),
+		NKeySeedFile:     os.Getenv("NATS_NKEY_SEED_FILE"),
+		CredsFile:        os.Getenv("NATS_CREDS_FILE"),
+		TLSCAFile:        os.Getenv("NATS_TLS_CA_FILE"),
+		TLSCertFile:      os.Getenv("NATS_TLS_CERT_FILE"),
+		TLSKeyFile:       os.Getenv("NATS_TLS_KEY_FILE"),
+		ConnectWait:      nats.DefaultTimeout,
+		ReconnectWait:    nats.DefaultReconnectWait,
+		MaxReconnects:    -1,
+		WatchdogInterval: 30 * time.Second,
+		SubjectPrefix:    os.Getenv("MESSAGING_SUBJECT_PREFIX"),
+	}
+
+	var err error
+	if config.TLSRequired, err = getEnvBool("NATS_TLS_REQUIRED", false); err != nil {
+		return Config{}, err
+	}
+	if config.ConnectWait, err = getEnvDuration("NATS_CONNECT_TIMEOUT", config.ConnectWait); err != nil {
+		return Config{}, err
+	}
+	if config.ReconnectWait, err = getEnvDuration("NATS_RECONNECT_WAIT", config.ReconnectWait); err != nil {
+		return Config{}, err
+	}
+	if config.MaxReconnects, err = getEnvInt("NATS_MAX_RECONNECTS", config.MaxReconnects); err != nil {
+		return Config{}, err
+	}
+	if config.WatchdogInterval, err = getEnvDuration("NATS_WATCHDOG_INTERVAL", config.WatchdogInterval); err != nil {
+		return Config{}, err
+	}
+	return config, config.Validate()
+}
+
+// Validate rejects configurations that mix authentication methods, name
+// half a client certificate or have an invalid subject prefix
+func (c Config) Validate() error {
+	if len(c.URLs) == 0 {
+		return fmt.Errorf("NATS_URL names no servers")
+	}
+	methods := 0
+	for _, set := range []bool{c.User != "" || c.Password != "", c.Token != "", c.NKeySeedFile != "", c.CredsFile != ""} {
+		if set {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return fmt.Errorf("use one of NATS_USER/NATS_PASSWORD, NATS_TOKEN, NATS_NKEY_SEED_FILE and NATS_CREDS_FILE")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("NATS_TLS_CERT_FILE and NATS_TLS_KEY_FILE go together")
+	}
+	if c.SubjectPrefix != "" {
+		if err := validSubject(c.SubjectPrefix); err != nil {
+			return fmt.Errorf("invalid MESSAGING_SUBJECT_PREFIX: %w", err)
+		}
+	}
+	return nil
+}
+
+// Options turns the configuration into options for nats.Connect
+func (c Config) Options() ([]nats.Option, error) {
+	options := []nats.Option{
+		nats.Timeout(c.ConnectWait),
+		nats.ReconnectWait(c.ReconnectWait),
+		nats.MaxReconnects(c.MaxReconnects),
+	}
+	if c.Name != "" {
+		options = append(options, nats.Name(c.Name))
+	}
+
+	switch {
+	case c.User != "":
+		options = append(options, nats.UserInfo(c.User, c.Password))
+	case c.Token != "":
+		options = append(options, nats.Token(c.Token))
+	case c.NKeySeedFile != "":
+		option, err := nats.NkeyOptionFromSeed(c.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load NATS_NKEY_SEED_FILE: %w", err)
+		}
+		options = append(options, option)
+	case c.CredsFile != "":
+		options = append(options, nats.UserCredentials(c.CredsFile))
+	}
+
+	if c.TLSRequired {
+		options = append(options, nats.Secure())
+	}
+	if c.TLSCAFile != "" {
+		options = append(options, nats.RootCAs(c.TLSCAFile))
+	}
+	if c.TLSCertFile != "" {
+		options = append(options, nats.ClientCert(c.TLSCertFile, c.TLSKeyFile))
+	}
+
+	options = append(options,
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("⚠️ Disconnected from NATS: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Printf("✅ Reconnected to NATS at %s", conn.ConnectedUrl())
+			checkSubscriptions()
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if sub == nil {
+				log.Printf("❌ NATS error: %v", err)
+				return
+			}
+			log.Printf("❌ NATS error on %s: %v", sub.Subject, err)
+			subscriptionError(sub, err)
+		}),
+	)
+	return options, nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
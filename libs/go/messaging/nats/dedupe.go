@@ -0,0 +1,73 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Dedupe wraps a handler so a message whose Nats-Msg-Id was already handled
+// within window is skipped, as replays and publisher retries deliver
+// messages more than once. Messages without an ID are always handled. IDs
+// are remembered once handled successfully, so a failed message can still be
+// retried, and by this instance only: use a queue group, or a JetStream
+// stream's duplicate window, to keep other instances from handling a copy.
+func Dedupe[T any](handler Handler[T], window time.Duration) Handler[T] {
+	seen := newSeenIDs(window)
+
+	return func(ctx context.Context, value T, msg *nats.Msg) error {
+		id := msg.Header.Get(nats.MsgIdHdr)
+		if id == "" {
+			return handler(ctx, value, msg)
+		}
+		if seen.contains(id) {
+			return nil
+		}
+
+		if err := handler(ctx, value, msg); err != nil {
+			return err
+		}
+		seen.add(id)
+		return nil
+	}
+}
+
+// seenIDs remembers message IDs for a window
+type seenIDs struct {
+	mu     sync.Mutex
+	window time.Duration
+	ids    map[string]time.Time // When each ID was handled
+	swept  time.Time
+}
+
+func newSeenIDs(window time.Duration) *seenIDs {
+	return &seenIDs{window: window, ids: make(map[string]time.Time), swept: time.Now()}
+}
+
+func (s *seenIDs) contains(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handledAt, ok := s.ids[id]
+	return ok && time.Since(handledAt) < s.window
+}
+
+// add remembers id, and forgets the IDs older than the window once per
+// window
+func (s *seenIDs) add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.ids[id] = now
+	if now.Sub(s.swept) < s.window {
+		return
+	}
+	for seenID, handledAt := range s.ids {
+		if now.Sub(handledAt) >= s.window {
+			delete(s.ids, seenID)
+		}
+	}
+	s.swept = now
+}
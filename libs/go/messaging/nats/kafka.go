@@ -0,0 +1,150 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaConfig describes how to reach Kafka
+type KafkaConfig struct {
+	Brokers  []string // Seed brokers, host:port
+	ClientID string   // Client ID the brokers log and quota by
+	// TopicPrefix namespaces every topic, like Config.SubjectPrefix
+	TopicPrefix string
+}
+
+// KafkaConfigFromEnv reads KAFKA_BROKERS, a comma separated list of seed
+// brokers, KAFKA_CLIENT_ID and MESSAGING_SUBJECT_PREFIX
+func KafkaConfigFromEnv() (KafkaConfig, error) {
+	config := KafkaConfig{
+		Brokers:     splitList(os.Getenv("KAFKA_BROKERS")),
+		ClientID:    os.Getenv("KAFKA_CLIENT_ID"),
+		TopicPrefix: os.Getenv("MESSAGING_SUBJECT_PREFIX"),
+	}
+	if len(config.Brokers) == 0 {
+		return KafkaConfig{}, fmt.Errorf("KAFKA_BROKERS names no brokers")
+	}
+	if config.TopicPrefix != "" {
+		if err := validSubject(config.TopicPrefix); err != nil {
+			return KafkaConfig{}, fmt.Errorf("invalid MESSAGING_SUBJECT_PREFIX: %w", err)
+		}
+	}
+	return config, nil
+}
+
+func (c KafkaConfig) options() []kgo.Opt {
+	options := []kgo.Opt{kgo.SeedBrokers(c.Brokers...)}
+	if c.ClientID != "" {
+		options = append(options, kgo.ClientID(c.ClientID))
+	}
+	return options
+}
+
+// kafkaBroker is a Broker on Kafka. Publishing shares one producer client,
+// each subscription consumes with a client of its own.
+type kafkaBroker struct {
+	config   KafkaConfig
+	producer *kgo.Client
+}
+
+// NewKafkaBroker connects a producer to Kafka
+func NewKafkaBroker(config KafkaConfig) (Broker, error) {
+	producer, err := kgo.NewClient(config.options()...)
+	if err != nil {
+		log.Println("❌ Failed to create Kafka client:", err)
+		return nil, err
+	}
+	log.Printf("✅ Kafka client ready for %v.", config.Brokers)
+	return &kafkaBroker{config: config, producer: producer}, nil
+}
+
+func (k *kafkaBroker) Publish(ctx context.Context, topic string, message Message) error {
+	record := &kgo.Record{Topic: prefixSubject(k.config.TopicPrefix, topic), Value: message.Data}
+	if message.Key != "" {
+		record.Key = []byte(message.Key)
+	}
+	headers := messageHeaders(ctx, message)
+	for name := range headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: name, Value: []byte(headers.Get(name))})
+	}
+
+	if err := k.producer.ProduceSync(ctx, record).FirstErr(); err != nil {
+		log.Printf("❌ Failed to publish message to %s: %v", topic, err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe consumes topic in the consumer group group, or from the end of
+// the topic without one. Offsets are committed automatically, so a message
+// whose handler fails is logged and not redelivered.
+func (k *kafkaBroker) Subscribe(topic, group string, handler MessageHandler) (Unsubscriber, error) {
+	options := append(k.config.options(), kgo.ConsumeTopics(prefixSubject(k.config.TopicPrefix, topic)))
+	if group != "" {
+		options = append(options, kgo.ConsumerGroup(group))
+	} else {
+		options = append(options, kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()))
+	}
+	consumer, err := kgo.NewClient(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subscription := &kafkaSubscription{consumer: consumer, cancel: cancel}
+	subscription.wg.Add(1)
+	go subscription.consume(ctx, handler)
+	return subscription, nil
+}
+
+func (k *kafkaBroker) Close() error {
+	k.producer.Close()
+	log.Println("✅ Kafka client closed.")
+	return nil
+}
+
+type kafkaSubscription struct {
+	consumer *kgo.Client
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func (s *kafkaSubscription) consume(ctx context.Context, handler MessageHandler) {
+	defer s.wg.Done()
+	for {
+		fetches := s.consumer.PollFetches(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Printf("❌ Failed to fetch from %s partition %d: %v", topic, partition, err)
+		})
+
+		fetches.EachRecord(func(record *kgo.Record) {
+			message := Message{Key: string(record.Key), Headers: make(map[string]string, len(record.Headers)), Data: record.Value}
+			header := nats.Header{}
+			for _, recordHeader := range record.Headers {
+				message.Headers[recordHeader.Key] = string(recordHeader.Value)
+				header.Set(recordHeader.Key, string(recordHeader.Value))
+			}
+
+			messageCtx := ContextWithTraceHeaders(ctx, header)
+			if err := handler(messageCtx, message); err != nil {
+				log.Printf("❌ Failed to handle message on %s (trace %s): %v", record.Topic, TraceID(messageCtx), err)
+			}
+		})
+	}
+}
+
+func (s *kafkaSubscription) Unsubscribe() error {
+	s.cancel()
+	s.wg.Wait()
+	s.consumer.Close()
+	return nil
+}
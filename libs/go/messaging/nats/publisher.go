@@ -1,6 +1,7 @@
 package messaging
 
 import (
+	"context"
 	"log"
 	"github.com/nats-io/nats.go"
 )
@@ -14,7 +15,10 @@ func PublishCartMessage(msg string) error {
 	}
 
 	// Publish the message to the "cart.created" subject
-	err := nc.Publish("cart.created", []byte(msg))
+	message := nats.NewMsg(prefixSubject(subjectPrefix, "cart.created"))
+	message.Data = []byte(msg)
+	message.Header = outgoingTraceHeaders(context.Background())
+	err := nc.PublishMsg(message)
 	if err != nil {
 		log.Println("❌ Failed to publish message to cart.created:", err)
 		return err
@@ -0,0 +1,202 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// errorHeader carries the error of a failed request back to the requester
+const errorHeader = "Error"
+
+// RetryPolicy says how often and how patiently a handler is retried
+type RetryPolicy struct {
+	MaxAttempts    int           // Attempts in all, the first included
+	InitialBackoff time.Duration // Wait before the first retry
+	MaxBackoff     time.Duration // Cap on the wait between retries
+}
+
+// DefaultRetryPolicy retries a transient failure twice, within about a second
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     time.Second,
+}
+
+// transientError marks an error worth retrying
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string { return e.err.Error() }
+
+func (e transientError) Unwrap() error { return e.err }
+
+// Transient marks err as a temporary failure, one a retry may get past
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transientError{err: err}
+}
+
+// IsTransient reports whether err is worth retrying: errors marked with
+// Transient, and timeouts, such as a database query running out of time
+func IsTransient(err error) bool {
+	var transient transientError
+	if errors.As(err, &transient) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Retry wraps a handler so transient failures are retried with jittered
+// backoff, and panics are recovered as errors. When the last attempt fails,
+// a JetStream message is NAKed for redelivery and a request is answered
+// with the error in the Error header. Outcomes are counted per subject, see
+// HandlerStats.
+func Retry[T any](handler Handler[T], policy RetryPolicy) Handler[T] {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	return func(ctx context.Context, value T, msg *nats.Msg) error {
+		stats := subjectStatsFor(msg)
+		atomic.AddUint64(&stats.handled, 1)
+
+		backoff := policy.InitialBackoff
+		err := safeHandle(handler, ctx, value, msg, stats)
+		for attempt := 2; err != nil && attempt <= policy.MaxAttempts && IsTransient(err); attempt++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(jitter(backoff)):
+			}
+			backoff = min(backoff*2, policy.MaxBackoff)
+
+			atomic.AddUint64(&stats.retried, 1)
+			err = safeHandle(handler, ctx, value, msg, stats)
+		}
+		if err == nil {
+			return nil
+		}
+
+		atomic.AddUint64(&stats.failed, 1)
+		rejectMessage(msg, err)
+		return err
+	}
+}
+
+// safeHandle runs the handler once, turning a panic into an error
+func safeHandle[T any](handler Handler[T], ctx context.Context, value T, msg *nats.Msg, stats *subjectStats) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&stats.panics, 1)
+			log.Printf("💥 Panic handling message on %s: %v\n%s", msg.Subject, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, value, msg)
+}
+
+// jitter spreads a backoff over [backoff/2, backoff), so the instances that
+// failed together do not retry together
+func jitter(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)))
+}
+
+// rejectMessage tells the sender a message could not be handled: JetStream
+// redelivers it later, a requester gets the error
+func rejectMessage(msg *nats.Msg, handleErr error) {
+	if _, err := msg.Metadata(); err == nil {
+		if err := msg.Nak(); err != nil {
+			log.Printf("❌ Failed to NAK message on %s: %v", msg.Subject, err)
+		}
+		return
+	}
+	if msg.Reply == "" {
+		return
+	}
+
+	reply := nats.NewMsg(msg.Reply)
+	reply.Header.Set(errorHeader, handleErr.Error())
+	if err := msg.RespondMsg(reply); err != nil {
+		log.Printf("❌ Failed to answer request on %s: %v", msg.Subject, err)
+	}
+}
+
+// SubjectStats counts the outcomes of the messages of a subject
+type SubjectStats struct {
+	Handled uint64 `json:"handled"` // Messages handed to the handler
+	Failed  uint64 `json:"failed"`  // Messages whose last attempt failed
+	Retried uint64 `json:"retried"` // Retries of transient failures
+	Panics  uint64 `json:"panics"`  // Attempts that panicked
+}
+
+type subjectStats struct {
+	handled, failed, retried, panics uint64 // Updated atomically
+}
+
+var (
+	statsMu        sync.Mutex
+	statsBySubject = make(map[string]*subjectStats)
+)
+
+// subjectStatsFor returns the counters of a message's subscription subject,
+// so wildcard subscriptions are counted once rather than per subject. The
+// subject prefix is left out, as callers of HandlerStats know subjects
+// without it.
+func subjectStatsFor(msg *nats.Msg) *subjectStats {
+	subject := msg.Subject
+	if msg.Sub != nil && msg.Sub.Subject != "" {
+		subject = msg.Sub.Subject
+	}
+	if subjectPrefix != "" {
+		subject = strings.TrimPrefix(subject, subjectPrefix+".")
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats, ok := statsBySubject[subject]
+	if !ok {
+		stats = &subjectStats{}
+		statsBySubject[subject] = stats
+	}
+	return stats
+}
+
+// HandlerStats returns the counts of the handlers wrapped with Retry, by
+// subject
+func HandlerStats() map[string]SubjectStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	result := make(map[string]SubjectStats, len(statsBySubject))
+	for subject, stats := range statsBySubject {
+		result[subject] = SubjectStats{
+			Handled: atomic.LoadUint64(&stats.handled),
+			Failed:  atomic.LoadUint64(&stats.failed),
+			Retried: atomic.LoadUint64(&stats.retried),
+			Panics:  atomic.LoadUint64(&stats.panics),
+		}
+	}
+	return result
+}
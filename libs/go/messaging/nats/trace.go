@@ -0,0 +1,115 @@
+package messaging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// W3C Trace Context headers, see https://www.w3.org/TR/trace-context/
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// traceparent is a parsed traceparent header, version-traceid-parentid-flags
+type traceparent struct {
+	traceID  string // 32 lowercase hex digits, not all zero
+	parentID string // 16 lowercase hex digits, not all zero
+	flags    string // 2 hex digits, 01 when sampled
+}
+
+func (t traceparent) String() string {
+	return "00-" + t.traceID + "-" + t.parentID + "-" + t.flags
+}
+
+// parseTraceparent reads a traceparent header, ok is false when it is
+// malformed. Later versions are read as version 00, as the spec asks.
+func parseTraceparent(value string) (t traceparent, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return traceparent{}, false
+	}
+	t = traceparent{traceID: parts[1], parentID: parts[2], flags: parts[3]}
+	if !isTraceHex(parts[0], 2) || !isTraceHex(t.traceID, 32) || !isTraceHex(t.parentID, 16) || !isTraceHex(t.flags, 2) {
+		return traceparent{}, false
+	}
+	if strings.Trim(t.traceID, "0") == "" || strings.Trim(t.parentID, "0") == "" {
+		return traceparent{}, false
+	}
+	return t, true
+}
+
+// isTraceHex reports whether value is size lowercase hex digits
+func isTraceHex(value string, size int) bool {
+	if len(value) != size {
+		return false
+	}
+	for _, c := range value {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomTraceHex(size int) string {
+	id := make([]byte, size/2)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+type traceHeadersKey struct{}
+
+// ContextWithTraceHeaders keeps the W3C trace headers of header in ctx. A
+// malformed traceparent is dropped along with its tracestate, so the next
+// publish starts a new trace instead of passing on garbage.
+func ContextWithTraceHeaders(ctx context.Context, header nats.Header) context.Context {
+	parent, ok := parseTraceparent(header.Get(traceparentHeader))
+	if !ok {
+		return ctx
+	}
+	trace := nats.Header{}
+	trace.Set(traceparentHeader, parent.String())
+	if state := header.Get(tracestateHeader); state != "" {
+		trace.Set(tracestateHeader, state)
+	}
+	return context.WithValue(ctx, traceHeadersKey{}, trace)
+}
+
+// TraceHeaders returns the trace headers kept in ctx, nil when there are none
+func TraceHeaders(ctx context.Context) nats.Header {
+	trace, _ := ctx.Value(traceHeadersKey{}).(nats.Header)
+	return trace
+}
+
+// TraceID returns the trace ID of ctx as 32 hex digits, or "" outside a trace
+func TraceID(ctx context.Context) string {
+	parent, _ := parseTraceparent(TraceHeaders(ctx).Get(traceparentHeader))
+	return parent.traceID
+}
+
+// outgoingTraceHeaders are the trace headers of a message published within
+// ctx: the trace of ctx continues with a new parent ID for the hop, and
+// without one a new, sampled trace starts
+func outgoingTraceHeaders(ctx context.Context) nats.Header {
+	incoming := TraceHeaders(ctx)
+	parent, ok := parseTraceparent(incoming.Get(traceparentHeader))
+	if !ok {
+		parent = traceparent{traceID: randomTraceHex(32), flags: "01"}
+	}
+	parent.parentID = randomTraceHex(16)
+
+	trace := nats.Header{}
+	trace.Set(traceparentHeader, parent.String())
+	if state := incoming.Get(tracestateHeader); ok && state != "" {
+		trace.Set(tracestateHeader, state)
+	}
+	return trace
+}
@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// contentTypeHeader names the codec a message was encoded with
+const contentTypeHeader = "Content-Type"
+
+// Handler processes a decoded message. ctx carries the trace headers of the
+// message, for the handler to pass on to whatever it publishes.
+type Handler[T any] func(ctx context.Context, value T, msg *nats.Msg) error
+
+// Option adjusts Publish and Subscribe
+type Option func(*options)
+
+type options struct {
+	codec Codec
+	queue string
+	msgID string
+}
+
+// WithCodec encodes published messages with codec instead of JSON. It has
+// no effect on Subscribe, which decodes by the Content-Type header.
+func WithCodec(codec Codec) Option {
+	return func(o *options) { o.codec = codec }
+}
+
+// WithMsgID sets the Nats-Msg-Id header of a published message. A JetStream
+// stream keeps one copy of the messages with the same ID within its
+// duplicate window, and Dedupe drops them on the consuming side, so derive
+// the ID from what the message is about, such as an outbox row ID, rather
+// than from the attempt.
+func WithMsgID(id string) Option {
+	return func(o *options) { o.msgID = id }
+}
+
+// WithQueue subscribes as a member of a queue group, so each message goes to
+// one instance of the service only
+func WithQueue(queue string) Option {
+	return func(o *options) { o.queue = queue }
+}
+
+func buildOptions(opts []Option) options {
+	o := options{codec: JSONCodec{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Subject names the subject of an event, "<entity>.<event>" in lower case,
+// as in cart.created. Further tokens narrow it down, as in
+// order.status.shipped.
+func Subject(entity, event string, tokens ...string) string {
+	return strings.ToLower(strings.Join(append([]string{entity, event}, tokens...), "."))
+}
+
+// prefixSubject puts subject in the namespace of prefix, see
+// Config.SubjectPrefix
+func prefixSubject(prefix, subject string) string {
+	if prefix == "" {
+		return subject
+	}
+	return prefix + "." + subject
+}
+
+// validSubject rejects subjects that cannot be published to: empty tokens,
+// whitespace and wildcards
+func validSubject(subject string) error {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return fmt.Errorf("invalid subject %q", subject)
+		}
+	}
+	return nil
+}
+
+// Publish encodes value and sends it to subject, under the configured
+// subject prefix. The message continues the trace of ctx, or starts a new
+// one, in its W3C traceparent header.
+func Publish[T any](ctx context.Context, subject string, value T, opts ...Option) error {
+	o := buildOptions(opts)
+
+	// Ensure NATS is connected before publishing
+	if nc == nil || !nc.IsConnected() {
+		log.Println("❌ NATS connection is closed or not established.")
+		return nats.ErrConnectionClosed
+	}
+	if err := validSubject(subject); err != nil {
+		return err
+	}
+
+	data, err := o.codec.Encode(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode message for %s: %w", subject, err)
+	}
+
+	msg := nats.NewMsg(prefixSubject(subjectPrefix, subject))
+	msg.Data = data
+	msg.Header.Set(contentTypeHeader, o.codec.ContentType())
+	if o.msgID != "" {
+		msg.Header.Set(nats.MsgIdHdr, o.msgID)
+	}
+	for name, values := range outgoingTraceHeaders(ctx) {
+		msg.Header[name] = values
+	}
+
+	if err := nc.PublishMsg(msg); err != nil {
+		log.Printf("❌ Failed to publish message to %s: %v", subject, err)
+		return err
+	}
+	return nil
+}
+
+// Subscribe decodes the messages of subject, under the configured subject
+// prefix, into T and hands them to handler, in a context carrying the
+// message's trace. Messages that fail to decode, and handler errors, are
+// logged. The watchdog resubscribes when the subscription stops consuming.
+func Subscribe[T any](subject string, handler Handler[T], opts ...Option) (*Subscription, error) {
+	o := buildOptions(opts)
+
+	if nc == nil || !nc.IsConnected() {
+		log.Println("❌ NATS connection is closed or not established.")
+		return nil, nats.ErrConnectionClosed
+	}
+
+	callback := func(msg *nats.Msg) {
+		ctx := ContextWithTraceHeaders(context.Background(), msg.Header)
+		value, err := decode[T](msg)
+		if err != nil {
+			log.Printf("❌ Dropped message on %s (trace %s): %v", msg.Subject, TraceID(ctx), err)
+			return
+		}
+
+		if err := handler(ctx, value, msg); err != nil {
+			log.Printf("❌ Failed to handle message on %s (trace %s): %v", msg.Subject, TraceID(ctx), err)
+		}
+	}
+
+	return subscribe(subject, o.queue, callback)
+}
+
+// decode decodes a message into a T. When T is a pointer, as generated
+// protobuf types are used, the value it points to is allocated first.
+func decode[T any](msg *nats.Msg) (T, error) {
+	var value T
+	codec, err := codecFor(msg.Header.Get(contentTypeHeader))
+	if err != nil {
+		return value, err
+	}
+
+	target := any(&value)
+	if t := reflect.TypeOf(value); t != nil && t.Kind() == reflect.Pointer {
+		value = reflect.New(t.Elem()).Interface().(T)
+		target = value
+	}
+	if err := codec.Decode(msg.Data, target); err != nil {
+		return value, fmt.Errorf("failed to decode message: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,217 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Subscription is a subscription the watchdog keeps consuming: when the
+// server closes it, or it did not survive a reconnect, it is made again with
+// the same subject, queue group and handler.
+type Subscription struct {
+	subject  string // Without the subject prefix
+	queue    string
+	callback nats.MsgHandler
+
+	mu           sync.Mutex
+	sub          *nats.Subscription
+	closed       bool
+	resubscribes int
+	lastErr      error
+}
+
+// SubscriptionStatus is the state of a Subscription, for health checks
+type SubscriptionStatus struct {
+	Subject      string `json:"subject"`
+	Queue        string `json:"queue,omitempty"`
+	Active       bool   `json:"active"`
+	Dropped      int    `json:"dropped"` // Messages dropped as the handler fell behind
+	Resubscribes int    `json:"resubscribes"`
+	LastError    string `json:"lastError,omitempty"`
+}
+
+var (
+	subscriptionsMu sync.Mutex
+	subscriptions   = make(map[*Subscription]struct{})
+
+	watchdogStop chan struct{}
+	watchdogWG   sync.WaitGroup
+)
+
+// subscribe subscribes callback to subject under the subject prefix, in the
+// queue group queue when it is set, and hands the subscription to the
+// watchdog
+func subscribe(subject, queue string, callback nats.MsgHandler) (*Subscription, error) {
+	s := &Subscription{subject: subject, queue: queue, callback: callback}
+	if err := s.resubscribe(); err != nil {
+		return nil, err
+	}
+
+	subscriptionsMu.Lock()
+	subscriptions[s] = struct{}{}
+	subscriptionsMu.Unlock()
+	return s, nil
+}
+
+// Subject is the subject subscribed to, without the subject prefix
+func (s *Subscription) Subject() string {
+	return s.subject
+}
+
+// Unsubscribe ends the subscription, for good
+func (s *Subscription) Unsubscribe() error {
+	subscriptionsMu.Lock()
+	delete(subscriptions, s)
+	subscriptionsMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.sub == nil || !s.sub.IsValid() {
+		return nil
+	}
+	return s.sub.Unsubscribe()
+}
+
+// resubscribe subscribes again unless the subscription is still valid
+func (s *Subscription) resubscribe() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || (s.sub != nil && s.sub.IsValid()) {
+		return nil
+	}
+	if nc == nil || !nc.IsConnected() {
+		return nats.ErrConnectionClosed
+	}
+
+	subject := prefixSubject(subjectPrefix, s.subject)
+	var sub *nats.Subscription
+	var err error
+	if s.queue != "" {
+		sub, err = nc.QueueSubscribe(subject, s.queue, s.callback)
+	} else {
+		sub, err = nc.Subscribe(subject, s.callback)
+	}
+	if err != nil {
+		s.lastErr = err
+		return err
+	}
+
+	if s.sub != nil {
+		s.resubscribes++
+		log.Printf("🔁 Resubscribed to %s.", s.subject)
+	}
+	s.sub = sub
+	return nil
+}
+
+// status reports the state of the subscription
+func (s *Subscription) status() SubscriptionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := SubscriptionStatus{Subject: s.subject, Queue: s.queue, Resubscribes: s.resubscribes}
+	if s.sub != nil && s.sub.IsValid() && nc != nil && nc.IsConnected() {
+		status.Active = true
+		status.Dropped, _ = s.sub.Dropped()
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+// checkSubscriptions resubscribes the subscriptions that stopped consuming
+func checkSubscriptions() {
+	for _, s := range watchedSubscriptions() {
+		if err := s.resubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			log.Printf("❌ Failed to resubscribe to %s: %v", s.subject, err)
+		}
+	}
+}
+
+// subscriptionError records an error the server reported for a subscription,
+// such as a slow consumer or a permissions violation
+func subscriptionError(sub *nats.Subscription, err error) {
+	for _, s := range watchedSubscriptions() {
+		s.mu.Lock()
+		if s.sub == sub {
+			s.lastErr = err
+		}
+		s.mu.Unlock()
+	}
+}
+
+func watchedSubscriptions() []*Subscription {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	watched := make([]*Subscription, 0, len(subscriptions))
+	for s := range subscriptions {
+		watched = append(watched, s)
+	}
+	return watched
+}
+
+// startWatchdog checks the subscriptions every interval until stopWatchdog.
+// They are also checked after every reconnect, see Config.Options.
+func startWatchdog(interval time.Duration) {
+	if interval <= 0 || watchdogStop != nil {
+		return
+	}
+	watchdogStop = make(chan struct{})
+	watchdogWG.Add(1)
+	go func(stop chan struct{}) {
+		defer watchdogWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				checkSubscriptions()
+			case <-stop:
+				return
+			}
+		}
+	}(watchdogStop)
+}
+
+func stopWatchdog() {
+	if watchdogStop == nil {
+		return
+	}
+	close(watchdogStop)
+	watchdogWG.Wait()
+	watchdogStop = nil
+}
+
+// SubscriptionHealth returns the state of every subscription made with
+// Subscribe or a NATS Broker
+func SubscriptionHealth() []SubscriptionStatus {
+	watched := watchedSubscriptions()
+	statuses := make([]SubscriptionStatus, 0, len(watched))
+	for _, s := range watched {
+		statuses = append(statuses, s.status())
+	}
+	return statuses
+}
+
+// HealthCheck fails when NATS is not connected or a subscription is not
+// consuming, for services to report in their readiness checks
+func HealthCheck() error {
+	if nc == nil || !nc.IsConnected() {
+		return nats.ErrConnectionClosed
+	}
+	for _, status := range SubscriptionHealth() {
+		if !status.Active {
+			if status.LastError != "" {
+				return fmt.Errorf("subscription to %s is not consuming: %s", status.Subject, status.LastError)
+			}
+			return fmt.Errorf("subscription to %s is not consuming", status.Subject)
+		}
+	}
+	return nil
+}
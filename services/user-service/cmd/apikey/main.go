@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/services"
+	"user-service-new/internal/infrastructure"
+	postgresRepo "user-service-new/internal/infrastructure/db/postgres"
+)
+
+// apikey manages the keys other services use to call privileged TCP methods.
+//
+//	apikey issue -name order-service
+//	apikey rotate -id <key id>
+//	apikey revoke -id <key id>
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	if err := godotenv.Load(".env"); err != nil {
+		log.Printf("No .env file found in current directory: %v", err)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	name := flags.String("name", "", "name of the calling service")
+	id := flags.String("id", "", "id of an existing key")
+	flags.Parse(os.Args[2:])
+
+	db, err := gorm.Open(postgres.Open(os.Getenv("DATABASE_URL")), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	// Tell the running instances to stop accepting revoked keys right away
+	var cacheInvalidator *infrastructure.CacheInvalidator
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		cacheInvalidator = infrastructure.NewCacheInvalidator(natsURL)
+		cacheInvalidator.Start()
+	}
+
+	apiKeyService := services.NewAPIKeyService(postgresRepo.NewAPIKeyRepository(db), cacheInvalidator)
+
+	var result *command.APIKeyCommandResult
+	switch os.Args[1] {
+	case "issue":
+		result, err = apiKeyService.IssueAPIKey(&command.IssueAPIKeyCommand{Name: *name})
+	case "rotate":
+		result, err = apiKeyService.RotateAPIKey(&command.RotateAPIKeyCommand{Id: parseID(*id)})
+	case "revoke":
+		result, err = apiKeyService.RevokeAPIKey(&command.RevokeAPIKeyCommand{Id: parseID(*id)})
+	default:
+		usage()
+	}
+	if cacheInvalidator != nil {
+		cacheInvalidator.Stop()
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", os.Args[1], err)
+	}
+
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(output))
+}
+
+func parseID(id string) uuid.UUID {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		log.Fatalf("invalid -id: %v", err)
+	}
+	return parsed
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apikey issue -name <service> | rotate -id <key id> | revoke -id <key id>")
+	os.Exit(2)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/infrastructure"
+)
+
+// hashbench times password hashing on the machine it runs on, to pick
+// BCRYPT_COST and ARGON2_* values that keep a login under the target latency.
+//
+//	hashbench                 # the hasher configured in .env / the environment
+//	hashbench -sweep          # bcrypt costs and argon2id memory sizes side by side
+//	hashbench -sweep -n 20 -target 250ms
+func main() {
+	sweep := flag.Bool("sweep", false, "compare a range of bcrypt and argon2id settings")
+	rounds := flag.Int("n", 5, "hashes per setting")
+	target := flag.Duration("target", 250*time.Millisecond, "acceptable time per hash")
+	flag.Parse()
+
+	if err := godotenv.Load(".env"); err != nil {
+		log.Printf("No .env file found in current directory: %v", err)
+	}
+
+	if !*sweep {
+		report("configured", infrastructure.NewPasswordHasher(), *rounds, *target)
+		return
+	}
+
+	for cost := 8; cost <= 14; cost++ {
+		report(fmt.Sprintf("bcrypt cost=%d", cost), entities.NewBcryptHasher(cost), *rounds, *target)
+	}
+	for _, memory := range []uint32{19 * 1024, 32 * 1024, 64 * 1024, 128 * 1024} {
+		for _, iterations := range []uint32{1, 2, 3} {
+			name := fmt.Sprintf("argon2id m=%dKiB t=%d p=2", memory, iterations)
+			report(name, entities.NewArgon2idHasher(memory, iterations, 2), *rounds, *target)
+		}
+	}
+}
+
+func report(name string, hasher entities.PasswordHasher, rounds int, target time.Duration) {
+	const password = "correct horse battery staple"
+
+	var total time.Duration
+	var hash string
+	for i := 0; i < rounds; i++ {
+		start := time.Now()
+		h, err := hasher.Hash(password)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		total += time.Since(start)
+		hash = h
+	}
+
+	// Verification is what a login pays, check it costs the same as hashing
+	start := time.Now()
+	if err := hasher.Verify(hash, password); err != nil {
+		log.Fatalf("%s: verify failed: %v", name, err)
+	}
+	verify := time.Since(start)
+
+	average := total / time.Duration(rounds)
+	verdict := "ok"
+	if average > target {
+		verdict = "too slow"
+	} else if average < target/10 {
+		verdict = "weak"
+	}
+	if bcryptHasher, ok := hasher.(*entities.BcryptHasher); ok && bcryptHasher.Cost < bcrypt.DefaultCost {
+		verdict = "weak"
+	}
+
+	fmt.Printf("%-32s hash %-12v verify %-12v %s\n", name, average.Round(time.Millisecond), verify.Round(time.Millisecond), verdict)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"user-service-new/internal/infrastructure"
+)
+
+// replay republishes the user events a JetStream stream captured between two
+// times, for consumers rebuilding their state after losing data.
+//
+//	replay -stream USER_EVENTS -from 2024-01-01T00:00:00Z -subject user.replay.billing
+//	replay -stream USER_EVENTS -from 2024-01-01T00:00:00Z -to 2024-01-02T00:00:00Z -types user.created -subject user.replay.billing -dry-run
+func main() {
+	if err := godotenv.Load(".env"); err != nil {
+		log.Printf("No .env file found in current directory: %v", err)
+	}
+
+	stream := flag.String("stream", os.Getenv("NATS_EVENTS_STREAM"), "JetStream stream capturing the user events")
+	from := flag.String("from", "", "replay events stored from this time, RFC 3339")
+	to := flag.String("to", "", "up to this time, RFC 3339, now by default")
+	filter := flag.String("filter", "user.>", "subjects of the stream to read")
+	types := flag.String("types", "", "comma separated event types to replay, all by default")
+	subject := flag.String("subject", "", "subject to republish the events on")
+	dryRun := flag.Bool("dry-run", false, "count the events without republishing them")
+	flag.Parse()
+
+	natsURL := os.Getenv("NATS_URL")
+	if natsURL == "" {
+		log.Fatalf("NATS_URL is not set")
+	}
+	if *stream == "" || *subject == "" || *from == "" {
+		fmt.Fprintln(os.Stderr, "usage: replay -stream <stream> -from <time> [-to <time>] [-types <types>] -subject <subject> [-dry-run]")
+		os.Exit(2)
+	}
+
+	replay := infrastructure.EventReplay{
+		Stream: *stream,
+		Filter: *filter,
+		From:   parseTime("from", *from),
+		Target: *subject,
+		DryRun: *dryRun,
+	}
+	if *to != "" {
+		replay.To = parseTime("to", *to)
+	}
+	if *types != "" {
+		replay.Types = strings.Split(*types, ",")
+	}
+
+	result, err := infrastructure.NewEventReplayer(natsURL).Replay(replay)
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(output))
+	if err != nil {
+		log.Fatalf("replay failed: %v", err)
+	}
+}
+
+func parseTime(name, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("invalid -%s: %v", name, err)
+	}
+	return parsed
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"user-service-new/internal/application/services"
+	"user-service-new/internal/domain/entities"
 	"user-service-new/internal/infrastructure"
 	postgresRepo "user-service-new/internal/infrastructure/db/postgres"
+	"user-service-new/internal/interface/grpcapi"
+	"user-service-new/internal/interface/httpapi"
 	"user-service-new/internal/interface/tcp"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -34,35 +40,171 @@ func main() {
 
 	log.Printf("Connected to database: %v", db)
 
+	// Serve lookups and profiles from read replicas, when configured
+	readReplicas, err := initReadReplicas(db)
+	if err != nil {
+		log.Fatalf("Failed to configure read replicas: %v", err)
+	}
+	if readReplicas != nil {
+		readReplicas.Start()
+	}
+
 	// // Auto migrate database
-	// if err := db.AutoMigrate(&postgresRepo.UserModel{}); err != nil {
+	// if err := db.AutoMigrate(&postgresRepo.UserModel{}, &postgresRepo.APIKeyModel{}, &postgresRepo.RecoveryCodeModel{}, &postgresRepo.DeviceModel{}, &postgresRepo.InviteModel{}, &postgresRepo.LoginHistoryModel{}, &postgresRepo.UserEmailModel{}, &postgresRepo.AuditEventModel{}); err != nil {
 	// 	log.Fatalf("Failed to migrate database: %v", err)
 	// }
 
+	// Apply the configured password policy to new registrations
+	entities.SetPasswordPolicy(infrastructure.NewPasswordPolicy())
+	entities.SetPasswordHasher(infrastructure.NewPasswordHasher())
+	entities.SetTermsPolicy(infrastructure.NewTermsPolicy())
+	entities.SetRegistrationDenylist(infrastructure.NewRegistrationDenylist())
+	infrastructure.WatchRegistrationDenylist()
+
 	// Initialize infrastructure services
 	redisService := infrastructure.NewRedisService()
 	defer redisService.Close()
+	redisService.StartHealthCheck()
 
 	jwtService := infrastructure.NewJWTService()
 	otpService := infrastructure.NewOTPService()
 	rateLimiter := infrastructure.NewRateLimiter(15*time.Minute, 5)
+	// Count requests in Redis, so limits hold across every instance
+	if infrastructure.GetEnvAsBool("RATE_LIMIT_DISTRIBUTED", true) {
+		rateLimiter.EnableRedis(redisService, "requests")
+		otpService.EnableRedisRateLimits(redisService)
+	}
+	emailNotifier := infrastructure.NewEmailNotifier()
+	// Queue OTP emails in Redis, so the email provider's latency stays off
+	// the request path
+	var emailQueue *infrastructure.EmailQueue
+	if infrastructure.GetEnvAsBool("EMAIL_QUEUE_ENABLED", true) {
+		emailQueue = infrastructure.NewEmailQueue(redisService, emailNotifier)
+		emailQueue.Start()
+		otpService.EnableEmailQueue(emailQueue)
+	}
+	breachChecker := infrastructure.NewBreachChecker()
+	eventBus := infrastructure.NewEventBus()
 
 	// Initialize repositories
-	userRepo := postgresRepo.NewUserRepository(db)
-	idempotencyRepo := postgresRepo.NewIdempotencyRepository(db)
+	// Remember usernames and emails found unused, so signup spam does not
+	// query Postgres for each attempt
+	userRepo := infrastructure.NewMissingUserCache(postgresRepo.NewUserRepository(db, readReplicas), redisService)
+	// Serve idempotency records from Redis, writing them behind to Postgres
+	idempotencyStore := infrastructure.NewIdempotencyStore(postgresRepo.NewIdempotencyRepository(db), redisService)
+	idempotencyStore.Start()
+	apiKeyRepo := postgresRepo.NewAPIKeyRepository(db)
+	recoveryCodeRepo := postgresRepo.NewRecoveryCodeRepository(db)
+	deviceRepo := postgresRepo.NewDeviceRepository(db)
+	inviteRepo := postgresRepo.NewInviteRepository(db)
+	loginHistoryRepo := postgresRepo.NewLoginHistoryRepository(db)
+	userEmailRepo := postgresRepo.NewUserEmailRepository(db)
+	auditRepo := postgresRepo.NewAuditRepository(db)
 
 	// Initialize services
 	userService := services.NewUserService(
 		userRepo,
-		idempotencyRepo,
+		idempotencyStore,
+		postgresRepo.NewUnitOfWork(db),
+		recoveryCodeRepo,
+		deviceRepo,
+		loginHistoryRepo,
+		userEmailRepo,
+		auditRepo,
 		redisService,
 		jwtService,
 		otpService,
 		rateLimiter,
+		emailNotifier,
+		breachChecker,
+		eventBus,
 	)
+	// Evict revoked API keys from the cache of every instance, not just the
+	// one revoking them
+	var cacheInvalidator *infrastructure.CacheInvalidator
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		cacheInvalidator = infrastructure.NewCacheInvalidator(natsURL)
+		cacheInvalidator.Start()
+	}
+
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo, cacheInvalidator)
+	avatarService := services.NewAvatarService(userRepo, redisService, infrastructure.NewObjectStorage(), eventBus)
+	inviteService := services.NewInviteService(userRepo, inviteRepo, emailNotifier, rateLimiter, breachChecker, eventBus)
+	adminService := services.NewAdminService(userRepo, auditRepo, redisService, jwtService, otpService, eventBus)
+
+	// Remove accounts and pending registrations that never completed verification
+	purgeScheduler := services.NewPurgeScheduler(userRepo, redisService, eventBus)
+	purgeScheduler.Start()
 
 	// Initialize TCP handler
-	tcpHandler := tcp.NewTCPHandler(userService)
+	tcpHandler := tcp.NewTCPHandler(userService, apiKeyService, avatarService, inviteService, adminService, eventBus)
+
+	// Serve TLS, and authenticate internal callers by client certificate, when configured
+	tlsConfig, err := infrastructure.NewTCPTLSConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure TCP TLS: %v", err)
+	}
+	if tlsConfig != nil {
+		identities, err := tcp.ParseCertificateIdentities(os.Getenv("TCP_MTLS_IDENTITIES"))
+		if err != nil {
+			log.Fatalf("Failed to parse TCP_MTLS_IDENTITIES: %v", err)
+		}
+		requireClientCert := infrastructure.GetEnvAsBool("TCP_MTLS_REQUIRED", false)
+		if requireClientCert && tlsConfig.ClientCAs == nil {
+			log.Fatalf("TCP_MTLS_REQUIRED needs TCP_TLS_CLIENT_CA_FILE")
+		}
+		tcpHandler.EnableTLS(tlsConfig, requireClientCert, identities)
+	}
+
+	// Let callers without TLS encrypt frame content with a pre-shared key
+	if value := os.Getenv("TCP_ENCRYPTION_KEYS"); value != "" {
+		keys, err := tcp.ParseEncryptionKeys(value)
+		if err != nil {
+			log.Fatalf("Failed to parse TCP_ENCRYPTION_KEYS: %v", err)
+		}
+		tcpHandler.EnableEncryption(keys)
+	}
+
+	// Check the dependencies for readiness probes and the health method
+	healthChecker := infrastructure.NewHealthChecker()
+	healthChecker.Add("postgres", func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	})
+	healthChecker.Add("redis", redisService.HealthCheck)
+	var natsPublisher *infrastructure.NATSPublisher
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		healthChecker.Add("nats", infrastructure.NATSHealthCheck(natsURL))
+		// Not ready while API key revocations from other instances go unheard
+		healthChecker.Add("nats_subscriptions", cacheInvalidator.HealthCheck)
+
+		// Tell other services about accounts being created, verified and
+		// deleted, in payloads they are known to understand
+		if err := infrastructure.CheckEventSchemas(); err != nil {
+			log.Fatalf("Invalid event schemas: %v", err)
+		}
+		natsPublisher = infrastructure.NewNATSPublisher(natsURL, eventBus)
+		natsPublisher.Start()
+	}
+	tcpHandler.EnableHealthChecks(healthChecker)
+	tcpHandler.EnableCircuitBreakerMetrics("redis", redisService.BreakerStats)
+	tcpHandler.EnableCacheMetrics(redisService.CacheMetrics)
+
+	// Keep the admin methods to the services that manage users, when named
+	adminServices := infrastructure.GetEnvAsList("ADMIN_SERVICES")
+	if len(adminServices) > 0 {
+		tcpHandler.EnableAdminServices(adminServices)
+	}
+
+	// Refuse requests whose replay nonce was seen before
+	tcpHandler.EnableReplayProtection(
+		redisService,
+		infrastructure.GetEnvAsDuration("TCP_REPLAY_WINDOW", 5*time.Minute),
+		infrastructure.GetEnvAsBool("TCP_REPLAY_PROTECTION_REQUIRED", false),
+	)
 
 	// Start TCP server in a goroutine
 	go func() {
@@ -77,6 +219,62 @@ func main() {
 		}
 	}()
 
+	// Initialize HTTP handler
+	httpHandler := httpapi.NewHTTPHandler(userService, avatarService, inviteService, apiKeyService, adminService)
+	if len(adminServices) > 0 {
+		httpHandler.EnableAdminServices(adminServices)
+	}
+
+	// Take the client's address, and country, from the proxies in front
+	if proxies := infrastructure.GetEnvAsList("HTTP_TRUSTED_PROXIES"); len(proxies) > 0 {
+		networks, err := httpapi.ParseTrustedProxies(proxies)
+		if err != nil {
+			log.Fatalf("Failed to parse HTTP_TRUSTED_PROXIES: %v", err)
+		}
+		httpHandler.EnableTrustedProxies(networks, os.Getenv("HTTP_COUNTRY_HEADER"))
+	}
+
+	// Start HTTP server in a goroutine
+	go func() {
+		port := os.Getenv("HTTP_PORT")
+		if port == "" {
+			port = "8080"
+		}
+
+		log.Printf("Starting HTTP server on port %s", port)
+		if err := httpHandler.Start(":" + port); err != nil {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	// Serve the main methods over gRPC as well, through the TCP handler
+	grpcServer := grpcapi.NewGRPCServer(tcpHandler)
+	go func() {
+		port := os.Getenv("GRPC_PORT")
+		if port == "" {
+			port = "50051"
+		}
+
+		log.Printf("Starting gRPC server on port %s", port)
+		if err := grpcServer.Start(":" + port); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+
+	// Serve liveness and readiness probes on a port of their own
+	healthServer := httpapi.NewHealthServer(healthChecker)
+	go func() {
+		port := os.Getenv("HEALTH_PORT")
+		if port == "" {
+			port = "8081"
+		}
+
+		log.Printf("Starting health server on port %s", port)
+		if err := healthServer.Start(":" + port); err != nil {
+			log.Fatalf("Health server failed: %v", err)
+		}
+	}()
+
 	// Graceful shutdown handling
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -85,9 +283,48 @@ func main() {
 	<-sigCh
 	log.Println("Received shutdown signal, initiating graceful shutdown...")
 
-	// Shutdown TCP server
-	if err := tcpHandler.Stop(); err != nil {
-		log.Printf("Error shutting down TCP server: %v", err)
+	// Fail readiness first, so no new traffic arrives while draining
+	healthServer.Drain()
+
+	purgeScheduler.Stop()
+
+	// Drain the API servers side by side, so none keeps taking requests while
+	// another one drains, with the whole shutdown bounded by SHUTDOWN_TIMEOUT
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), infrastructure.GetEnvAsDuration("SHUTDOWN_TIMEOUT", 10*time.Second))
+	defer cancel()
+	var wg sync.WaitGroup
+	stop := func(name string, stopServer func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := stopServer(); err != nil {
+				log.Printf("Error shutting down %s server: %v", name, err)
+			}
+		}()
+	}
+	stop("TCP", tcpHandler.Stop)
+	stop("HTTP", func() error { return httpHandler.Stop(shutdownCtx) })
+	stop("gRPC", func() error { return grpcServer.Stop(shutdownCtx) })
+	wg.Wait()
+
+	// The events of the drained requests are out by now
+	if natsPublisher != nil {
+		natsPublisher.Stop()
+	}
+	if cacheInvalidator != nil {
+		cacheInvalidator.Stop()
+	}
+	idempotencyStore.Stop()
+	if readReplicas != nil {
+		readReplicas.Stop()
+	}
+	if emailQueue != nil {
+		emailQueue.Stop()
+	}
+
+	// The probes go last, liveness has to pass until the drain is over
+	if err := healthServer.Stop(shutdownCtx); err != nil {
+		log.Printf("Error shutting down health server: %v", err)
 	}
 
 	log.Println("Service shutdown completed successfully")
@@ -106,11 +343,45 @@ func initDatabase() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := configureConnectionPool(db); err != nil {
+		return nil, err
+	}
 
-	// Configure connection pool from environment variables
+	return db, nil
+}
+
+// initReadReplicas connects to the replicas of DATABASE_REPLICA_URLS, nil
+// when there are none. A replica that is down is not fatal, it takes no
+// reads until its lag check passes.
+func initReadReplicas(primary *gorm.DB) (*postgresRepo.ReadReplicas, error) {
+	dsns := infrastructure.GetEnvAsList("DATABASE_REPLICA_URLS")
+	if len(dsns) == 0 {
+		return nil, nil
+	}
+
+	replicas := make([]*gorm.DB, 0, len(dsns))
+	for i, dsn := range dsns {
+		replica, err := gorm.Open(postgres.Open(dsn), &gorm.Config{DisableAutomaticPing: true})
+		if err != nil {
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		if err := configureConnectionPool(replica); err != nil {
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		replicas = append(replicas, replica)
+	}
+
+	maxLag := infrastructure.GetEnvAsDuration("DB_REPLICA_MAX_LAG", time.Second)
+	checkInterval := infrastructure.GetEnvAsDuration("DB_REPLICA_CHECK_INTERVAL", 5*time.Second)
+	log.Printf("Read Replica Config - Replicas: %d, Max Lag: %v, Check Interval: %v", len(replicas), maxLag, checkInterval)
+	return postgresRepo.NewReadReplicas(primary, replicas, maxLag, checkInterval), nil
+}
+
+// configureConnectionPool sizes the pool of db from environment variables
+func configureConnectionPool(db *gorm.DB) error {
 	sqlDB, err := db.DB()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Get connection pool settings from environment
@@ -122,5 +393,5 @@ func initDatabase() (*gorm.DB, error) {
 	sqlDB.SetMaxOpenConns(maxOpenConns)
 	sqlDB.SetConnMaxLifetime(connMaxLifetime)
 
-	return db, nil
+	return nil
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"reflect"
+	"time"
+
+	"user-service-new/internal/infrastructure"
+	"user-service-new/internal/interface/tcp"
+)
+
+// tcpconformance replays golden frames against the TCP server and checks the
+// answers, so a change to the framing code cannot silently break clients.
+// With -fuzz it also sends mutated golden frames and checks that the server
+// keeps answering.
+//
+//	tcpconformance -serve                  # against an in-process server without backing services
+//	tcpconformance -addr localhost:3005    # against a running server
+//	tcpconformance -serve -fuzz 5000 -seed 42
+//
+// The golden cases only use methods that need no database, so -serve runs
+// them anywhere.
+
+//go:embed golden.json
+var goldenCases []byte
+
+// goldenCase is a run of writes on a fresh connection and the frames expected back
+type goldenCase struct {
+	Name   string          `json:"name"`
+	Send   []string        `json:"send"` // Hex encoded, written one by one
+	Expect []expectedFrame `json:"expect"`
+	Closed bool            `json:"closed"` // The server closes the connection instead of answering
+}
+
+// expectedFrame describes a response. Responses are matched by request ID,
+// since pipelined requests may be answered in any order, and content is
+// matched as a subset of the response JSON.
+type expectedFrame struct {
+	Version   byte                   `json:"version"`
+	RequestID string                 `json:"requestID"`
+	Flags     *byte                  `json:"flags"`
+	Content   map[string]interface{} `json:"content"`
+	Empty     bool                   `json:"empty"`
+}
+
+type response struct {
+	version   byte
+	requestID string
+	flags     byte
+	content   []byte
+}
+
+func main() {
+	addr := flag.String("addr", "", "address of the TCP server, default localhost:$TCP_PORT")
+	serve := flag.Bool("serve", false, "start a TCP server in this process and test it")
+	casesFile := flag.String("cases", "", "golden cases file, default the built-in cases")
+	fuzz := flag.Int("fuzz", 0, "mutated frames to send after the golden cases")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed of the fuzzer")
+	flag.Parse()
+
+	data := goldenCases
+	if *casesFile != "" {
+		var err error
+		if data, err = os.ReadFile(*casesFile); err != nil {
+			log.Fatalf("Reading %s: %v", *casesFile, err)
+		}
+	}
+	var cases []goldenCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		log.Fatalf("Parsing golden cases: %v", err)
+	}
+
+	if *serve {
+		*addr = "127.0.0.1:39005"
+		handler := tcp.NewTCPHandler(nil, nil, nil, nil, nil, infrastructure.NewEventBus())
+		go func() {
+			if err := handler.Start(*addr); err != nil {
+				log.Fatalf("TCP server failed: %v", err)
+			}
+		}()
+		defer handler.Stop()
+		time.Sleep(100 * time.Millisecond)
+	}
+	if *addr == "" {
+		*addr = "localhost:" + infrastructure.GetEnvAsString("TCP_PORT", "3005")
+	}
+
+	failed := 0
+	for _, c := range cases {
+		if err := run(*addr, c); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", c.Name, err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.Name)
+	}
+
+	if *fuzz > 0 {
+		if err := fuzzServer(*addr, cases, *fuzz, *seed); err != nil {
+			failed++
+			fmt.Printf("FAIL fuzz (seed %d): %v\n", *seed, err)
+		} else {
+			fmt.Printf("ok   fuzz, %d frames (seed %d)\n", *fuzz, *seed)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of the checks failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+// run replays c on a new connection
+func run(addr string, c goldenCase) error {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, chunk := range c.Send {
+		frame, err := hex.DecodeString(chunk)
+		if err != nil {
+			return fmt.Errorf("invalid hex in case: %v", err)
+		}
+		if _, err := conn.Write(frame); err != nil {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if c.Closed {
+		if _, err := reader.ReadByte(); err == nil {
+			return fmt.Errorf("expected the connection to be closed, got data")
+		} else if !errors.Is(err, io.EOF) && !isReset(err) {
+			return fmt.Errorf("expected the connection to be closed: %v", err)
+		}
+		return nil
+	}
+
+	pending := make(map[string]expectedFrame)
+	for _, expected := range c.Expect {
+		pending[expected.RequestID] = expected
+	}
+	for len(pending) > 0 {
+		got, err := readResponse(reader)
+		if err != nil {
+			return fmt.Errorf("reading response: %v", err)
+		}
+		expected, ok := pending[got.requestID]
+		if !ok {
+			return fmt.Errorf("unexpected response for request %s: %s", got.requestID, got.content)
+		}
+		if err := expected.check(got); err != nil {
+			return fmt.Errorf("request %s: %v", got.requestID, err)
+		}
+		delete(pending, got.requestID)
+	}
+	return nil
+}
+
+func (e expectedFrame) check(got *response) error {
+	if got.version != e.Version {
+		return fmt.Errorf("version %d, want %d", got.version, e.Version)
+	}
+	if e.Flags != nil && got.flags != *e.Flags {
+		return fmt.Errorf("flags 0x%02x, want 0x%02x", got.flags, *e.Flags)
+	}
+	if e.Empty {
+		if len(got.content) != 0 {
+			return fmt.Errorf("content %s, want none", got.content)
+		}
+		return nil
+	}
+
+	var content interface{}
+	if err := json.Unmarshal(got.content, &content); err != nil {
+		return fmt.Errorf("content is not JSON: %v", err)
+	}
+	var want interface{}
+	wantJSON, _ := json.Marshal(e.Content)
+	json.Unmarshal(wantJSON, &want)
+	if !contains(content, want) {
+		return fmt.Errorf("content %s does not match %s", got.content, wantJSON)
+	}
+	return nil
+}
+
+// contains reports whether every field of want is in got with the same value.
+// Arrays match element by element, so got may hold more elements.
+func contains(got, want interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		gotMap, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if !contains(gotMap[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		gotSlice, ok := got.([]interface{})
+		if !ok || len(gotSlice) < len(want) {
+			return false
+		}
+		for i := range want {
+			if !contains(gotSlice[i], want[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(got, want)
+	}
+}
+
+// readResponse reads a version 1 or version 3 server frame
+func readResponse(r *bufio.Reader) (*response, error) {
+	header := make([]byte, 19)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0x55 || header[1] != 0x57 {
+		return nil, fmt.Errorf("invalid magic bytes % x", header[:2])
+	}
+	got := &response{version: header[2], requestID: hex.EncodeToString(header[3:])}
+
+	if got.version == 3 {
+		var flagsAndLen [3]byte
+		if _, err := io.ReadFull(r, flagsAndLen[:]); err != nil {
+			return nil, err
+		}
+		got.flags = flagsAndLen[0]
+		if _, err := r.Discard(int(binary.LittleEndian.Uint16(flagsAndLen[1:]))); err != nil {
+			return nil, err
+		}
+	}
+
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	got.content = make([]byte, binary.LittleEndian.Uint32(lenBuf[:]))
+	if _, err := io.ReadFull(r, got.content); err != nil {
+		return nil, err
+	}
+	return got, nil
+}
+
+// fuzzServer sends n mutations of the golden frames, each on its own
+// connection, and checks after every batch of them that a ping still works
+func fuzzServer(addr string, cases []goldenCase, n int, seed int64) error {
+	random := rand.New(rand.NewSource(seed))
+
+	var frames [][]byte
+	for _, c := range cases {
+		var frame []byte
+		for _, chunk := range c.Send {
+			data, _ := hex.DecodeString(chunk)
+			frame = append(frame, data...)
+		}
+		frames = append(frames, frame)
+	}
+
+	ping := goldenCase{Name: "ping", Send: cases[0].Send, Expect: cases[0].Expect}
+	for i := 0; i < n; i++ {
+		frame := mutate(random, frames[random.Intn(len(frames))])
+
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			return fmt.Errorf("iteration %d: %v", i, err)
+		}
+		conn.Write(frame)
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		io.Copy(io.Discard, conn)
+		conn.Close()
+
+		if i%100 == 99 || i == n-1 {
+			if err := run(addr, ping); err != nil {
+				return fmt.Errorf("server stopped answering after iteration %d, last frame %x: %v", i, frame, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mutate flips, drops, duplicates or truncates parts of frame
+func mutate(random *rand.Rand, frame []byte) []byte {
+	mutated := bytes.Clone(frame)
+	for edits := 1 + random.Intn(4); edits > 0 && len(mutated) > 0; edits-- {
+		i := random.Intn(len(mutated))
+		switch random.Intn(5) {
+		case 0:
+			mutated[i] ^= byte(1 << random.Intn(8))
+		case 1:
+			mutated[i] = byte(random.Intn(256))
+		case 2:
+			mutated = append(mutated[:i], mutated[i+1:]...)
+		case 3:
+			mutated = append(mutated[:i+1], mutated[i:]...)
+		case 4:
+			mutated = mutated[:i]
+		}
+	}
+	return mutated
+}
+
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// tcpgen generates the code that has to agree with the list of TCP methods
+// in internal/interface/tcp/methods.json: the request structs handlers decode
+// their content into, the registration of the handlers, and the typed stubs
+// of the Go client. Run it with go generate after editing the schema:
+//
+//	go generate ./internal/interface/tcp
+
+// method is a schema entry
+type method struct {
+	Method     string  `json:"method"`     // Name, with a "vN/" prefix for versions from 2 on
+	Handler    string  `json:"handler"`    // TCPHandler method serving it
+	RawContent bool    `json:"rawContent"` // Content is passed on as is, batch for instance
+	Request    []field `json:"request"`    // Fields of the request content, none for methods that ignore it
+}
+
+type field struct {
+	Name string `json:"name"`
+	JSON string `json:"json"`
+	Type string `json:"type"`
+}
+
+// GoName is the method name in Go, "login.verify" becomes "LoginVerify" and
+// version 2 of it, "v2/login.verify", LoginVerifyV2
+func (m method) GoName() string {
+	version, method, versioned := strings.Cut(m.Method, "/")
+	if !versioned {
+		method = m.Method
+	}
+	var name strings.Builder
+	for _, part := range strings.Split(method, ".") {
+		if part != "" {
+			name.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	if versioned {
+		name.WriteString(strings.ToUpper(version))
+	}
+	return name.String()
+}
+
+func (m method) RequestType() string {
+	return m.GoName() + "Request"
+}
+
+func main() {
+	schemaFile := flag.String("schema", "methods.json", "schema of the TCP methods")
+	serverFile := flag.String("server", "methods_gen.go", "output for the request structs and registration")
+	clientFile := flag.String("client", "client/client_gen.go", "output for the client stubs")
+	flag.Parse()
+
+	data, err := os.ReadFile(*schemaFile)
+	if err != nil {
+		log.Fatalf("Reading schema: %v", err)
+	}
+	var methods []method
+	if err := json.Unmarshal(data, &methods); err != nil {
+		log.Fatalf("Parsing schema: %v", err)
+	}
+	if err := validate(methods); err != nil {
+		log.Fatalf("Invalid schema: %v", err)
+	}
+
+	generate(*serverFile, serverTemplate, *schemaFile, methods)
+	generate(*clientFile, clientTemplate, *schemaFile, methods)
+}
+
+// validate catches the mistakes that would otherwise surface as compile
+// errors in generated code
+func validate(methods []method) error {
+	seen := make(map[string]bool)
+	for _, m := range methods {
+		if m.Method == "" || m.Handler == "" {
+			return fmt.Errorf("entry %+v needs a method and a handler", m)
+		}
+		if seen[m.Method] {
+			return fmt.Errorf("method %s is listed twice", m.Method)
+		}
+		seen[m.Method] = true
+		if version, name, versioned := strings.Cut(m.Method, "/"); versioned {
+			if n, err := strconv.Atoi(strings.TrimPrefix(version, "v")); !strings.HasPrefix(version, "v") || err != nil || n < 2 {
+				return fmt.Errorf("method %s needs a version prefix from v2 on", m.Method)
+			}
+			if !seen[name] {
+				return fmt.Errorf("method %s is listed before version 1 of %s", m.Method, name)
+			}
+		}
+		if m.RawContent && len(m.Request) > 0 {
+			return fmt.Errorf("method %s has raw content and request fields", m.Method)
+		}
+		for _, f := range m.Request {
+			if f.Name == "" || f.JSON == "" || f.Type == "" {
+				return fmt.Errorf("method %s has an incomplete field %+v", m.Method, f)
+			}
+		}
+	}
+	return nil
+}
+
+func generate(path string, tmpl *template.Template, schemaFile string, methods []method) {
+	var source bytes.Buffer
+	err := tmpl.Execute(&source, struct {
+		Schema  string
+		Methods []method
+	}{schemaFile, methods})
+	if err != nil {
+		log.Fatalf("Generating %s: %v", path, err)
+	}
+
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		log.Fatalf("Formatting %s: %v\n%s", path, err, source.Bytes())
+	}
+	if err := os.WriteFile(path, formatted, 0o644); err != nil {
+		log.Fatalf("Writing %s: %v", path, err)
+	}
+}
+
+var serverTemplate = template.Must(template.New("server").Parse(`// Code generated by tcpgen from {{.Schema}}. DO NOT EDIT.
+
+package tcp
+{{range .Methods}}{{if .Request}}
+// {{.RequestType}} is the content of "{{.Method}}" requests
+type {{.RequestType}} struct {
+{{- range .Request}}
+	{{.Name}} {{.Type}} ` + "`json:\"{{.JSON}}\"`" + `
+{{- end}}
+}
+{{end}}{{end}}
+// registerMethods registers the methods every TCPHandler serves
+func (h *TCPHandler) registerMethods() {
+{{- range .Methods}}
+	h.Register("{{.Method}}", h.{{.Handler}})
+{{- end}}
+}
+`))
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by tcpgen from {{.Schema}}. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	"user-service-new/internal/interface/tcp"
+)
+{{range .Methods}}
+// {{.GoName}} calls "{{.Method}}"
+{{- if .Request}}
+func (c *Client) {{.GoName}}(ctx context.Context, request *tcp.{{.RequestType}}) (json.RawMessage, error) {
+	return c.Call(ctx, "{{.Method}}", request)
+}
+{{- else if .RawContent}}
+func (c *Client) {{.GoName}}(ctx context.Context, content json.RawMessage) (json.RawMessage, error) {
+	return c.Call(ctx, "{{.Method}}", content)
+}
+{{- else}}
+func (c *Client) {{.GoName}}(ctx context.Context) (json.RawMessage, error) {
+	return c.Call(ctx, "{{.Method}}", struct{}{})
+}
+{{- end}}
+{{end}}`))
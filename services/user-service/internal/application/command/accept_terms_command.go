@@ -0,0 +1,17 @@
+package command
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AcceptTermsCommand struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Version int       `json:"version"`
+}
+
+type AcceptTermsCommandResult struct {
+	TermsVersion int       `json:"terms_version"`
+	AcceptedAt   time.Time `json:"accepted_at"`
+}
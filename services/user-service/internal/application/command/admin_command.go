@@ -0,0 +1,23 @@
+package command
+
+import "github.com/google/uuid"
+
+type ForceLogoutCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	// RequestedBy names the calling service, for the audit log
+	RequestedBy string `json:"requested_by"`
+}
+
+type ForceLogoutCommandResult struct {
+	RevokedSessions int `json:"revoked_sessions"`
+}
+
+type ResendVerificationCommand struct {
+	Email string `json:"email"`
+	// RequestedBy names the calling service, for the audit log
+	RequestedBy string `json:"requested_by"`
+}
+
+type ResendVerificationCommandResult struct {
+	Message string `json:"message"`
+}
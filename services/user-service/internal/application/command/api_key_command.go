@@ -0,0 +1,24 @@
+package command
+
+import "github.com/google/uuid"
+
+type IssueAPIKeyCommand struct {
+	Name string `json:"name"`
+}
+
+type RotateAPIKeyCommand struct {
+	Id uuid.UUID `json:"id"`
+}
+
+type RevokeAPIKeyCommand struct {
+	Id uuid.UUID `json:"id"`
+}
+
+// APIKeyCommandResult carries the plaintext key, which is only ever returned
+// at issue or rotation time.
+type APIKeyCommandResult struct {
+	Id     uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	Prefix string    `json:"prefix"`
+	Key    string    `json:"key,omitempty"`
+}
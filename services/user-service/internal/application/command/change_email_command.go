@@ -0,0 +1,24 @@
+package command
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/common"
+)
+
+type ChangeEmailCommand struct {
+	UserID   uuid.UUID `json:"user_id"`
+	NewEmail string    `json:"new_email"`
+}
+
+type ChangeEmailCommandResult struct {
+	Message string `json:"message"`
+}
+
+type ConfirmEmailChangeCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	OTP    string    `json:"otp"`
+}
+
+type ConfirmEmailChangeCommandResult struct {
+	Result *common.UserResult `json:"result"`
+}
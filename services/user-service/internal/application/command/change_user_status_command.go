@@ -0,0 +1,18 @@
+package command
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/common"
+)
+
+type ChangeUserStatusCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	Status string    `json:"status"`
+	Reason string    `json:"reason"`
+	// ChangedBy names the calling service, for the audit log
+	ChangedBy string `json:"changed_by"`
+}
+
+type ChangeUserStatusCommandResult struct {
+	User *common.UserResult `json:"user"`
+}
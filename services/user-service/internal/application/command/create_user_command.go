@@ -3,10 +3,12 @@ package command
 import "user-service-new/internal/application/common"
 
 type CreateUserCommand struct {
-	Username       string `json:"username"`
-	Email          string `json:"email"`
-	Password       string `json:"password"`
-	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// AcceptedTermsVersion must match the published terms when they are tracked
+	AcceptedTermsVersion int    `json:"accepted_terms_version,omitempty"`
+	IdempotencyKey       string `json:"idempotency_key,omitempty"`
 }
 
 type CreateUserCommandResult struct {
@@ -0,0 +1,33 @@
+package command
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/common"
+)
+
+type CreateGuestCommand struct {
+	DeviceFingerprint string `json:"device_fingerprint"`
+	IPAddress         string `json:"ip_address,omitempty"`
+}
+
+type UpgradeAccountCommand struct {
+	UserID               uuid.UUID `json:"user_id"`
+	Username             string    `json:"username,omitempty"`
+	Email                string    `json:"email"`
+	Password             string    `json:"password"`
+	AcceptedTermsVersion int       `json:"accepted_terms_version,omitempty"`
+}
+
+type UpgradeAccountCommandResult struct {
+	Message string `json:"message"`
+}
+
+type ConfirmAccountUpgradeCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	OTP    string    `json:"otp"`
+}
+
+type ConfirmAccountUpgradeCommandResult struct {
+	Token string             `json:"token"`
+	User  *common.UserResult `json:"user"`
+}
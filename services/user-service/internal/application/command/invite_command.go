@@ -0,0 +1,33 @@
+package command
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/common"
+)
+
+type CreateInviteCommand struct {
+	// InviterID is the inviting user, nil for invites issued by an operator
+	InviterID *uuid.UUID `json:"inviter_id,omitempty"`
+	Email     string     `json:"email"`
+}
+
+type CreateInviteCommandResult struct {
+	Id        uuid.UUID `json:"id"`
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RegisterWithInviteCommand struct {
+	Token                string `json:"token"`
+	Username             string `json:"username"`
+	Email                string `json:"email"`
+	Password             string `json:"password"`
+	AcceptedTermsVersion int    `json:"accepted_terms_version,omitempty"`
+}
+
+type RegisterWithInviteCommandResult struct {
+	Result *common.UserResult `json:"result"`
+}
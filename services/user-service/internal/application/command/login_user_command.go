@@ -3,11 +3,28 @@ package command
 import "user-service-new/internal/application/common"
 
 type LoginUserCommand struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username          string `json:"username"`
+	Password          string `json:"password"`
+	RecoveryCode      string `json:"recovery_code,omitempty"`
+	DeviceFingerprint string `json:"device_fingerprint,omitempty"`
+	UserAgent         string `json:"user_agent,omitempty"`
+	IPAddress         string `json:"ip_address,omitempty"`
+	Country           string `json:"country,omitempty"` // ISO country code resolved by the gateway
+	// AcceptedTermsVersion accepts newer terms as part of the login they block
+	AcceptedTermsVersion int `json:"accepted_terms_version,omitempty"`
 }
 
+// LoginUserCommandResult either carries a token, or a challenge the caller has
+// to complete with VerifyLoginCommand before a token is issued.
 type LoginUserCommandResult struct {
-	Token string             `json:"token"`
-	User  *common.UserResult `json:"user"`
+	Token             string             `json:"token,omitempty"`
+	User              *common.UserResult `json:"user,omitempty"`
+	ChallengeRequired bool               `json:"challenge_required,omitempty"`
+	ChallengeID       string             `json:"challenge_id,omitempty"`
+}
+
+type VerifyLoginCommand struct {
+	ChallengeID string `json:"challenge_id"`
+	OTP         string `json:"otp"`
+	TrustDevice bool   `json:"trust_device"`
 }
@@ -0,0 +1,14 @@
+package command
+
+import "github.com/google/uuid"
+
+type RegenerateRecoveryCodesCommand struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Password string    `json:"password"`
+}
+
+// RegenerateRecoveryCodesCommandResult carries the plaintext codes, which are
+// only ever returned at generation time.
+type RegenerateRecoveryCodesCommandResult struct {
+	Codes []string `json:"codes"`
+}
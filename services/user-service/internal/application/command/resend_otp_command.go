@@ -0,0 +1,10 @@
+package command
+
+type ResendOTPCommand struct {
+	Email string `json:"email"`
+}
+
+type ResendOTPCommandResult struct {
+	Message          string `json:"message"`
+	RemainingResends int    `json:"remaining_resends"`
+}
@@ -0,0 +1,12 @@
+package command
+
+import "github.com/google/uuid"
+
+type RevokeDeviceCommand struct {
+	UserID   uuid.UUID `json:"user_id"`
+	DeviceID uuid.UUID `json:"device_id"`
+}
+
+type RevokeDeviceCommandResult struct {
+	Message string `json:"message"`
+}
@@ -1,10 +1,12 @@
 package command
 
 type SendOTPCommand struct {
-	Username       string `json:"username"`
-	Email          string `json:"email"`
-	Password       string `json:"password"`
-	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Username             string `json:"username"`
+	Email                string `json:"email"`
+	Password             string `json:"password"`
+	Channel              string `json:"channel,omitempty"` // Only "email", the default: the OTP verifies the email
+	AcceptedTermsVersion int    `json:"accepted_terms_version,omitempty"`
+	IdempotencyKey       string `json:"idempotency_key,omitempty"`
 }
 
 type SendOTPCommandResult struct {
@@ -0,0 +1,21 @@
+package command
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/common"
+)
+
+// UpdateProfileCommand changes only the fields that are set
+type UpdateProfileCommand struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Username    *string   `json:"username"`
+	Email       *string   `json:"email"`
+	DisplayName *string   `json:"display_name"`
+	Bio         *string   `json:"bio"`
+}
+
+type UpdateProfileCommandResult struct {
+	Result *common.UserResult `json:"result"`
+	// EmailChangePending is set when a new email is waiting for OTP confirmation
+	EmailChangePending bool `json:"email_change_pending"`
+}
@@ -0,0 +1,13 @@
+package command
+
+import "github.com/google/uuid"
+
+type UploadAvatarCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	Image  []byte    `json:"image"`
+}
+
+type UploadAvatarCommandResult struct {
+	AvatarURL string            `json:"avatar_url"`
+	Variants  map[string]string `json:"variants"`
+}
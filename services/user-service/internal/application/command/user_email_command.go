@@ -0,0 +1,33 @@
+package command
+
+import (
+	"github.com/google/uuid"
+)
+
+type AddEmailCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
+
+type AddEmailCommandResult struct {
+	Message string `json:"message"`
+}
+
+type ConfirmEmailAdditionCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	OTP    string    `json:"otp"`
+}
+
+type SetPrimaryEmailCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
+
+type RemoveEmailCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
+
+type RemoveEmailCommandResult struct {
+	Message string `json:"message"`
+}
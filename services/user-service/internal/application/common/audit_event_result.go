@@ -0,0 +1,17 @@
+package common
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEventResult struct {
+	Id        uuid.UUID `json:"id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor,omitempty"` // Absent when the user acted
+	Detail    string    `json:"detail,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package common
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeviceResult struct {
+	Id         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	LastIP     string    `json:"last_ip"`
+	Country    string    `json:"country,omitempty"`
+	Trusted    bool      `json:"trusted"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
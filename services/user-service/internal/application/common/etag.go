@@ -0,0 +1,32 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// ETag identifies this version of the user for conditional reads. Every
+// write to the user moves updated_at, and with it the tag.
+func (u *UserResult) ETag() string {
+	sum := sha256.Sum256([]byte(u.Id.String() + "|" + u.UpdatedAt.UTC().Format(time.RFC3339Nano)))
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// ETagMatches reports whether an If-None-Match value names etag: "*", or a
+// comma separated list of tags compared weakly, as RFC 9110 asks for
+// If-None-Match. Clients may leave out the quotes.
+func ETagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		if tag != "" && tag == etag {
+			return true
+		}
+	}
+	return false
+}
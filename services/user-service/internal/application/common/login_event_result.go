@@ -0,0 +1,18 @@
+package common
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type LoginEventResult struct {
+	Id            uuid.UUID `json:"id"`
+	Method        string    `json:"method"`
+	Success       bool      `json:"success"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	IPAddress     string    `json:"ip_address,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	Country       string    `json:"country,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package common
+
+import "time"
+
+// SessionResult describes a token a user holds without revealing it
+type SessionResult struct {
+	// Id is a digest of the token, stable across listings
+	Id          string     `json:"id"`
+	TokenType   string     `json:"token_type"`
+	Scopes      []string   `json:"scopes,omitempty"`
+	DeviceBound bool       `json:"device_bound,omitempty"`
+	IssuedAt    *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
@@ -0,0 +1,10 @@
+package common
+
+import "time"
+
+type UserEmailResult struct {
+	Email      string     `json:"email"`
+	Primary    bool       `json:"primary"`
+	Verified   bool       `json:"verified"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
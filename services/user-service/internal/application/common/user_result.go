@@ -7,10 +7,17 @@ import (
 )
 
 type UserResult struct {
-	Id         uuid.UUID `json:"id"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
-	Username   string    `json:"username"`
-	Email      string    `json:"email"`
-	IsVerified bool      `json:"is_verified"`
+	Id           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	IsVerified   bool       `json:"is_verified"`
+	Status       string     `json:"status"`
+	DisplayName  string     `json:"display_name,omitempty"`
+	Bio          string     `json:"bio,omitempty"`
+	AvatarURL    string     `json:"avatar_url,omitempty"`
+	InvitedBy    *uuid.UUID `json:"invited_by,omitempty"`
+	TermsVersion int        `json:"terms_version,omitempty"`
+	IsGuest      bool       `json:"is_guest,omitempty"`
 }
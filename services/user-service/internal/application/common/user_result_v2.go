@@ -0,0 +1,57 @@
+package common
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserResultV2 is the user as version 2 of the API returns it: the profile
+// anyone may see apart from the account details only its owner may, with
+// camelCase names like the rest of the API
+type UserResultV2 struct {
+	Profile ProfileResult `json:"profile"`
+	Account AccountResult `json:"account"`
+}
+
+type ProfileResult struct {
+	Id          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	AvatarURL   string    `json:"avatarUrl,omitempty"`
+}
+
+type AccountResult struct {
+	Email        string     `json:"email"`
+	IsVerified   bool       `json:"isVerified"`
+	Status       string     `json:"status"`
+	IsGuest      bool       `json:"isGuest"`
+	TermsVersion int        `json:"termsVersion"`
+	InvitedBy    *uuid.UUID `json:"invitedBy,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// NewUserResultV2 reshapes a version 1 user result
+func NewUserResultV2(user *UserResult) *UserResultV2 {
+	return &UserResultV2{
+		Profile: ProfileResult{
+			Id:          user.Id,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			Bio:         user.Bio,
+			AvatarURL:   user.AvatarURL,
+		},
+		Account: AccountResult{
+			Email:        user.Email,
+			IsVerified:   user.IsVerified,
+			Status:       user.Status,
+			IsGuest:      user.IsGuest,
+			TermsVersion: user.TermsVersion,
+			InvitedBy:    user.InvitedBy,
+			CreatedAt:    user.CreatedAt,
+			UpdatedAt:    user.UpdatedAt,
+		},
+	}
+}
@@ -0,0 +1,15 @@
+package interfaces
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/query"
+)
+
+type AdminService interface {
+	ListUsers(listQuery *query.ListUsersQuery) (*query.ListUsersQueryResult, error)
+	ListSessions(userID uuid.UUID) (*query.SessionQueryListResult, error)
+	ForceLogout(logoutCommand *command.ForceLogoutCommand) (*command.ForceLogoutCommandResult, error)
+	ResendVerification(resendCommand *command.ResendVerificationCommand) (*command.ResendVerificationCommandResult, error)
+	GetAuditTrail(auditQuery *query.AuditTrailQuery) (*query.AuditTrailQueryResult, error)
+}
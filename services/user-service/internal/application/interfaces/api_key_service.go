@@ -0,0 +1,13 @@
+package interfaces
+
+import (
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/domain/entities"
+)
+
+type APIKeyService interface {
+	IssueAPIKey(issueCommand *command.IssueAPIKeyCommand) (*command.APIKeyCommandResult, error)
+	RotateAPIKey(rotateCommand *command.RotateAPIKeyCommand) (*command.APIKeyCommandResult, error)
+	RevokeAPIKey(revokeCommand *command.RevokeAPIKeyCommand) (*command.APIKeyCommandResult, error)
+	ValidateAPIKey(key string) (*entities.APIKey, error)
+}
@@ -0,0 +1,12 @@
+package interfaces
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/query"
+)
+
+type AvatarService interface {
+	UploadAvatar(uploadCommand *command.UploadAvatarCommand) (*command.UploadAvatarCommandResult, error)
+	GetAvatar(userID uuid.UUID, size int) (*query.AvatarQueryResult, error)
+}
@@ -0,0 +1,8 @@
+package interfaces
+
+import "user-service-new/internal/application/command"
+
+type InviteService interface {
+	CreateInvite(createCommand *command.CreateInviteCommand) (*command.CreateInviteCommandResult, error)
+	RegisterWithInvite(registerCommand *command.RegisterWithInviteCommand) (*command.RegisterWithInviteCommandResult, error)
+}
@@ -9,8 +9,29 @@ import (
 type UserService interface {
 	CreateUser(createCommand *command.CreateUserCommand) (*command.CreateUserCommandResult, error)
 	LoginUser(loginCommand *command.LoginUserCommand) (*command.LoginUserCommandResult, error)
+	VerifyLogin(verifyLoginCommand *command.VerifyLoginCommand) (*command.LoginUserCommandResult, error)
 	SendOTP(sendOTPCommand *command.SendOTPCommand) (*command.SendOTPCommandResult, error)
 	VerifyOTP(verifyOTPCommand *command.VerifyOTPCommand) (*command.VerifyOTPCommandResult, error)
+	ResendOTP(resendOTPCommand *command.ResendOTPCommand) (*command.ResendOTPCommandResult, error)
+	ChangeEmail(changeEmailCommand *command.ChangeEmailCommand) (*command.ChangeEmailCommandResult, error)
+	ConfirmEmailChange(confirmCommand *command.ConfirmEmailChangeCommand) (*command.ConfirmEmailChangeCommandResult, error)
+	AddEmail(addCommand *command.AddEmailCommand) (*command.AddEmailCommandResult, error)
+	ConfirmEmailAddition(confirmCommand *command.ConfirmEmailAdditionCommand) (*query.UserEmailQueryListResult, error)
+	ListEmails(userID uuid.UUID) (*query.UserEmailQueryListResult, error)
+	SetPrimaryEmail(primaryCommand *command.SetPrimaryEmailCommand) (*query.UserEmailQueryListResult, error)
+	RemoveEmail(removeCommand *command.RemoveEmailCommand) (*command.RemoveEmailCommandResult, error)
+	UpdateProfile(updateCommand *command.UpdateProfileCommand) (*command.UpdateProfileCommandResult, error)
+	RegenerateRecoveryCodes(regenerateCommand *command.RegenerateRecoveryCodesCommand) (*command.RegenerateRecoveryCodesCommandResult, error)
+	ListDevices(userID uuid.UUID) (*query.DeviceQueryListResult, error)
+	GetLoginHistory(historyQuery *query.LoginHistoryQuery) (*query.LoginHistoryQueryResult, error)
+	RevokeDevice(revokeCommand *command.RevokeDeviceCommand) (*command.RevokeDeviceCommandResult, error)
 	FindUserById(id uuid.UUID) (*query.UserQueryResult, error)
 	GetProfile(id uuid.UUID) (*query.UserQueryResult, error)
+	GetJWKS() (*query.JWKSQueryResult, error)
+	CreateGuest(createCommand *command.CreateGuestCommand) (*command.LoginUserCommandResult, error)
+	UpgradeAccount(upgradeCommand *command.UpgradeAccountCommand) (*command.UpgradeAccountCommandResult, error)
+	ConfirmAccountUpgrade(confirmCommand *command.ConfirmAccountUpgradeCommand) (*command.ConfirmAccountUpgradeCommandResult, error)
+	AcceptTerms(acceptCommand *command.AcceptTermsCommand) (*command.AcceptTermsCommandResult, error)
+	ChangeUserStatus(changeCommand *command.ChangeUserStatusCommand) (*command.ChangeUserStatusCommandResult, error)
+	IntrospectToken(introspectQuery *query.IntrospectTokenQuery) (*query.TokenIntrospectionQueryResult, error)
 }
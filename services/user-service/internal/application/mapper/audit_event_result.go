@@ -0,0 +1,18 @@
+package mapper
+
+import (
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/domain/entities"
+)
+
+func NewAuditEventResultFromEntity(event *entities.AuditEvent) *common.AuditEventResult {
+	return &common.AuditEventResult{
+		Id:        event.Id,
+		Action:    event.Action,
+		Actor:     event.Actor,
+		Detail:    event.Detail,
+		IPAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		CreatedAt: event.CreatedAt,
+	}
+}
@@ -0,0 +1,18 @@
+package mapper
+
+import (
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/domain/entities"
+)
+
+func NewDeviceResultFromEntity(device *entities.Device) *common.DeviceResult {
+	return &common.DeviceResult{
+		Id:         device.Id,
+		UserAgent:  device.UserAgent,
+		LastIP:     device.LastIP,
+		Country:    device.LastCountry,
+		Trusted:    device.Trusted,
+		CreatedAt:  device.CreatedAt,
+		LastSeenAt: device.LastSeenAt,
+	}
+}
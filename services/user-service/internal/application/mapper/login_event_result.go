@@ -0,0 +1,19 @@
+package mapper
+
+import (
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/domain/entities"
+)
+
+func NewLoginEventResultFromEntity(event *entities.LoginEvent) *common.LoginEventResult {
+	return &common.LoginEventResult{
+		Id:            event.Id,
+		Method:        event.Method,
+		Success:       event.Success,
+		FailureReason: event.FailureReason,
+		IPAddress:     event.IPAddress,
+		UserAgent:     event.UserAgent,
+		Country:       event.Country,
+		CreatedAt:     event.CreatedAt,
+	}
+}
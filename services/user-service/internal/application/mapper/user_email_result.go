@@ -0,0 +1,24 @@
+package mapper
+
+import (
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/domain/entities"
+)
+
+func NewUserEmailResultFromEntity(email *entities.UserEmail) *common.UserEmailResult {
+	verifiedAt := email.VerifiedAt
+	return &common.UserEmailResult{
+		Email:      email.Email,
+		Verified:   true,
+		VerifiedAt: &verifiedAt,
+	}
+}
+
+// NewPrimaryEmailResultFromUser describes the address stored on the user itself
+func NewPrimaryEmailResultFromUser(user *entities.User) *common.UserEmailResult {
+	return &common.UserEmailResult{
+		Email:    user.Email,
+		Primary:  true,
+		Verified: user.IsVerified,
+	}
+}
@@ -7,12 +7,19 @@ import (
 
 func NewUserResultFromEntity(user *entities.User) *common.UserResult {
 	return &common.UserResult{
-		Id:         user.Id,
-		CreatedAt:  user.CreatedAt,
-		UpdatedAt:  user.UpdatedAt,
-		Username:   user.Username,
-		Email:      user.Email,
-		IsVerified: user.IsVerified,
+		Id:           user.Id,
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+		Username:     user.Username,
+		Email:        user.Email,
+		IsVerified:   user.IsVerified,
+		Status:       string(user.Status),
+		DisplayName:  user.DisplayName,
+		Bio:          user.Bio,
+		AvatarURL:    user.AvatarURL,
+		InvitedBy:    user.InvitedBy,
+		TermsVersion: user.TermsVersion,
+		IsGuest:      user.IsGuest,
 	}
 }
 
@@ -0,0 +1,19 @@
+package query
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/common"
+)
+
+type AuditTrailQuery struct {
+	UserID uuid.UUID `json:"user_id"`
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+type AuditTrailQueryResult struct {
+	Result []*common.AuditEventResult `json:"result"`
+	// NextCursor continues the trail, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
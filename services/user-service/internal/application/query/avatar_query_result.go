@@ -0,0 +1,6 @@
+package query
+
+type AvatarQueryResult struct {
+	Data        []byte `json:"data"`
+	ContentType string `json:"content_type"`
+}
@@ -0,0 +1,7 @@
+package query
+
+import "user-service-new/internal/application/common"
+
+type DeviceQueryListResult struct {
+	Result []*common.DeviceResult `json:"result"`
+}
@@ -0,0 +1,24 @@
+package query
+
+import "github.com/google/uuid"
+
+type IntrospectTokenQuery struct {
+	Token string `json:"token"`
+	// DeviceFingerprint is required for device bound tokens, which are only
+	// active on the device they were issued to
+	DeviceFingerprint string `json:"device_fingerprint,omitempty"`
+}
+
+// TokenIntrospectionQueryResult follows the shape of RFC 7662 responses. Only
+// Active is set for tokens that fail verification.
+type TokenIntrospectionQueryResult struct {
+	Active      bool      `json:"active"`
+	Status      string    `json:"status,omitempty"`
+	UserID      uuid.UUID `json:"user_id,omitempty"`
+	Scopes      []string  `json:"scopes,omitempty"`
+	TokenType   string    `json:"token_type,omitempty"`
+	DeviceBound bool      `json:"device_bound,omitempty"`
+	IssuedAt    int64     `json:"iat,omitempty"`
+	ExpiresAt   int64     `json:"exp,omitempty"`
+	Revoked     bool      `json:"revoked"`
+}
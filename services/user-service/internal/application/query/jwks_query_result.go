@@ -0,0 +1,8 @@
+package query
+
+import "user-service-new/internal/application/common"
+
+// JWKSQueryResult serializes as a standard JWKS document
+type JWKSQueryResult struct {
+	Keys []*common.JWKResult `json:"keys"`
+}
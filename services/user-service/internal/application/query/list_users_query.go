@@ -0,0 +1,34 @@
+package query
+
+import (
+	"time"
+
+	"user-service-new/internal/application/common"
+)
+
+// User list sort orders, a leading "-" sorts descending
+const (
+	UserSortNewest       = "-created_at"
+	UserSortOldest       = "created_at"
+	UserSortUsername     = "username"
+	UserSortUsernameDesc = "-username"
+)
+
+type ListUsersQuery struct {
+	// Search matches usernames and emails containing it, empty for every user
+	Search        string     `json:"search"`
+	Verified      *bool      `json:"verified,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`  // Inclusive
+	CreatedBefore *time.Time `json:"created_before,omitempty"` // Exclusive
+	// Sort is one of the UserSort orders, UserSortNewest when empty
+	Sort string `json:"sort"`
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+type ListUsersQueryResult struct {
+	Result []*common.UserResult `json:"result"`
+	// NextCursor continues the listing, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,15 @@
+package query
+
+import (
+	"github.com/google/uuid"
+	"user-service-new/internal/application/common"
+)
+
+type LoginHistoryQuery struct {
+	UserID uuid.UUID `json:"user_id"`
+	Limit  int       `json:"limit"`
+}
+
+type LoginHistoryQueryResult struct {
+	Result []*common.LoginEventResult `json:"result"`
+}
@@ -0,0 +1,7 @@
+package query
+
+import "user-service-new/internal/application/common"
+
+type SessionQueryListResult struct {
+	Result []*common.SessionResult `json:"result"`
+}
@@ -0,0 +1,9 @@
+package query
+
+import "user-service-new/internal/application/common"
+
+// UserEmailQueryListResult lists the primary address first, then the
+// secondary ones in the order they were added
+type UserEmailQueryListResult struct {
+	Result []*common.UserEmailResult `json:"result"`
+}
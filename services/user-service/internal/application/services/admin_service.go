@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/application/interfaces"
+	"user-service-new/internal/application/mapper"
+	"user-service-new/internal/application/query"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+	"user-service-new/internal/infrastructure"
+)
+
+const (
+	defaultUserListLimit = 20
+	maxUserListLimit     = 100
+
+	defaultAuditTrailLimit = 50
+	maxAuditTrailLimit     = 200
+)
+
+// AdminService backs the user management tools of operators and support
+type AdminService struct {
+	userRepo     repositories.UserRepository
+	auditRepo    repositories.AuditRepository
+	redisService *infrastructure.RedisService
+	jwtService   *infrastructure.JWTService
+	otpService   *infrastructure.OTPService
+	events       *infrastructure.EventBus
+}
+
+func NewAdminService(userRepo repositories.UserRepository, auditRepo repositories.AuditRepository, redisService *infrastructure.RedisService, jwtService *infrastructure.JWTService, otpService *infrastructure.OTPService, events *infrastructure.EventBus) interfaces.AdminService {
+	return &AdminService{
+		userRepo:     userRepo,
+		auditRepo:    auditRepo,
+		redisService: redisService,
+		jwtService:   jwtService,
+		otpService:   otpService,
+		events:       events,
+	}
+}
+
+// ListUsers returns a page of the users matching the query's filters. Pages
+// are keyset pages: each ends with a cursor to the next, so users added in
+// between neither repeat nor shift users across pages.
+func (s *AdminService) ListUsers(listQuery *query.ListUsersQuery) (*query.ListUsersQueryResult, error) {
+	limit := listQuery.Limit
+	if limit < 1 {
+		limit = defaultUserListLimit
+	}
+	if limit > maxUserListLimit {
+		return nil, entities.ErrInvalidInput.Errorf("limit must be at most %d", maxUserListLimit)
+	}
+
+	sortOrder := listQuery.Sort
+	if sortOrder == "" {
+		sortOrder = query.UserSortNewest
+	}
+	sort := repositories.UserSort{
+		Field:      repositories.UserSortField(strings.TrimPrefix(sortOrder, "-")),
+		Descending: strings.HasPrefix(sortOrder, "-"),
+	}
+	if sort.Field != repositories.UserSortCreatedAt && sort.Field != repositories.UserSortUsername {
+		return nil, entities.ErrInvalidInput.Errorf("unsupported sort: %s", listQuery.Sort)
+	}
+
+	page := repositories.UserPage{Limit: limit + 1} // One more tells whether a next page exists
+	if listQuery.Cursor != "" {
+		cursor, err := decodeUserCursor(listQuery.Cursor, sortOrder)
+		if err != nil {
+			return nil, err
+		}
+		page.After = cursor
+	}
+
+	filter := repositories.UserFilter{
+		Search:        listQuery.Search,
+		Verified:      listQuery.Verified,
+		CreatedAfter:  listQuery.CreatedAfter,
+		CreatedBefore: listQuery.CreatedBefore,
+	}
+	users, err := s.userRepo.ListUsers(context.Background(), filter, page, sort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	result := &query.ListUsersQueryResult{Result: make([]*common.UserResult, 0, limit)}
+	if len(users) > limit {
+		users = users[:limit]
+		result.NextCursor = encodeUserCursor(repositories.CursorOf(users[limit-1]), sortOrder)
+	}
+	for _, user := range users {
+		result.Result = append(result.Result, mapper.NewUserResultFromEntity(user))
+	}
+	return result, nil
+}
+
+// userCursor is the opaque cursor clients get. It names the sort order it
+// was made for, since a position in one order means nothing in another.
+type userCursor struct {
+	Sort      string    `json:"s"`
+	CreatedAt time.Time `json:"c"`
+	Username  string    `json:"u"`
+	Id        uuid.UUID `json:"i"`
+}
+
+func encodeUserCursor(cursor *repositories.UserCursor, sortOrder string) string {
+	data, _ := json.Marshal(userCursor{
+		Sort:      sortOrder,
+		CreatedAt: cursor.CreatedAt,
+		Username:  cursor.Username,
+		Id:        cursor.Id,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(value, sortOrder string) (*repositories.UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid cursor")
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid cursor")
+	}
+	if cursor.Sort != sortOrder {
+		return nil, entities.ErrInvalidInput.Errorf("invalid cursor: it belongs to a listing with another sort order")
+	}
+	return &repositories.UserCursor{CreatedAt: cursor.CreatedAt, Username: cursor.Username, Id: cursor.Id}, nil
+}
+
+// ListSessions describes the unexpired tokens of a user
+func (s *AdminService) ListSessions(userID uuid.UUID) (*query.SessionQueryListResult, error) {
+	ctx := context.Background()
+
+	user, err := s.userRepo.FindById(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	result := &query.SessionQueryListResult{Result: make([]*common.SessionResult, 0, len(user.Tokens))}
+	for _, token := range user.Tokens {
+		if session := s.describeSession(ctx, token); session != nil {
+			result.Result = append(result.Result, session)
+		}
+	}
+	return result, nil
+}
+
+// describeSession returns nil for tokens that expired or no longer verify
+func (s *AdminService) describeSession(ctx context.Context, token string) *common.SessionResult {
+	digest := sha256.Sum256([]byte(token))
+	session := &common.SessionResult{Id: hex.EncodeToString(digest[:8])}
+
+	if strings.Count(token, ".") != 2 {
+		// Opaque tokens only live as long as their Redis key
+		ttl, err := s.redisService.GetTokenTTL(ctx, token)
+		if err != nil || ttl <= 0 {
+			return nil
+		}
+		session.TokenType = "opaque"
+		session.Scopes = []string{"user"}
+		session.ExpiresAt = time.Now().Add(ttl)
+		return session
+	}
+
+	claims, err := s.jwtService.ParseToken(token)
+	if err != nil {
+		return nil
+	}
+	session.TokenType = "jwt"
+	session.Scopes = claims.Scopes
+	session.DeviceBound = claims.DeviceHash != ""
+	session.ExpiresAt = claims.ExpiresAt
+	if !claims.IssuedAt.IsZero() {
+		session.IssuedAt = &claims.IssuedAt
+	}
+	return session
+}
+
+// ForceLogout revokes every token of a user. Connections authenticated with
+// them lose the user through the token revoked event.
+func (s *AdminService) ForceLogout(logoutCommand *command.ForceLogoutCommand) (*command.ForceLogoutCommandResult, error) {
+	ctx := context.Background()
+
+	user, err := s.userRepo.FindById(logoutCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	tokens, err := s.userRepo.ClearTokens(ctx, user.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear tokens: %w", err)
+	}
+	if err := s.redisService.DeleteTokens(ctx, tokens); err != nil {
+		return nil, fmt.Errorf("failed to drop cached tokens: %w", err)
+	}
+	s.events.Publish(entities.NewUserEvent(entities.UserEventTokenRevoked, user.Id, "admin_logout"))
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(user.Id, entities.AuditForcedLogout, valueOrUnknown(logoutCommand.RequestedBy), fmt.Sprintf("%d sessions", len(tokens)), "", ""))
+
+	log.Printf("User %s logged out of %d sessions by %s", user.Id, len(tokens), valueOrUnknown(logoutCommand.RequestedBy))
+
+	return &command.ForceLogoutCommandResult{RevokedSessions: len(tokens)}, nil
+}
+
+// ResendVerification emails a pending registration its OTP again. Unlike
+// ResendOTP it skips the cooldown and resend cap meant for the registrant.
+func (s *AdminService) ResendVerification(resendCommand *command.ResendVerificationCommand) (*command.ResendVerificationCommandResult, error) {
+	ctx := context.Background()
+
+	email := strings.TrimSpace(resendCommand.Email)
+	if email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("email is required")
+	}
+
+	user, err := s.redisService.GetUserData(ctx, email)
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return nil, entities.ErrRegistrationExpired
+		}
+		return nil, fmt.Errorf("failed to retrieve user data: %w", err)
+	}
+	if user == nil {
+		return nil, entities.ErrRegistrationExpired
+	}
+
+	otp, err := s.redisService.GetOTP(ctx, email)
+	if err != nil && err.Error() != "redis: nil" {
+		return nil, fmt.Errorf("redis error: %w", err)
+	}
+	if otp == "" {
+		otp = s.otpService.GenerateOTP(ctx)
+		if err := s.redisService.SetOTP(ctx, email, otp, s.otpService.OTP_EXPIRY); err != nil {
+			return nil, fmt.Errorf("failed to cache OTP: %w", err)
+		}
+	}
+
+	if err := s.otpService.SendOTPVia(ctx, infrastructure.OTPChannelEmail, email, otp); err != nil {
+		return nil, fmt.Errorf("failed to send OTP: %w", err)
+	}
+
+	log.Printf("Verification OTP for the registration of %s resent by %s", user.Username, valueOrUnknown(resendCommand.RequestedBy))
+
+	return &command.ResendVerificationCommandResult{Message: "Verification OTP resent successfully"}, nil
+}
+
+// GetAuditTrail returns a page of the user's audit log, newest first. The
+// trail outlives the account, so users that were deleted can be looked up.
+func (s *AdminService) GetAuditTrail(auditQuery *query.AuditTrailQuery) (*query.AuditTrailQueryResult, error) {
+	limit := auditQuery.Limit
+	if limit < 1 {
+		limit = defaultAuditTrailLimit
+	}
+	if limit > maxAuditTrailLimit {
+		return nil, entities.ErrInvalidInput.Errorf("limit must be at most %d", maxAuditTrailLimit)
+	}
+
+	var before *repositories.AuditCursor
+	if auditQuery.Cursor != "" {
+		cursor, err := decodeAuditCursor(auditQuery.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		before = cursor
+	}
+
+	// One more tells whether a next page exists
+	events, err := s.auditRepo.ListByUser(context.Background(), auditQuery.UserID, before, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	result := &query.AuditTrailQueryResult{Result: make([]*common.AuditEventResult, 0, limit)}
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[limit-1]
+		result.NextCursor = encodeAuditCursor(&repositories.AuditCursor{CreatedAt: last.CreatedAt, Id: last.Id})
+	}
+	for _, event := range events {
+		result.Result = append(result.Result, mapper.NewAuditEventResultFromEntity(event))
+	}
+	return result, nil
+}
+
+// auditCursor is the opaque cursor of audit trail pages
+type auditCursor struct {
+	CreatedAt time.Time `json:"c"`
+	Id        uuid.UUID `json:"i"`
+}
+
+func encodeAuditCursor(cursor *repositories.AuditCursor) string {
+	data, _ := json.Marshal(auditCursor{CreatedAt: cursor.CreatedAt, Id: cursor.Id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeAuditCursor(value string) (*repositories.AuditCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid cursor")
+	}
+	var cursor auditCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid cursor")
+	}
+	return &repositories.AuditCursor{CreatedAt: cursor.CreatedAt, Id: cursor.Id}, nil
+}
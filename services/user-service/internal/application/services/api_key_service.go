@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/interfaces"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+	"user-service-new/internal/infrastructure"
+)
+
+// apiKeyCacheTTL bounds how long a validated key is trusted without going back
+// to Postgres, and therefore how long a revoked key can keep working on
+// instances the revocation did not reach.
+const apiKeyCacheTTL = time.Minute
+
+// apiKeyCacheName names the cache of validated keys in cache invalidations
+const apiKeyCacheName = "api_key"
+
+type cachedAPIKey struct {
+	key       *entities.APIKey
+	expiresAt time.Time
+}
+
+type APIKeyService struct {
+	apiKeyRepo    repositories.APIKeyRepository
+	cache         map[string]cachedAPIKey
+	cacheMutex    sync.RWMutex
+	invalidations *infrastructure.CacheInvalidator // Nil when revocations stay on this instance
+}
+
+// NewAPIKeyService builds the service. With invalidations, revoking a key
+// evicts it from the cache of every instance, not just this one.
+func NewAPIKeyService(apiKeyRepo repositories.APIKeyRepository, invalidations *infrastructure.CacheInvalidator) interfaces.APIKeyService {
+	s := &APIKeyService{
+		apiKeyRepo:    apiKeyRepo,
+		cache:         make(map[string]cachedAPIKey),
+		invalidations: invalidations,
+	}
+	if invalidations != nil {
+		invalidations.Handle(apiKeyCacheName, s.evictCachedKey)
+	}
+	return s
+}
+
+func (s *APIKeyService) IssueAPIKey(issueCommand *command.IssueAPIKeyCommand) (*command.APIKeyCommandResult, error) {
+	ctx := context.Background()
+
+	key, plaintext, err := entities.NewAPIKey(strings.TrimSpace(issueCommand.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	createdKey, err := s.apiKeyRepo.Create(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Issued API key %s for %s", createdKey.Prefix, createdKey.Name)
+
+	return &command.APIKeyCommandResult{
+		Id:     createdKey.Id,
+		Name:   createdKey.Name,
+		Prefix: createdKey.Prefix,
+		Key:    plaintext,
+	}, nil
+}
+
+func (s *APIKeyService) RotateAPIKey(rotateCommand *command.RotateAPIKeyCommand) (*command.APIKeyCommandResult, error) {
+	ctx := context.Background()
+
+	existingKey, err := s.apiKeyRepo.FindById(ctx, rotateCommand.Id)
+	if err != nil {
+		return nil, err
+	}
+	if existingKey == nil {
+		return nil, entities.ErrNotFound.Errorf("api key not found")
+	}
+	if existingKey.IsRevoked() {
+		return nil, entities.ErrConflict.Errorf("api key already revoked")
+	}
+
+	// Issue the replacement first so the caller is never left without a key
+	result, err := s.IssueAPIKey(&command.IssueAPIKeyCommand{Name: existingKey.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.revoke(ctx, existingKey); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *APIKeyService) RevokeAPIKey(revokeCommand *command.RevokeAPIKeyCommand) (*command.APIKeyCommandResult, error) {
+	ctx := context.Background()
+
+	existingKey, err := s.apiKeyRepo.FindById(ctx, revokeCommand.Id)
+	if err != nil {
+		return nil, err
+	}
+	if existingKey == nil {
+		return nil, entities.ErrNotFound.Errorf("api key not found")
+	}
+
+	revokedKey, err := s.revoke(ctx, existingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &command.APIKeyCommandResult{
+		Id:     revokedKey.Id,
+		Name:   revokedKey.Name,
+		Prefix: revokedKey.Prefix,
+	}, nil
+}
+
+func (s *APIKeyService) ValidateAPIKey(key string) (*entities.APIKey, error) {
+	if key == "" {
+		return nil, entities.ErrUnauthorized.Errorf("api key required")
+	}
+
+	keyHash := entities.HashAPIKey(key)
+
+	s.cacheMutex.RLock()
+	cached, ok := s.cache[keyHash]
+	s.cacheMutex.RUnlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.key, nil
+	}
+
+	apiKey, err := s.apiKeyRepo.FindByHash(context.Background(), keyHash)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil || apiKey.IsRevoked() {
+		return nil, entities.ErrUnauthorized.Errorf("invalid api key")
+	}
+
+	s.cacheMutex.Lock()
+	s.cache[keyHash] = cachedAPIKey{key: apiKey, expiresAt: time.Now().Add(apiKeyCacheTTL)}
+	s.cacheMutex.Unlock()
+
+	return apiKey, nil
+}
+
+func (s *APIKeyService) revoke(ctx context.Context, key *entities.APIKey) (*entities.APIKey, error) {
+	key.Revoke()
+
+	revokedKey, err := s.apiKeyRepo.Update(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the cached entry so this instance stops accepting the key
+	// immediately, and the others as soon as the invalidation reaches them
+	s.evictCachedKey(key.KeyHash)
+	if s.invalidations != nil {
+		s.invalidations.Invalidate(apiKeyCacheName, key.KeyHash)
+	}
+
+	log.Printf("Revoked API key %s for %s", revokedKey.Prefix, revokedKey.Name)
+	return revokedKey, nil
+}
+
+// evictCachedKey drops the cached key with keyHash, or every cached key when
+// keyHash is empty
+func (s *APIKeyService) evictCachedKey(keyHash string) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	if keyHash == "" {
+		s.cache = make(map[string]cachedAPIKey)
+		return
+	}
+	delete(s.cache, keyHash)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/interfaces"
+	"user-service-new/internal/application/query"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+	"user-service-new/internal/infrastructure"
+)
+
+// AvatarSizes are the square variants stored for every upload, in pixels
+var AvatarSizes = []int{64, 128, 256, 512}
+
+// defaultAvatarSize is the variant recorded as the user's avatar URL
+const defaultAvatarSize = 256
+
+type AvatarService struct {
+	userRepo      repositories.UserRepository
+	redisService  *infrastructure.RedisService
+	storage       infrastructure.ObjectStorage
+	publicBaseURL string
+	maxBytes      int
+	events        *infrastructure.EventBus
+}
+
+// NewAvatarService stores avatars in storage and builds their URLs from
+// publicBaseURL, the address the HTTP server is reachable at
+func NewAvatarService(userRepo repositories.UserRepository, redisService *infrastructure.RedisService, storage infrastructure.ObjectStorage, events *infrastructure.EventBus) interfaces.AvatarService {
+	return &AvatarService{
+		userRepo:      userRepo,
+		redisService:  redisService,
+		storage:       storage,
+		publicBaseURL: strings.TrimRight(infrastructure.GetEnvAsString("AVATAR_PUBLIC_BASE_URL", "http://localhost:8080"), "/"),
+		maxBytes:      infrastructure.GetEnvAsInt("AVATAR_MAX_BYTES", 5*1024*1024),
+		events:        events,
+	}
+}
+
+func (s *AvatarService) UploadAvatar(uploadCommand *command.UploadAvatarCommand) (*command.UploadAvatarCommandResult, error) {
+	ctx := context.Background()
+
+	if len(uploadCommand.Image) == 0 {
+		return nil, entities.ErrInvalidInput.Errorf("image is required")
+	}
+	if len(uploadCommand.Image) > s.maxBytes {
+		return nil, entities.ErrInvalidInput.Errorf("image must be at most %d bytes", s.maxBytes)
+	}
+
+	user, err := s.userRepo.FindById(uploadCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	img, err := infrastructure.DecodeImage(uploadCommand.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every upload gets its own prefix so cached URLs of the old avatar never
+	// serve the new image
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	keyPrefix := fmt.Sprintf("avatars/%s/%s", user.Id, version)
+
+	for _, size := range AvatarSizes {
+		data, err := infrastructure.EncodePNG(infrastructure.ResizeSquare(img, size))
+		if err != nil {
+			return nil, err
+		}
+		if err := s.storage.Put(ctx, avatarVariantKey(keyPrefix, size), "image/png", data); err != nil {
+			s.deleteVariants(ctx, keyPrefix)
+			return nil, fmt.Errorf("failed to store avatar: %w", err)
+		}
+	}
+
+	previousKey := user.SetAvatar(keyPrefix, s.variantURL(user.Id, version, defaultAvatarSize))
+
+	validatedUser, err := entities.NewValidatedUser(user)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.userRepo.Update(validatedUser); err != nil {
+		s.deleteVariants(ctx, keyPrefix)
+		return nil, err
+	}
+
+	if err := s.redisService.DeleteProfile(ctx, user.Id.String()); err != nil {
+		log.Printf("Failed to drop cached profile for user %s: %v", user.Id, err)
+	}
+	s.events.Publish(entities.NewUserEvent(entities.UserEventProfileChanged, user.Id, "avatar_changed"))
+	if previousKey != "" {
+		s.deleteVariants(ctx, previousKey)
+	}
+
+	result := command.UploadAvatarCommandResult{
+		AvatarURL: user.AvatarURL,
+		Variants:  make(map[string]string, len(AvatarSizes)),
+	}
+	for _, size := range AvatarSizes {
+		result.Variants[strconv.Itoa(size)] = s.variantURL(user.Id, version, size)
+	}
+
+	return &result, nil
+}
+
+// GetAvatar returns the stored variant closest to size without going below it
+func (s *AvatarService) GetAvatar(userID uuid.UUID, size int) (*query.AvatarQueryResult, error) {
+	user, err := s.userRepo.FindById(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.AvatarKey == "" {
+		return nil, infrastructure.ErrObjectNotFound
+	}
+
+	data, contentType, err := s.storage.Get(context.Background(), avatarVariantKey(user.AvatarKey, closestAvatarSize(size)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &query.AvatarQueryResult{Data: data, ContentType: contentType}, nil
+}
+
+func (s *AvatarService) variantURL(userID uuid.UUID, version string, size int) string {
+	return fmt.Sprintf("%s/avatars/%s/%d?v=%s", s.publicBaseURL, userID, size, version)
+}
+
+func (s *AvatarService) deleteVariants(ctx context.Context, keyPrefix string) {
+	for _, size := range AvatarSizes {
+		if err := s.storage.Delete(ctx, avatarVariantKey(keyPrefix, size)); err != nil {
+			log.Printf("Failed to delete avatar %s: %v", avatarVariantKey(keyPrefix, size), err)
+		}
+	}
+}
+
+func avatarVariantKey(keyPrefix string, size int) string {
+	return fmt.Sprintf("%s/%d.png", keyPrefix, size)
+}
+
+func closestAvatarSize(size int) int {
+	for _, candidate := range AvatarSizes {
+		if candidate >= size {
+			return candidate
+		}
+	}
+	return AvatarSizes[len(AvatarSizes)-1]
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/interfaces"
+	"user-service-new/internal/application/mapper"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+	"user-service-new/internal/infrastructure"
+)
+
+type InviteService struct {
+	userRepo      repositories.UserRepository
+	inviteRepo    repositories.InviteRepository
+	notifier      *infrastructure.EmailNotifier
+	rateLimiter   *infrastructure.RateLimiter
+	breachChecker *infrastructure.BreachChecker
+	events        *infrastructure.EventBus
+	inviteTTL     time.Duration
+	linkBaseURL   string
+}
+
+func NewInviteService(userRepo repositories.UserRepository, inviteRepo repositories.InviteRepository, notifier *infrastructure.EmailNotifier, rateLimiter *infrastructure.RateLimiter, breachChecker *infrastructure.BreachChecker, events *infrastructure.EventBus) interfaces.InviteService {
+	return &InviteService{
+		userRepo:      userRepo,
+		inviteRepo:    inviteRepo,
+		notifier:      notifier,
+		rateLimiter:   rateLimiter,
+		breachChecker: breachChecker,
+		events:        events,
+		inviteTTL:     infrastructure.GetEnvAsDuration("INVITE_TTL", 7*24*time.Hour),
+		linkBaseURL:   infrastructure.GetEnvAsString("INVITE_LINK_BASE_URL", ""),
+	}
+}
+
+// CreateInvite issues an invite and emails the token to the invitee
+func (s *InviteService) CreateInvite(createCommand *command.CreateInviteCommand) (*command.CreateInviteCommandResult, error) {
+	ctx := context.Background()
+
+	email := strings.TrimSpace(createCommand.Email)
+	if email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("email is required")
+	}
+
+	inviterName := "An administrator"
+	if createCommand.InviterID != nil {
+		inviter, err := s.userRepo.FindById(*createCommand.InviterID)
+		if err != nil {
+			return nil, err
+		}
+		if inviter == nil {
+			return nil, entities.ErrNotFound.Errorf("inviter not found")
+		}
+		if !inviter.IsActive() {
+			return nil, entities.ErrAccountInactive.Errorf("inviter account is not active")
+		}
+		if !s.rateLimiter.Allow("invite:" + inviter.Id.String()) {
+			return nil, s.rateLimiter.Exceeded("invite:"+inviter.Id.String(), "too many invites, please try again later")
+		}
+		inviterName = inviter.Username
+	}
+
+	existingUser, err := s.userRepo.FindByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		return nil, entities.ErrEmailExists
+	}
+
+	invite, token, err := entities.NewInvite(email, createCommand.InviterID, s.inviteTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.inviteRepo.Create(ctx, invite); err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s invited you to create an account.\n\nYour invite code is: %s\n", inviterName, token)
+	if s.linkBaseURL != "" {
+		text += fmt.Sprintf("\nOr follow this link: %s?invite=%s\n", s.linkBaseURL, token)
+	}
+	text += fmt.Sprintf("\nThe invite expires on %s.", invite.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"))
+
+	if err := s.notifier.Send(ctx, email, "You're invited", text); err != nil {
+		log.Printf("Failed to send invite %s: %v", invite.Id, err)
+	}
+
+	return &command.CreateInviteCommandResult{
+		Id:        invite.Id,
+		Email:     invite.Email,
+		Token:     token,
+		ExpiresAt: invite.ExpiresAt,
+	}, nil
+}
+
+// RegisterWithInvite creates an already verified user, skipping the email OTP
+func (s *InviteService) RegisterWithInvite(registerCommand *command.RegisterWithInviteCommand) (*command.RegisterWithInviteCommandResult, error) {
+	ctx := context.Background()
+
+	invite, err := s.inviteRepo.FindByTokenHash(ctx, entities.HashInviteToken(registerCommand.Token))
+	if err != nil {
+		return nil, err
+	}
+	if invite == nil || invite.IsAccepted() || invite.IsExpired() {
+		return nil, entities.ErrExpired.Errorf("invite is invalid or has expired")
+	}
+	if !invite.MatchesEmail(registerCommand.Email) {
+		return nil, entities.ErrInvalidInput.Errorf("email does not match the invite")
+	}
+
+	// The invited address was chosen by an admin, only the username is checked
+	if err := entities.CheckRegistrationDenylist(registerCommand.Username, ""); err != nil {
+		return nil, err
+	}
+
+	existingUser, err := s.userRepo.FindByUsername(registerCommand.Username)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		return nil, entities.ErrUsernameExists
+	}
+	existingUser, err = s.userRepo.FindByEmail(invite.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		return nil, entities.ErrEmailExists
+	}
+
+	newUser := entities.NewUser(registerCommand.Username, invite.Email, registerCommand.Password)
+	newUser.AcceptInvite(invite.InviterId)
+	if err := acceptRegistrationTerms(newUser, registerCommand.AcceptedTermsVersion); err != nil {
+		return nil, err
+	}
+
+	validatedUser, err := entities.NewValidatedUser(newUser)
+	if err != nil {
+		return nil, err
+	}
+	if err := entities.CheckPasswordPolicy(registerCommand.Password); err != nil {
+		return nil, err
+	}
+	if err := checkPasswordBreach(s.breachChecker, registerCommand.Password); err != nil {
+		return nil, err
+	}
+
+	// Claim the invite before creating the user so it can only be spent once
+	claimed, err := s.inviteRepo.MarkAccepted(ctx, invite.Id, newUser.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, entities.ErrExpired.Errorf("invite is invalid or has expired")
+	}
+
+	createdUser, err := s.userRepo.Create(validatedUser)
+	if err != nil {
+		if releaseErr := s.inviteRepo.Release(ctx, invite.Id); releaseErr != nil {
+			log.Printf("Failed to release invite %s: %v", invite.Id, releaseErr)
+		}
+		if errors.Is(err, entities.ErrUserExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to register user: %w", err)
+	}
+	s.events.Publish(entities.NewUserEvent(entities.UserEventCreated, createdUser.Id, "invite"))
+	s.events.Publish(entities.NewUserEvent(entities.UserEventVerified, createdUser.Id, "invite"))
+
+	return &command.RegisterWithInviteCommandResult{
+		Result: mapper.NewUserResultFromEntity(createdUser),
+	}, nil
+}
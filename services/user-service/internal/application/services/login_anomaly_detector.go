@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+// LoginSignals describes where a login attempt comes from
+type LoginSignals struct {
+	DeviceFingerprint string
+	IPAddress         string
+	Country           string
+	UserAgent         string
+}
+
+// LoginAnomaly lists why a login looks unlike the user's previous ones
+type LoginAnomaly struct {
+	Reasons []string
+}
+
+// LoginAnomalyDetector compares a login against the devices the user has
+// logged in from before
+type LoginAnomalyDetector struct {
+	deviceRepo repositories.DeviceRepository
+}
+
+func NewLoginAnomalyDetector(deviceRepo repositories.DeviceRepository) *LoginAnomalyDetector {
+	return &LoginAnomalyDetector{deviceRepo: deviceRepo}
+}
+
+// Evaluate returns nil when the login matches the user's history. A user
+// without any history is never flagged, there is nothing to compare against.
+func (d *LoginAnomalyDetector) Evaluate(ctx context.Context, userID uuid.UUID, signals LoginSignals) (*LoginAnomaly, error) {
+	history, err := d.deviceRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, nil
+	}
+
+	fingerprintHash := ""
+	if signals.DeviceFingerprint != "" {
+		fingerprintHash = entities.HashDeviceFingerprint(signals.DeviceFingerprint)
+	}
+
+	var knownDevice, knownIP, knownCountry, knownAgent bool
+	for _, device := range history {
+		if fingerprintHash != "" && device.FingerprintHash == fingerprintHash {
+			knownDevice = true
+		}
+		if signals.IPAddress != "" && device.LastIP == signals.IPAddress {
+			knownIP = true
+		}
+		if signals.Country != "" && strings.EqualFold(device.LastCountry, signals.Country) {
+			knownCountry = true
+		}
+		if signals.UserAgent != "" && userAgentFamily(device.UserAgent) == userAgentFamily(signals.UserAgent) {
+			knownAgent = true
+		}
+	}
+
+	// A recognized device is trusted to roam between networks
+	if knownDevice {
+		return nil, nil
+	}
+
+	var reasons []string
+	if signals.Country != "" && !knownCountry {
+		reasons = append(reasons, "new country: "+signals.Country)
+	}
+	if signals.IPAddress != "" && !knownIP && signals.UserAgent != "" && !knownAgent {
+		reasons = append(reasons, "new network and browser")
+	}
+
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+	return &LoginAnomaly{Reasons: reasons}, nil
+}
+
+// userAgentFamily reduces a user agent to its product token, so browser
+// updates do not look like a new client
+func userAgentFamily(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	for _, family := range []string{"edg", "opr", "chrome", "firefox", "safari", "okhttp", "curl"} {
+		if strings.Contains(userAgent, family) {
+			return family
+		}
+	}
+	if i := strings.IndexAny(userAgent, "/ "); i > 0 {
+		return userAgent[:i]
+	}
+	return userAgent
+}
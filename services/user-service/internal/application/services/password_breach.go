@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"log"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/infrastructure"
+)
+
+// checkPasswordBreach rejects passwords found in known breaches. The check
+// fails open: an unreachable API or an open circuit never blocks a signup.
+func checkPasswordBreach(checker *infrastructure.BreachChecker, password string) error {
+	if !checker.Enabled() {
+		return nil
+	}
+
+	breached, err := checker.IsBreached(context.Background(), password)
+	if err != nil {
+		log.Printf("Skipping password breach check: %v", err)
+		return nil
+	}
+	if breached {
+		return &entities.ValidationError{
+			Field: "password",
+			Violations: []entities.PolicyViolation{{
+				Code:    entities.ViolationBreached,
+				Message: "has appeared in a data breach, choose a different password",
+			}},
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+	"user-service-new/internal/infrastructure"
+)
+
+// purgeLockKey lets a single instance run each purge when several are deployed
+const purgeLockKey = "purge:unverified:lock"
+
+// PurgeScheduler periodically deletes accounts that never completed
+// verification and pending registrations that were stored without an expiry,
+// and finishes or rolls back the registration sagas that were interrupted
+type PurgeScheduler struct {
+	userRepo      repositories.UserRepository
+	redisService  *infrastructure.RedisService
+	events        *infrastructure.EventBus
+	interval      time.Duration
+	unverifiedTTL time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewPurgeScheduler(userRepo repositories.UserRepository, redisService *infrastructure.RedisService, events *infrastructure.EventBus) *PurgeScheduler {
+	scheduler := &PurgeScheduler{
+		userRepo:      userRepo,
+		redisService:  redisService,
+		events:        events,
+		interval:      infrastructure.GetEnvAsDuration("PURGE_INTERVAL", time.Hour),
+		unverifiedTTL: infrastructure.GetEnvAsDuration("PURGE_UNVERIFIED_AFTER", 7*24*time.Hour),
+		stop:          make(chan struct{}),
+	}
+
+	log.Printf("Purge Scheduler Config - Interval: %v, Unverified After: %v", scheduler.interval, scheduler.unverifiedTTL)
+	return scheduler
+}
+
+// Start runs a purge every interval until Stop is called. An interval of 0
+// disables the scheduler.
+func (p *PurgeScheduler) Start() {
+	if p.interval <= 0 {
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.RunOnce(context.Background())
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the scheduler and waits for a running purge to finish
+func (p *PurgeScheduler) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// RunOnce performs a single purge. Errors are logged, the next run retries.
+func (p *PurgeScheduler) RunOnce(ctx context.Context) {
+	// Hold the lock for most of the interval so other instances skip this round
+	acquired, err := p.redisService.AcquireCooldown(ctx, purgeLockKey, p.interval/2)
+	if err != nil {
+		log.Printf("Failed to acquire purge lock: %v", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	resumed, compensated, err := recoverSagas(ctx, p.redisService, registrationRecoverySteps(p.redisService, p.events))
+	if err != nil {
+		log.Printf("Failed to list interrupted sagas: %v", err)
+	}
+	if resumed > 0 || compensated > 0 {
+		log.Printf("Recovered interrupted registrations: %d resumed, %d rolled back", resumed, compensated)
+	}
+
+	cutoff := time.Now().Add(-p.unverifiedTTL)
+	users, err := p.userRepo.DeleteUnverifiedBefore(ctx, cutoff)
+	if err != nil {
+		log.Printf("Failed to purge unverified users: %v", err)
+	}
+	for _, userID := range users {
+		p.events.Publish(entities.NewUserEvent(entities.UserEventDeleted, userID, "unverified_purged"))
+	}
+
+	keys := 0
+	// The keys SendOTP leaves behind
+	cache := p.redisService.Cache()
+	for _, pattern := range []string{cache.UserData.Pattern(), cache.OTP.Pattern()} {
+		deleted, err := p.redisService.DeleteKeysWithoutExpiry(ctx, pattern)
+		keys += deleted
+		if err != nil {
+			log.Printf("Failed to purge pending registrations matching %s: %v", pattern, err)
+		}
+	}
+
+	if len(users) > 0 || keys > 0 {
+		log.Printf("Purged %d unverified users created before %s and %d stale pending registration keys", len(users), cutoff.Format(time.RFC3339), keys)
+	}
+}
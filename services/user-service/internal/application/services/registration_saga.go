@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+	"user-service-new/internal/infrastructure"
+)
+
+// The sagas of registration by OTP, each keyed by the registering email
+const (
+	// registrationStartSaga stores a pending registration and sends its OTP
+	registrationStartSaga = "registration.start"
+	// registrationCompleteSaga turns a verified pending registration into a user
+	registrationCompleteSaga = "registration.complete"
+)
+
+// registrationStart stores a pending registration, then issues and sends its
+// OTP. Sending is last, as it cannot be undone: when it fails the stored
+// registration and OTP are removed instead of lingering without a way to
+// verify them.
+type registrationStart struct {
+	redisService *infrastructure.RedisService
+	otpService   *infrastructure.OTPService
+	email        string
+	user         *entities.User // The pending registration
+
+	otp string // Issued by issue_otp, kept out of the persisted state
+}
+
+func (r *registrationStart) steps() []sagaStep {
+	cache := r.redisService.Cache()
+	return []sagaStep{
+		{
+			name: "store_registration",
+			run: func(ctx context.Context, data map[string]string) error {
+				if err := r.redisService.SetUserData(ctx, r.email, r.user, cache.UserData.TTL); err != nil {
+					return fmt.Errorf("failed to cache user data: %w", err)
+				}
+				return nil
+			},
+			compensate: func(ctx context.Context, data map[string]string) error {
+				return r.redisService.DeleteUserData(ctx, r.email)
+			},
+		},
+		{
+			name: "issue_otp",
+			run: func(ctx context.Context, data map[string]string) error {
+				// Resend the outstanding OTP rather than invalidate it
+				otp, err := r.redisService.GetOTP(ctx, r.email)
+				if err != nil && err.Error() != "redis: nil" {
+					return fmt.Errorf("redis error: %w", err)
+				}
+				if otp == "" {
+					otp = r.otpService.GenerateOTP(ctx)
+					if err := r.redisService.SetOTP(ctx, r.email, otp, cache.OTP.TTL); err != nil {
+						return fmt.Errorf("failed to cache OTP: %w", err)
+					}
+				}
+				r.otp = otp
+				return nil
+			},
+			compensate: func(ctx context.Context, data map[string]string) error {
+				return r.redisService.DeleteOTP(ctx, r.email)
+			},
+		},
+		{
+			name: "send_otp",
+			run: func(ctx context.Context, data map[string]string) error {
+				if err := r.otpService.SendOTPVia(ctx, infrastructure.OTPChannelEmail, r.email, r.otp); err != nil {
+					return fmt.Errorf("failed to send OTP: %w", err)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// registrationComplete creates the user of a verified registration, then
+// announces it and clears the pending registration. Creating the user is
+// the pivot: after it the saga only goes forward, so a user is never left
+// without its events or with a registration that could be verified again.
+type registrationComplete struct {
+	redisService *infrastructure.RedisService
+	userRepo     repositories.UserRepository
+	events       *infrastructure.EventBus
+	email        string
+	user         *entities.ValidatedUser // The verified registration
+
+	createdUser *entities.User // Set by create_user
+}
+
+func (r *registrationComplete) steps() []sagaStep {
+	return []sagaStep{
+		{
+			name:  "create_user",
+			pivot: true,
+			run: func(ctx context.Context, data map[string]string) error {
+				createdUser, err := r.userRepo.Create(r.user)
+				if errors.Is(err, entities.ErrUserExists) {
+					return err
+				}
+				if err != nil {
+					return fmt.Errorf("failed to register user: %w", err)
+				}
+				r.createdUser = createdUser
+				data["userID"] = createdUser.Id.String()
+				return nil
+			},
+		},
+		{
+			name: "publish_events",
+			run: func(ctx context.Context, data map[string]string) error {
+				userID, err := uuid.Parse(data["userID"])
+				if err != nil {
+					return fmt.Errorf("saga lost the created user: %w", err)
+				}
+				r.events.Publish(entities.NewUserEvent(entities.UserEventCreated, userID, "registration"))
+				r.events.Publish(entities.NewUserEvent(entities.UserEventVerified, userID, "registration_otp"))
+				return nil
+			},
+		},
+		{
+			name: "clear_registration",
+			run: func(ctx context.Context, data map[string]string) error {
+				writes := r.redisService.Batch()
+				writes.DeleteOTP(ctx, r.email)
+				writes.DeleteUserData(ctx, r.email)
+				if err := writes.Exec(ctx); err != nil {
+					return fmt.Errorf("failed to clear pending registration: %w", err)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// registrationRecoverySteps rebuilds the steps of an interrupted
+// registration saga. Recovery only compensates registrationStart and
+// resumes registrationComplete after its pivot, so the steps need no more
+// than the stores.
+func registrationRecoverySteps(redisService *infrastructure.RedisService, events *infrastructure.EventBus) func(state *infrastructure.SagaState) []sagaStep {
+	return func(state *infrastructure.SagaState) []sagaStep {
+		switch state.Name {
+		case registrationStartSaga:
+			return (&registrationStart{redisService: redisService, email: state.ID}).steps()
+		case registrationCompleteSaga:
+			return (&registrationComplete{redisService: redisService, events: events, email: state.ID}).steps()
+		}
+		return nil
+	}
+}
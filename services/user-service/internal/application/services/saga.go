@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"user-service-new/internal/infrastructure"
+)
+
+// Saga statuses
+const (
+	sagaRunning      = "running"
+	sagaCompensating = "compensating"
+)
+
+const (
+	// sagaStateTTL keeps the state of an interrupted saga until recovery
+	// gets to it
+	sagaStateTTL = 24 * time.Hour
+	// sagaStaleAfter is how long a saga goes without progress before
+	// recovery takes it over from the request that ran it
+	sagaStaleAfter = time.Minute
+)
+
+// sagaStep is a step of a saga. Steps hand what later steps and
+// compensations need through data, which is persisted with the saga.
+type sagaStep struct {
+	name string
+	run  func(ctx context.Context, data map[string]string) error
+	// compensate undoes run, nil when there is nothing to undo. It may run
+	// for a step that failed or never took effect, so it has to tolerate
+	// that.
+	compensate func(ctx context.Context, data map[string]string) error
+	// pivot marks the step after which the saga only goes forward: a later
+	// step that fails is retried by recovery rather than compensated
+	pivot bool
+}
+
+// saga runs steps in order and persists its progress before each one. When
+// a step fails before the pivot, the steps taken are compensated in reverse
+// order. An interrupted saga is finished or rolled back by recoverSagas.
+type saga struct {
+	store *infrastructure.RedisService
+	state *infrastructure.SagaState
+	steps []sagaStep
+}
+
+func newSaga(store *infrastructure.RedisService, name, id string, steps []sagaStep) *saga {
+	return &saga{
+		store: store,
+		state: &infrastructure.SagaState{Name: name, ID: id, Status: sagaRunning, Data: make(map[string]string)},
+		steps: steps,
+	}
+}
+
+// execute runs the steps not done yet. It returns the error of the failed
+// step once its saga is compensated. Past the pivot a failed step is left to
+// recovery and execute succeeds, since the saga's outcome is settled.
+func (s *saga) execute(ctx context.Context) error {
+	for _, step := range s.steps {
+		if s.completed(step.name) {
+			continue
+		}
+
+		s.state.Current = step.name
+		if err := s.save(ctx); err != nil {
+			// Without the state a crash could not be recovered, so do not go on
+			s.compensate(ctx, err)
+			return fmt.Errorf("failed to save %s saga: %w", s.state.Name, err)
+		}
+
+		if err := step.run(ctx, s.state.Data); err != nil {
+			if s.pastPivot() {
+				s.state.Error = err.Error()
+				s.save(ctx)
+				log.Printf("Step %s of %s saga for %s failed, recovery will retry it: %v", step.name, s.state.Name, s.state.ID, err)
+				return nil
+			}
+			s.compensate(ctx, err)
+			return err
+		}
+		s.state.Completed = append(s.state.Completed, step.name)
+		s.state.Current = ""
+	}
+
+	if err := s.store.DeleteSagaState(ctx, s.state.Name, s.state.ID); err != nil {
+		log.Printf("Failed to delete state of finished %s saga for %s: %v", s.state.Name, s.state.ID, err)
+	}
+	return nil
+}
+
+// compensate undoes the steps taken, and the one that was running, in
+// reverse order. A compensation that fails leaves the saga to recovery.
+func (s *saga) compensate(ctx context.Context, cause error) {
+	s.state.Status = sagaCompensating
+	s.state.Error = cause.Error()
+
+	undo := s.state.Completed
+	if s.state.Current != "" {
+		undo = append(undo, s.state.Current)
+	}
+	for i := len(undo) - 1; i >= 0; i-- {
+		step := s.step(undo[i])
+		if step == nil || step.compensate == nil {
+			continue
+		}
+		if err := step.compensate(ctx, s.state.Data); err != nil {
+			log.Printf("Failed to compensate step %s of %s saga for %s, recovery will retry it: %v", step.name, s.state.Name, s.state.ID, err)
+			s.state.Completed = undo[:i+1]
+			s.state.Current = ""
+			s.save(ctx)
+			return
+		}
+	}
+
+	if err := s.store.DeleteSagaState(ctx, s.state.Name, s.state.ID); err != nil {
+		log.Printf("Failed to delete state of compensated %s saga for %s: %v", s.state.Name, s.state.ID, err)
+	}
+	log.Printf("Rolled back %s saga for %s: %v", s.state.Name, s.state.ID, cause)
+}
+
+func (s *saga) save(ctx context.Context) error {
+	s.state.UpdatedAt = time.Now()
+	return s.store.SetSagaState(ctx, s.state, sagaStateTTL)
+}
+
+func (s *saga) completed(name string) bool {
+	for _, done := range s.state.Completed {
+		if done == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pastPivot reports whether a pivot step is done
+func (s *saga) pastPivot() bool {
+	for _, step := range s.steps {
+		if step.pivot && s.completed(step.name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *saga) step(name string) *sagaStep {
+	for i := range s.steps {
+		if s.steps[i].name == name {
+			return &s.steps[i]
+		}
+	}
+	return nil
+}
+
+// recoverSagas finishes or rolls back the sagas that made no progress for
+// sagaStaleAfter: those past their pivot are resumed, the others
+// compensated. steps rebuilds the steps of a saga from its state, nil for
+// sagas it does not know.
+func recoverSagas(ctx context.Context, store *infrastructure.RedisService, steps func(state *infrastructure.SagaState) []sagaStep) (resumed, compensated int, err error) {
+	states, err := store.ListSagaStates(ctx)
+	for _, state := range states {
+		if time.Since(state.UpdatedAt) < sagaStaleAfter {
+			continue
+		}
+		sagaSteps := steps(state)
+		if sagaSteps == nil {
+			continue
+		}
+		if state.Data == nil {
+			state.Data = make(map[string]string)
+		}
+
+		s := &saga{store: store, state: state, steps: sagaSteps}
+		if state.Status == sagaRunning && s.pastPivot() {
+			s.execute(ctx)
+			resumed++
+			continue
+		}
+		cause := fmt.Errorf("interrupted")
+		if state.Error != "" {
+			cause = fmt.Errorf("%s", state.Error)
+		}
+		s.compensate(ctx, cause)
+		compensated++
+	}
+	return resumed, compensated, err
+}
@@ -6,10 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
 	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/common"
 	"user-service-new/internal/application/interfaces"
 	"user-service-new/internal/application/mapper"
 	"user-service-new/internal/application/query"
@@ -18,30 +21,76 @@ import (
 	"user-service-new/internal/infrastructure"
 )
 
+// otpExpiryWarning is how long before a login challenge's OTP expires the
+// user is told, see warnOTPExpiring
+const otpExpiryWarning = time.Minute
+
 type UserService struct {
-	userRepo        repositories.UserRepository
-	idempotencyRepo repositories.IdempotencyRepository
-	redisService    *infrastructure.RedisService
-	jwtService      *infrastructure.JWTService
-	otpService      *infrastructure.OTPService
-	rateLimiter     *infrastructure.RateLimiter
+	userRepo         repositories.UserRepository
+	idempotencyRepo  repositories.IdempotencyRepository
+	unitOfWork       repositories.UnitOfWork
+	recoveryCodeRepo repositories.RecoveryCodeRepository
+	deviceRepo       repositories.DeviceRepository
+	loginHistoryRepo repositories.LoginHistoryRepository
+	userEmailRepo    repositories.UserEmailRepository
+	auditRepo        repositories.AuditRepository
+	redisService     *infrastructure.RedisService
+	jwtService       *infrastructure.JWTService
+	otpService       *infrastructure.OTPService
+	rateLimiter      *infrastructure.RateLimiter
+	notifier         *infrastructure.EmailNotifier
+	breachChecker    *infrastructure.BreachChecker
+	events           *infrastructure.EventBus
+	anomalyDetector  *LoginAnomalyDetector
+
+	// loginOTPForUntrustedDevices challenges logins from unrecognized devices with an OTP
+	loginOTPForUntrustedDevices bool
+	// loginStepUpOnAnomaly challenges logins the anomaly detector flags with an OTP
+	loginStepUpOnAnomaly bool
+
+	// profileLoads and credentialLoads coalesce concurrent lookups of the same
+	// profile or login identifier into a single database query
+	profileLoads    singleflight.Group
+	credentialLoads singleflight.Group
 }
 
 func NewUserService(
 	userRepo repositories.UserRepository,
 	idempotencyRepo repositories.IdempotencyRepository,
+	unitOfWork repositories.UnitOfWork,
+	recoveryCodeRepo repositories.RecoveryCodeRepository,
+	deviceRepo repositories.DeviceRepository,
+	loginHistoryRepo repositories.LoginHistoryRepository,
+	userEmailRepo repositories.UserEmailRepository,
+	auditRepo repositories.AuditRepository,
 	redisService *infrastructure.RedisService,
 	jwtService *infrastructure.JWTService,
 	otpService *infrastructure.OTPService,
 	rateLimiter *infrastructure.RateLimiter,
+	notifier *infrastructure.EmailNotifier,
+	breachChecker *infrastructure.BreachChecker,
+	events *infrastructure.EventBus,
 ) interfaces.UserService {
 	return &UserService{
-		userRepo:        userRepo,
-		idempotencyRepo: idempotencyRepo,
-		redisService:    redisService,
-		jwtService:      jwtService,
-		otpService:      otpService,
-		rateLimiter:     rateLimiter,
+		userRepo:         userRepo,
+		idempotencyRepo:  idempotencyRepo,
+		unitOfWork:       unitOfWork,
+		recoveryCodeRepo: recoveryCodeRepo,
+		deviceRepo:       deviceRepo,
+		loginHistoryRepo: loginHistoryRepo,
+		userEmailRepo:    userEmailRepo,
+		auditRepo:        auditRepo,
+		redisService:     redisService,
+		jwtService:       jwtService,
+		otpService:       otpService,
+		rateLimiter:      rateLimiter,
+		notifier:         notifier,
+		breachChecker:    breachChecker,
+		events:           events,
+		anomalyDetector:  NewLoginAnomalyDetector(deviceRepo),
+
+		loginOTPForUntrustedDevices: infrastructure.GetEnvAsBool("LOGIN_OTP_UNTRUSTED_DEVICES", false),
+		loginStepUpOnAnomaly:        infrastructure.GetEnvAsBool("LOGIN_STEP_UP_ON_ANOMALY", false),
 	}
 }
 
@@ -72,13 +121,19 @@ func (s *UserService) CreateUser(createCommand *command.CreateUserCommand) (*com
 		idempotencyRecord = entities.NewIdempotencyRecord(createCommand.IdempotencyKey, string(requestJSON))
 	}
 
-	// Check if user already exists
+	// Reserved usernames and disposable email providers are refused outright
+	if err := entities.CheckRegistrationDenylist(createCommand.Username, createCommand.Email); err != nil {
+		return nil, err
+	}
+
+	// Check if user already exists. Concurrent registrations can both get
+	// past this; the loser's insert then fails with the same errors.
 	existingUser, err := s.userRepo.FindByUsername(createCommand.Username)
 	if err != nil {
 		return nil, err
 	}
 	if existingUser != nil {
-		return nil, errors.New("username already exists")
+		return nil, entities.ErrUsernameExists
 	}
 
 	existingUser, err = s.userRepo.FindByEmail(createCommand.Email)
@@ -86,74 +141,464 @@ func (s *UserService) CreateUser(createCommand *command.CreateUserCommand) (*com
 		return nil, err
 	}
 	if existingUser != nil {
-		return nil, errors.New("email already exists")
+		return nil, entities.ErrEmailExists
 	}
 
 	// Create new user
 	newUser := entities.NewUser(createCommand.Username, createCommand.Email, createCommand.Password)
+	if err := acceptRegistrationTerms(newUser, createCommand.AcceptedTermsVersion); err != nil {
+		return nil, err
+	}
 	validatedUser, err := entities.NewValidatedUser(newUser)
 	if err != nil {
 		return nil, err
 	}
-
-	createdUser, err := s.userRepo.Create(validatedUser)
-	if err != nil {
+	if err := entities.CheckPasswordPolicy(createCommand.Password); err != nil {
 		return nil, err
 	}
-
-	result := command.CreateUserCommandResult{
-		Result: mapper.NewUserResultFromEntity(createdUser),
+	if err := checkPasswordBreach(s.breachChecker, createCommand.Password); err != nil {
+		return nil, err
 	}
 
-	// Store response in idempotency record
-	if idempotencyRecord != nil {
-		responseJSON, _ := json.Marshal(result)
-		idempotencyRecord.SetResponse(string(responseJSON), 200)
-		_, err = s.idempotencyRepo.Create(ctx, idempotencyRecord)
+	// The user and the idempotency record holding the response commit
+	// together, so a retry either finds both or creates both
+	var result command.CreateUserCommandResult
+	var createdUser *entities.User
+	err = s.unitOfWork.Do(ctx, func(tx repositories.Transaction) error {
+		user, err := tx.Users().Create(validatedUser)
 		if err != nil {
-			log.Printf("Failed to store idempotency record: %v", err)
+			return err
+		}
+		result = command.CreateUserCommandResult{
+			Result: mapper.NewUserResultFromEntity(user),
+		}
+
+		if idempotencyRecord != nil {
+			responseJSON, _ := json.Marshal(result)
+			idempotencyRecord.SetResponse(string(responseJSON), 200)
+			if _, err := tx.IdempotencyRecords().Create(ctx, idempotencyRecord); err != nil {
+				return fmt.Errorf("failed to store idempotency record: %w", err)
+			}
 		}
+		createdUser = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The transaction went around the user repository, which forgets the
+	// username and email were free on its own writes only
+	if err := s.redisService.ForgetMissingUser(ctx, createdUser.Username, createdUser.Email); err != nil {
+		log.Printf("Failed to forget missing user records: %v", err)
 	}
+	s.events.Publish(entities.NewUserEvent(entities.UserEventCreated, createdUser.Id, "registration"))
 
 	return &result, nil
 }
 
 func (s *UserService) LoginUser(loginCommand *command.LoginUserCommand) (*command.LoginUserCommandResult, error) {
+	ctx := context.Background()
 
 	// Find user by credentials
-	user, err := s.userRepo.FindByCredentials(loginCommand.Username)
+	user, err := s.findByCredentials(loginCommand.Username)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, errors.New("invalid credentials")
+		return nil, entities.ErrInvalidCredentials
+	}
+
+	method := entities.LoginMethodPassword
+	if loginCommand.RecoveryCode != "" {
+		method = entities.LoginMethodRecoveryCode
 	}
 
 	// Check password
 	if err := user.CheckPassword(loginCommand.Password); err != nil {
-		return nil, errors.New("invalid credentials")
+		s.recordLogin(ctx, user.Id, method, false, "invalid_password", loginCommand.IPAddress, loginCommand.UserAgent, loginCommand.Country)
+		return nil, entities.ErrInvalidCredentials
+	}
+
+	// Only tell the account holder about the status, after the password matched
+	if err := checkUserStatus(user); err != nil {
+		s.recordLogin(ctx, user.Id, method, false, "account_"+string(user.Status), loginCommand.IPAddress, loginCommand.UserAgent, loginCommand.Country)
+		return nil, err
+	}
+
+	// Newer terms can be accepted as part of the login they would block
+	if loginCommand.AcceptedTermsVersion != 0 {
+		if err := s.acceptTerms(ctx, user, loginCommand.AcceptedTermsVersion); err != nil {
+			return nil, err
+		}
+	}
+	if err := user.CheckTermsAccepted(); err != nil {
+		s.recordLogin(ctx, user.Id, method, false, "terms_not_accepted", loginCommand.IPAddress, loginCommand.UserAgent, loginCommand.Country)
+		return nil, err
+	}
+
+	// Move the stored hash to the configured algorithm while the plaintext is at hand
+	if user.PasswordNeedsRehash() {
+		go s.rehashPassword(user.Id, user.Password, loginCommand.Password)
+	}
+
+	// A recovery code, when presented, must be valid and is spent on use
+	if loginCommand.RecoveryCode != "" {
+		if !s.rateLimiter.Allow("recovery:" + user.Id.String()) {
+			return nil, s.rateLimiter.Exceeded("recovery:"+user.Id.String(), "too many recovery attempts, please try again later")
+		}
+
+		code, err := s.recoveryCodeRepo.ConsumeUnused(ctx, user.Id, entities.HashRecoveryCode(loginCommand.RecoveryCode))
+		if err != nil {
+			return nil, err
+		}
+		if code == nil {
+			s.recordLogin(ctx, user.Id, method, false, "invalid_recovery_code", loginCommand.IPAddress, loginCommand.UserAgent, loginCommand.Country)
+			return nil, entities.ErrInvalidRecoveryCode
+		}
+	}
+
+	// Look up the device the login comes from
+	var device *entities.Device
+	if loginCommand.DeviceFingerprint != "" {
+		device, err = s.deviceRepo.FindByFingerprint(ctx, user.Id, entities.HashDeviceFingerprint(loginCommand.DeviceFingerprint))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Compare the login against the user's history before it gets recorded
+	anomaly, err := s.anomalyDetector.Evaluate(ctx, user.Id, LoginSignals{
+		DeviceFingerprint: loginCommand.DeviceFingerprint,
+		IPAddress:         loginCommand.IPAddress,
+		Country:           loginCommand.Country,
+		UserAgent:         loginCommand.UserAgent,
+	})
+	if err != nil {
+		log.Printf("Failed to evaluate login anomaly: %v", err)
+	}
+	if anomaly != nil {
+		s.notifySuspiciousLogin(user, loginCommand, anomaly)
+	}
+
+	// Unrecognized devices and suspicious logins have to pass an OTP challenge,
+	// unless a recovery code already stood in for it
+	if loginCommand.RecoveryCode == "" {
+		untrusted := s.loginOTPForUntrustedDevices && (device == nil || !device.Trusted)
+		suspicious := s.loginStepUpOnAnomaly && anomaly != nil
+		if untrusted || suspicious {
+			return s.startLoginChallenge(ctx, user, loginCommand)
+		}
+	}
+
+	s.recordDevice(ctx, user.Id, device, loginCommand.DeviceFingerprint, loginCommand.UserAgent, loginCommand.IPAddress, loginCommand.Country, false)
+	s.recordLogin(ctx, user.Id, method, true, "", loginCommand.IPAddress, loginCommand.UserAgent, loginCommand.Country)
+
+	return s.issueLoginToken(user, nil)
+}
+
+func (s *UserService) VerifyLogin(verifyLoginCommand *command.VerifyLoginCommand) (*command.LoginUserCommandResult, error) {
+	ctx := context.Background()
+
+	// Apply rate limiting for challenge attempts
+	if !s.rateLimiter.Allow("verify_login:" + verifyLoginCommand.ChallengeID) {
+		return nil, s.rateLimiter.Exceeded("verify_login:"+verifyLoginCommand.ChallengeID, "too many verification attempts, please try again later")
+	}
+
+	// The challenge is single use: it is taken before the OTP is checked, so
+	// a wrong OTP ends it too and concurrent attempts cannot both succeed
+	challenge, err := s.redisService.TakeLoginChallenge(ctx, verifyLoginCommand.ChallengeID)
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return nil, entities.ErrExpired.Errorf("login challenge expired or not found")
+		}
+		return nil, fmt.Errorf("failed to retrieve login challenge: %w", err)
+	}
+
+	isValid, err := s.otpService.VerifyOTP(ctx, "", verifyLoginCommand.OTP, challenge.OTP)
+	if err != nil {
+		return nil, entities.ErrInvalidOTP.Errorf("OTP verification failed: %w", err)
 	}
 
-	// Generate JWT token
-	token, err := s.jwtService.GenerateToken(user.Id.String())
+	userID, err := uuid.Parse(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !isValid {
+		s.recordLogin(ctx, userID, entities.LoginMethodOTPChallenge, false, "invalid_otp", challenge.IPAddress, challenge.UserAgent, challenge.Country)
+		return nil, entities.ErrInvalidOTP
+	}
+	user, err := s.userRepo.FindById(userID)
 	if err != nil {
 		return nil, err
 	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+	if err := checkUserStatus(user); err != nil {
+		return nil, err
+	}
 
-	// Store token in Redis and update database concurrently
-	go func() {
-		// Store in Redis for quick validation
-		redisErr := s.redisService.SetToken(context.Background(), token, user.Id.String(), time.Hour*24)
-		if redisErr != nil {
-			log.Printf("Failed to store token in Redis: %v", redisErr)
+	var device *entities.Device
+	if challenge.DeviceFingerprint != "" {
+		device, err = s.deviceRepo.FindByFingerprint(ctx, user.Id, entities.HashDeviceFingerprint(challenge.DeviceFingerprint))
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.recordDevice(ctx, user.Id, device, challenge.DeviceFingerprint, challenge.UserAgent, challenge.IPAddress, challenge.Country, verifyLoginCommand.TrustDevice)
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(user.Id, entities.AuditOTPVerified, "", "login_challenge", challenge.IPAddress, challenge.UserAgent))
+	s.recordLogin(ctx, user.Id, entities.LoginMethodOTPChallenge, true, "", challenge.IPAddress, challenge.UserAgent, challenge.Country)
+
+	return s.issueLoginToken(user, nil)
+}
+
+// startLoginChallenge parks a login behind an OTP sent to the account email
+func (s *UserService) startLoginChallenge(ctx context.Context, user *entities.User, loginCommand *command.LoginUserCommand) (*command.LoginUserCommandResult, error) {
+	if !s.rateLimiter.Allow("login_challenge:" + user.Id.String()) {
+		return nil, s.rateLimiter.Exceeded("login_challenge:"+user.Id.String(), "too many login attempts, please try again later")
+	}
+
+	challengeID := uuid.New().String()
+	otp := s.otpService.GenerateOTP(ctx)
+
+	challenge := &infrastructure.LoginChallenge{
+		UserID:            user.Id.String(),
+		OTP:               otp,
+		DeviceFingerprint: loginCommand.DeviceFingerprint,
+		UserAgent:         loginCommand.UserAgent,
+		IPAddress:         loginCommand.IPAddress,
+		Country:           loginCommand.Country,
+	}
+	if err := s.redisService.SetLoginChallenge(ctx, challengeID, challenge, s.otpService.OTP_EXPIRY); err != nil {
+		return nil, fmt.Errorf("failed to cache login challenge: %w", err)
+	}
+
+	if err := s.otpService.SendOTP(ctx, user.Email, otp); err != nil {
+		s.redisService.DeleteKey(ctx, "login_challenge:"+challengeID)
+		return nil, fmt.Errorf("failed to send OTP: %w", err)
+	}
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(user.Id, entities.AuditOTPSent, "", "login_challenge", loginCommand.IPAddress, loginCommand.UserAgent))
+
+	s.warnOTPExpiring(user.Id, challengeID)
+
+	result := command.LoginUserCommandResult{
+		ChallengeRequired: true,
+		ChallengeID:       challengeID,
+	}
+
+	return &result, nil
+}
+
+// recordDevice remembers the device a login came from. Failures are logged
+// rather than returned, device tracking must never block a valid login.
+func (s *UserService) recordDevice(ctx context.Context, userID uuid.UUID, device *entities.Device, fingerprint, userAgent, ip, country string, trust bool) {
+	if fingerprint == "" {
+		return
+	}
+
+	if device == nil {
+		newDevice, err := entities.NewDevice(userID, fingerprint, userAgent, ip, country)
+		if err != nil {
+			log.Printf("Failed to record device: %v", err)
+			return
 		}
+		device = newDevice
+	} else {
+		device.Touch(userAgent, ip, country)
+	}
+
+	if trust {
+		device.MarkAsTrusted()
+	}
+
+	if _, err := s.deviceRepo.Save(ctx, device); err != nil {
+		log.Printf("Failed to record device: %v", err)
+	}
+}
+
+// notifySuspiciousLogin warns the account owner by email without delaying the login
+func (s *UserService) notifySuspiciousLogin(user *entities.User, loginCommand *command.LoginUserCommand, anomaly *LoginAnomaly) {
+	log.Printf("Suspicious login for user %s: %s", user.Id, strings.Join(anomaly.Reasons, ", "))
 
-		// Update user's tokens in PostgreSQL asynchronously
-		dbErr := s.userRepo.UpdateTokens(context.Background(), user.Id, token)
-		if dbErr != nil {
-			log.Printf("Failed to update tokens in database: %v", dbErr)
+	text := fmt.Sprintf(
+		"We noticed a sign-in to your account that doesn't match your usual activity.\n\n"+
+			"Time: %s\nIP address: %s\nCountry: %s\nDevice: %s\nReason: %s\n\n"+
+			"If this was you, no action is needed. If not, change your password immediately.",
+		time.Now().UTC().Format(time.RFC1123), valueOrUnknown(loginCommand.IPAddress), valueOrUnknown(loginCommand.Country),
+		valueOrUnknown(loginCommand.UserAgent), strings.Join(anomaly.Reasons, ", "))
+
+	go func() {
+		if err := s.notifier.Send(context.Background(), user.Email, "New sign-in to your account", text); err != nil {
+			log.Printf("Failed to send suspicious login notification: %v", err)
 		}
 	}()
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// acceptRegistrationTerms requires a new account to accept the published
+// terms, when they are tracked, and records the acceptance on the user
+func acceptRegistrationTerms(user *entities.User, acceptedVersion int) error {
+	policy := entities.CurrentTermsPolicy()
+	if err := policy.CheckRegistration(acceptedVersion); err != nil {
+		return err
+	}
+	if !policy.Enabled() {
+		return nil
+	}
+	return user.AcceptTerms(acceptedVersion)
+}
+
+// acceptTerms records an existing user's acceptance of version and drops the
+// cached profile that still shows the old one
+func (s *UserService) acceptTerms(ctx context.Context, user *entities.User, version int) error {
+	if err := user.AcceptTerms(version); err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdateTermsAcceptance(ctx, user.Id, user.TermsVersion, *user.TermsAcceptedAt); err != nil {
+		return fmt.Errorf("failed to record terms acceptance: %w", err)
+	}
+	s.redisService.DeleteProfile(ctx, user.Id.String())
+	s.events.Publish(entities.NewUserEvent(entities.UserEventProfileChanged, user.Id, "terms_accepted"))
+	return nil
+}
+
+// AcceptTerms records that the user accepted the published terms of service
+func (s *UserService) AcceptTerms(acceptCommand *command.AcceptTermsCommand) (*command.AcceptTermsCommandResult, error) {
+	user, err := s.userRepo.FindById(acceptCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	if err := s.acceptTerms(context.Background(), user, acceptCommand.Version); err != nil {
+		return nil, err
+	}
+
+	result := command.AcceptTermsCommandResult{
+		TermsVersion: user.TermsVersion,
+		AcceptedAt:   *user.TermsAcceptedAt,
+	}
+
+	return &result, nil
+}
+
+// checkUserStatus rejects suspended and banned users
+func checkUserStatus(user *entities.User) error {
+	if user.IsActive() {
+		return nil
+	}
+	if user.StatusReason != "" {
+		return entities.ErrAccountInactive.Errorf("account %s: %s", user.Status, user.StatusReason)
+	}
+	return entities.ErrAccountInactive.Errorf("account %s", user.Status)
+}
+
+// recordLogin appends to the user's login history and announces successful
+// logins. Like device tracking it only logs failures, a history write must
+// never decide a login.
+func (s *UserService) recordLogin(ctx context.Context, userID uuid.UUID, method string, success bool, failureReason, ip, userAgent, country string) {
+	event := entities.NewLoginEvent(userID, method, success, failureReason, ip, userAgent, country)
+	if err := s.loginHistoryRepo.Record(ctx, event); err != nil {
+		log.Printf("Failed to record login for user %s: %v", userID, err)
+	}
+	if success {
+		s.events.Publish(entities.NewUserEvent(entities.UserEventLoginSucceeded, userID, method))
+		appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(userID, entities.AuditLoginSucceeded, "", method, ip, userAgent))
+	} else {
+		appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(userID, entities.AuditLoginFailed, "", method+": "+failureReason, ip, userAgent))
+	}
+}
+
+// appendAudit adds event to the audit log. Failures are logged rather than
+// returned, like those of the login history.
+func appendAudit(ctx context.Context, auditRepo repositories.AuditRepository, event *entities.AuditEvent) {
+	if err := auditRepo.Append(ctx, event); err != nil {
+		log.Printf("Failed to record %s audit event for user %s: %v", event.Action, event.UserId, err)
+	}
+}
+
+// warnOTPExpiring announces that the OTP of a login challenge is about to
+// expire, otpExpiryWarning before it does, unless the login was completed
+func (s *UserService) warnOTPExpiring(userID uuid.UUID, challengeID string) {
+	delay := s.otpService.OTP_EXPIRY - otpExpiryWarning
+	if delay <= 0 {
+		return
+	}
+	time.AfterFunc(delay, func() {
+		if _, err := s.redisService.GetLoginChallenge(context.Background(), challengeID); err != nil {
+			return
+		}
+		s.events.Publish(entities.NewUserEvent(entities.UserEventOTPExpiring, userID, "login_challenge"))
+	})
+}
+
+// rehashPassword replaces a password hash made with an outdated algorithm or
+// parameters. Failures are only logged, the next login tries again.
+func (s *UserService) rehashPassword(userID uuid.UUID, oldHash, password string) {
+	user := &entities.User{Password: password}
+	if err := user.HashPassword(); err != nil {
+		log.Printf("Failed to rehash password for user %s: %v", userID, err)
+		return
+	}
+
+	updated, err := s.userRepo.UpdatePasswordHash(context.Background(), userID, oldHash, user.Password)
+	if err != nil {
+		log.Printf("Failed to store rehashed password for user %s: %v", userID, err)
+		return
+	}
+	if updated {
+		log.Printf("Rehashed password for user %s", userID)
+	}
+}
+
+// issueLoginToken generates the session token for an authenticated user.
+// The writes the login queued in writes, such as dropping the challenge it
+// used up, go to Redis along with the token, in one round trip, before it
+// returns. Without writes the token is stored in the background.
+func (s *UserService) issueLoginToken(user *entities.User, writes *infrastructure.RedisBatch) (*command.LoginUserCommandResult, error) {
+	ctx := context.Background()
+
+	// Generate JWT token, guests only get a token bound to their device
+	var token string
+	var err error
+	if user.IsGuest {
+		token, err = s.jwtService.GenerateDeviceBoundToken(user.Id.String(), guestTokenScope, user.GuestDeviceHash)
+	} else {
+		token, err = s.jwtService.GenerateToken(user.Id.String())
+	}
+	if err != nil {
+		if writes != nil {
+			if redisErr := writes.Exec(ctx); redisErr != nil {
+				log.Printf("Failed to write login to Redis: %v", redisErr)
+			}
+		}
+		return nil, err
+	}
+
+	// Store the token in Redis for quick validation
+	if writes != nil {
+		writes.SetToken(ctx, token, user.Id.String(), s.redisService.Cache().Token.TTL)
+		if redisErr := writes.Exec(ctx); redisErr != nil {
+			log.Printf("Failed to write login to Redis: %v", redisErr)
+		}
+	} else if redisErr := s.redisService.SetToken(ctx, token, user.Id.String(), s.redisService.Cache().Token.TTL); redisErr != nil {
+		log.Printf("Failed to store token in Redis: %v", redisErr)
+	}
+
+	// And in PostgreSQL before handing it out, so introspection, which falls
+	// back to it when Redis misses the token, finds it from the first call
+	if err := s.userRepo.UpdateTokens(ctx, user.Id, token); err != nil {
+		return nil, fmt.Errorf("failed to store token: %w", err)
+	}
 
 	result := command.LoginUserCommandResult{
 		Token: token,
@@ -183,55 +628,59 @@ func (s *UserService) SendOTP(sendOTPCommand *command.SendOTPCommand) (*command.
 		}
 	}
 
+	// Reserved usernames and disposable email providers are refused outright
+	if err := entities.CheckRegistrationDenylist(sendOTPCommand.Username, sendOTPCommand.Email); err != nil {
+		return nil, err
+	}
+
 	// Check if user already exists
 	existingUser, err := s.userRepo.FindByUsername(sendOTPCommand.Username)
 	if err != nil {
 		return nil, err
 	}
 	if existingUser != nil {
-		return nil, errors.New("username already exists")
+		return nil, entities.ErrUsernameExists
 	}
 
-	// Apply rate limiting for OTP generation
-	if !s.rateLimiter.Allow(sendOTPCommand.Email) {
-		return nil, errors.New("too many OTP requests, please try again later")
+	// Validate the registration data, including the password policy, before an OTP goes out
+	tempUser := entities.NewUser(sendOTPCommand.Username, sendOTPCommand.Email, sendOTPCommand.Password)
+	if err := acceptRegistrationTerms(tempUser, sendOTPCommand.AcceptedTermsVersion); err != nil {
+		return nil, err
 	}
-
-	// Check if OTP already exists in cache and hasn't expired
-	otpKey := "otp:" + sendOTPCommand.Email
-	otp, err := s.redisService.GetOTP(ctx, otpKey)
-	if err != nil {
-		// If Redis is not available or key doesn't exist, continue with new OTP generation
-		if err.Error() == "redis: nil" {
-			otp = ""
-		} else {
-			return nil, fmt.Errorf("redis error: %w", err)
-		}
+	if _, err := entities.NewValidatedUser(tempUser); err != nil {
+		return nil, err
 	}
-
-	// Generate new OTP if needed
-	if otp == "" {
-		otp = s.otpService.GenerateOTP(ctx)
-
-		// Set OTP in cache with 5-minute expiration
-		if err := s.redisService.SetOTP(ctx, otpKey, otp, 5*time.Minute); err != nil {
-			return nil, fmt.Errorf("failed to cache OTP: %w", err)
-		}
+	if err := entities.CheckPasswordPolicy(sendOTPCommand.Password); err != nil {
+		return nil, err
+	}
+	if err := checkPasswordBreach(s.breachChecker, sendOTPCommand.Password); err != nil {
+		return nil, err
 	}
 
-	// Create temporary user for OTP process
-	tempUser := entities.NewUser(sendOTPCommand.Username, sendOTPCommand.Email, sendOTPCommand.Password)
+	// Verifying the OTP verifies the email, so the OTP goes to that address
+	// and over no other channel
+	if sendOTPCommand.Channel != "" && sendOTPCommand.Channel != infrastructure.OTPChannelEmail {
+		return nil, entities.ErrInvalidInput.Errorf("registration OTPs are only sent by email")
+	}
 
-	// Send OTP to user
-	if err := s.otpService.SendOTP(ctx, sendOTPCommand.Email, otp); err != nil {
-		// Clean up the cached OTP if we couldn't send it
-		s.redisService.DeleteKey(ctx, otpKey)
-		return nil, fmt.Errorf("failed to send OTP: %w", err)
+	// Apply rate limiting for OTP generation, globally and per channel
+	if !s.rateLimiter.Allow(sendOTPCommand.Email) {
+		return nil, s.rateLimiter.Exceeded(sendOTPCommand.Email, "too many OTP requests, please try again later")
+	}
+	if !s.otpService.AllowChannel(infrastructure.OTPChannelEmail, sendOTPCommand.Email) {
+		return nil, s.otpService.ChannelLimitExceeded(infrastructure.OTPChannelEmail, sendOTPCommand.Email, "too many OTP requests, please try again later")
 	}
 
-	// Store user data with a longer TTL (15 minutes)
-	if err := s.redisService.SetUserData(ctx, sendOTPCommand.Email, tempUser, 15*time.Minute); err != nil {
-		return nil, fmt.Errorf("failed to cache user data: %w", err)
+	// Store the pending registration, then issue and send its OTP. When
+	// sending fails the registration and OTP are rolled back.
+	registration := &registrationStart{
+		redisService: s.redisService,
+		otpService:   s.otpService,
+		email:        sendOTPCommand.Email,
+		user:         tempUser,
+	}
+	if err := newSaga(s.redisService, registrationStartSaga, sendOTPCommand.Email, registration.steps()).execute(ctx); err != nil {
+		return nil, err
 	}
 
 	result := command.SendOTPCommandResult{
@@ -253,6 +702,67 @@ func (s *UserService) SendOTP(sendOTPCommand *command.SendOTPCommand) (*command.
 	return &result, nil
 }
 
+func (s *UserService) ResendOTP(resendOTPCommand *command.ResendOTPCommand) (*command.ResendOTPCommandResult, error) {
+	ctx := context.Background()
+	email := resendOTPCommand.Email
+
+	// Only registrations that are still pending can be resent
+	user, err := s.redisService.GetUserData(ctx, email)
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return nil, entities.ErrRegistrationExpired
+		}
+		return nil, fmt.Errorf("failed to retrieve user data: %w", err)
+	}
+	if user == nil {
+		return nil, entities.ErrRegistrationExpired
+	}
+
+	// Enforce the cooldown between resends, independent of the generation limiter
+	allowed, err := s.redisService.AcquireCooldown(ctx, "otp_resend_cooldown:"+email, s.otpService.OTP_RESEND_COOLDOWN)
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %w", err)
+	}
+	if !allowed {
+		wait, _ := s.redisService.GetTTL(ctx, "otp_resend_cooldown:"+email)
+		return nil, entities.NewRateLimitError("please wait before requesting another OTP", entities.RateLimit{Limit: 1, ResetAfter: wait})
+	}
+
+	// Cap the number of resends for the lifetime of the pending registration
+	resends, err := s.redisService.IncrementCounter(ctx, "otp_resend_count:"+email, s.redisService.Cache().UserData.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %w", err)
+	}
+	if int(resends) > s.otpService.OTP_MAX_RESENDS {
+		wait, _ := s.redisService.GetTTL(ctx, "otp_resend_count:"+email)
+		return nil, entities.NewRateLimitError("maximum OTP resends reached, please register again later", entities.RateLimit{Limit: s.otpService.OTP_MAX_RESENDS, ResetAfter: wait})
+	}
+
+	// Resend the outstanding OTP, or issue a fresh one if it already expired
+	otp, err := s.redisService.GetOTP(ctx, email)
+	if err != nil && err.Error() != "redis: nil" {
+		return nil, fmt.Errorf("redis error: %w", err)
+	}
+	if otp == "" {
+		otp = s.otpService.GenerateOTP(ctx)
+		if err := s.redisService.SetOTP(ctx, email, otp, s.redisService.Cache().OTP.TTL); err != nil {
+			return nil, fmt.Errorf("failed to cache OTP: %w", err)
+		}
+	}
+
+	// Only ever to the registering email, the one address the OTP may verify
+	if err := s.otpService.SendOTPVia(ctx, infrastructure.OTPChannelEmail, email, otp); err != nil {
+		return nil, fmt.Errorf("failed to send OTP: %w", err)
+	}
+
+	result := command.ResendOTPCommandResult{
+		Message:          "OTP resent successfully",
+		RemainingResends: s.otpService.OTP_MAX_RESENDS - int(resends),
+	}
+
+	return &result, nil
+}
+
 func (s *UserService) VerifyOTP(verifyOTPCommand *command.VerifyOTPCommand) (*command.VerifyOTPCommandResult, error) {
 	ctx := context.Background()
 
@@ -275,33 +785,32 @@ func (s *UserService) VerifyOTP(verifyOTPCommand *command.VerifyOTPCommand) (*co
 
 	// Apply rate limiting for OTP verification attempts
 	if !s.rateLimiter.Allow("verify:" + verifyOTPCommand.Email) {
-		return nil, errors.New("too many verification attempts, please try again later")
+		return nil, s.rateLimiter.Exceeded("verify:"+verifyOTPCommand.Email, "too many verification attempts, please try again later")
 	}
 
 	// Get OTP from cache
-	otpKey := "otp:" + verifyOTPCommand.Email
-	cacheOtp, err := s.redisService.GetOTP(ctx, otpKey)
+	cacheOtp, err := s.redisService.GetOTP(ctx, verifyOTPCommand.Email)
 	if err != nil {
 		// If Redis is not available or key doesn't exist, return error
 		if err.Error() == "redis: nil" {
-			return nil, errors.New("OTP expired or not found")
+			return nil, entities.ErrOTPExpired
 		}
 		return nil, fmt.Errorf("failed to retrieve OTP from cache: %w", err)
 	}
 
 	// Check if OTP exists
 	if cacheOtp == "" {
-		return nil, errors.New("OTP expired or not found")
+		return nil, entities.ErrOTPExpired
 	}
 
 	// Verify OTP
 	isValid, err := s.otpService.VerifyOTP(ctx, verifyOTPCommand.Email, verifyOTPCommand.OTP, cacheOtp)
 	if err != nil {
-		return nil, fmt.Errorf("OTP verification failed: %w", err)
+		return nil, entities.ErrInvalidOTP.Errorf("OTP verification failed: %w", err)
 	}
 
 	if !isValid {
-		return nil, errors.New("invalid OTP")
+		return nil, entities.ErrInvalidOTP
 	}
 
 	// If OTP is valid, get user data from cache
@@ -309,13 +818,13 @@ func (s *UserService) VerifyOTP(verifyOTPCommand *command.VerifyOTPCommand) (*co
 	if err != nil {
 		// If Redis is not available or key doesn't exist, return error
 		if err.Error() == "redis: nil" {
-			return nil, errors.New("user data expired or not found")
+			return nil, entities.ErrExpired.Errorf("user data expired or not found")
 		}
 		return nil, fmt.Errorf("failed to retrieve user data: %w", err)
 	}
 
 	if user == nil {
-		return nil, errors.New("user data expired or not found")
+		return nil, entities.ErrExpired.Errorf("user data expired or not found")
 	}
 
 	// Mark user as verified
@@ -327,14 +836,20 @@ func (s *UserService) VerifyOTP(verifyOTPCommand *command.VerifyOTPCommand) (*co
 		return nil, err
 	}
 
-	createdUser, err := s.userRepo.Create(validatedUser)
-	if err != nil {
-		return nil, fmt.Errorf("failed to register user: %w", err)
+	// Create the user, announce it and clear the pending registration. Once
+	// the user exists, a later step that fails is finished by recovery.
+	registration := &registrationComplete{
+		redisService: s.redisService,
+		userRepo:     s.userRepo,
+		events:       s.events,
+		email:        verifyOTPCommand.Email,
+		user:         validatedUser,
 	}
-
-	// Clean up cache after successful registration
-	s.redisService.DeleteKey(ctx, otpKey)
-	s.redisService.DeleteKey(ctx, "user:"+verifyOTPCommand.Email)
+	if err := newSaga(s.redisService, registrationCompleteSaga, verifyOTPCommand.Email, registration.steps()).execute(ctx); err != nil {
+		return nil, err
+	}
+	createdUser := registration.createdUser
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(createdUser.Id, entities.AuditOTPVerified, "", "registration", "", ""))
 
 	result := command.VerifyOTPCommandResult{
 		Result: mapper.NewUserResultFromEntity(createdUser),
@@ -355,17 +870,539 @@ func (s *UserService) VerifyOTP(verifyOTPCommand *command.VerifyOTPCommand) (*co
 	return &result, nil
 }
 
-func (s *UserService) FindUserById(id uuid.UUID) (*query.UserQueryResult, error) {
-	user, err := s.userRepo.FindById(id)
+func (s *UserService) ChangeEmail(changeEmailCommand *command.ChangeEmailCommand) (*command.ChangeEmailCommandResult, error) {
+	ctx := context.Background()
+
+	user, err := s.userRepo.FindById(changeEmailCommand.UserID)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, errors.New("user not found")
+		return nil, entities.ErrUserNotFound
 	}
 
-	result := query.UserQueryResult{
-		Result: mapper.NewUserResultFromEntity(user),
+	if changeEmailCommand.NewEmail == user.Email {
+		return nil, entities.ErrInvalidInput.Errorf("new email must differ from the current email")
+	}
+	if err := entities.CheckRegistrationDenylist("", changeEmailCommand.NewEmail); err != nil {
+		return nil, err
+	}
+
+	// Reject addresses that already belong to another account up front
+	existingUser, err := s.userRepo.FindByEmail(changeEmailCommand.NewEmail)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		return nil, entities.ErrEmailExists
+	}
+
+	// Apply rate limiting per account, not per target address
+	if !s.rateLimiter.Allow("email_change:" + user.Id.String()) {
+		return nil, s.rateLimiter.Exceeded("email_change:"+user.Id.String(), "too many email change requests, please try again later")
+	}
+
+	otp := s.otpService.GenerateOTP(ctx)
+
+	// Hold the pending address until the OTP sent to it is confirmed
+	pending := &infrastructure.PendingEmailChange{
+		Email: changeEmailCommand.NewEmail,
+		OTP:   otp,
+	}
+	if err := s.redisService.SetPendingEmailChange(ctx, user.Id.String(), pending, s.otpService.OTP_EXPIRY); err != nil {
+		return nil, fmt.Errorf("failed to cache pending email: %w", err)
+	}
+
+	if err := s.otpService.SendOTP(ctx, changeEmailCommand.NewEmail, otp); err != nil {
+		s.redisService.DeleteKey(ctx, "email_change:"+user.Id.String())
+		return nil, fmt.Errorf("failed to send OTP: %w", err)
+	}
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(user.Id, entities.AuditOTPSent, "", "email_change", "", ""))
+
+	result := command.ChangeEmailCommandResult{
+		Message: "OTP sent to the new email address",
+	}
+
+	return &result, nil
+}
+
+func (s *UserService) ConfirmEmailChange(confirmCommand *command.ConfirmEmailChangeCommand) (*command.ConfirmEmailChangeCommandResult, error) {
+	ctx := context.Background()
+	userID := confirmCommand.UserID.String()
+
+	// Apply rate limiting for confirmation attempts
+	if !s.rateLimiter.Allow("verify_email_change:" + userID) {
+		return nil, s.rateLimiter.Exceeded("verify_email_change:"+userID, "too many verification attempts, please try again later")
+	}
+
+	pending, err := s.redisService.GetPendingEmailChange(ctx, userID)
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return nil, entities.ErrExpired.Errorf("email change expired or not found")
+		}
+		return nil, fmt.Errorf("failed to retrieve pending email change: %w", err)
+	}
+
+	isValid, err := s.otpService.VerifyOTP(ctx, pending.Email, confirmCommand.OTP, pending.OTP)
+	if err != nil {
+		return nil, entities.ErrInvalidOTP.Errorf("OTP verification failed: %w", err)
+	}
+	if !isValid {
+		return nil, entities.ErrInvalidOTP
+	}
+
+	user, err := s.userRepo.FindById(confirmCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	// The address may have been claimed while the OTP was outstanding
+	existingUser, err := s.userRepo.FindByEmail(pending.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil && existingUser.Id != user.Id {
+		s.redisService.DeleteKey(ctx, "email_change:"+userID)
+		return nil, entities.ErrEmailExists
+	}
+
+	if err := user.UpdateProfile(user.Username, pending.Email); err != nil {
+		return nil, err
+	}
+
+	validatedUser, err := entities.NewValidatedUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedUser, err := s.userRepo.Update(validatedUser)
+	if errors.Is(err, entities.ErrUserExists) {
+		// Claimed since the check above
+		s.redisService.DeleteKey(ctx, "email_change:"+userID)
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update email: %w", err)
+	}
+
+	// Clean up and drop the cached profile so reads see the new address
+	writes := s.redisService.Batch()
+	writes.DeleteKey(ctx, "email_change:"+userID)
+	writes.DeleteProfile(ctx, userID)
+	if err := writes.Exec(ctx); err != nil {
+		log.Printf("Failed to drop pending email change: %v", err)
+	}
+	s.events.Publish(entities.NewUserEvent(entities.UserEventProfileChanged, updatedUser.Id, "email_changed"))
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(updatedUser.Id, entities.AuditOTPVerified, "", "email_change", "", ""))
+
+	result := command.ConfirmEmailChangeCommandResult{
+		Result: mapper.NewUserResultFromEntity(updatedUser),
+	}
+
+	return &result, nil
+}
+
+// AddEmail starts attaching a secondary address by sending an OTP to it
+func (s *UserService) AddEmail(addCommand *command.AddEmailCommand) (*command.AddEmailCommandResult, error) {
+	ctx := context.Background()
+	email := strings.TrimSpace(addCommand.Email)
+
+	user, err := s.userRepo.FindById(addCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+	if user.IsGuest {
+		return nil, entities.ErrInvalidInput.Errorf("guest accounts must be upgraded before adding emails")
+	}
+
+	if err := entities.CheckRegistrationDenylist("", email); err != nil {
+		return nil, err
+	}
+	if _, err := entities.NewUserEmail(user.Id, email); err != nil {
+		return nil, err
+	}
+
+	// Primary and secondary addresses of every account are checked at once
+	existingUser, err := s.userRepo.FindByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		return nil, entities.ErrEmailExists
+	}
+
+	emails, err := s.userEmailRepo.ListByUser(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) >= entities.MaxSecondaryEmails {
+		return nil, entities.ErrInvalidInput.Errorf("at most %d additional emails can be added", entities.MaxSecondaryEmails)
+	}
+
+	if !s.rateLimiter.Allow("email_add:" + user.Id.String()) {
+		return nil, s.rateLimiter.Exceeded("email_add:"+user.Id.String(), "too many email requests, please try again later")
+	}
+
+	otp := s.otpService.GenerateOTP(ctx)
+	pending := &infrastructure.PendingEmailChange{
+		Email: email,
+		OTP:   otp,
+	}
+	if err := s.redisService.SetPendingEmailAddition(ctx, user.Id.String(), pending, s.otpService.OTP_EXPIRY); err != nil {
+		return nil, fmt.Errorf("failed to cache pending email: %w", err)
+	}
+
+	if err := s.otpService.SendOTP(ctx, email, otp); err != nil {
+		s.redisService.DeleteKey(ctx, "email_add:"+user.Id.String())
+		return nil, fmt.Errorf("failed to send OTP: %w", err)
+	}
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(user.Id, entities.AuditOTPSent, "", "email_addition", "", ""))
+
+	result := command.AddEmailCommandResult{
+		Message: "OTP sent to the new email address",
+	}
+
+	return &result, nil
+}
+
+// ConfirmEmailAddition attaches the pending address once its OTP is verified
+func (s *UserService) ConfirmEmailAddition(confirmCommand *command.ConfirmEmailAdditionCommand) (*query.UserEmailQueryListResult, error) {
+	ctx := context.Background()
+	userID := confirmCommand.UserID.String()
+
+	if !s.rateLimiter.Allow("verify_email_add:" + userID) {
+		return nil, s.rateLimiter.Exceeded("verify_email_add:"+userID, "too many verification attempts, please try again later")
+	}
+
+	pending, err := s.redisService.GetPendingEmailAddition(ctx, userID)
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return nil, entities.ErrExpired.Errorf("email addition expired or not found")
+		}
+		return nil, fmt.Errorf("failed to retrieve pending email: %w", err)
+	}
+
+	isValid, err := s.otpService.VerifyOTP(ctx, pending.Email, confirmCommand.OTP, pending.OTP)
+	if err != nil {
+		return nil, entities.ErrInvalidOTP.Errorf("OTP verification failed: %w", err)
+	}
+	if !isValid {
+		return nil, entities.ErrInvalidOTP
+	}
+
+	// The address may have been claimed while the OTP was outstanding
+	existingUser, err := s.userRepo.FindByEmail(pending.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		s.redisService.DeleteKey(ctx, "email_add:"+userID)
+		return nil, entities.ErrEmailExists
+	}
+
+	email, err := entities.NewUserEmail(confirmCommand.UserID, pending.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userEmailRepo.Create(ctx, email); err != nil {
+		if errors.Is(err, entities.ErrEmailExists) {
+			// Claimed since the check above
+			s.redisService.DeleteKey(ctx, "email_add:"+userID)
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to add email: %w", err)
+	}
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(confirmCommand.UserID, entities.AuditOTPVerified, "", "email_addition", "", ""))
+
+	// FindByEmail matches the address from now on
+	writes := s.redisService.Batch()
+	writes.ForgetMissingUser(ctx, "", pending.Email)
+	writes.DeleteKey(ctx, "email_add:"+userID)
+	if err := writes.Exec(ctx); err != nil {
+		log.Printf("Failed to drop pending email addition: %v", err)
+	}
+
+	return s.ListEmails(confirmCommand.UserID)
+}
+
+// ListEmails returns the user's primary address and its verified secondary ones
+func (s *UserService) ListEmails(userID uuid.UUID) (*query.UserEmailQueryListResult, error) {
+	user, err := s.userRepo.FindById(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	emails, err := s.userEmailRepo.ListByUser(context.Background(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := query.UserEmailQueryListResult{
+		Result: make([]*common.UserEmailResult, 0, len(emails)+1),
+	}
+	if user.Email != "" {
+		result.Result = append(result.Result, mapper.NewPrimaryEmailResultFromUser(user))
+	}
+	for _, email := range emails {
+		result.Result = append(result.Result, mapper.NewUserEmailResultFromEntity(email))
+	}
+
+	return &result, nil
+}
+
+// SetPrimaryEmail promotes a verified secondary address to primary; the old
+// primary address stays attached as a secondary one
+func (s *UserService) SetPrimaryEmail(primaryCommand *command.SetPrimaryEmailCommand) (*query.UserEmailQueryListResult, error) {
+	ctx := context.Background()
+	email := strings.TrimSpace(primaryCommand.Email)
+
+	user, err := s.userRepo.FindById(primaryCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+	if email == user.Email {
+		return s.ListEmails(user.Id)
+	}
+
+	if err := s.userEmailRepo.MakePrimary(ctx, user.Id, email); err != nil {
+		return nil, err
+	}
+
+	// Cached profiles still carry the previous primary address
+	s.redisService.DeleteProfile(ctx, user.Id.String())
+	s.events.Publish(entities.NewUserEvent(entities.UserEventProfileChanged, user.Id, "email_changed"))
+
+	return s.ListEmails(user.Id)
+}
+
+// RemoveEmail detaches a secondary address, the primary one cannot be removed
+func (s *UserService) RemoveEmail(removeCommand *command.RemoveEmailCommand) (*command.RemoveEmailCommandResult, error) {
+	email := strings.TrimSpace(removeCommand.Email)
+
+	user, err := s.userRepo.FindById(removeCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+	if email == user.Email {
+		return nil, entities.ErrInvalidInput.Errorf("the primary email cannot be removed, make another email primary first")
+	}
+
+	deleted, err := s.userEmailRepo.Delete(context.Background(), user.Id, email)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, entities.ErrNotFound.Errorf("email not found")
+	}
+
+	result := command.RemoveEmailCommandResult{
+		Message: "email removed",
+	}
+
+	return &result, nil
+}
+
+// UpdateProfile applies a partial profile update. A new email is not applied
+// directly, it goes through the same OTP confirmation as email.change.
+func (s *UserService) UpdateProfile(updateCommand *command.UpdateProfileCommand) (*command.UpdateProfileCommandResult, error) {
+	ctx := context.Background()
+
+	user, err := s.userRepo.FindById(updateCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	if updateCommand.Username != nil {
+		username := strings.TrimSpace(*updateCommand.Username)
+		if username != user.Username {
+			if err := entities.CheckRegistrationDenylist(username, ""); err != nil {
+				return nil, err
+			}
+			existingUser, err := s.userRepo.FindByUsername(username)
+			if err != nil {
+				return nil, err
+			}
+			if existingUser != nil {
+				return nil, entities.ErrUsernameExists
+			}
+			if err := user.UpdateProfile(username, user.Email); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	displayName, bio := user.DisplayName, user.Bio
+	if updateCommand.DisplayName != nil {
+		displayName = strings.TrimSpace(*updateCommand.DisplayName)
+	}
+	if updateCommand.Bio != nil {
+		bio = strings.TrimSpace(*updateCommand.Bio)
+	}
+	if err := user.UpdateDetails(displayName, bio); err != nil {
+		return nil, err
+	}
+
+	validatedUser, err := entities.NewValidatedUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start the email change before saving, so a rejected address fails the
+	// whole update instead of leaving the other fields changed
+	emailChangePending := false
+	if updateCommand.Email != nil {
+		email := strings.TrimSpace(*updateCommand.Email)
+		if email != "" && email != user.Email {
+			if _, err := s.ChangeEmail(&command.ChangeEmailCommand{UserID: user.Id, NewEmail: email}); err != nil {
+				return nil, err
+			}
+			emailChangePending = true
+		}
+	}
+
+	updatedUser, err := s.userRepo.Update(validatedUser)
+	if err != nil {
+		if emailChangePending {
+			// The OTP already sent can no longer confirm anything
+			s.redisService.DeleteKey(ctx, "email_change:"+user.Id.String())
+		}
+		return nil, err
+	}
+
+	if err := s.redisService.DeleteProfile(ctx, user.Id.String()); err != nil {
+		log.Printf("Failed to drop cached profile for user %s: %v", user.Id, err)
+	}
+	s.events.Publish(entities.NewUserEvent(entities.UserEventProfileChanged, user.Id, "profile_updated"))
+
+	result := command.UpdateProfileCommandResult{
+		Result:             mapper.NewUserResultFromEntity(updatedUser),
+		EmailChangePending: emailChangePending,
+	}
+
+	return &result, nil
+}
+
+func (s *UserService) RegenerateRecoveryCodes(regenerateCommand *command.RegenerateRecoveryCodesCommand) (*command.RegenerateRecoveryCodesCommandResult, error) {
+	ctx := context.Background()
+
+	user, err := s.userRepo.FindById(regenerateCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	// Regenerating invalidates the old codes, so require the password again
+	if err := user.CheckPassword(regenerateCommand.Password); err != nil {
+		return nil, entities.ErrInvalidCredentials
+	}
+
+	codes, plaintexts, err := entities.NewRecoveryCodes(user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recoveryCodeRepo.ReplaceForUser(ctx, user.Id, codes); err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+
+	result := command.RegenerateRecoveryCodesCommandResult{
+		Codes: plaintexts,
+	}
+
+	return &result, nil
+}
+
+func (s *UserService) ListDevices(userID uuid.UUID) (*query.DeviceQueryListResult, error) {
+	devices, err := s.deviceRepo.ListByUser(context.Background(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := query.DeviceQueryListResult{
+		Result: make([]*common.DeviceResult, 0, len(devices)),
+	}
+	for _, device := range devices {
+		result.Result = append(result.Result, mapper.NewDeviceResultFromEntity(device))
+	}
+
+	return &result, nil
+}
+
+// Login history page size when the caller does not ask for one, and its cap
+const (
+	defaultLoginHistoryLimit = 20
+	maxLoginHistoryLimit     = 100
+)
+
+// GetLoginHistory returns the user's most recent login attempts
+func (s *UserService) GetLoginHistory(historyQuery *query.LoginHistoryQuery) (*query.LoginHistoryQueryResult, error) {
+	limit := historyQuery.Limit
+	if limit <= 0 || limit > maxLoginHistoryLimit {
+		limit = defaultLoginHistoryLimit
+	}
+
+	events, err := s.loginHistoryRepo.ListByUser(context.Background(), historyQuery.UserID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := query.LoginHistoryQueryResult{
+		Result: make([]*common.LoginEventResult, 0, len(events)),
+	}
+	for _, event := range events {
+		result.Result = append(result.Result, mapper.NewLoginEventResultFromEntity(event))
+	}
+
+	return &result, nil
+}
+
+func (s *UserService) RevokeDevice(revokeCommand *command.RevokeDeviceCommand) (*command.RevokeDeviceCommandResult, error) {
+	deleted, err := s.deviceRepo.Delete(context.Background(), revokeCommand.UserID, revokeCommand.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, entities.ErrNotFound.Errorf("device not found")
+	}
+
+	result := command.RevokeDeviceCommandResult{
+		Message: "Device revoked successfully",
+	}
+
+	return &result, nil
+}
+
+func (s *UserService) FindUserById(id uuid.UUID) (*query.UserQueryResult, error) {
+	user, err := s.userRepo.FindById(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	result := query.UserQueryResult{
+		Result: mapper.NewUserResultFromEntity(user),
 	}
 
 	return &result, nil
@@ -387,18 +1424,12 @@ func (s *UserService) GetProfile(id uuid.UUID) (*query.UserQueryResult, error) {
 	// If Redis error (like redis: nil), continue to database lookup
 
 	// If not in cache, get it from the database
-	user, err := s.userRepo.GetProfile(ctx, id)
+	user, err := s.loadProfile(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, errors.New("user not found")
-	}
-
-	// Cache the user profile in Redis for future access, with TTL
-	err = s.redisService.SetProfile(ctx, id.String(), user, 24*time.Hour)
-	if err != nil {
-		log.Printf("Failed to cache user profile: %v", err)
+		return nil, entities.ErrUserNotFound
 	}
 
 	result := query.UserQueryResult{
@@ -407,3 +1438,499 @@ func (s *UserService) GetProfile(id uuid.UUID) (*query.UserQueryResult, error) {
 
 	return &result, nil
 }
+
+// loadProfile reads a profile missing from the cache from the database and
+// caches it. Concurrent misses of the same profile share one query and one
+// cache write, and get the same user, which they must not modify.
+func (s *UserService) loadProfile(ctx context.Context, id uuid.UUID) (*entities.User, error) {
+	loaded, err, _ := s.profileLoads.Do(id.String(), func() (interface{}, error) {
+		user, err := s.userRepo.GetProfile(ctx, id)
+		if err != nil || user == nil {
+			return nil, err
+		}
+
+		// Cache the user profile in Redis for future access, with TTL
+		if err := s.redisService.SetProfile(ctx, id.String(), user, s.redisService.Cache().Profile.TTL); err != nil {
+			log.Printf("Failed to cache user profile: %v", err)
+		}
+		return user, nil
+	})
+	if err != nil || loaded == nil {
+		return nil, err
+	}
+	return loaded.(*entities.User), nil
+}
+
+// findByCredentials resolves a login identifier. Concurrent logins with the
+// same identifier share one query, each getting its own copy of the user.
+func (s *UserService) findByCredentials(identifier string) (*entities.User, error) {
+	loaded, err, _ := s.credentialLoads.Do(identifier, func() (interface{}, error) {
+		user, err := s.userRepo.FindByCredentials(identifier)
+		if err != nil || user == nil {
+			return nil, err
+		}
+		return user, nil
+	})
+	if err != nil || loaded == nil {
+		return nil, err
+	}
+
+	user := *loaded.(*entities.User)
+	user.Tokens = append([]string(nil), user.Tokens...)
+	return &user, nil
+}
+
+func (s *UserService) GetJWKS() (*query.JWKSQueryResult, error) {
+	jwks := s.jwtService.JWKS()
+
+	result := query.JWKSQueryResult{
+		Keys: make([]*common.JWKResult, 0, len(jwks.Keys)),
+	}
+	for _, key := range jwks.Keys {
+		result.Keys = append(result.Keys, &common.JWKResult{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+		})
+	}
+
+	return &result, nil
+}
+
+// IntrospectToken reports whether a token is still usable. JWTs are verified
+// locally and then checked against the stored sessions in Redis and Postgres,
+// anything else is treated as an opaque token and looked up in Redis only.
+func (s *UserService) IntrospectToken(introspectQuery *query.IntrospectTokenQuery) (*query.TokenIntrospectionQueryResult, error) {
+	ctx := context.Background()
+
+	token := strings.TrimSpace(introspectQuery.Token)
+	if token == "" {
+		return nil, entities.ErrInvalidInput.Errorf("token is required")
+	}
+
+	if strings.Count(token, ".") != 2 {
+		return s.introspectOpaqueToken(ctx, token)
+	}
+
+	claims, err := s.jwtService.ParseToken(token)
+	if err != nil {
+		// Expired, tampered or foreign tokens are simply inactive
+		return &query.TokenIntrospectionQueryResult{Active: false}, nil
+	}
+
+	// Device bound tokens are only good on the device they were issued to,
+	// which the caller has to name
+	if claims.DeviceHash != "" && (introspectQuery.DeviceFingerprint == "" ||
+		entities.HashDeviceFingerprint(introspectQuery.DeviceFingerprint) != claims.DeviceHash) {
+		return &query.TokenIntrospectionQueryResult{Active: false}, nil
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return &query.TokenIntrospectionQueryResult{Active: false}, nil
+	}
+
+	stored, err := s.isTokenStored(ctx, userID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.findUserForIntrospection(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return &query.TokenIntrospectionQueryResult{Active: false}, nil
+	}
+
+	result := &query.TokenIntrospectionQueryResult{
+		Active:      stored && user.IsActive(),
+		Status:      statusOrActive(user.Status),
+		UserID:      userID,
+		Scopes:      claims.Scopes,
+		TokenType:   "jwt",
+		DeviceBound: claims.DeviceHash != "",
+		ExpiresAt:   claims.ExpiresAt.Unix(),
+		Revoked:     !stored,
+	}
+	if !claims.IssuedAt.IsZero() {
+		result.IssuedAt = claims.IssuedAt.Unix()
+	}
+
+	return result, nil
+}
+
+func (s *UserService) introspectOpaqueToken(ctx context.Context, token string) (*query.TokenIntrospectionQueryResult, error) {
+	var user *entities.User
+	storedUserID, err := s.redisService.GetToken(ctx, token)
+	switch {
+	case err == nil:
+		userID, err := uuid.Parse(storedUserID)
+		if err != nil {
+			return &query.TokenIntrospectionQueryResult{Active: false}, nil
+		}
+		user, err = s.findUserForIntrospection(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+	case err.Error() == "redis: nil":
+		return &query.TokenIntrospectionQueryResult{Active: false}, nil
+	default:
+		// Redis is slow or down, the user's tokens are in Postgres too
+		log.Printf("Failed to look up token in Redis, falling back to the database: %v", err)
+		user, err = s.userRepo.FindByToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if user == nil {
+		return &query.TokenIntrospectionQueryResult{Active: false}, nil
+	}
+	userID := user.Id
+
+	result := &query.TokenIntrospectionQueryResult{
+		Active:    user.IsActive(),
+		Status:    statusOrActive(user.Status),
+		UserID:    userID,
+		Scopes:    []string{"user"},
+		TokenType: "opaque",
+	}
+	if ttl, err := s.redisService.GetTokenTTL(ctx, token); err == nil && ttl > 0 {
+		result.ExpiresAt = time.Now().Add(ttl).Unix()
+	}
+
+	return result, nil
+}
+
+// isTokenStored reports whether a token is still one of the user's sessions.
+// Redis is checked first, Postgres covers tokens that were evicted from the
+// cache or issued while Redis was down.
+func (s *UserService) isTokenStored(ctx context.Context, userID uuid.UUID, token string) (bool, error) {
+	storedUserID, err := s.redisService.GetToken(ctx, token)
+	if err == nil {
+		return storedUserID == userID.String(), nil
+	}
+	if err.Error() != "redis: nil" {
+		log.Printf("Failed to look up token in Redis: %v", err)
+	}
+
+	return s.userRepo.HasToken(ctx, userID, token)
+}
+
+// findUserForIntrospection loads the token owner, preferring the profile cache.
+// The cache is dropped whenever a status changes, so it never hides a ban.
+func (s *UserService) findUserForIntrospection(ctx context.Context, userID uuid.UUID) (*entities.User, error) {
+	if cachedUser, err := s.redisService.GetProfile(ctx, userID.String()); err == nil && cachedUser != nil {
+		return cachedUser, nil
+	}
+	return s.userRepo.FindById(userID)
+}
+
+func statusOrActive(status entities.UserStatus) string {
+	if status == "" {
+		return string(entities.UserStatusActive)
+	}
+	return string(status)
+}
+
+// ChangeUserStatus suspends, bans or reinstates a user. Suspended and banned
+// users cannot log in, and introspection reports their tokens as inactive.
+func (s *UserService) ChangeUserStatus(changeCommand *command.ChangeUserStatusCommand) (*command.ChangeUserStatusCommandResult, error) {
+	ctx := context.Background()
+
+	status, err := entities.ParseUserStatus(changeCommand.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindById(changeCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	if err := user.ChangeStatus(status, strings.TrimSpace(changeCommand.Reason)); err != nil {
+		return nil, err
+	}
+
+	validatedUser, err := entities.NewValidatedUser(user)
+	if err != nil {
+		return nil, err
+	}
+	updatedUser, err := s.userRepo.Update(validatedUser)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cached profile carries the old status
+	if err := s.redisService.DeleteProfile(ctx, user.Id.String()); err != nil {
+		log.Printf("Failed to drop cached profile for user %s: %v", user.Id, err)
+	}
+	s.events.Publish(entities.NewUserEvent(entities.UserEventProfileChanged, user.Id, "status_changed"))
+	// Introspection stops accepting the tokens of suspended and banned users
+	if status != entities.UserStatusActive {
+		s.events.Publish(entities.NewUserEvent(entities.UserEventTokenRevoked, user.Id, "account_"+string(status)))
+	}
+
+	log.Printf("User %s status changed to %s by %s: %s", user.Id, status, valueOrUnknown(changeCommand.ChangedBy), valueOrUnknown(user.StatusReason))
+	detail := string(status)
+	if user.StatusReason != "" {
+		detail += ": " + user.StatusReason
+	}
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(user.Id, entities.AuditStatusChanged, valueOrUnknown(changeCommand.ChangedBy), detail, "", ""))
+
+	return &command.ChangeUserStatusCommandResult{
+		User: mapper.NewUserResultFromEntity(updatedUser),
+	}, nil
+}
+
+// guestTokenScope marks tokens of guest accounts
+const guestTokenScope = "guest"
+
+// CreateGuest returns a token for the guest account bound to the device,
+// creating the account on the device's first visit
+func (s *UserService) CreateGuest(createCommand *command.CreateGuestCommand) (*command.LoginUserCommandResult, error) {
+	ctx := context.Background()
+
+	fingerprint := strings.TrimSpace(createCommand.DeviceFingerprint)
+	if fingerprint == "" {
+		return nil, entities.ErrInvalidInput.Errorf("device fingerprint is required")
+	}
+
+	user, err := s.userRepo.FindGuestByDevice(entities.HashDeviceFingerprint(fingerprint))
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		// Only new guests count against the limit, resuming one is free
+		limitKey := "guest:" + valueOrUnknown(createCommand.IPAddress)
+		if !s.rateLimiter.Allow(limitKey) {
+			return nil, s.rateLimiter.Exceeded(limitKey, "too many guest accounts, please try again later")
+		}
+
+		guest, err := entities.NewGuestUser(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		validatedUser, err := entities.NewValidatedUser(guest)
+		if err != nil {
+			return nil, err
+		}
+		created, err := s.userRepo.Create(validatedUser)
+		switch {
+		case errors.Is(err, entities.ErrUserExists):
+			// A concurrent request from the device created its guest first
+			user, err = s.userRepo.FindGuestByDevice(guest.GuestDeviceHash)
+			if err != nil {
+				return nil, err
+			}
+			if user == nil {
+				return nil, entities.ErrUserExists
+			}
+		case err != nil:
+			return nil, fmt.Errorf("failed to create guest: %w", err)
+		default:
+			user = created
+			log.Printf("Created guest user %s", user.Id)
+			s.events.Publish(entities.NewUserEvent(entities.UserEventCreated, user.Id, "guest"))
+		}
+	}
+
+	if err := checkUserStatus(user); err != nil {
+		return nil, err
+	}
+
+	device, err := s.deviceRepo.FindByFingerprint(ctx, user.Id, user.GuestDeviceHash)
+	if err != nil {
+		return nil, err
+	}
+	s.recordDevice(ctx, user.Id, device, fingerprint, "", createCommand.IPAddress, "", true)
+	s.recordLogin(ctx, user.Id, entities.LoginMethodGuest, true, "", createCommand.IPAddress, "", "")
+
+	return s.issueLoginToken(user, nil)
+}
+
+// UpgradeAccount starts turning a guest into a full account by sending an OTP
+// to the email it wants to attach
+func (s *UserService) UpgradeAccount(upgradeCommand *command.UpgradeAccountCommand) (*command.UpgradeAccountCommandResult, error) {
+	ctx := context.Background()
+
+	user, err := s.userRepo.FindById(upgradeCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+	if !user.IsGuest {
+		return nil, entities.ErrConflict.Errorf("account is not a guest account")
+	}
+
+	email := strings.TrimSpace(upgradeCommand.Email)
+	username := strings.TrimSpace(upgradeCommand.Username)
+
+	// Check the credentials now so the user does not wait for an OTP in vain
+	if err := entities.CheckRegistrationDenylist(username, email); err != nil {
+		return nil, err
+	}
+	if err := entities.CurrentTermsPolicy().CheckRegistration(upgradeCommand.AcceptedTermsVersion); err != nil {
+		return nil, err
+	}
+	candidate := entities.NewUser(valueOrDefault(username, user.Username), email, upgradeCommand.Password)
+	if _, err := entities.NewValidatedUser(candidate); err != nil {
+		return nil, err
+	}
+	if err := entities.CheckPasswordPolicy(upgradeCommand.Password); err != nil {
+		return nil, err
+	}
+	if err := checkPasswordBreach(s.breachChecker, upgradeCommand.Password); err != nil {
+		return nil, err
+	}
+
+	existingUser, err := s.userRepo.FindByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		return nil, entities.ErrEmailExists
+	}
+	if username != "" && username != user.Username {
+		existingUser, err = s.userRepo.FindByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		if existingUser != nil {
+			return nil, entities.ErrUsernameExists
+		}
+	}
+
+	if !s.rateLimiter.Allow("account_upgrade:" + user.Id.String()) {
+		return nil, s.rateLimiter.Exceeded("account_upgrade:"+user.Id.String(), "too many upgrade requests, please try again later")
+	}
+
+	if err := candidate.HashPassword(); err != nil {
+		return nil, err
+	}
+
+	otp := s.otpService.GenerateOTP(ctx)
+	pending := &infrastructure.PendingAccountUpgrade{
+		Username:     username,
+		Email:        email,
+		PasswordHash: candidate.Password,
+		OTP:          otp,
+		TermsVersion: upgradeCommand.AcceptedTermsVersion,
+	}
+	if err := s.redisService.SetPendingAccountUpgrade(ctx, user.Id.String(), pending, s.otpService.OTP_EXPIRY); err != nil {
+		return nil, fmt.Errorf("failed to cache pending upgrade: %w", err)
+	}
+
+	if err := s.otpService.SendOTP(ctx, email, otp); err != nil {
+		s.redisService.DeleteKey(ctx, "account_upgrade:"+user.Id.String())
+		return nil, fmt.Errorf("failed to send OTP: %w", err)
+	}
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(user.Id, entities.AuditOTPSent, "", "account_upgrade", "", ""))
+
+	return &command.UpgradeAccountCommandResult{
+		Message: "OTP sent to the email address",
+	}, nil
+}
+
+// ConfirmAccountUpgrade attaches the pending email and password to the guest,
+// keeping its id, and returns a regular token in place of the guest one
+func (s *UserService) ConfirmAccountUpgrade(confirmCommand *command.ConfirmAccountUpgradeCommand) (*command.ConfirmAccountUpgradeCommandResult, error) {
+	ctx := context.Background()
+	userID := confirmCommand.UserID.String()
+
+	if !s.rateLimiter.Allow("verify_account_upgrade:" + userID) {
+		return nil, s.rateLimiter.Exceeded("verify_account_upgrade:"+userID, "too many verification attempts, please try again later")
+	}
+
+	pending, err := s.redisService.GetPendingAccountUpgrade(ctx, userID)
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return nil, entities.ErrExpired.Errorf("account upgrade expired or not found")
+		}
+		return nil, fmt.Errorf("failed to retrieve pending upgrade: %w", err)
+	}
+
+	isValid, err := s.otpService.VerifyOTP(ctx, pending.Email, confirmCommand.OTP, pending.OTP)
+	if err != nil {
+		return nil, entities.ErrInvalidOTP.Errorf("OTP verification failed: %w", err)
+	}
+	if !isValid {
+		return nil, entities.ErrInvalidOTP
+	}
+
+	user, err := s.userRepo.FindById(confirmCommand.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, entities.ErrUserNotFound
+	}
+
+	// The address may have been claimed while the OTP was outstanding
+	existingUser, err := s.userRepo.FindByEmail(pending.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil && existingUser.Id != user.Id {
+		s.redisService.DeleteKey(ctx, "account_upgrade:"+userID)
+		return nil, entities.ErrEmailExists
+	}
+
+	if err := user.UpgradeFromGuest(pending.Username, pending.Email, pending.PasswordHash); err != nil {
+		return nil, err
+	}
+	if err := acceptRegistrationTerms(user, pending.TermsVersion); err != nil {
+		return nil, err
+	}
+
+	validatedUser, err := entities.NewValidatedUser(user)
+	if err != nil {
+		return nil, err
+	}
+	updatedUser, err := s.userRepo.Update(validatedUser)
+	if errors.Is(err, entities.ErrUserExists) {
+		// Claimed since the check above
+		s.redisService.DeleteKey(ctx, "account_upgrade:"+userID)
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upgrade account: %w", err)
+	}
+
+	// Drop the pending upgrade and the guest profile as the token is stored
+	writes := s.redisService.Batch()
+	writes.DeleteKey(ctx, "account_upgrade:"+userID)
+	writes.DeleteProfile(ctx, userID)
+	loginResult, err := s.issueLoginToken(updatedUser, writes)
+
+	s.events.Publish(entities.NewUserEvent(entities.UserEventProfileChanged, updatedUser.Id, "account_upgraded"))
+	s.events.Publish(entities.NewUserEvent(entities.UserEventVerified, updatedUser.Id, "account_upgraded"))
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(updatedUser.Id, entities.AuditOTPVerified, "", "account_upgrade", "", ""))
+	appendAudit(ctx, s.auditRepo, entities.NewAuditEvent(updatedUser.Id, entities.AuditPasswordChanged, "", "account_upgraded", "", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	return &command.ConfirmAccountUpgradeCommandResult{
+		Token: loginResult.Token,
+		User:  loginResult.User,
+	}, nil
+}
+
+func valueOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,50 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit actions
+const (
+	AuditLoginSucceeded = "login.succeeded"
+	AuditLoginFailed    = "login.failed"
+	// AuditPasswordChanged is recorded when a password is set on an account
+	// that exists, such as a guest upgrading
+	AuditPasswordChanged = "password.changed"
+	AuditOTPSent         = "otp.sent"
+	AuditOTPVerified     = "otp.verified"
+	AuditStatusChanged   = "admin.status_changed"
+	AuditForcedLogout    = "admin.logout"
+)
+
+// AuditEvent is a security relevant event on a user's account. Events are
+// only ever appended to the audit log, never changed or removed.
+type AuditEvent struct {
+	Id     uuid.UUID
+	UserId uuid.UUID
+	Action string
+	// Actor is the service that acted on the user's behalf, such as an admin
+	// tool, and empty when the user did
+	Actor string
+	// Detail says more about the action, such as the login method and why a
+	// login failed, or what an OTP was sent for
+	Detail    string
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+func NewAuditEvent(userID uuid.UUID, action, actor, detail, ip, userAgent string) *AuditEvent {
+	return &AuditEvent{
+		Id:        uuid.New(),
+		UserId:    userID,
+		Action:    action,
+		Actor:     actor,
+		Detail:    detail,
+		IPAddress: ip,
+		UserAgent: userAgent,
+		CreatedAt: time.Now(),
+	}
+}
@@ -0,0 +1,62 @@
+package entities
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Device struct {
+	Id              uuid.UUID
+	UserId          uuid.UUID
+	FingerprintHash string
+	UserAgent       string
+	LastIP          string
+	LastCountry     string
+	Trusted         bool
+	CreatedAt       time.Time
+	LastSeenAt      time.Time
+}
+
+func NewDevice(userID uuid.UUID, fingerprint, userAgent, ip, country string) (*Device, error) {
+	if fingerprint == "" {
+		return nil, ErrInvalidInput.Errorf("device fingerprint must not be empty")
+	}
+
+	now := time.Now()
+	return &Device{
+		Id:              uuid.New(),
+		UserId:          userID,
+		FingerprintHash: HashDeviceFingerprint(fingerprint),
+		UserAgent:       userAgent,
+		LastIP:          ip,
+		LastCountry:     country,
+		CreatedAt:       now,
+		LastSeenAt:      now,
+	}, nil
+}
+
+// HashDeviceFingerprint keeps raw client fingerprints out of the database
+func HashDeviceFingerprint(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *Device) Touch(userAgent, ip, country string) {
+	if userAgent != "" {
+		d.UserAgent = userAgent
+	}
+	if ip != "" {
+		d.LastIP = ip
+	}
+	if country != "" {
+		d.LastCountry = country
+	}
+	d.LastSeenAt = time.Now()
+}
+
+func (d *Device) MarkAsTrusted() {
+	d.Trusted = true
+}
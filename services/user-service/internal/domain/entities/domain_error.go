@@ -0,0 +1,136 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Stable, machine-readable error codes. Clients branch on these, so they are
+// only ever added, never renamed; the messages beside them may change.
+const (
+	CodeInvalidInput        = "invalid_input"
+	CodeValidationFailed    = "validation_failed"
+	CodeUnauthorized        = "unauthorized"
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeInvalidOTP          = "invalid_otp"
+	CodeInvalidRecoveryCode = "invalid_recovery_code"
+	CodeForbidden           = "forbidden"
+	CodeAccountInactive     = "account_inactive"
+	CodeNotFound            = "not_found"
+	CodeUserNotFound        = "user_not_found"
+	CodeConflict            = "conflict"
+	CodeUserExists          = "user_exists"
+	CodeUsernameExists      = "username_exists"
+	CodeEmailExists         = "email_exists"
+	CodeExpired             = "expired"
+	CodeOTPExpired          = "otp_expired"
+	CodeRegistrationExpired = "registration_expired"
+	CodePayloadTooLarge     = "payload_too_large"
+	CodeRateLimited         = "rate_limited"
+	CodeUnavailable         = "unavailable"
+	CodeInternal            = "internal"
+)
+
+// DomainError is an error with a stable code. Errors match by code, so
+// errors.Is(err, ErrRateLimited) holds whatever the message says.
+type DomainError struct {
+	Code      string
+	Message   string
+	RateLimit *RateLimit // The exhausted quota of a rate_limited error, when known
+	cause     error
+}
+
+// RateLimit describes a quota a request ran out of
+type RateLimit struct {
+	Limit      int           // Requests allowed per window
+	Remaining  int           // Requests left in the current window
+	ResetAfter time.Duration // Until another request is allowed
+}
+
+// ResetSeconds is ResetAfter in whole seconds, rounded up so a client that
+// waits that long is not turned away again
+func (l *RateLimit) ResetSeconds() int64 {
+	return int64((l.ResetAfter + time.Second - 1) / time.Second)
+}
+
+var (
+	ErrInvalidInput        = &DomainError{Code: CodeInvalidInput, Message: "invalid input"}
+	ErrUnauthorized        = &DomainError{Code: CodeUnauthorized, Message: "unauthorized"}
+	ErrInvalidCredentials  = &DomainError{Code: CodeInvalidCredentials, Message: "invalid credentials"}
+	ErrInvalidOTP          = &DomainError{Code: CodeInvalidOTP, Message: "invalid OTP"}
+	ErrInvalidRecoveryCode = &DomainError{Code: CodeInvalidRecoveryCode, Message: "invalid recovery code"}
+	ErrForbidden           = &DomainError{Code: CodeForbidden, Message: "forbidden"}
+	ErrAccountInactive     = &DomainError{Code: CodeAccountInactive, Message: "account is not active"}
+	ErrNotFound            = &DomainError{Code: CodeNotFound, Message: "not found"}
+	ErrUserNotFound        = &DomainError{Code: CodeUserNotFound, Message: "user not found"}
+	ErrConflict            = &DomainError{Code: CodeConflict, Message: "conflict"}
+	ErrUserExists          = &DomainError{Code: CodeUserExists, Message: "user already exists"}
+	ErrUsernameExists      = &DomainError{Code: CodeUsernameExists, Message: "username already exists"}
+	ErrEmailExists         = &DomainError{Code: CodeEmailExists, Message: "email already exists"}
+	ErrExpired             = &DomainError{Code: CodeExpired, Message: "expired or not found"}
+	ErrOTPExpired          = &DomainError{Code: CodeOTPExpired, Message: "OTP expired or not found"}
+	ErrRegistrationExpired = &DomainError{Code: CodeRegistrationExpired, Message: "registration expired or not found"}
+	ErrPayloadTooLarge     = &DomainError{Code: CodePayloadTooLarge, Message: "payload too large"}
+	ErrRateLimited         = &DomainError{Code: CodeRateLimited, Message: "too many requests, please try again later"}
+	ErrUnavailable         = &DomainError{Code: CodeUnavailable, Message: "service unavailable, try again later"}
+)
+
+func (e *DomainError) Error() string {
+	return e.Message
+}
+
+// Is matches any error with the same code. ErrUserExists also matches
+// username_exists and email_exists, so callers can tell any duplicate user
+// apart from other failures.
+func (e *DomainError) Is(target error) bool {
+	t, ok := target.(*DomainError)
+	if !ok {
+		return false
+	}
+	if t.Code == CodeUserExists {
+		return e.Code == CodeUserExists || e.Code == CodeUsernameExists || e.Code == CodeEmailExists
+	}
+	return t.Code == e.Code
+}
+
+func (e *DomainError) Unwrap() error {
+	return e.cause
+}
+
+// Errorf returns an error with the code of e and a message formatted like
+// fmt.Errorf, wrapping the operand of a %w verb
+func (e *DomainError) Errorf(format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &DomainError{Code: e.Code, Message: err.Error(), cause: errors.Unwrap(err)}
+}
+
+// NewRateLimitError returns a rate_limited error with message that tells
+// the client about the quota it exhausted
+func NewRateLimitError(message string, limit RateLimit) error {
+	return &DomainError{Code: CodeRateLimited, Message: message, RateLimit: &limit}
+}
+
+// RateLimitOf returns the quota carried by a rate_limited error, nil when
+// err carries none
+func RateLimitOf(err error) *RateLimit {
+	var domainErr *DomainError
+	if errors.As(err, &domainErr) {
+		return domainErr.RateLimit
+	}
+	return nil
+}
+
+// ErrorCode is the code of err: that of a domain error, validation_failed for
+// a validation error, and internal for anything else
+func ErrorCode(err error) string {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return CodeValidationFailed
+	}
+	var domainErr *DomainError
+	if errors.As(err, &domainErr) {
+		return domainErr.Code
+	}
+	return CodeInternal
+}
@@ -0,0 +1,47 @@
+package entities
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const guestUsernamePrefix = "guest_"
+
+// NewGuestUser creates an anonymous user tied to one device. It has no email
+// or password until it is upgraded, and keeps its id when that happens.
+func NewGuestUser(deviceFingerprint string) (*User, error) {
+	if deviceFingerprint == "" {
+		return nil, ErrInvalidInput.Errorf("device fingerprint must not be empty")
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+
+	user := NewUser(guestUsernamePrefix+hex.EncodeToString(suffix), "", "")
+	user.IsGuest = true
+	user.GuestDeviceHash = HashDeviceFingerprint(deviceFingerprint)
+	return user, nil
+}
+
+// UpgradeFromGuest turns a guest into a full account with a verified email.
+// hashedPassword must already be hashed, an empty username keeps the
+// generated one.
+func (u *User) UpgradeFromGuest(username, email, hashedPassword string) error {
+	if !u.IsGuest {
+		return ErrConflict.Errorf("account is not a guest account")
+	}
+
+	if username != "" {
+		u.Username = username
+	}
+	u.Email = email
+	u.Password = hashedPassword
+	u.IsGuest = false
+	u.GuestDeviceHash = ""
+	u.IsVerified = true
+	u.UpdatedAt = time.Now()
+	return u.validate()
+}
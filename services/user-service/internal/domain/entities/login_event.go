@@ -0,0 +1,45 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Login methods recorded in the login history
+const (
+	LoginMethodPassword     = "password"
+	LoginMethodRecoveryCode = "recovery_code"
+	LoginMethodOTPChallenge = "otp_challenge"
+	LoginMethodGuest        = "guest"
+)
+
+// LoginEvent is one successful or failed login attempt on an existing account
+type LoginEvent struct {
+	Id            uuid.UUID
+	UserId        uuid.UUID
+	Method        string
+	Success       bool
+	FailureReason string
+	IPAddress     string
+	UserAgent     string
+	Country       string
+	CreatedAt     time.Time
+}
+
+func NewLoginEvent(userID uuid.UUID, method string, success bool, failureReason, ip, userAgent, country string) *LoginEvent {
+	if success {
+		failureReason = ""
+	}
+	return &LoginEvent{
+		Id:            uuid.New(),
+		UserId:        userID,
+		Method:        method,
+		Success:       success,
+		FailureReason: failureReason,
+		IPAddress:     ip,
+		UserAgent:     userAgent,
+		Country:       country,
+		CreatedAt:     time.Now(),
+	}
+}
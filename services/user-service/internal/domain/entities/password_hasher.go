@@ -0,0 +1,204 @@
+package entities
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes new passwords and verifies stored hashes in its own
+// format. Stored hashes carry their parameters, so a hasher can verify hashes
+// made with other settings and report them through NeedsRehash.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) error
+	// Recognizes reports whether hash is in this hasher's format
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether hash should be replaced by a fresh Hash
+	NeedsRehash(hash string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+func (h *BcryptHasher) Verify(hash, password string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+func (h *BcryptHasher) Recognizes(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idHasher produces PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func NewArgon2idHasher(memory, iterations uint32, parallelism uint8) *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      memory,
+		Iterations:  iterations,
+		Parallelism: parallelism,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) error {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// Recognizes decodes the whole hash, a value merely starting like one is not
+func (h *Argon2idHasher) Recognizes(hash string) bool {
+	_, _, _, err := decodeArgon2idHash(hash)
+	return err == nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.Memory || params.Iterations != h.Iterations || params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+}
+
+func decodeArgon2idHash(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+	// An empty key would match every password
+	if len(salt) == 0 || len(key) == 0 {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	return params, salt, key, nil
+}
+
+var (
+	passwordHasher      PasswordHasher = NewBcryptHasher(bcrypt.DefaultCost)
+	passwordHasherMutex sync.RWMutex
+
+	// knownPasswordHashers verify stored hashes whatever algorithm is configured
+	knownPasswordHashers = []PasswordHasher{
+		NewBcryptHasher(bcrypt.DefaultCost),
+		NewArgon2idHasher(64*1024, 3, 2),
+	}
+)
+
+// SetPasswordHasher replaces the hasher new passwords are hashed with.
+// Hashes made by the other supported algorithms keep verifying.
+func SetPasswordHasher(hasher PasswordHasher) {
+	passwordHasherMutex.Lock()
+	defer passwordHasherMutex.Unlock()
+	passwordHasher = hasher
+}
+
+func currentPasswordHasher() PasswordHasher {
+	passwordHasherMutex.RLock()
+	defer passwordHasherMutex.RUnlock()
+	return passwordHasher
+}
+
+// hasherFor returns the hasher that can verify hash, or nil if the value is
+// not a hash any supported algorithm produces
+func hasherFor(hash string) PasswordHasher {
+	if current := currentPasswordHasher(); current.Recognizes(hash) {
+		return current
+	}
+	for _, hasher := range knownPasswordHashers {
+		if hasher.Recognizes(hash) {
+			return hasher
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package entities
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Password policy violation codes returned to clients
+const (
+	ViolationTooShort      = "too_short"
+	ViolationTooLong       = "too_long"
+	ViolationMissingUpper  = "missing_uppercase"
+	ViolationMissingLower  = "missing_lowercase"
+	ViolationMissingDigit  = "missing_digit"
+	ViolationMissingSymbol = "missing_symbol"
+	ViolationCommon        = "common_password"
+	ViolationBreached      = "breached_password"
+)
+
+// bcrypt silently truncates input past 72 bytes
+const maxPasswordLength = 72
+
+var commonPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "password", "password1",
+	"password123", "qwerty", "qwerty123", "abc123", "111111", "123123",
+	"letmein", "welcome", "admin", "admin123", "iloveyou", "monkey",
+	"dragon", "football", "baseball", "sunshine", "princess", "passw0rd",
+	"trustno1", "superman", "1q2w3e4r", "zaq12wsx", "000000", "changeme",
+}
+
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports every rule a field failed, so clients can show all
+// problems at once instead of one per round trip.
+type ValidationError struct {
+	Field      string            `json:"field"`
+	Violations []PolicyViolation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return fmt.Sprintf("%s is invalid: %s", e.Field, strings.Join(messages, "; "))
+}
+
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	denylist      map[string]struct{}
+}
+
+func NewPasswordPolicy(minLength int, requireUpper, requireLower, requireDigit, requireSymbol bool, denylist []string) *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:     minLength,
+		RequireUpper:  requireUpper,
+		RequireLower:  requireLower,
+		RequireDigit:  requireDigit,
+		RequireSymbol: requireSymbol,
+		denylist:      make(map[string]struct{}, len(commonPasswords)+len(denylist)),
+	}
+
+	for _, password := range commonPasswords {
+		policy.denylist[password] = struct{}{}
+	}
+	for _, password := range denylist {
+		if password = strings.ToLower(strings.TrimSpace(password)); password != "" {
+			policy.denylist[password] = struct{}{}
+		}
+	}
+
+	return policy
+}
+
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return NewPasswordPolicy(8, false, true, true, false, nil)
+}
+
+// Validate returns a *ValidationError listing every rule the password breaks
+func (p *PasswordPolicy) Validate(password string) error {
+	var violations []PolicyViolation
+
+	if len(password) < p.MinLength {
+		violations = append(violations, PolicyViolation{
+			Code:    ViolationTooShort,
+			Message: fmt.Sprintf("must be at least %d characters", p.MinLength),
+		})
+	}
+	if len(password) > maxPasswordLength {
+		violations = append(violations, PolicyViolation{
+			Code:    ViolationTooLong,
+			Message: fmt.Sprintf("must be at most %d bytes", maxPasswordLength),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PolicyViolation{Code: ViolationMissingUpper, Message: "must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PolicyViolation{Code: ViolationMissingLower, Message: "must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PolicyViolation{Code: ViolationMissingDigit, Message: "must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PolicyViolation{Code: ViolationMissingSymbol, Message: "must contain a symbol"})
+	}
+
+	if _, denied := p.denylist[strings.ToLower(password)]; denied {
+		violations = append(violations, PolicyViolation{Code: ViolationCommon, Message: "is too common"})
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Field: "password", Violations: violations}
+	}
+	return nil
+}
+
+var (
+	passwordPolicy      = DefaultPasswordPolicy()
+	passwordPolicyMutex sync.RWMutex
+)
+
+// SetPasswordPolicy replaces the policy applied by CheckPasswordPolicy
+func SetPasswordPolicy(policy *PasswordPolicy) {
+	passwordPolicyMutex.Lock()
+	defer passwordPolicyMutex.Unlock()
+	passwordPolicy = policy
+}
+
+func currentPasswordPolicy() *PasswordPolicy {
+	passwordPolicyMutex.RLock()
+	defer passwordPolicyMutex.RUnlock()
+	return passwordPolicy
+}
+
+// CheckPasswordPolicy checks a plaintext password chosen by a user against
+// the configured policy. It is given the password from the request, never a
+// user's stored value, which may as well be a hash.
+func CheckPasswordPolicy(password string) error {
+	return currentPasswordPolicy().Validate(password)
+}
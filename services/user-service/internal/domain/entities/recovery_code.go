@@ -0,0 +1,86 @@
+package entities
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecoveryCodeCount is how many codes a user holds after each regeneration
+const RecoveryCodeCount = 10
+
+// Crockford-style alphabet without easily confused characters
+const recoveryCodeAlphabet = "ABCDEFGHJKMNPQRSTVWXYZ23456789"
+
+type RecoveryCode struct {
+	Id        uuid.UUID
+	UserId    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// NewRecoveryCodes generates a fresh set of single-use codes for a user and
+// returns them with their plaintext values, which are shown once and never stored.
+func NewRecoveryCodes(userID uuid.UUID) ([]*RecoveryCode, []string, error) {
+	codes := make([]*RecoveryCode, 0, RecoveryCodeCount)
+	plaintexts := make([]string, 0, RecoveryCodeCount)
+	now := time.Now()
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		plaintext, err := generateRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, &RecoveryCode{
+			Id:        uuid.New(),
+			UserId:    userID,
+			CodeHash:  HashRecoveryCode(plaintext),
+			CreatedAt: now,
+		})
+		plaintexts = append(plaintexts, plaintext)
+	}
+
+	return codes, plaintexts, nil
+}
+
+// HashRecoveryCode normalizes a code as typed by a user and hashes it
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *RecoveryCode) IsUsed() bool {
+	return c.UsedAt != nil
+}
+
+func (c *RecoveryCode) MarkAsUsed() {
+	now := time.Now()
+	c.UsedAt = &now
+}
+
+// generateRecoveryCode returns a code formatted as XXXXX-XXXXX
+func generateRecoveryCode() (string, error) {
+	// rand.Int draws uniformly, unlike a random byte modulo the alphabet size
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	code := make([]byte, 0, 11)
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code = append(code, recoveryCodeAlphabet[n.Int64()])
+	}
+	return string(code), nil
+}
@@ -0,0 +1,135 @@
+package entities
+
+import (
+	"strings"
+	"sync"
+)
+
+// Registration denylist violation codes returned to clients
+const (
+	ViolationReservedUsername = "reserved_username"
+	ViolationDisposableEmail  = "disposable_email"
+)
+
+var defaultReservedUsernames = []string{
+	"admin", "administrator", "root", "support", "help", "system", "sysadmin",
+	"moderator", "mod", "staff", "security", "abuse", "postmaster", "webmaster",
+	"hostmaster", "noreply", "api", "billing", "official", "owner",
+}
+
+var defaultDisposableEmailDomains = []string{
+	"mailinator.com", "guerrillamail.com", "10minutemail.com", "tempmail.com",
+	"temp-mail.org", "yopmail.com", "trashmail.com", "sharklasers.com",
+	"getnada.com", "dispostable.com", "maildrop.cc", "throwawaymail.com",
+}
+
+// RegistrationDenylist rejects reserved usernames and disposable email
+// domains when accounts are created or renamed
+type RegistrationDenylist struct {
+	reservedUsernames map[string]struct{}
+	blockedDomains    map[string]struct{}
+}
+
+// NewRegistrationDenylist combines the built-in lists with extra entries
+func NewRegistrationDenylist(reservedUsernames, blockedDomains []string) *RegistrationDenylist {
+	denylist := &RegistrationDenylist{
+		reservedUsernames: make(map[string]struct{}, len(defaultReservedUsernames)+len(reservedUsernames)),
+		blockedDomains:    make(map[string]struct{}, len(defaultDisposableEmailDomains)+len(blockedDomains)),
+	}
+
+	for _, username := range append(append([]string{}, defaultReservedUsernames...), reservedUsernames...) {
+		if username = normalizeUsername(username); username != "" {
+			denylist.reservedUsernames[username] = struct{}{}
+		}
+	}
+	for _, domain := range append(append([]string{}, defaultDisposableEmailDomains...), blockedDomains...) {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" && !strings.HasPrefix(domain, "#") {
+			denylist.blockedDomains[domain] = struct{}{}
+		}
+	}
+
+	return denylist
+}
+
+// Size returns the number of reserved usernames and blocked domains
+func (d *RegistrationDenylist) Size() (int, int) {
+	return len(d.reservedUsernames), len(d.blockedDomains)
+}
+
+// CheckUsername returns a *ValidationError for reserved usernames. Case and
+// separators are ignored, so "Ad_min" is as reserved as "admin".
+func (d *RegistrationDenylist) CheckUsername(username string) error {
+	if _, reserved := d.reservedUsernames[normalizeUsername(username)]; reserved {
+		return &ValidationError{
+			Field:      "username",
+			Violations: []PolicyViolation{{Code: ViolationReservedUsername, Message: "is reserved"}},
+		}
+	}
+	return nil
+}
+
+// CheckEmail returns a *ValidationError for addresses at a blocked domain or
+// any of its subdomains
+func (d *RegistrationDenylist) CheckEmail(email string) error {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil
+	}
+
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	for domain != "" {
+		if _, blocked := d.blockedDomains[domain]; blocked {
+			return &ValidationError{
+				Field:      "email",
+				Violations: []PolicyViolation{{Code: ViolationDisposableEmail, Message: "uses a disposable email provider"}},
+			}
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return nil
+}
+
+func normalizeUsername(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if strings.HasPrefix(username, "#") {
+		return ""
+	}
+	return strings.NewReplacer("_", "", "-", "", ".", "").Replace(username)
+}
+
+var (
+	registrationDenylist      = NewRegistrationDenylist(nil, nil)
+	registrationDenylistMutex sync.RWMutex
+)
+
+// SetRegistrationDenylist replaces the denylist, it is safe to call while
+// requests are being served
+func SetRegistrationDenylist(denylist *RegistrationDenylist) {
+	registrationDenylistMutex.Lock()
+	defer registrationDenylistMutex.Unlock()
+	registrationDenylist = denylist
+}
+
+// CheckRegistrationDenylist validates a username and email against the
+// current denylist. Either may be empty to skip it.
+func CheckRegistrationDenylist(username, email string) error {
+	registrationDenylistMutex.RLock()
+	denylist := registrationDenylist
+	registrationDenylistMutex.RUnlock()
+
+	if username != "" {
+		if err := denylist.CheckUsername(username); err != nil {
+			return err
+		}
+	}
+	if email != "" {
+		if err := denylist.CheckEmail(email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package entities
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ViolationTermsNotAccepted is returned when a registration does not accept
+// the current terms of service
+const ViolationTermsNotAccepted = "terms_not_accepted"
+
+// TermsPolicy describes the published terms of service. CurrentVersion is
+// what new acceptances record; users below RequiredVersion cannot log in
+// until they accept again. A CurrentVersion of 0 turns tracking off.
+type TermsPolicy struct {
+	CurrentVersion  int
+	RequiredVersion int
+}
+
+func (p TermsPolicy) Enabled() bool {
+	return p.CurrentVersion > 0
+}
+
+// CheckRegistration requires new accounts to accept the current version
+func (p TermsPolicy) CheckRegistration(acceptedVersion int) error {
+	if !p.Enabled() || acceptedVersion == p.CurrentVersion {
+		return nil
+	}
+	return &ValidationError{
+		Field: "terms",
+		Violations: []PolicyViolation{{
+			Code:    ViolationTermsNotAccepted,
+			Message: fmt.Sprintf("terms of service version %d must be accepted", p.CurrentVersion),
+		}},
+	}
+}
+
+// TermsAcceptanceRequiredError blocks a login until Version is accepted
+type TermsAcceptanceRequiredError struct {
+	Version int
+}
+
+func (e *TermsAcceptanceRequiredError) Error() string {
+	return fmt.Sprintf("terms of service version %d must be accepted before logging in", e.Version)
+}
+
+// AcceptTerms records that the user accepted version of the terms, which
+// must be the one currently published
+func (u *User) AcceptTerms(version int) error {
+	policy := CurrentTermsPolicy()
+	if !policy.Enabled() {
+		return fmt.Errorf("terms of service are not configured")
+	}
+	if version != policy.CurrentVersion {
+		return ErrInvalidInput.Errorf("terms of service version %d is not current, accept version %d", version, policy.CurrentVersion)
+	}
+
+	now := time.Now()
+	u.TermsVersion = version
+	u.TermsAcceptedAt = &now
+	u.UpdatedAt = now
+	return nil
+}
+
+// CheckTermsAccepted returns a *TermsAcceptanceRequiredError when the user has
+// to accept newer terms before logging in. Guests never accepted any terms
+// and are not held to them.
+func (u *User) CheckTermsAccepted() error {
+	policy := CurrentTermsPolicy()
+	if !policy.Enabled() || u.IsGuest || u.TermsVersion >= policy.RequiredVersion {
+		return nil
+	}
+	return &TermsAcceptanceRequiredError{Version: policy.CurrentVersion}
+}
+
+var (
+	termsPolicy      TermsPolicy
+	termsPolicyMutex sync.RWMutex
+)
+
+func SetTermsPolicy(policy TermsPolicy) {
+	termsPolicyMutex.Lock()
+	defer termsPolicyMutex.Unlock()
+	termsPolicy = policy
+}
+
+func CurrentTermsPolicy() TermsPolicy {
+	termsPolicyMutex.RLock()
+	defer termsPolicyMutex.RUnlock()
+	return termsPolicy
+}
@@ -3,20 +3,41 @@ package entities
 import (
 	"errors"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
 )
 
 type User struct {
-	Id         uuid.UUID
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
-	Username   string
-	Email      string
-	Password   string
-	Tokens     []string
-	IsVerified bool
+	Id           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Username     string
+	Email        string
+	Password     string
+	Tokens       []string
+	IsVerified   bool
+	Status       UserStatus
+	StatusReason string
+	DisplayName  string
+	Bio          string
+	AvatarURL    string
+	AvatarKey    string
+	InvitedBy    *uuid.UUID
+	// TermsVersion is the terms of service version last accepted, 0 for none
+	TermsVersion    int
+	TermsAcceptedAt *time.Time
+	// Guests have no email or password, only the device they were created on
+	IsGuest         bool
+	GuestDeviceHash string
+	// Version is the version of the row the user was loaded from. Saving the
+	// user fails with ErrConflict once the row has moved past it.
+	Version int
 }
 
 func NewUser(username, email, password string) *User {
@@ -29,36 +50,82 @@ func NewUser(username, email, password string) *User {
 		Password:   password,
 		Tokens:     make([]string, 0),
 		IsVerified: false,
+		Status:     UserStatusActive,
 	}
 }
 
 func (u *User) validate() error {
 	if u.Username == "" {
-		return errors.New("username must not be empty")
+		return ErrInvalidInput.Errorf("username must not be empty")
+	}
+	if u.IsGuest {
+		return nil
 	}
 	if u.Email == "" {
-		return errors.New("email must not be empty")
+		return ErrInvalidInput.Errorf("email must not be empty")
 	}
 	if u.Password == "" {
-		return errors.New("password must not be empty")
+		return ErrInvalidInput.Errorf("password must not be empty")
 	}
 	if u.CreatedAt.After(u.UpdatedAt) {
-		return errors.New("created_at must be before updated_at")
+		return ErrInvalidInput.Errorf("created_at must be before updated_at")
 	}
 	return nil
 }
 
 func (u *User) HashPassword() error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPassword, err := currentPasswordHasher().Hash(u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
 func (u *User) CheckPassword(password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+	hasher := hasherFor(u.Password)
+	if hasher == nil {
+		return errors.New("unsupported password hash")
+	}
+	return hasher.Verify(u.Password, password)
+}
+
+// PasswordNeedsRehash reports whether the stored hash was made with another
+// algorithm or weaker parameters than the configured hasher uses
+func (u *User) PasswordNeedsRehash() bool {
+	return currentPasswordHasher().NeedsRehash(u.Password)
+}
+
+// UpdateDetails sets the free-form profile fields shown to other users
+func (u *User) UpdateDetails(displayName, bio string) error {
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return ErrInvalidInput.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+	}
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return ErrInvalidInput.Errorf("bio must be at most %d characters", maxBioLength)
+	}
+
+	u.DisplayName = displayName
+	u.Bio = bio
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+// SetAvatar points the user at a newly stored avatar and returns the storage
+// key of the one it replaces, if any
+func (u *User) SetAvatar(key, url string) string {
+	previousKey := u.AvatarKey
+	u.AvatarKey = key
+	u.AvatarURL = url
+	u.UpdatedAt = time.Now()
+	return previousKey
+}
+
+// AcceptInvite links the user to whoever invited them. The invite was sent to
+// the user's email, so the address counts as verified.
+func (u *User) AcceptInvite(inviterID *uuid.UUID) {
+	u.InvitedBy = inviterID
+	u.MarkAsVerified()
 }
 
 func (u *User) AddToken(token string) {
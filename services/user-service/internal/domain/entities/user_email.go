@@ -0,0 +1,39 @@
+package entities
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxSecondaryEmails caps the verified addresses a user can attach besides
+// the primary one
+const MaxSecondaryEmails = 5
+
+// UserEmail is a verified secondary address of a user. The primary address
+// stays on User.Email; promoting a secondary swaps the two.
+type UserEmail struct {
+	Id         uuid.UUID
+	UserId     uuid.UUID
+	Email      string
+	VerifiedAt time.Time
+	CreatedAt  time.Time
+}
+
+// NewUserEmail records an address whose ownership was just proven with an OTP
+func NewUserEmail(userID uuid.UUID, email string) (*UserEmail, error) {
+	email = strings.TrimSpace(email)
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, ErrInvalidInput.Errorf("email is invalid")
+	}
+
+	now := time.Now()
+	return &UserEmail{
+		Id:         uuid.New(),
+		UserId:     userID,
+		Email:      email,
+		VerifiedAt: now,
+		CreatedAt:  now,
+	}, nil
+}
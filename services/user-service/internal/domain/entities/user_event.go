@@ -0,0 +1,42 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User event types
+const (
+	// UserEventTokenRevoked means the user's existing tokens stopped being valid
+	UserEventTokenRevoked = "token.revoked"
+	// UserEventProfileChanged means a cached copy of the user's profile is stale
+	UserEventProfileChanged = "profile.changed"
+	// UserEventLoginSucceeded means someone logged in as the user
+	UserEventLoginSucceeded = "login.succeeded"
+	// UserEventOTPExpiring means the OTP of a pending login is about to expire
+	UserEventOTPExpiring = "otp.expiring"
+	// UserEventCreated means an account was created
+	UserEventCreated = "user.created"
+	// UserEventVerified means the account's email address was verified
+	UserEventVerified = "user.verified"
+	// UserEventDeleted means the account was removed for good
+	UserEventDeleted = "user.deleted"
+)
+
+// UserEvent tells other parts of the system that something about a user changed
+type UserEvent struct {
+	Type       string    `json:"type"`
+	UserId     uuid.UUID `json:"userID"`
+	Reason     string    `json:"reason,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func NewUserEvent(eventType string, userID uuid.UUID, reason string) UserEvent {
+	return UserEvent{
+		Type:       eventType,
+		UserId:     userID,
+		Reason:     reason,
+		OccurredAt: time.Now(),
+	}
+}
@@ -0,0 +1,41 @@
+package entities
+
+import "time"
+
+type UserStatus string
+
+const (
+	UserStatusActive    UserStatus = "active"
+	UserStatusSuspended UserStatus = "suspended"
+	UserStatusBanned    UserStatus = "banned"
+)
+
+func ParseUserStatus(value string) (UserStatus, error) {
+	switch status := UserStatus(value); status {
+	case UserStatusActive, UserStatusSuspended, UserStatusBanned:
+		return status, nil
+	}
+	return "", ErrInvalidInput.Errorf("status must be one of active, suspended, banned")
+}
+
+// IsActive reports whether the user may log in and use their tokens. Users
+// stored before statuses existed have none and count as active.
+func (u *User) IsActive() bool {
+	return u.Status == "" || u.Status == UserStatusActive
+}
+
+// ChangeStatus moves the user to a new status. A reason is required for
+// anything but reinstating, so support can tell the user why.
+func (u *User) ChangeStatus(status UserStatus, reason string) error {
+	if status != UserStatusActive && reason == "" {
+		return ErrInvalidInput.Errorf("a reason is required to suspend or ban a user")
+	}
+	if status == UserStatusActive {
+		reason = ""
+	}
+
+	u.Status = status
+	u.StatusReason = reason
+	u.UpdatedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,15 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *entities.APIKey) (*entities.APIKey, error)
+	FindById(ctx context.Context, id uuid.UUID) (*entities.APIKey, error)
+	FindByHash(ctx context.Context, keyHash string) (*entities.APIKey, error)
+	Update(ctx context.Context, key *entities.APIKey) (*entities.APIKey, error)
+}
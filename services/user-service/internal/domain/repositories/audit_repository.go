@@ -0,0 +1,24 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+// AuditRepository is the append-only audit log. It offers no way to change
+// or remove an event.
+type AuditRepository interface {
+	Append(ctx context.Context, event *entities.AuditEvent) error
+	// ListByUser returns the user's events newest first, at most limit of
+	// them, starting right after before when it is not nil
+	ListByUser(ctx context.Context, userID uuid.UUID, before *AuditCursor, limit int) ([]*entities.AuditEvent, error)
+}
+
+// AuditCursor is the position of the last event of a page
+type AuditCursor struct {
+	CreatedAt time.Time
+	Id        uuid.UUID
+}
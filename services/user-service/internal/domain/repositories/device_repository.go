@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+type DeviceRepository interface {
+	FindByFingerprint(ctx context.Context, userID uuid.UUID, fingerprintHash string) (*entities.Device, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]*entities.Device, error)
+	Save(ctx context.Context, device *entities.Device) (*entities.Device, error)
+	// Delete removes a device of the user, reporting false if it did not exist
+	Delete(ctx context.Context, userID uuid.UUID, deviceID uuid.UUID) (bool, error)
+}
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"user-service-new/internal/domain/entities"
 )
@@ -10,4 +11,7 @@ type IdempotencyRepository interface {
 	FindByKey(ctx context.Context, key string) (*entities.IdempotencyRecord, error)
 	Create(ctx context.Context, record *entities.IdempotencyRecord) (*entities.IdempotencyRecord, error)
 	Update(ctx context.Context, record *entities.IdempotencyRecord) (*entities.IdempotencyRecord, error)
+	// DeleteCreatedBefore removes the records created before cutoff, once they
+	// are too old to be replayed, and returns how many it removed
+	DeleteCreatedBefore(ctx context.Context, cutoff time.Time) (int64, error)
 }
\ No newline at end of file
@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+type InviteRepository interface {
+	Create(ctx context.Context, invite *entities.Invite) error
+	FindByTokenHash(ctx context.Context, tokenHash string) (*entities.Invite, error)
+	// MarkAccepted claims an unaccepted invite for userID, returning false if
+	// it was already accepted
+	MarkAccepted(ctx context.Context, id uuid.UUID, userID uuid.UUID) (bool, error)
+	// Release undoes MarkAccepted when registration fails afterwards
+	Release(ctx context.Context, id uuid.UUID) error
+}
@@ -0,0 +1,14 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+type LoginHistoryRepository interface {
+	Record(ctx context.Context, event *entities.LoginEvent) error
+	// ListByUser returns the most recent events first, at most limit of them
+	ListByUser(ctx context.Context, userID uuid.UUID, limit int) ([]*entities.LoginEvent, error)
+}
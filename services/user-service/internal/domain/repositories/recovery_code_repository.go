@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+type RecoveryCodeRepository interface {
+	// ReplaceForUser discards every existing code of the user and stores the new set
+	ReplaceForUser(ctx context.Context, userID uuid.UUID, codes []*entities.RecoveryCode) error
+	// ConsumeUnused marks the matching unused code as used, returning nil if there is none
+	ConsumeUnused(ctx context.Context, userID uuid.UUID, codeHash string) (*entities.RecoveryCode, error)
+	CountUnused(ctx context.Context, userID uuid.UUID) (int, error)
+}
@@ -0,0 +1,18 @@
+package repositories
+
+import "context"
+
+// UnitOfWork groups writes to several repositories in one transaction, so
+// they commit together or not at all
+type UnitOfWork interface {
+	// Do calls fn with repositories writing in a new transaction, committed
+	// when fn returns nil and rolled back when it returns an error
+	Do(ctx context.Context, fn func(tx Transaction) error) error
+}
+
+// Transaction hands out the repositories taking part in a unit of work. They
+// are only valid until fn returns.
+type Transaction interface {
+	Users() UserRepository
+	IdempotencyRecords() IdempotencyRepository
+}
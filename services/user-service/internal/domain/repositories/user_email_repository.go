@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+type UserEmailRepository interface {
+	Create(ctx context.Context, email *entities.UserEmail) error
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]*entities.UserEmail, error)
+	// Delete removes a secondary address of the user, reporting false if it did not exist
+	Delete(ctx context.Context, userID uuid.UUID, email string) (bool, error)
+	// MakePrimary swaps a secondary address with the user's primary one, so the
+	// old primary stays attached as a secondary address
+	MakePrimary(ctx context.Context, userID uuid.UUID, email string) error
+}
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"user-service-new/internal/domain/entities"
@@ -13,8 +14,66 @@ type UserRepository interface {
 	FindByUsername(username string) (*entities.User, error)
 	FindByEmail(email string) (*entities.User, error)
 	FindByCredentials(username string) (*entities.User, error)
+	FindGuestByDevice(deviceHash string) (*entities.User, error)
 	Update(user *entities.ValidatedUser) (*entities.User, error)
+	UpdatePasswordHash(ctx context.Context, userID uuid.UUID, oldHash, newHash string) (bool, error)
+	UpdateTermsAcceptance(ctx context.Context, userID uuid.UUID, version int, acceptedAt time.Time) error
 	Delete(id uuid.UUID) error
+	// DeleteUnverifiedBefore permanently removes registered accounts that were
+	// never verified and were created before cutoff, guests excluded, and
+	// returns their IDs
+	DeleteUnverifiedBefore(ctx context.Context, cutoff time.Time) ([]uuid.UUID, error)
 	UpdateTokens(ctx context.Context, userID uuid.UUID, token string) error
+	// ClearTokens forgets every token of the user and returns them
+	ClearTokens(ctx context.Context, userID uuid.UUID) ([]string, error)
+	HasToken(ctx context.Context, userID uuid.UUID, token string) (bool, error)
+	// FindByToken returns the user holding token, nil when none does
+	FindByToken(ctx context.Context, token string) (*entities.User, error)
 	GetProfile(ctx context.Context, userID uuid.UUID) (*entities.User, error)
+	// ListUsers returns up to page.Limit users matching filter in sort
+	// order, starting after page.After
+	ListUsers(ctx context.Context, filter UserFilter, page UserPage, sort UserSort) ([]*entities.User, error)
+}
+
+// UserFilter narrows ListUsers, zero fields match every user
+type UserFilter struct {
+	// Search matches usernames and emails containing it
+	Search        string
+	Verified      *bool
+	CreatedAfter  *time.Time // Inclusive
+	CreatedBefore *time.Time // Exclusive
+}
+
+// UserSortField is a column users can be listed by
+type UserSortField string
+
+const (
+	UserSortCreatedAt UserSortField = "created_at"
+	UserSortUsername  UserSortField = "username"
+)
+
+// UserSort orders ListUsers. Users with equal values are ordered by ID, so
+// the order is total and pages never overlap.
+type UserSort struct {
+	Field      UserSortField
+	Descending bool
+}
+
+// UserPage is a keyset page: the users after the cursor, not an offset, so
+// pages stay stable while users are added
+type UserPage struct {
+	After *UserCursor // nil for the first page
+	Limit int
+}
+
+// UserCursor is the position of the last user of a page in its sort order
+type UserCursor struct {
+	CreatedAt time.Time
+	Username  string
+	Id        uuid.UUID
+}
+
+// CursorOf returns the position of user, whichever field users are sorted by
+func CursorOf(user *entities.User) *UserCursor {
+	return &UserCursor{CreatedAt: user.CreatedAt, Username: user.Username, Id: user.Id}
 }
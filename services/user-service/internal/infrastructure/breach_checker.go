@@ -0,0 +1,107 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pwnedPasswordsRangeURL = "https://api.pwnedpasswords.com/range/"
+
+// BreachChecker looks passwords up in the Have I Been Pwned range API. Only
+// the first five characters of the SHA-1 hash leave the service
+// (k-anonymity), the match against the returned suffixes happens locally.
+type BreachChecker struct {
+	enabled    bool
+	baseURL    string
+	minCount   int
+	httpClient *http.Client
+	breaker    *CircuitBreaker
+}
+
+func NewBreachChecker() *BreachChecker {
+	checker := &BreachChecker{
+		enabled:    GetEnvAsBool("HIBP_ENABLED", false),
+		baseURL:    GetEnvAsString("HIBP_API_URL", pwnedPasswordsRangeURL),
+		minCount:   GetEnvAsInt("HIBP_MIN_BREACH_COUNT", 1),
+		httpClient: &http.Client{Timeout: GetEnvAsDuration("HIBP_TIMEOUT", 2*time.Second)},
+		breaker: NewCircuitBreaker(
+			GetEnvAsInt("HIBP_BREAKER_MAX_FAILURES", 5),
+			GetEnvAsDuration("HIBP_BREAKER_COOLDOWN", time.Minute),
+		),
+	}
+
+	if checker.minCount < 1 {
+		checker.minCount = 1
+	}
+
+	log.Printf("Breach Check Config - Enabled: %t, Min Breach Count: %d", checker.enabled, checker.minCount)
+	return checker
+}
+
+// Enabled reports whether passwords should be checked at all
+func (c *BreachChecker) Enabled() bool {
+	return c != nil && c.enabled
+}
+
+// IsBreached reports whether password appears in at least HIBP_MIN_BREACH_COUNT
+// breaches. It returns ErrCircuitOpen without a request while the API is
+// considered down.
+func (c *BreachChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	var count int
+	err := c.breaker.Execute(func() error {
+		var err error
+		count, err = c.lookup(ctx, prefix, suffix)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return count >= c.minCount, nil
+}
+
+func (c *BreachChecker) lookup(ctx context.Context, prefix, suffix string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Padding hides the real number of suffixes in the response size
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "user-service")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("pwned passwords API returned status %d", resp.StatusCode)
+	}
+
+	// Each line is SUFFIX:COUNT, padding entries have a count of 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, countText, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found || !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(countText)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pwned passwords response: %v", err)
+		}
+		return count, nil
+	}
+	return 0, scanner.Err()
+}
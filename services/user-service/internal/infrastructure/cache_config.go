@@ -0,0 +1,59 @@
+package infrastructure
+
+import (
+	"strings"
+	"time"
+)
+
+// CacheNamespace is where a kind of cached data lives in Redis and how long
+// it is kept
+type CacheNamespace struct {
+	Prefix string
+	TTL    time.Duration
+}
+
+// Key is the key of id in the namespace, without the environment prefix,
+// which RedisService adds to every key
+func (n CacheNamespace) Key(id string) string {
+	return n.Prefix + id
+}
+
+// Pattern matches every key of the namespace, for scans
+func (n CacheNamespace) Pattern() string {
+	return n.Prefix + "*"
+}
+
+// CacheConfig holds the key prefixes and TTLs of the cache namespaces.
+// CACHE_<NAMESPACE>_PREFIX and CACHE_<NAMESPACE>_TTL override a namespace,
+// CACHE_PROFILE_TTL for instance. CACHE_KEY_PREFIX goes in front of every
+// key the service writes, so environments sharing a Redis never collide.
+type CacheConfig struct {
+	KeyPrefix string // Ends with ":" unless empty
+	Profile   CacheNamespace
+	Token     CacheNamespace
+	OTP       CacheNamespace
+	UserData  CacheNamespace // Pending registrations
+	Missing   CacheNamespace // Usernames and emails no user has
+}
+
+func LoadCacheConfig() CacheConfig {
+	keyPrefix := strings.TrimSpace(GetEnvAsString("CACHE_KEY_PREFIX", ""))
+	if keyPrefix != "" && !strings.HasSuffix(keyPrefix, ":") {
+		keyPrefix += ":"
+	}
+	return CacheConfig{
+		KeyPrefix: keyPrefix,
+		Profile:   loadCacheNamespace("PROFILE", "profile:", 24*time.Hour),
+		Token:     loadCacheNamespace("TOKEN", "token:", 24*time.Hour),
+		OTP:       loadCacheNamespace("OTP", "otp:", 5*time.Minute),
+		UserData:  loadCacheNamespace("USERDATA", "user:", 15*time.Minute),
+		Missing:   loadCacheNamespace("MISSING", "missing_user:", 30*time.Second),
+	}
+}
+
+func loadCacheNamespace(name, prefix string, ttl time.Duration) CacheNamespace {
+	return CacheNamespace{
+		Prefix: GetEnvAsString("CACHE_"+name+"_PREFIX", prefix),
+		TTL:    GetEnvAsDuration("CACHE_"+name+"_TTL", ttl),
+	}
+}
@@ -0,0 +1,366 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// CacheInvalidationSubject carries the keys every instance has to evict
+	// from the caches it keeps in memory
+	CacheInvalidationSubject = "user.cache.invalidate"
+	// cacheInvalidationBuffer is how many invalidations may wait to be sent
+	cacheInvalidationBuffer = 256
+	// maxCacheInvalidationSize bounds the invalidation messages read, they
+	// hold a cache name and a key
+	maxCacheInvalidationSize = 4096
+)
+
+// CacheInvalidation tells the other instances to evict a key from a cache
+type CacheInvalidation struct {
+	Cache  string `json:"cache"`
+	Key    string `json:"key"`
+	Origin string `json:"origin"` // The instance that sent it, and evicted the key already
+}
+
+// CacheInvalidator fans cache evictions out to every instance over NATS.
+// Redis is shared and needs none of this; it is for the caches an instance
+// keeps in memory, like validated API keys, which would otherwise go on
+// serving a revoked entry until it expires.
+//
+// Like NATSPublisher it speaks just enough of the NATS client protocol, on
+// a connection of its own that it keeps open. Invalidations are core NATS
+// messages, so an instance disconnected when one is sent misses it; its
+// caches are cleared when it reconnects instead. A watchdog pings the server
+// every NATS_WATCHDOG_INTERVAL and reconnects when it stops answering, and
+// HealthCheck reports whether invalidations are being received.
+type CacheInvalidator struct {
+	address  string
+	connect  natsConnectOptions
+	subject  string // CacheInvalidationSubject under the subject prefix
+	instance string
+	outgoing chan CacheInvalidation
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	watchdogInterval time.Duration
+	lastPong         atomic.Int64 // Unix nanoseconds of the last PONG read
+
+	statusMu   sync.Mutex
+	subscribed bool
+	lastErr    error // Why it is not subscribed
+
+	handlersMu sync.RWMutex
+	handlers   map[string]func(key string)
+}
+
+// NewCacheInvalidator prepares an invalidator for the server at natsURL,
+// in the form NewNATSPublisher takes. Start begins fanning out.
+func NewCacheInvalidator(natsURL string) *CacheInvalidator {
+	return &CacheInvalidator{
+		address:  natsAddress(natsURL),
+		connect:  newNATSConnectOptions(natsURL),
+		subject:  natsSubject(natsSubjectPrefix(), CacheInvalidationSubject),
+		instance: uuid.New().String(),
+		outgoing: make(chan CacheInvalidation, cacheInvalidationBuffer),
+		done:     make(chan struct{}),
+		handlers: make(map[string]func(key string)),
+
+		watchdogInterval: GetEnvAsDuration("NATS_WATCHDOG_INTERVAL", 30*time.Second),
+	}
+}
+
+// Handle has evict called for the keys other instances invalidate in cache.
+// After a reconnection, when invalidations may have been missed, evict is
+// called with an empty key to drop every entry.
+func (c *CacheInvalidator) Handle(cache string, evict func(key string)) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.handlers[cache] = evict
+}
+
+// Invalidate tells the other instances to evict key from cache. The caller
+// evicts its own copy. It does not block: when NATS cannot keep up the
+// invalidation is dropped, and the entry lives until it expires.
+func (c *CacheInvalidator) Invalidate(cache, key string) {
+	select {
+	case c.outgoing <- CacheInvalidation{Cache: cache, Key: key, Origin: c.instance}:
+	default:
+		log.Printf("Dropped invalidation of %s cache entry, NATS is not keeping up", cache)
+	}
+}
+
+// Start fans invalidations out until Stop is called
+func (c *CacheInvalidator) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Stop closes the connection. Invalidations still waiting are sent first,
+// as long as NATS can be reached.
+func (c *CacheInvalidator) Stop() {
+	close(c.done)
+	c.wg.Wait()
+}
+
+// run keeps a connection subscribed to the invalidations, reconnecting with
+// backoff when it breaks, and sends the outgoing ones on it
+func (c *CacheInvalidator) run() {
+	defer c.wg.Done()
+
+	backoff := 100 * time.Millisecond
+	connected := false
+	for {
+		conn, writer, broken, err := c.subscribe()
+		if err != nil {
+			if backoff == 100*time.Millisecond {
+				log.Printf("Error subscribing to cache invalidations, retrying: %v", err)
+			}
+			c.setStatus(false, err)
+			select {
+			case <-c.done:
+				if waiting := len(c.outgoing); waiting > 0 {
+					log.Printf("Dropped %d cache invalidations on shutdown, NATS could not be reached", waiting)
+				}
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, natsMaxBackoff)
+			continue
+		}
+		backoff = 100 * time.Millisecond
+
+		// Invalidations sent while this instance was away were missed
+		if connected {
+			c.evictAll()
+		}
+		connected = true
+
+		if !c.send(conn, writer, broken) {
+			return
+		}
+	}
+}
+
+// send writes the outgoing invalidations on conn until it breaks, false
+// once the invalidator stops. It also pings the server, and gives up on the
+// connection when a ping goes unanswered for a whole interval.
+func (c *CacheInvalidator) send(conn net.Conn, writer *lockedWriter, broken <-chan struct{}) bool {
+	defer conn.Close()
+
+	var watchdog <-chan time.Time
+	if c.watchdogInterval > 0 {
+		ticker := time.NewTicker(c.watchdogInterval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	var pingSent time.Time
+
+	for {
+		select {
+		case <-c.done:
+			c.flush(conn, writer)
+			return false
+		case <-broken:
+			log.Printf("Lost the NATS connection for cache invalidations, reconnecting")
+			c.setStatus(false, fmt.Errorf("connection lost"))
+			return true
+		case now := <-watchdog:
+			if !pingSent.IsZero() && c.lastPong.Load() < pingSent.UnixNano() {
+				log.Printf("NATS stopped answering pings on the cache invalidations connection, reconnecting")
+				c.setStatus(false, fmt.Errorf("no answer to ping within %v", c.watchdogInterval))
+				return true
+			}
+			if err := writer.write(conn, "PING\r\n"); err != nil {
+				log.Printf("Error pinging NATS for cache invalidations: %v", err)
+				c.setStatus(false, err)
+				return true
+			}
+			pingSent = now
+		case invalidation := <-c.outgoing:
+			if err := c.publish(conn, writer, invalidation); err != nil {
+				log.Printf("Error sending invalidation of %s cache entry: %v", invalidation.Cache, err)
+				c.setStatus(false, err)
+				return true
+			}
+		}
+	}
+}
+
+// flush sends the invalidations still waiting when Stop is called
+func (c *CacheInvalidator) flush(conn net.Conn, writer *lockedWriter) {
+	for {
+		select {
+		case invalidation := <-c.outgoing:
+			if err := c.publish(conn, writer, invalidation); err != nil {
+				log.Printf("Dropped %d cache invalidations on shutdown: %v", len(c.outgoing)+1, err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *CacheInvalidator) publish(conn net.Conn, writer *lockedWriter, invalidation CacheInvalidation) error {
+	payload, err := json.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+	return writer.write(conn, fmt.Sprintf("PUB %s %d\r\n%s\r\n", c.subject, len(payload), payload))
+}
+
+// subscribe connects and subscribes to the invalidations. broken is closed
+// when the connection stops delivering them.
+func (c *CacheInvalidator) subscribe() (net.Conn, *lockedWriter, <-chan struct{}, error) {
+	conn, reader, bufferedWriter, _, err := dialNATS(c.address, c.connect)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	writer := &lockedWriter{writer: bufferedWriter}
+	if err := writer.write(conn, "SUB "+c.subject+" 1\r\n"); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	c.setStatus(true, nil)
+	broken := make(chan struct{})
+	go func() {
+		defer close(broken)
+		err := c.read(conn, reader, writer)
+		select {
+		case <-c.done:
+			// The connection was closed on purpose
+		default:
+			log.Printf("Error reading cache invalidations: %v", err)
+		}
+	}()
+	log.Printf("Subscribed to cache invalidations on NATS at %s", c.address)
+	return conn, writer, broken, nil
+}
+
+// read evicts the keys invalidated by other instances and answers the
+// server's pings, until the connection breaks
+func (c *CacheInvalidator) read(conn net.Conn, reader *bufio.Reader, writer *lockedWriter) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PING":
+			if err := writer.write(conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case "PONG":
+			c.lastPong.Store(time.Now().UnixNano())
+		case "-ERR":
+			log.Printf("NATS reported an error: %s", strings.TrimSpace(line))
+			// The connection stays up, but nothing arrives on a refused subscription
+			if strings.Contains(line, "Permissions Violation for Subscription") {
+				c.setStatus(false, fmt.Errorf("%s", strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'")))
+			}
+		case "MSG", "HMSG":
+			// MSG <subject> <sid> [reply] <size>, HMSG has the header size
+			// before the total size
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 || size > maxCacheInvalidationSize {
+				return fmt.Errorf("unexpected message from NATS: %.40q", line)
+			}
+			message := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, message); err != nil {
+				return err
+			}
+			headerSize := 0
+			if fields[0] == "HMSG" {
+				if headerSize, err = strconv.Atoi(fields[len(fields)-2]); err != nil || headerSize > size {
+					return fmt.Errorf("unexpected message from NATS: %.40q", line)
+				}
+			}
+			c.evict(message[headerSize:size])
+		}
+	}
+}
+
+func (c *CacheInvalidator) setStatus(subscribed bool, err error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	c.subscribed = subscribed
+	if err != nil {
+		c.lastErr = err
+	}
+}
+
+// HealthCheck fails while invalidations are not being received, as the
+// caches may then serve entries other instances evicted
+func (c *CacheInvalidator) HealthCheck(ctx context.Context) error {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	if c.subscribed {
+		return nil
+	}
+	if c.lastErr != nil {
+		return fmt.Errorf("not subscribed to %s: %w", c.subject, c.lastErr)
+	}
+	return fmt.Errorf("not subscribed to %s", c.subject)
+}
+
+// evict applies an invalidation received from NATS
+func (c *CacheInvalidator) evict(payload []byte) {
+	var invalidation CacheInvalidation
+	if err := json.Unmarshal(payload, &invalidation); err != nil {
+		log.Printf("Error unmarshaling cache invalidation: %v", err)
+		return
+	}
+	if invalidation.Origin == c.instance || invalidation.Key == "" {
+		return
+	}
+
+	c.handlersMu.RLock()
+	evict := c.handlers[invalidation.Cache]
+	c.handlersMu.RUnlock()
+	if evict != nil {
+		evict(invalidation.Key)
+	}
+}
+
+// evictAll empties every cache with a handler
+func (c *CacheInvalidator) evictAll() {
+	c.handlersMu.RLock()
+	defer c.handlersMu.RUnlock()
+	for _, evict := range c.handlers {
+		evict("")
+	}
+}
+
+// lockedWriter serializes the writes of the sender and of the reader
+// answering pings
+type lockedWriter struct {
+	mu     sync.Mutex
+	writer *bufio.Writer
+}
+
+func (w *lockedWriter) write(conn net.Conn, data string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	w.writer.WriteString(data)
+	return w.writer.Flush()
+}
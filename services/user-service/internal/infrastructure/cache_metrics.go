@@ -0,0 +1,186 @@
+package infrastructure
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Cache namespaces RedisService reports metrics for
+const (
+	CacheProfile  = "profile"
+	CacheToken    = "token"
+	CacheOTP      = "otp"
+	CacheUserData = "userdata" // Pending registrations
+	CacheMissing  = "missing"  // Usernames and emails no user has
+)
+
+// cacheLatencyBuckets are the upper bounds of the cache latency histograms.
+// Slower operations fall into a last, unbounded bucket.
+var cacheLatencyBuckets = []time.Duration{
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	time.Second,
+}
+
+// cacheLatency is a histogram of operation latencies
+type cacheLatency struct {
+	maxNano int64    // Slowest operation, reported for percentiles in the last bucket
+	buckets []uint64 // One per cacheLatencyBuckets entry plus the unbounded one
+}
+
+func newCacheLatency() *cacheLatency {
+	return &cacheLatency{buckets: make([]uint64, len(cacheLatencyBuckets)+1)}
+}
+
+func (l *cacheLatency) observe(elapsed time.Duration) {
+	bucket := len(cacheLatencyBuckets)
+	for i, bound := range cacheLatencyBuckets {
+		if elapsed <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddUint64(&l.buckets[bucket], 1)
+
+	for {
+		max := atomic.LoadInt64(&l.maxNano)
+		if int64(elapsed) <= max || atomic.CompareAndSwapInt64(&l.maxNano, max, int64(elapsed)) {
+			break
+		}
+	}
+}
+
+// snapshot reports the latency percentiles in milliseconds, each the upper
+// bound of the bucket holding the operation at that rank, and the count of
+// each bucket keyed by its bound in milliseconds
+func (l *cacheLatency) snapshot() map[string]interface{} {
+	counts := make([]uint64, len(l.buckets))
+	var total uint64
+	for i := range l.buckets {
+		counts[i] = atomic.LoadUint64(&l.buckets[i])
+		total += counts[i]
+	}
+
+	percentile := func(q float64) float64 {
+		if total == 0 {
+			return 0
+		}
+		rank := uint64(q*float64(total) + 0.5)
+		if rank == 0 {
+			rank = 1
+		}
+		var seen uint64
+		for i, count := range counts {
+			seen += count
+			if seen >= rank && i < len(cacheLatencyBuckets) {
+				return durationMillis(cacheLatencyBuckets[i])
+			}
+		}
+		return durationMillis(time.Duration(atomic.LoadInt64(&l.maxNano)))
+	}
+
+	histogram := make(map[string]uint64, len(counts))
+	for i, count := range counts {
+		bound := "+Inf"
+		if i < len(cacheLatencyBuckets) {
+			bound = strconv.FormatFloat(durationMillis(cacheLatencyBuckets[i]), 'f', -1, 64)
+		}
+		histogram[bound] = count
+	}
+
+	return map[string]interface{}{
+		"p50Ms":     percentile(0.50),
+		"p95Ms":     percentile(0.95),
+		"p99Ms":     percentile(0.99),
+		"bucketsMs": histogram,
+	}
+}
+
+// cacheStats counts the reads and writes of one cache namespace
+type cacheStats struct {
+	hits   uint64
+	misses uint64
+	writes uint64
+	errors uint64 // Reads and writes Redis failed, not counted as hits or misses
+
+	readLatency  *cacheLatency
+	writeLatency *cacheLatency
+}
+
+// cacheMetrics holds the statistics of every cache namespace. The namespaces
+// are fixed, so it needs no lock.
+type cacheMetrics struct {
+	namespaces map[string]*cacheStats
+}
+
+func newCacheMetrics() *cacheMetrics {
+	m := &cacheMetrics{namespaces: make(map[string]*cacheStats)}
+	for _, namespace := range []string{CacheProfile, CacheToken, CacheOTP, CacheUserData, CacheMissing} {
+		m.namespaces[namespace] = &cacheStats{readLatency: newCacheLatency(), writeLatency: newCacheLatency()}
+	}
+	return m
+}
+
+// observeRead counts a read of namespace, a miss when err is redis.Nil
+func (m *cacheMetrics) observeRead(namespace string, elapsed time.Duration, err error) {
+	stats := m.namespaces[namespace]
+	switch {
+	case err == nil:
+		atomic.AddUint64(&stats.hits, 1)
+	case errors.Is(err, redis.Nil):
+		atomic.AddUint64(&stats.misses, 1)
+	default:
+		atomic.AddUint64(&stats.errors, 1)
+	}
+	stats.readLatency.observe(elapsed)
+}
+
+func (m *cacheMetrics) observeWrite(namespace string, elapsed time.Duration, err error) {
+	stats := m.namespaces[namespace]
+	if err != nil {
+		atomic.AddUint64(&stats.errors, 1)
+	} else {
+		atomic.AddUint64(&stats.writes, 1)
+	}
+	stats.writeLatency.observe(elapsed)
+}
+
+// snapshot reports the counters, hit rate and latencies of every namespace
+func (m *cacheMetrics) snapshot() map[string]interface{} {
+	namespaces := make(map[string]interface{}, len(m.namespaces))
+	for namespace, stats := range m.namespaces {
+		hits := atomic.LoadUint64(&stats.hits)
+		misses := atomic.LoadUint64(&stats.misses)
+		hitRate := 0.0
+		if hits+misses > 0 {
+			hitRate = float64(hits) / float64(hits+misses)
+		}
+
+		namespaces[namespace] = map[string]interface{}{
+			"hits":         hits,
+			"misses":       misses,
+			"hitRate":      hitRate,
+			"writes":       atomic.LoadUint64(&stats.writes),
+			"errors":       atomic.LoadUint64(&stats.errors),
+			"readLatency":  stats.readLatency.snapshot(),
+			"writeLatency": stats.writeLatency.snapshot(),
+		}
+	}
+	return namespaces
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,145 @@
+package infrastructure
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of calling a dependency that keeps failing
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Circuit breaker states
+const (
+	CircuitClosed = "closed"
+	CircuitOpen   = "open"
+	// CircuitHalfOpen lets a single trial call through to decide whether to
+	// close the circuit again
+	CircuitHalfOpen = "half_open"
+)
+
+// CircuitBreakerStats is the state of a CircuitBreaker and what it counted
+// since it was made, for metrics
+type CircuitBreakerStats struct {
+	State    string `json:"state"`
+	Calls    uint64 `json:"calls"`    // Calls let through
+	Failures uint64 `json:"failures"` // Calls let through that failed
+	Rejected uint64 `json:"rejected"` // Calls refused while open
+	Opened   uint64 `json:"opened"`   // Times the circuit opened
+}
+
+// CircuitBreaker stops calling a failing dependency after maxFailures
+// consecutive errors. Once cooldown has passed a single trial call is let
+// through; success closes the circuit again, failure reopens it.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	maxFailures int
+	cooldown    time.Duration
+	failures    int
+	openedAt    time.Time
+	trialActive bool
+	stats       CircuitBreakerStats
+
+	// onStateChange is called with the state before and after a transition
+	onStateChange func(from, to string)
+}
+
+func NewCircuitBreaker(maxFailures int, cooldown time.Duration) *CircuitBreaker {
+	if maxFailures < 1 {
+		maxFailures = 1
+	}
+	return &CircuitBreaker{
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+	}
+}
+
+// OnStateChange has fn called with the state before and after each
+// transition, to log them for instance
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to string)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.onStateChange = fn
+}
+
+// Execute runs fn unless the circuit is open, and records its outcome
+func (cb *CircuitBreaker) Execute(fn func() error) error {
+	if !cb.allow() {
+		return ErrCircuitOpen
+	}
+
+	err := fn()
+	cb.record(err)
+	return err
+}
+
+// State returns the current state, half open once the cooldown has passed
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state()
+}
+
+// Stats returns the current state and the counts
+func (cb *CircuitBreaker) Stats() CircuitBreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	stats := cb.stats
+	stats.State = cb.state()
+	return stats
+}
+
+func (cb *CircuitBreaker) state() string {
+	switch {
+	case cb.failures < cb.maxFailures:
+		return CircuitClosed
+	case cb.trialActive || time.Since(cb.openedAt) >= cb.cooldown:
+		return CircuitHalfOpen
+	default:
+		return CircuitOpen
+	}
+}
+
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures < cb.maxFailures {
+		cb.stats.Calls++
+		return true
+	}
+	if time.Since(cb.openedAt) < cb.cooldown || cb.trialActive {
+		cb.stats.Rejected++
+		return false
+	}
+	cb.trialActive = true
+	cb.stats.Calls++
+	return true
+}
+
+func (cb *CircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	from := cb.state()
+
+	cb.trialActive = false
+	if err == nil {
+		cb.failures = 0
+	} else {
+		cb.stats.Failures++
+		cb.failures++
+		if cb.failures >= cb.maxFailures {
+			cb.openedAt = time.Now()
+			if from != CircuitOpen {
+				cb.stats.Opened++
+			}
+		}
+	}
+
+	to := cb.state()
+	onStateChange := cb.onStateChange
+	cb.mu.Unlock()
+
+	if onStateChange != nil && from != to {
+		onStateChange(from, to)
+	}
+}
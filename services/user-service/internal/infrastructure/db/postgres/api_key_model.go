@@ -0,0 +1,20 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyModel struct {
+	Id        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name      string    `gorm:"index;not null"`
+	Prefix    string    `gorm:"not null"`
+	KeyHash   string    `gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+func (APIKeyModel) TableName() string {
+	return "api_keys"
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+type apiKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) repositories.APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *entities.APIKey) (*entities.APIKey, error) {
+	model := toAPIKeyModel(key)
+
+	if err := r.db.WithContext(ctx).Create(&model).Error; err != nil {
+		return nil, err
+	}
+
+	return r.FindById(ctx, key.Id)
+}
+
+func (r *apiKeyRepository) FindById(ctx context.Context, id uuid.UUID) (*entities.APIKey, error) {
+	var model APIKeyModel
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toAPIKeyEntity(&model), nil
+}
+
+func (r *apiKeyRepository) FindByHash(ctx context.Context, keyHash string) (*entities.APIKey, error) {
+	var model APIKeyModel
+	if err := r.db.WithContext(ctx).Where("key_hash = ?", keyHash).First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toAPIKeyEntity(&model), nil
+}
+
+func (r *apiKeyRepository) Update(ctx context.Context, key *entities.APIKey) (*entities.APIKey, error) {
+	model := toAPIKeyModel(key)
+
+	if err := r.db.WithContext(ctx).Save(&model).Error; err != nil {
+		return nil, err
+	}
+
+	return r.FindById(ctx, key.Id)
+}
+
+func toAPIKeyModel(key *entities.APIKey) APIKeyModel {
+	return APIKeyModel{
+		Id:        key.Id,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		KeyHash:   key.KeyHash,
+		CreatedAt: key.CreatedAt,
+		RevokedAt: key.RevokedAt,
+	}
+}
+
+func toAPIKeyEntity(model *APIKeyModel) *entities.APIKey {
+	return &entities.APIKey{
+		Id:        model.Id,
+		Name:      model.Name,
+		Prefix:    model.Prefix,
+		KeyHash:   model.KeyHash,
+		CreatedAt: model.CreatedAt,
+		RevokedAt: model.RevokedAt,
+	}
+}
@@ -0,0 +1,22 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEventModel struct {
+	Id        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid();index:idx_audit_log_user_created,priority:3,sort:desc"`
+	UserId    uuid.UUID `gorm:"type:uuid;not null;index:idx_audit_log_user_created,priority:1"`
+	Action    string    `gorm:"not null"`
+	Actor     string
+	Detail    string
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time `gorm:"not null;index:idx_audit_log_user_created,priority:2,sort:desc"`
+}
+
+func (AuditEventModel) TableName() string {
+	return "audit_log"
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) repositories.AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) Append(ctx context.Context, event *entities.AuditEvent) error {
+	model := AuditEventModel{
+		Id:        event.Id,
+		UserId:    event.UserId,
+		Action:    event.Action,
+		Actor:     event.Actor,
+		Detail:    event.Detail,
+		IPAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		CreatedAt: event.CreatedAt,
+	}
+
+	return r.db.WithContext(ctx).Create(&model).Error
+}
+
+func (r *auditRepository) ListByUser(ctx context.Context, userID uuid.UUID, before *repositories.AuditCursor, limit int) ([]*entities.AuditEvent, error) {
+	db := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if before != nil {
+		// Ties on the time are broken by ID, as in the order
+		db = db.Where("(created_at, id) < (?, ?)", before.CreatedAt, before.Id)
+	}
+
+	var models []AuditEventModel
+	if err := db.Order("created_at DESC, id DESC").Limit(limit).Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	events := make([]*entities.AuditEvent, 0, len(models))
+	for i := range models {
+		events = append(events, toAuditEventEntity(&models[i]))
+	}
+	return events, nil
+}
+
+func toAuditEventEntity(model *AuditEventModel) *entities.AuditEvent {
+	return &entities.AuditEvent{
+		Id:        model.Id,
+		UserId:    model.UserId,
+		Action:    model.Action,
+		Actor:     model.Actor,
+		Detail:    model.Detail,
+		IPAddress: model.IPAddress,
+		UserAgent: model.UserAgent,
+		CreatedAt: model.CreatedAt,
+	}
+}
@@ -0,0 +1,23 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeviceModel struct {
+	Id              uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserId          uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_devices_user_fingerprint"`
+	FingerprintHash string    `gorm:"not null;uniqueIndex:idx_devices_user_fingerprint"`
+	UserAgent       string
+	LastIP          string
+	LastCountry     string
+	Trusted         bool `gorm:"default:false"`
+	CreatedAt       time.Time
+	LastSeenAt      time.Time
+}
+
+func (DeviceModel) TableName() string {
+	return "devices"
+}
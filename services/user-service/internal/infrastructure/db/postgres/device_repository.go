@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+type deviceRepository struct {
+	db *gorm.DB
+}
+
+func NewDeviceRepository(db *gorm.DB) repositories.DeviceRepository {
+	return &deviceRepository{db: db}
+}
+
+func (r *deviceRepository) FindByFingerprint(ctx context.Context, userID uuid.UUID, fingerprintHash string) (*entities.Device, error) {
+	var model DeviceModel
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND fingerprint_hash = ?", userID, fingerprintHash).
+		First(&model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toDeviceEntity(&model), nil
+}
+
+func (r *deviceRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]*entities.Device, error) {
+	var models []DeviceModel
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("last_seen_at DESC").
+		Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]*entities.Device, 0, len(models))
+	for i := range models {
+		devices = append(devices, toDeviceEntity(&models[i]))
+	}
+	return devices, nil
+}
+
+func (r *deviceRepository) Save(ctx context.Context, device *entities.Device) (*entities.Device, error) {
+	model := DeviceModel{
+		Id:              device.Id,
+		UserId:          device.UserId,
+		FingerprintHash: device.FingerprintHash,
+		UserAgent:       device.UserAgent,
+		LastIP:          device.LastIP,
+		LastCountry:     device.LastCountry,
+		Trusted:         device.Trusted,
+		CreatedAt:       device.CreatedAt,
+		LastSeenAt:      device.LastSeenAt,
+	}
+
+	if err := r.db.WithContext(ctx).Save(&model).Error; err != nil {
+		return nil, err
+	}
+
+	return toDeviceEntity(&model), nil
+}
+
+func (r *deviceRepository) Delete(ctx context.Context, userID uuid.UUID, deviceID uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", deviceID, userID).Delete(&DeviceModel{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func toDeviceEntity(model *DeviceModel) *entities.Device {
+	return &entities.Device{
+		Id:              model.Id,
+		UserId:          model.UserId,
+		FingerprintHash: model.FingerprintHash,
+		UserAgent:       model.UserAgent,
+		LastIP:          model.LastIP,
+		LastCountry:     model.LastCountry,
+		Trusted:         model.Trusted,
+		CreatedAt:       model.CreatedAt,
+		LastSeenAt:      model.LastSeenAt,
+	}
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -97,4 +98,9 @@ func (r *idempotencyRepository) Update(ctx context.Context, record *entities.Ide
 		StatusCode: updatedRecord.StatusCode,
 		CreatedAt:  updatedRecord.CreatedAt,
 	}, nil
+}
+
+func (r *idempotencyRepository) DeleteCreatedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("created_at < ?", cutoff).Delete(&IdempotencyRecord{})
+	return result.RowsAffected, result.Error
 }
\ No newline at end of file
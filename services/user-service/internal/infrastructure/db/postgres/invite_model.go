@@ -0,0 +1,22 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type InviteModel struct {
+	Id         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TokenHash  string     `gorm:"uniqueIndex;not null"`
+	Email      string     `gorm:"index;not null"`
+	InviterId  *uuid.UUID `gorm:"type:uuid;index"`
+	CreatedAt  time.Time
+	ExpiresAt  time.Time `gorm:"not null"`
+	AcceptedAt *time.Time
+	AcceptedBy *uuid.UUID `gorm:"type:uuid"`
+}
+
+func (InviteModel) TableName() string {
+	return "invites"
+}
@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+type inviteRepository struct {
+	db *gorm.DB
+}
+
+func NewInviteRepository(db *gorm.DB) repositories.InviteRepository {
+	return &inviteRepository{db: db}
+}
+
+func (r *inviteRepository) Create(ctx context.Context, invite *entities.Invite) error {
+	model := InviteModel{
+		Id:         invite.Id,
+		TokenHash:  invite.TokenHash,
+		Email:      invite.Email,
+		InviterId:  invite.InviterId,
+		CreatedAt:  invite.CreatedAt,
+		ExpiresAt:  invite.ExpiresAt,
+		AcceptedAt: invite.AcceptedAt,
+		AcceptedBy: invite.AcceptedBy,
+	}
+	return r.db.WithContext(ctx).Create(&model).Error
+}
+
+func (r *inviteRepository) FindByTokenHash(ctx context.Context, tokenHash string) (*entities.Invite, error) {
+	var model InviteModel
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &entities.Invite{
+		Id:         model.Id,
+		TokenHash:  model.TokenHash,
+		Email:      model.Email,
+		InviterId:  model.InviterId,
+		CreatedAt:  model.CreatedAt,
+		ExpiresAt:  model.ExpiresAt,
+		AcceptedAt: model.AcceptedAt,
+		AcceptedBy: model.AcceptedBy,
+	}, nil
+}
+
+func (r *inviteRepository) MarkAccepted(ctx context.Context, id uuid.UUID, userID uuid.UUID) (bool, error) {
+	// Conditional update so two registrations cannot spend the same invite
+	result := r.db.WithContext(ctx).
+		Model(&InviteModel{}).
+		Where("id = ? AND accepted_at IS NULL", id).
+		Updates(map[string]interface{}{"accepted_at": time.Now(), "accepted_by": userID})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *inviteRepository) Release(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&InviteModel{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"accepted_at": nil, "accepted_by": nil}).Error
+}
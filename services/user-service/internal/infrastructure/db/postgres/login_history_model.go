@@ -0,0 +1,23 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type LoginHistoryModel struct {
+	Id            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserId        uuid.UUID `gorm:"type:uuid;not null;index:idx_login_history_user_created,priority:1"`
+	Method        string    `gorm:"not null"`
+	Success       bool      `gorm:"not null"`
+	FailureReason string
+	IPAddress     string
+	UserAgent     string
+	Country       string
+	CreatedAt     time.Time `gorm:"index:idx_login_history_user_created,priority:2,sort:desc"`
+}
+
+func (LoginHistoryModel) TableName() string {
+	return "login_history"
+}
@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+type loginHistoryRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginHistoryRepository(db *gorm.DB) repositories.LoginHistoryRepository {
+	return &loginHistoryRepository{db: db}
+}
+
+func (r *loginHistoryRepository) Record(ctx context.Context, event *entities.LoginEvent) error {
+	model := LoginHistoryModel{
+		Id:            event.Id,
+		UserId:        event.UserId,
+		Method:        event.Method,
+		Success:       event.Success,
+		FailureReason: event.FailureReason,
+		IPAddress:     event.IPAddress,
+		UserAgent:     event.UserAgent,
+		Country:       event.Country,
+		CreatedAt:     event.CreatedAt,
+	}
+
+	return r.db.WithContext(ctx).Create(&model).Error
+}
+
+func (r *loginHistoryRepository) ListByUser(ctx context.Context, userID uuid.UUID, limit int) ([]*entities.LoginEvent, error) {
+	var models []LoginHistoryModel
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*entities.LoginEvent, 0, len(models))
+	for i := range models {
+		events = append(events, toLoginEventEntity(&models[i]))
+	}
+	return events, nil
+}
+
+func toLoginEventEntity(model *LoginHistoryModel) *entities.LoginEvent {
+	return &entities.LoginEvent{
+		Id:            model.Id,
+		UserId:        model.UserId,
+		Method:        model.Method,
+		Success:       model.Success,
+		FailureReason: model.FailureReason,
+		IPAddress:     model.IPAddress,
+		UserAgent:     model.UserAgent,
+		Country:       model.Country,
+		CreatedAt:     model.CreatedAt,
+	}
+}
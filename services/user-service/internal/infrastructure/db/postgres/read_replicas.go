@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// replicaLagQuery is how far a replica's replay is behind, in seconds. A
+// replica that replayed all it received is not behind, however long ago the
+// primary last wrote.
+const replicaLagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+// ReadReplicas spreads reads that tolerate slightly stale data over replica
+// databases. Every check interval each replica's replication lag is measured;
+// one that is further behind than the lag limit, or does not answer, gets no
+// reads until it catches up. With no replica usable, reads go to the primary.
+type ReadReplicas struct {
+	primary  *gorm.DB
+	replicas []*readReplica
+	maxLag   time.Duration
+	interval time.Duration
+	next     uint64 // Round robin position
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type readReplica struct {
+	name   string // For logs, the DSN holds credentials
+	db     *gorm.DB
+	usable atomic.Bool
+}
+
+func NewReadReplicas(primary *gorm.DB, replicas []*gorm.DB, maxLag, interval time.Duration) *ReadReplicas {
+	pool := &ReadReplicas{
+		primary:  primary,
+		maxLag:   maxLag,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	for i, db := range replicas {
+		pool.replicas = append(pool.replicas, &readReplica{name: fmt.Sprintf("replica %d", i+1), db: db})
+	}
+	return pool
+}
+
+// Start checks the replicas, so they take reads from the first request on,
+// and then keeps checking them every interval until Stop is called
+func (p *ReadReplicas) Start() {
+	p.checkLag()
+	if p.interval <= 0 {
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.checkLag()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *ReadReplicas) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// Reader is the database for a read, a usable replica in turn or the primary
+func (p *ReadReplicas) Reader() *gorm.DB {
+	n := uint64(len(p.replicas))
+	start := atomic.AddUint64(&p.next, 1)
+	for i := uint64(0); i < n; i++ {
+		if replica := p.replicas[(start+i)%n]; replica.usable.Load() {
+			return replica.db
+		}
+	}
+	return p.primary
+}
+
+func (p *ReadReplicas) checkLag() {
+	for _, replica := range p.replicas {
+		lag, err := p.measureLag(replica)
+		usable := err == nil && lag <= p.maxLag
+		wasUsable := replica.usable.Swap(usable)
+
+		switch {
+		case wasUsable && err != nil:
+			log.Printf("Postgres %s takes no reads, it does not answer: %v", replica.name, err)
+		case wasUsable && !usable:
+			log.Printf("Postgres %s takes no reads, it is %v behind", replica.name, lag)
+		case !wasUsable && usable:
+			log.Printf("Postgres %s takes reads, it is %v behind", replica.name, lag)
+		}
+	}
+}
+
+func (p *ReadReplicas) measureLag(replica *readReplica) (time.Duration, error) {
+	timeout := p.interval
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var seconds float64
+	if err := replica.db.WithContext(ctx).Raw(replicaLagQuery).Scan(&seconds).Error; err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
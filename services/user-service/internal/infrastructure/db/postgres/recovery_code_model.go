@@ -0,0 +1,19 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RecoveryCodeModel struct {
+	Id        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserId    uuid.UUID `gorm:"type:uuid;index;not null"`
+	CodeHash  string    `gorm:"not null"`
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+func (RecoveryCodeModel) TableName() string {
+	return "recovery_codes"
+}
@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+type recoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) repositories.RecoveryCodeRepository {
+	return &recoveryCodeRepository{db: db}
+}
+
+func (r *recoveryCodeRepository) ReplaceForUser(ctx context.Context, userID uuid.UUID, codes []*entities.RecoveryCode) error {
+	models := make([]RecoveryCodeModel, 0, len(codes))
+	for _, code := range codes {
+		models = append(models, RecoveryCodeModel{
+			Id:        code.Id,
+			UserId:    code.UserId,
+			CodeHash:  code.CodeHash,
+			CreatedAt: code.CreatedAt,
+			UsedAt:    code.UsedAt,
+		})
+	}
+
+	// Old and new sets must never be valid at the same time
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCodeModel{}).Error; err != nil {
+			return err
+		}
+		if len(models) == 0 {
+			return nil
+		}
+		return tx.Create(&models).Error
+	})
+}
+
+func (r *recoveryCodeRepository) ConsumeUnused(ctx context.Context, userID uuid.UUID, codeHash string) (*entities.RecoveryCode, error) {
+	now := time.Now()
+
+	// A single conditional update keeps concurrent logins from spending the same code twice
+	var model RecoveryCodeModel
+	result := r.db.WithContext(ctx).
+		Model(&model).
+		Clauses(clause.Returning{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &entities.RecoveryCode{
+		Id:        model.Id,
+		UserId:    model.UserId,
+		CodeHash:  model.CodeHash,
+		CreatedAt: model.CreatedAt,
+		UsedAt:    model.UsedAt,
+	}, nil
+}
+
+func (r *recoveryCodeRepository) CountUnused(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&RecoveryCodeModel{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return int(count), err
+}
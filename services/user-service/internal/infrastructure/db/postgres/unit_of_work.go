@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"user-service-new/internal/domain/repositories"
+)
+
+type unitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) repositories.UnitOfWork {
+	return &unitOfWork{db: db}
+}
+
+func (u *unitOfWork) Do(ctx context.Context, fn func(tx repositories.Transaction) error) error {
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&transaction{db: tx})
+	})
+}
+
+// transaction gives the repositories the transaction's connection
+type transaction struct {
+	db *gorm.DB
+}
+
+func (t *transaction) Users() repositories.UserRepository {
+	return &UserRepository{db: t.db}
+}
+
+func (t *transaction) IdempotencyRecords() repositories.IdempotencyRepository {
+	return &idempotencyRepository{db: t.db}
+}
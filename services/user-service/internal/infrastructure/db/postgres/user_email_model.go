@@ -0,0 +1,19 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserEmailModel struct {
+	Id         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserId     uuid.UUID `gorm:"type:uuid;not null;index"`
+	Email      string    `gorm:"uniqueIndex;not null"`
+	VerifiedAt time.Time
+	CreatedAt  time.Time
+}
+
+func (UserEmailModel) TableName() string {
+	return "user_emails"
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+type userEmailRepository struct {
+	db *gorm.DB
+}
+
+func NewUserEmailRepository(db *gorm.DB) repositories.UserEmailRepository {
+	return &userEmailRepository{db: db}
+}
+
+func (r *userEmailRepository) Create(ctx context.Context, email *entities.UserEmail) error {
+	model := UserEmailModel{
+		Id:         email.Id,
+		UserId:     email.UserId,
+		Email:      email.Email,
+		VerifiedAt: email.VerifiedAt,
+		CreatedAt:  email.CreatedAt,
+	}
+	return userConflictError(r.db.WithContext(ctx).Create(&model).Error)
+}
+
+func (r *userEmailRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]*entities.UserEmail, error) {
+	var models []UserEmailModel
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]*entities.UserEmail, 0, len(models))
+	for i := range models {
+		emails = append(emails, toUserEmailEntity(&models[i]))
+	}
+	return emails, nil
+}
+
+func (r *userEmailRepository) Delete(ctx context.Context, userID uuid.UUID, email string) (bool, error) {
+	result := r.db.WithContext(ctx).Where("user_id = ? AND email = ?", userID, email).Delete(&UserEmailModel{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *userEmailRepository) MakePrimary(ctx context.Context, userID uuid.UUID, email string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var secondary UserEmailModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND email = ?", userID, email).
+			First(&secondary).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return entities.ErrInvalidInput.Errorf("email is not a verified address of the user")
+			}
+			return err
+		}
+
+		var user UserModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+
+		// The new primary only lives in user_emails, so neither update collides
+		// with a unique index
+		if err := tx.Model(&UserModel{}).Where("id = ?", userID).Update("email", secondary.Email).Error; err != nil {
+			return err
+		}
+		return tx.Model(&UserEmailModel{}).Where("id = ?", secondary.Id).Update("email", user.Email).Error
+	})
+}
+
+func toUserEmailEntity(model *UserEmailModel) *entities.UserEmail {
+	return &entities.UserEmail{
+		Id:         model.Id,
+		UserId:     model.UserId,
+		Email:      model.Email,
+		VerifiedAt: model.VerifiedAt,
+		CreatedAt:  model.CreatedAt,
+	}
+}
@@ -8,15 +8,30 @@ import (
 )
 
 type UserModel struct {
-	Id         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
-	DeletedAt  gorm.DeletedAt `gorm:"index"`
-	Username   string         `gorm:"uniqueIndex;not null"`
-	Email      string         `gorm:"uniqueIndex;not null"`
-	Password   string         `gorm:"not null"`
-	Tokens     []string       `gorm:"type:text[]"`
-	IsVerified bool           `gorm:"default:false"`
+	Id              uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid();index:idx_users_created_at_id,priority:2"`
+	CreatedAt       time.Time `gorm:"index:idx_users_created_at_id,priority:1"`
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt `gorm:"index"`
+	Username        string         `gorm:"uniqueIndex;not null"`
+	Email           string         `gorm:"uniqueIndex:idx_users_email,where:email <> '';not null"`
+	Password        string         `gorm:"not null"` // empty for guests
+	Tokens          []string       `gorm:"type:text[]"`
+	IsVerified      bool           `gorm:"default:false"`
+	Status          string         `gorm:"index;not null;default:active"`
+	StatusReason    string
+	DisplayName     string
+	Bio             string
+	AvatarURL       string
+	AvatarKey       string
+	InvitedBy       *uuid.UUID `gorm:"type:uuid;index"`
+	TermsVersion    int        `gorm:"not null;default:0"`
+	TermsAcceptedAt *time.Time
+	IsGuest         bool `gorm:"default:false"`
+	// Unique among guests so a device always resumes the same guest account
+	GuestDeviceHash string `gorm:"uniqueIndex:idx_users_guest_device_hash,where:guest_device_hash <> ''"`
+	// Version goes up with every update of the columns Update writes, so an
+	// update made from a stale copy of the row is detected
+	Version int `gorm:"not null;default:0"`
 }
 
 func (UserModel) TableName() string {
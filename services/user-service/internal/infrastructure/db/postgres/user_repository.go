@@ -3,42 +3,76 @@ package postgres
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"user-service-new/internal/domain/entities"
 	"user-service-new/internal/domain/repositories"
-	"gorm.io/gorm"
 )
 
 type UserRepository struct {
-	db *gorm.DB
+	db       *gorm.DB
+	replicas *ReadReplicas // nil when every query goes to db
 }
 
-func NewUserRepository(db *gorm.DB) repositories.UserRepository {
-	return &UserRepository{db: db}
+// NewUserRepository serves user lists from replicas when given any. Every
+// other read stays on the primary: users are loaded by ID to be changed and
+// saved whole, and a stale copy would undo the writes it missed. Lookups by
+// username, email and credentials decide logins, statuses and whether a name
+// is taken, token lookups follow a login's write immediately, and profiles
+// fill the cache introspection checks statuses in.
+func NewUserRepository(db *gorm.DB, replicas *ReadReplicas) repositories.UserRepository {
+	return &UserRepository{db: db, replicas: replicas}
+}
+
+// reader is the database for reads that tolerate replication lag
+func (r *UserRepository) reader() *gorm.DB {
+	if r.replicas == nil {
+		return r.db
+	}
+	return r.replicas.Reader()
 }
 
 func (r *UserRepository) Create(user *entities.ValidatedUser) (*entities.User, error) {
 	userEntity := user.GetUser()
 
-	// Hash password before saving
-	if err := userEntity.HashPassword(); err != nil {
-		return nil, err
+	// Hash password before saving, guests have none
+	if !userEntity.IsGuest {
+		if err := userEntity.HashPassword(); err != nil {
+			return nil, err
+		}
 	}
 
 	userModel := UserModel{
-		Id:         userEntity.Id,
-		CreatedAt:  userEntity.CreatedAt,
-		UpdatedAt:  userEntity.UpdatedAt,
-		Username:   userEntity.Username,
-		Email:      userEntity.Email,
-		Password:   userEntity.Password,
-		Tokens:     userEntity.Tokens,
-		IsVerified: userEntity.IsVerified,
+		Id:              userEntity.Id,
+		CreatedAt:       userEntity.CreatedAt,
+		UpdatedAt:       userEntity.UpdatedAt,
+		Username:        userEntity.Username,
+		Email:           userEntity.Email,
+		Password:        userEntity.Password,
+		Tokens:          userEntity.Tokens,
+		IsVerified:      userEntity.IsVerified,
+		Status:          string(userEntity.Status),
+		StatusReason:    userEntity.StatusReason,
+		DisplayName:     userEntity.DisplayName,
+		Bio:             userEntity.Bio,
+		AvatarURL:       userEntity.AvatarURL,
+		AvatarKey:       userEntity.AvatarKey,
+		InvitedBy:       userEntity.InvitedBy,
+		TermsVersion:    userEntity.TermsVersion,
+		TermsAcceptedAt: userEntity.TermsAcceptedAt,
+		IsGuest:         userEntity.IsGuest,
+		GuestDeviceHash: userEntity.GuestDeviceHash,
+		Version:         userEntity.Version,
 	}
 
 	if err := r.db.Create(&userModel).Error; err != nil {
-		return nil, err
+		return nil, userConflictError(err)
 	}
 
 	// Read back the created user to ensure data integrity
@@ -69,9 +103,22 @@ func (r *UserRepository) FindByUsername(username string) (*entities.User, error)
 	return r.mapToEntity(&userModel), nil
 }
 
+// FindByEmail matches the primary address and any verified secondary address
 func (r *UserRepository) FindByEmail(email string) (*entities.User, error) {
 	var userModel UserModel
-	if err := r.db.Where("email = ?", email).First(&userModel).Error; err != nil {
+	if err := r.db.Where("email = ? OR id IN (SELECT user_id FROM user_emails WHERE email = ?)", email, email).First(&userModel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return r.mapToEntity(&userModel), nil
+}
+
+func (r *UserRepository) FindGuestByDevice(deviceHash string) (*entities.User, error) {
+	var userModel UserModel
+	if err := r.db.Where("guest_device_hash = ? AND is_guest = ?", deviceHash, true).First(&userModel).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
@@ -81,53 +128,247 @@ func (r *UserRepository) FindByEmail(email string) (*entities.User, error) {
 	return r.mapToEntity(&userModel), nil
 }
 
+// FindByCredentials resolves a login identifier, a username or any verified
+// email address of the user
 func (r *UserRepository) FindByCredentials(username string) (*entities.User, error) {
-	return r.FindByUsername(username)
+	user, err := r.FindByUsername(username)
+	if err != nil || user != nil || !strings.Contains(username, "@") {
+		return user, err
+	}
+	return r.FindByEmail(username)
 }
 
 func (r *UserRepository) Update(user *entities.ValidatedUser) (*entities.User, error) {
 	userEntity := user.GetUser()
 
 	userModel := UserModel{
-		Id:         userEntity.Id,
-		CreatedAt:  userEntity.CreatedAt,
-		UpdatedAt:  userEntity.UpdatedAt,
-		Username:   userEntity.Username,
-		Email:      userEntity.Email,
-		Password:   userEntity.Password,
-		Tokens:     userEntity.Tokens,
-		IsVerified: userEntity.IsVerified,
+		Id:              userEntity.Id,
+		CreatedAt:       userEntity.CreatedAt,
+		UpdatedAt:       userEntity.UpdatedAt,
+		Username:        userEntity.Username,
+		Email:           userEntity.Email,
+		Password:        userEntity.Password,
+		Tokens:          userEntity.Tokens,
+		IsVerified:      userEntity.IsVerified,
+		Status:          string(userEntity.Status),
+		StatusReason:    userEntity.StatusReason,
+		DisplayName:     userEntity.DisplayName,
+		Bio:             userEntity.Bio,
+		AvatarURL:       userEntity.AvatarURL,
+		AvatarKey:       userEntity.AvatarKey,
+		InvitedBy:       userEntity.InvitedBy,
+		TermsVersion:    userEntity.TermsVersion,
+		TermsAcceptedAt: userEntity.TermsAcceptedAt,
+		IsGuest:         userEntity.IsGuest,
+		GuestDeviceHash: userEntity.GuestDeviceHash,
+		Version:         userEntity.Version,
 	}
 
-	if err := r.db.Save(&userModel).Error; err != nil {
-		return nil, err
+	// Compare and swap: the row is only written if nobody updated it since
+	// the user was loaded. Tokens are left out, they change on their own
+	// through UpdateTokens and ClearTokens.
+	userModel.Version++
+	result := r.db.Model(&UserModel{}).
+		Where("id = ? AND version = ?", userEntity.Id, userEntity.Version).
+		Select("*").
+		Omit("id", "created_at", "deleted_at", "tokens").
+		Updates(&userModel)
+	if result.Error != nil {
+		return nil, userConflictError(result.Error)
 	}
 
 	// Read back the updated user to ensure data integrity
-	return r.FindById(userEntity.Id)
+	updated, err := r.FindById(userEntity.Id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case updated == nil:
+		return nil, entities.ErrUserNotFound
+	case result.RowsAffected == 0:
+		return nil, entities.ErrConflict.Errorf("user was changed by another request, load it again and retry")
+	}
+	return updated, nil
+}
+
+// UpdatePasswordHash swaps the stored hash only if it is still oldHash, so a
+// password change that lands first is never overwritten
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, oldHash, newHash string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&UserModel{}).Where("id = ? AND password = ?", userID, oldHash).Updates(map[string]interface{}{
+		"password": newHash,
+		"version":  gorm.Expr("version + 1"),
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *UserRepository) UpdateTermsAcceptance(ctx context.Context, userID uuid.UUID, version int, acceptedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&UserModel{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"terms_version":     version,
+		"terms_accepted_at": acceptedAt,
+		"version":           gorm.Expr("version + 1"),
+	}).Error
 }
 
 func (r *UserRepository) Delete(id uuid.UUID) error {
 	return r.db.Delete(&UserModel{}, "id = ?", id).Error
 }
 
+func (r *UserRepository) DeleteUnverifiedBefore(ctx context.Context, cutoff time.Time) ([]uuid.UUID, error) {
+	var deleted []UserModel
+	err := r.db.WithContext(ctx).Unscoped().
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+		Where("is_verified = ? AND is_guest = ? AND created_at < ?", false, false, cutoff).
+		Delete(&deleted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, len(deleted))
+	for i, model := range deleted {
+		ids[i] = model.Id
+	}
+	return ids, nil
+}
+
 func (r *UserRepository) UpdateTokens(ctx context.Context, userID uuid.UUID, token string) error {
 	return r.db.Model(&UserModel{}).Where("id = ?", userID).Update("tokens", gorm.Expr("array_append(tokens, ?)", token)).Error
 }
 
+func (r *UserRepository) ClearTokens(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	var tokens []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var userModel UserModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("tokens").Where("id = ?", userID).First(&userModel).Error; err != nil {
+			return err
+		}
+		tokens = userModel.Tokens
+		return tx.Model(&UserModel{}).Where("id = ?", userID).Update("tokens", gorm.Expr("'{}'::text[]")).Error
+	})
+	return tokens, err
+}
+
+func (r *UserRepository) HasToken(ctx context.Context, userID uuid.UUID, token string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&UserModel{}).Where("id = ? AND ? = ANY(tokens)", userID, token).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *UserRepository) FindByToken(ctx context.Context, token string) (*entities.User, error) {
+	var userModel UserModel
+	if err := r.db.WithContext(ctx).Where("? = ANY(tokens)", token).First(&userModel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return r.mapToEntity(&userModel), nil
+}
+
+// GetProfile reads from the primary: profiles are cached for a day and
+// introspection checks the status in that cache, so a lagging replica's copy
+// could keep a just banned user's tokens active
 func (r *UserRepository) GetProfile(ctx context.Context, userID uuid.UUID) (*entities.User, error) {
-	return r.FindById(userID)
+	var userModel UserModel
+	if err := r.db.WithContext(ctx).Where("id = ?", userID).First(&userModel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return r.mapToEntity(&userModel), nil
+}
+
+func (r *UserRepository) ListUsers(ctx context.Context, filter repositories.UserFilter, page repositories.UserPage, sort repositories.UserSort) ([]*entities.User, error) {
+	db := r.reader().WithContext(ctx).Model(&UserModel{})
+	if term := strings.TrimSpace(filter.Search); term != "" {
+		// The term is matched literally, LIKE wildcards included
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term) + "%"
+		db = db.Where("username ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+	if filter.Verified != nil {
+		db = db.Where("is_verified = ?", *filter.Verified)
+	}
+	if filter.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *filter.CreatedBefore)
+	}
+
+	column, direction, comparison := "created_at", "ASC", ">"
+	if sort.Field == repositories.UserSortUsername {
+		column = "username"
+	}
+	if sort.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if page.After != nil {
+		var value interface{} = page.After.CreatedAt
+		if sort.Field == repositories.UserSortUsername {
+			value = page.After.Username
+		}
+		// Row comparison picks up right after the cursor, ties broken by ID
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison), value, page.After.Id)
+	}
+
+	var userModels []UserModel
+	if err := db.Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).Limit(page.Limit).Find(&userModels).Error; err != nil {
+		return nil, err
+	}
+
+	users := make([]*entities.User, 0, len(userModels))
+	for i := range userModels {
+		users = append(users, r.mapToEntity(&userModels[i]))
+	}
+	return users, nil
 }
 
 func (r *UserRepository) mapToEntity(userModel *UserModel) *entities.User {
 	return &entities.User{
-		Id:         userModel.Id,
-		CreatedAt:  userModel.CreatedAt,
-		UpdatedAt:  userModel.UpdatedAt,
-		Username:   userModel.Username,
-		Email:      userModel.Email,
-		Password:   userModel.Password,
-		Tokens:     userModel.Tokens,
-		IsVerified: userModel.IsVerified,
+		Id:              userModel.Id,
+		CreatedAt:       userModel.CreatedAt,
+		UpdatedAt:       userModel.UpdatedAt,
+		Username:        userModel.Username,
+		Email:           userModel.Email,
+		Password:        userModel.Password,
+		Tokens:          userModel.Tokens,
+		IsVerified:      userModel.IsVerified,
+		Status:          entities.UserStatus(userModel.Status),
+		StatusReason:    userModel.StatusReason,
+		DisplayName:     userModel.DisplayName,
+		Bio:             userModel.Bio,
+		AvatarURL:       userModel.AvatarURL,
+		AvatarKey:       userModel.AvatarKey,
+		InvitedBy:       userModel.InvitedBy,
+		TermsVersion:    userModel.TermsVersion,
+		TermsAcceptedAt: userModel.TermsAcceptedAt,
+		IsGuest:         userModel.IsGuest,
+		GuestDeviceHash: userModel.GuestDeviceHash,
+		Version:         userModel.Version,
+	}
+}
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation
+const uniqueViolation = "23505"
+
+// userConflictError turns a unique violation into the domain error of the
+// value taken, leaving other errors alone. Lookups before a write only catch
+// most duplicates; the unique indexes decide between concurrent writers. The
+// names are those of the indexes AutoMigrate creates and of the UNIQUE
+// columns of the README schema.
+func userConflictError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+		return err
+	}
+	switch pgErr.ConstraintName {
+	case "idx_users_username", "users_username_key":
+		return entities.ErrUsernameExists
+	case "idx_users_email", "users_email_key", "idx_user_emails_email", "user_emails_email_key":
+		return entities.ErrEmailExists
+	default:
+		return entities.ErrUserExists
 	}
 }
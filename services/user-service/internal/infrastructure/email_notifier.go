@@ -0,0 +1,41 @@
+package infrastructure
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/resend/resend-go/v2"
+)
+
+// EmailNotifier sends informational emails that are not OTP deliveries, such
+// as security alerts
+type EmailNotifier struct {
+	sender string
+	client *resend.Client
+}
+
+func NewEmailNotifier() *EmailNotifier {
+	return &EmailNotifier{
+		sender: os.Getenv("EMAIL_SENDER"),
+		client: resend.NewClient(os.Getenv("EMAIL_API_KEY")),
+	}
+}
+
+func (n *EmailNotifier) Send(ctx context.Context, recipientEmail, subject, text string) error {
+	params := &resend.SendEmailRequest{
+		From:    n.sender,
+		To:      []string{recipientEmail},
+		Subject: subject,
+		Text:    text,
+	}
+
+	response, err := n.client.Emails.Send(params)
+	if err != nil {
+		log.Printf("Resend error: %+v", err)
+		return err
+	}
+
+	log.Printf("Notification sent to %s. ID: %s", recipientEmail, response.Id)
+	return nil
+}
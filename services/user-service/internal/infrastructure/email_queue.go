@@ -0,0 +1,226 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// emailQueueGroup is the consumer group every instance's workers join, so
+	// each job goes to one of them
+	emailQueueGroup = "email_workers"
+	// emailQueueBlock bounds how long a worker waits for a job before
+	// checking whether it should stop
+	emailQueueBlock = 2 * time.Second
+	// emailSendTimeout bounds a delivery attempt
+	emailSendTimeout = 10 * time.Second
+	// emailPendingBatch is how many unacknowledged jobs a retry round looks at
+	emailPendingBatch = 100
+)
+
+// EmailSender sends an email right away, as EmailNotifier does
+type EmailSender interface {
+	Send(ctx context.Context, recipientEmail, subject, text string) error
+}
+
+// EmailQueue takes emails off the request path. Jobs are appended to a Redis
+// stream and EMAIL_QUEUE_WORKERS workers per instance send them. A job whose
+// delivery fails stays pending and is retried after EMAIL_QUEUE_RETRY_DELAY,
+// doubling with each attempt, by whichever instance gets to it first, as are
+// jobs of an instance that died while sending them. Jobs are given up on after
+// EMAIL_QUEUE_MAX_ATTEMPTS deliveries, or once they expire.
+type EmailQueue struct {
+	redisService *RedisService
+	sender       EmailSender
+	workers      int
+	maxAttempts  int
+	retryDelay   time.Duration
+	maxLength    int64
+	consumer     string // Prefix of this instance's consumer names
+
+	ctx    context.Context // Canceled by Stop
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewEmailQueue(redisService *RedisService, sender EmailSender) *EmailQueue {
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := &EmailQueue{
+		redisService: redisService,
+		sender:       sender,
+		workers:      GetEnvAsInt("EMAIL_QUEUE_WORKERS", 4),
+		maxAttempts:  GetEnvAsInt("EMAIL_QUEUE_MAX_ATTEMPTS", 5),
+		retryDelay:   GetEnvAsDuration("EMAIL_QUEUE_RETRY_DELAY", 15*time.Second),
+		maxLength:    int64(GetEnvAsInt("EMAIL_QUEUE_MAX_LENGTH", 10000)),
+		consumer:     fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	if queue.retryDelay <= 0 {
+		queue.retryDelay = 15 * time.Second
+	}
+
+	log.Printf("Email Queue Config - Workers: %d, Max Attempts: %d, Retry Delay: %v, Max Length: %d",
+		queue.workers, queue.maxAttempts, queue.retryDelay, queue.maxLength)
+	return queue
+}
+
+// Enqueue queues job for delivery. It fails with ErrDependencyDisabled while
+// Redis is, callers then send the email themselves.
+func (q *EmailQueue) Enqueue(ctx context.Context, job *EmailJob) error {
+	return q.redisService.EnqueueEmailJob(ctx, job, q.maxLength)
+}
+
+// Start runs the workers, and retries failed jobs every retry delay, until
+// Stop is called
+func (q *EmailQueue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go func(consumer string) {
+			defer q.wg.Done()
+			q.consume(consumer)
+		}(fmt.Sprintf("%s-%d", q.consumer, i))
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(q.retryDelay)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				q.retryFailed(q.consumer + "-retry")
+			case <-q.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the emails being sent. Jobs not started yet stay queued for
+// the next instance to start.
+func (q *EmailQueue) Stop() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+func (q *EmailQueue) consume(consumer string) {
+	for {
+		jobs, err := q.redisService.ReadEmailJobs(q.ctx, emailQueueGroup, consumer, 1, emailQueueBlock)
+		if q.ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, redis.Nil):
+			continue
+		case errors.Is(err, ErrDependencyDisabled):
+			return // Emails are sent directly
+		default:
+			log.Printf("Failed to read email jobs: %v", err)
+			select {
+			case <-time.After(time.Second):
+			case <-q.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		for _, job := range jobs {
+			q.deliver(job)
+		}
+	}
+}
+
+// retryFailed claims and sends again the jobs whose last attempt is older
+// than their backoff, failed or lost with the instance making it
+func (q *EmailQueue) retryFailed(consumer string) {
+	pending, err := q.redisService.PendingEmailJobs(q.ctx, emailQueueGroup, emailPendingBatch)
+	if err != nil {
+		if !errors.Is(err, ErrDependencyDisabled) && !errors.Is(err, context.Canceled) && !isNoGroup(err) {
+			log.Printf("Failed to list pending email jobs: %v", err)
+		}
+		return
+	}
+
+	for _, entry := range pending {
+		if q.ctx.Err() != nil {
+			return
+		}
+		backoff := q.backoff(entry.RetryCount)
+		if entry.Idle < backoff {
+			continue
+		}
+		if entry.RetryCount >= int64(q.maxAttempts) {
+			log.Printf("Giving up on email job %s after %d attempts", entry.ID, entry.RetryCount)
+			q.ack(entry.ID)
+			continue
+		}
+
+		// Fails when another instance claimed it meanwhile
+		job, err := q.redisService.ClaimEmailJob(q.ctx, emailQueueGroup, consumer, entry.ID, backoff)
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				log.Printf("Failed to claim email job %s: %v", entry.ID, err)
+			}
+			continue
+		}
+		q.deliver(job)
+	}
+}
+
+// backoff is how long after its last attempt a job is tried again
+func (q *EmailQueue) backoff(attempts int64) time.Duration {
+	backoff := q.retryDelay
+	for i := int64(1); i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+	return backoff
+}
+
+// deliver sends job and acknowledges it. A job that fails is left pending for
+// retryFailed.
+func (q *EmailQueue) deliver(job *EmailJob) {
+	if job.To == "" {
+		log.Printf("Dropping malformed email job %s", job.ID)
+		q.ack(job.ID)
+		return
+	}
+	if !job.ExpiresAt.IsZero() && time.Now().After(job.ExpiresAt) {
+		log.Printf("Dropping email to %s, it expired before it could be sent", job.To)
+		q.ack(job.ID)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+	defer cancel()
+	if err := q.sender.Send(ctx, job.To, job.Subject, job.Text); err != nil {
+		log.Printf("Failed to send email to %s, it will be retried: %v", job.To, err)
+		return
+	}
+	q.ack(job.ID)
+}
+
+// ack removes a job once done with. Stop does not cancel it, so an email sent
+// while stopping is not sent again.
+func (q *EmailQueue) ack(id string) {
+	if err := q.redisService.AckEmailJob(context.Background(), emailQueueGroup, id); err != nil {
+		log.Printf("Failed to acknowledge email job %s: %v", id, err)
+	}
+}
+
+// isNoGroup reports whether err says the consumer group does not exist yet,
+// before a worker first reads
+func isNoGroup(err error) bool {
+	return strings.HasPrefix(err.Error(), "NOGROUP")
+}
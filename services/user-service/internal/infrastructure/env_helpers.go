@@ -3,6 +3,7 @@ package infrastructure
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -33,3 +34,25 @@ func GetEnvAsString(key string, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// GetEnvAsBool gets environment variable as bool with default value
+func GetEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// GetEnvAsList gets a comma separated environment variable as a list,
+// skipping empty entries
+func GetEnvAsList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,64 @@
+package infrastructure
+
+import (
+	"log"
+	"sync"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// EventBus fans user events out to in-process subscribers. Publishing never
+// blocks: a subscriber that falls behind misses events rather than slowing
+// down the request that raised them.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*EventSubscription]struct{}
+}
+
+// EventSubscription receives the events published after it was created
+type EventSubscription struct {
+	bus    *EventBus
+	events chan entities.UserEvent
+	once   sync.Once
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*EventSubscription]struct{})}
+}
+
+// Subscribe registers a subscriber whose channel holds up to buffer events
+func (b *EventBus) Subscribe(buffer int) *EventSubscription {
+	subscription := &EventSubscription{bus: b, events: make(chan entities.UserEvent, buffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+func (b *EventBus) Publish(event entities.UserEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for subscription := range b.subscribers {
+		select {
+		case subscription.events <- event:
+		default:
+			log.Printf("Event subscriber is full, dropped %s event for user %s", event.Type, event.UserId)
+		}
+	}
+}
+
+func (s *EventSubscription) Events() <-chan entities.UserEvent {
+	return s.events
+}
+
+// Close unsubscribes and closes the events channel
+func (s *EventSubscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		defer s.bus.mu.Unlock()
+		delete(s.bus.subscribers, s)
+		close(s.events)
+	})
+}
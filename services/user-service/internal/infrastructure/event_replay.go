@@ -0,0 +1,416 @@
+package infrastructure
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// replayBatch is how many messages each pull asks JetStream for
+	replayBatch = 256
+	// replayRequestTimeout bounds waiting for a JetStream API response or a
+	// batch of messages
+	replayRequestTimeout = 10 * time.Second
+	// maxReplayMessageSize bounds the messages read, events are small
+	maxReplayMessageSize = 1 << 20
+)
+
+// EventReplay selects the events to republish
+type EventReplay struct {
+	Stream string    // JetStream stream capturing the events
+	Filter string    // Subjects to read, user.> by default
+	From   time.Time // Stored at or after From
+	To     time.Time // Stored before To, the zero time for up to now
+	Types  []string  // Event types to republish, every published type when empty
+	Target string    // Subject the events are republished on
+	DryRun bool      // Count the events without republishing them
+}
+
+// ReplayResult counts what a replay did
+type ReplayResult struct {
+	Read       int       `json:"read"`     // Messages read from the stream
+	Replayed   int       `json:"replayed"` // Events republished, or that would be on a dry run
+	Skipped    int       `json:"skipped"`  // Messages that are not selected user events
+	LastStored time.Time `json:"lastStored"`
+}
+
+// EventReplayer republishes the user events a JetStream stream captured, for
+// consumers rebuilding their state after losing data. It reads the stream
+// with an ephemeral pull consumer starting at the replay's start time, over
+// the JetStream API, in the same small subset of the NATS protocol as
+// NATSPublisher.
+type EventReplayer struct {
+	address string
+	connect natsConnectOptions
+	prefix  string // MESSAGING_SUBJECT_PREFIX
+
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	headers bool
+	inbox   string
+	sent    int // Requests sent, to tell their responses apart
+}
+
+// NewEventReplayer prepares a replayer for the server at natsURL, in the
+// form NewNATSPublisher takes
+func NewEventReplayer(natsURL string) *EventReplayer {
+	return &EventReplayer{
+		address: natsAddress(natsURL),
+		connect: newNATSConnectOptions(natsURL),
+		prefix:  natsSubjectPrefix(),
+	}
+}
+
+// jsMessage is a message delivered by JetStream, or the status ending a pull
+type jsMessage struct {
+	subject string
+	reply   string
+	status  string // Status code of a status message, empty for a message
+	header  string // The header block, as received
+	payload []byte
+}
+
+// consumerCreateResponse is the part of the JetStream response used here
+type consumerCreateResponse struct {
+	Name       string `json:"name"`
+	NumPending uint64 `json:"num_pending"`
+	Error      *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// Replay republishes the events replay selects on its target subject, in
+// the order they were stored. The filter and target subjects go under
+// MESSAGING_SUBJECT_PREFIX. The event's headers travel with it, except
+// Nats-Msg-Id, so a stream capturing the target does not drop the replayed
+// events as duplicates; consumers deduplicate by the CloudEvent id instead.
+func (r *EventReplayer) Replay(replay EventReplay) (ReplayResult, error) {
+	var result ReplayResult
+	if replay.Stream == "" || replay.Target == "" {
+		return result, fmt.Errorf("a stream and a target subject are required")
+	}
+	if replay.Filter == "" {
+		replay.Filter = "user.>"
+	}
+	types := make(map[string]bool, len(replay.Types))
+	for _, eventType := range replay.Types {
+		types[eventType] = true
+	}
+	if len(types) == 0 {
+		for eventType := range publishedEventVersions {
+			types[eventType] = true
+		}
+	}
+
+	if err := r.dial(); err != nil {
+		return result, err
+	}
+	defer r.conn.Close()
+
+	consumer, pending, err := r.createConsumer(replay.Stream, natsSubject(r.prefix, replay.Filter), replay.From)
+	if err != nil {
+		return result, err
+	}
+	defer r.publish("$JS.API.CONSUMER.DELETE."+replay.Stream+"."+consumer, "", "", nil)
+	log.Printf("Replaying up to %d messages of stream %s from %s", pending, replay.Stream, replay.From.Format(time.RFC3339))
+
+	target := natsSubject(r.prefix, replay.Target)
+	for {
+		messages, err := r.pull(replay.Stream, consumer)
+		if err != nil {
+			return result, err
+		}
+		if len(messages) == 0 {
+			return result, r.flush()
+		}
+
+		for _, message := range messages {
+			stored, remaining, err := jsAckMetadata(message.reply)
+			if err != nil {
+				return result, err
+			}
+			if !replay.To.IsZero() && !stored.Before(replay.To) {
+				return result, r.flush()
+			}
+			result.Read++
+			result.LastStored = stored
+			if err := r.publish(message.reply, "", "", []byte("+ACK")); err != nil {
+				return result, err
+			}
+
+			var event CloudEvent
+			if err := json.Unmarshal(message.payload, &event); err != nil || !types[event.Type] {
+				result.Skipped++
+			} else {
+				if !replay.DryRun {
+					if err := r.publish(target, "", replayHeader(message.header), message.payload); err != nil {
+						return result, err
+					}
+				}
+				result.Replayed++
+			}
+
+			if remaining == 0 {
+				return result, r.flush()
+			}
+		}
+	}
+}
+
+func (r *EventReplayer) dial() error {
+	conn, reader, writer, headers, err := dialNATS(r.address, r.connect)
+	if err != nil {
+		return err
+	}
+	r.conn, r.reader, r.writer, r.headers = conn, reader, writer, headers
+	r.inbox = "_INBOX." + strings.ReplaceAll(uuid.New().String(), "-", "")
+	fmt.Fprintf(r.writer, "SUB %s.* 1\r\n", r.inbox)
+	return r.writer.Flush()
+}
+
+// createConsumer creates an ephemeral pull consumer of the stream's filter
+// subjects, starting at from. pending is how many messages it has to read.
+func (r *EventReplayer) createConsumer(stream, filter string, from time.Time) (name string, pending uint64, err error) {
+	request, _ := json.Marshal(map[string]interface{}{
+		"stream_name": stream,
+		"config": map[string]interface{}{
+			"deliver_policy":     "by_start_time",
+			"opt_start_time":     from.UTC(),
+			"ack_policy":         "explicit",
+			"filter_subject":     filter,
+			"inactive_threshold": int64(time.Minute),
+		},
+	})
+	response, err := r.request("$JS.API.CONSUMER.CREATE."+stream, request)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create a consumer of stream %s: %w", stream, err)
+	}
+
+	var created consumerCreateResponse
+	if err := json.Unmarshal(response.payload, &created); err != nil {
+		return "", 0, fmt.Errorf("unexpected JetStream response: %w", err)
+	}
+	if created.Error != nil {
+		return "", 0, fmt.Errorf("failed to create a consumer of stream %s: %s (%d)", stream, created.Error.Description, created.Error.Code)
+	}
+	return created.Name, created.NumPending, nil
+}
+
+// pull fetches the next batch of messages, none once the consumer has read
+// everything
+func (r *EventReplayer) pull(stream, consumer string) ([]jsMessage, error) {
+	request, _ := json.Marshal(map[string]interface{}{"batch": replayBatch, "no_wait": true})
+	if err := r.publish("$JS.API.CONSUMER.MSG.NEXT."+stream+"."+consumer, r.nextReply(), "", request); err != nil {
+		return nil, err
+	}
+
+	var messages []jsMessage
+	for len(messages) < replayBatch {
+		message, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if message.status != "" {
+			// 404 and 408 end a pull with nothing more to deliver
+			if message.status == "404" || message.status == "408" {
+				break
+			}
+			return nil, fmt.Errorf("JetStream refused the pull: status %s", message.status)
+		}
+		if !strings.HasPrefix(message.reply, "$JS.ACK.") {
+			continue
+		}
+		messages = append(messages, message)
+		// The server has nothing to end the batch with after the last one
+		if _, pending, err := jsAckMetadata(message.reply); err == nil && pending == 0 {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// request sends a JetStream API request and waits for its response
+func (r *EventReplayer) request(subject string, payload []byte) (jsMessage, error) {
+	reply := r.nextReply()
+	if err := r.publish(subject, reply, "", payload); err != nil {
+		return jsMessage{}, err
+	}
+	for {
+		message, err := r.read()
+		if err != nil {
+			return jsMessage{}, err
+		}
+		if message.subject != reply {
+			continue
+		}
+		if message.status == "503" {
+			return jsMessage{}, fmt.Errorf("JetStream is not enabled on the server")
+		}
+		return message, nil
+	}
+}
+
+func (r *EventReplayer) nextReply() string {
+	r.sent++
+	return r.inbox + "." + strconv.Itoa(r.sent)
+}
+
+// publish sends a message, with a reply subject and a header block when
+// they are set
+func (r *EventReplayer) publish(subject, reply, header string, payload []byte) error {
+	replyTo := ""
+	if reply != "" {
+		replyTo = " " + reply
+	}
+	r.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	if header != "" && r.headers {
+		fmt.Fprintf(r.writer, "HPUB %s%s %d %d\r\n%s", subject, replyTo, len(header), len(header)+len(payload), header)
+	} else {
+		fmt.Fprintf(r.writer, "PUB %s%s %d\r\n", subject, replyTo, len(payload))
+	}
+	r.writer.Write(payload)
+	r.writer.WriteString("\r\n")
+	return r.writer.Flush()
+}
+
+// flush waits until the server processed everything published so far
+func (r *EventReplayer) flush() error {
+	r.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	r.writer.WriteString("PING\r\n")
+	if err := r.writer.Flush(); err != nil {
+		return err
+	}
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(replayRequestTimeout))
+		line, err := r.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "PONG" {
+			return nil
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return fmt.Errorf("NATS reported an error: %s", strings.TrimSpace(line))
+		}
+	}
+}
+
+// read returns the next message on the inbox, answering the server's pings
+func (r *EventReplayer) read() (jsMessage, error) {
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(replayRequestTimeout))
+		line, err := r.reader.ReadString('\n')
+		if err != nil {
+			return jsMessage{}, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PING":
+			r.writer.WriteString("PONG\r\n")
+			r.writer.Flush()
+		case "-ERR":
+			return jsMessage{}, fmt.Errorf("NATS reported an error: %s", strings.TrimSpace(line))
+		case "MSG", "HMSG":
+			return r.readMessage(fields, line)
+		}
+	}
+}
+
+// readMessage reads the body of MSG <subject> <sid> [reply] <size> or
+// HMSG <subject> <sid> [reply] <header size> <size>
+func (r *EventReplayer) readMessage(fields []string, line string) (jsMessage, error) {
+	hmsg := fields[0] == "HMSG"
+	sizes := 1
+	if hmsg {
+		sizes = 2
+	}
+	if len(fields) < 3+sizes || len(fields) > 4+sizes {
+		return jsMessage{}, fmt.Errorf("unexpected message from NATS: %.40q", line)
+	}
+
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || size < 0 || size > maxReplayMessageSize {
+		return jsMessage{}, fmt.Errorf("unexpected message from NATS: %.40q", line)
+	}
+	headerSize := 0
+	if hmsg {
+		if headerSize, err = strconv.Atoi(fields[len(fields)-2]); err != nil || headerSize < 0 || headerSize > size {
+			return jsMessage{}, fmt.Errorf("unexpected message from NATS: %.40q", line)
+		}
+	}
+	body := make([]byte, size+2)
+	if _, err := io.ReadFull(r.reader, body); err != nil {
+		return jsMessage{}, err
+	}
+
+	message := jsMessage{subject: fields[1], header: string(body[:headerSize]), payload: body[headerSize:size]}
+	if len(fields) == 4+sizes {
+		message.reply = fields[3]
+	}
+	// NATS/1.0 404 No Messages: a status with no headers after it
+	if status := strings.Fields(strings.SplitN(message.header, "\r\n", 2)[0]); len(status) > 1 {
+		message.status = status[1]
+	}
+	return message, nil
+}
+
+// jsAckMetadata reads when a message was stored, and how many remain after
+// it, from its ack subject: $JS.ACK.<stream>.<consumer>.<delivered>.<stream
+// seq>.<consumer seq>.<timestamp>.<pending>, with a domain and account hash
+// after ACK on servers from 2.9
+func jsAckMetadata(reply string) (stored time.Time, pending uint64, err error) {
+	tokens := strings.Split(reply, ".")
+	var timestamp, remaining string
+	switch {
+	case len(tokens) == 9:
+		timestamp, remaining = tokens[7], tokens[8]
+	case len(tokens) >= 11:
+		timestamp, remaining = tokens[9], tokens[10]
+	default:
+		return time.Time{}, 0, fmt.Errorf("unexpected JetStream ack subject %q", reply)
+	}
+
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("unexpected JetStream ack subject %q", reply)
+	}
+	if pending, err = strconv.ParseUint(remaining, 10, 64); err != nil {
+		return time.Time{}, 0, fmt.Errorf("unexpected JetStream ack subject %q", reply)
+	}
+	return time.Unix(0, nanos), pending, nil
+}
+
+// replayHeader is the header block of a replayed event: the original one
+// without Nats-Msg-Id, or none when nothing else is left
+func replayHeader(header string) string {
+	lines := strings.Split(strings.TrimSuffix(header, "\r\n\r\n"), "\r\n")
+	if len(lines) < 2 {
+		return ""
+	}
+	kept := []string{"NATS/1.0"}
+	for _, line := range lines[1:] {
+		name, _, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), "Nats-Msg-Id") && line != "" {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == 1 {
+		return ""
+	}
+	return strings.Join(kept, "\r\n") + "\r\n\r\n"
+}
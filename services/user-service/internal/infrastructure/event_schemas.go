@@ -0,0 +1,147 @@
+package infrastructure
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+// The data of each published event, one struct per version. A new version
+// may add fields; removing, renaming or retyping one breaks consumers and
+// needs a new event type instead, which CheckEventSchemas enforces.
+
+// UserCreatedV1 is version 1 of the data of user.created
+type UserCreatedV1 struct {
+	EventVersion int       `json:"event_version"`
+	UserID       uuid.UUID `json:"userID"`
+	Reason       string    `json:"reason,omitempty"` // registration, invite or guest
+}
+
+// UserVerifiedV1 is version 1 of the data of user.verified
+type UserVerifiedV1 struct {
+	EventVersion int       `json:"event_version"`
+	UserID       uuid.UUID `json:"userID"`
+	Reason       string    `json:"reason,omitempty"` // registration_otp, invite or account_upgraded
+}
+
+// UserDeletedV1 is version 1 of the data of user.deleted
+type UserDeletedV1 struct {
+	EventVersion int       `json:"event_version"`
+	UserID       uuid.UUID `json:"userID"`
+	Reason       string    `json:"reason,omitempty"` // unverified_purged
+}
+
+// eventSchema builds the data of one version of an event
+type eventSchema func(event entities.UserEvent) interface{}
+
+// eventSchemas registers every version of every published event, keyed by
+// event type and version
+var eventSchemas = map[string]map[int]eventSchema{
+	entities.UserEventCreated: {
+		1: func(event entities.UserEvent) interface{} {
+			return UserCreatedV1{EventVersion: 1, UserID: event.UserId, Reason: event.Reason}
+		},
+	},
+	entities.UserEventVerified: {
+		1: func(event entities.UserEvent) interface{} {
+			return UserVerifiedV1{EventVersion: 1, UserID: event.UserId, Reason: event.Reason}
+		},
+	},
+	entities.UserEventDeleted: {
+		1: func(event entities.UserEvent) interface{} {
+			return UserDeletedV1{EventVersion: 1, UserID: event.UserId, Reason: event.Reason}
+		},
+	},
+}
+
+// publishedEventVersions is the version each event is published at. Bump it
+// once consumers handle the new version.
+var publishedEventVersions = map[string]int{
+	entities.UserEventCreated:  1,
+	entities.UserEventVerified: 1,
+	entities.UserEventDeleted:  1,
+}
+
+// EventData returns the data of event at version, and refuses event types
+// and versions that are not registered, so nothing unknown to consumers
+// gets published
+func EventData(event entities.UserEvent, version int) (interface{}, error) {
+	versions, ok := eventSchemas[event.Type]
+	if !ok {
+		return nil, fmt.Errorf("no schema registered for %s events", event.Type)
+	}
+	schema, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("version %d of %s events is not registered", version, event.Type)
+	}
+	return schema(event), nil
+}
+
+// CheckEventSchemas verifies the registry: every published version is
+// registered, every version carries its event_version, and each version
+// keeps the fields of the one before with the same JSON types
+func CheckEventSchemas() error {
+	sample := entities.NewUserEvent("", uuid.New(), "sample")
+
+	for eventType, version := range publishedEventVersions {
+		if _, ok := eventSchemas[eventType][version]; !ok {
+			return fmt.Errorf("%s is published at version %d, which is not registered", eventType, version)
+		}
+	}
+
+	for eventType, versions := range eventSchemas {
+		numbers := make([]int, 0, len(versions))
+		for version := range versions {
+			numbers = append(numbers, version)
+		}
+		sort.Ints(numbers)
+
+		var previous map[string]reflect.Kind
+		for i, version := range numbers {
+			if version != i+1 {
+				return fmt.Errorf("versions of %s skip from %d to %d", eventType, i, version)
+			}
+
+			sample.Type = eventType
+			data := versions[version](sample)
+			eventVersion := reflect.ValueOf(data).FieldByName("EventVersion")
+			if !eventVersion.IsValid() || eventVersion.Kind() != reflect.Int || eventVersion.Int() != int64(version) {
+				return fmt.Errorf("version %d of %s does not carry event_version %d", version, eventType, version)
+			}
+
+			fields := jsonFields(reflect.TypeOf(data))
+			for name, kind := range previous {
+				current, ok := fields[name]
+				if !ok {
+					return fmt.Errorf("version %d of %s drops field %s", version, eventType, name)
+				}
+				if current != kind {
+					return fmt.Errorf("version %d of %s changes the type of field %s", version, eventType, name)
+				}
+			}
+			previous = fields
+		}
+	}
+	return nil
+}
+
+// jsonFields maps the JSON names of a struct's fields to their kinds
+func jsonFields(t reflect.Type) map[string]reflect.Kind {
+	fields := make(map[string]reflect.Kind, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type.Kind()
+	}
+	return fields
+}
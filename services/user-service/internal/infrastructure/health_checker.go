@@ -0,0 +1,122 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Component health statuses
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+	// HealthDisabled is a dependency the service was started without and runs
+	// degraded without, Redis for instance. It does not make the service unready.
+	HealthDisabled = "disabled"
+)
+
+// ErrDependencyDisabled is returned by checks of dependencies that are not
+// configured or were given up on at startup
+var ErrDependencyDisabled = errors.New("disabled")
+
+// HealthCheck reports whether a dependency can be reached
+type HealthCheck func(ctx context.Context) error
+
+// ComponentHealth is the outcome of one check
+type ComponentHealth struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthReport is the outcome of every check, up when none is down
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// HealthChecker runs the checks of the service's dependencies
+type HealthChecker struct {
+	checks  []namedCheck
+	timeout time.Duration
+}
+
+func NewHealthChecker() *HealthChecker {
+	return &HealthChecker{timeout: GetEnvAsDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second)}
+}
+
+// Add registers the check of a dependency, reported under name
+func (c *HealthChecker) Add(name string, check HealthCheck) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Check runs every check at once, each limited to the check timeout
+func (c *HealthChecker) Check(ctx context.Context) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := HealthReport{Status: HealthUp, Components: make([]ComponentHealth, len(c.checks))}
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check namedCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.check(ctx)
+
+			component := ComponentHealth{Name: check.name, Status: HealthUp, LatencyMs: time.Since(start).Milliseconds()}
+			switch {
+			case errors.Is(err, ErrDependencyDisabled):
+				component.Status = HealthDisabled
+			case err != nil:
+				component.Status = HealthDown
+				component.Error = err.Error()
+			}
+			report.Components[i] = component
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status == HealthDown {
+			report.Status = HealthDown
+		}
+	}
+	return report
+}
+
+// NATSHealthCheck checks that the NATS server at natsURL, nats://host:port,
+// accepts connections and greets them with its INFO line
+func NATSHealthCheck(natsURL string) HealthCheck {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", natsAddress(natsURL))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetReadDeadline(deadline)
+		}
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "INFO ") {
+			return fmt.Errorf("unexpected greeting from NATS: %.40q", line)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,213 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+const (
+	// idempotencyCleanupLockKey lets a single instance run each cleanup when
+	// several are deployed
+	idempotencyCleanupLockKey = "idempotency_cleanup:lock"
+	// idempotencyWriteTimeout bounds writing a record behind to Postgres
+	idempotencyWriteTimeout = 5 * time.Second
+)
+
+// idempotencyWrite is a record waiting to be written behind to Postgres
+type idempotencyWrite struct {
+	record *entities.IdempotencyRecord
+	update bool
+}
+
+// IdempotencyStore keeps idempotency records in Redis for IDEMPOTENCY_TTL in
+// front of the Postgres repository, so replaying a command costs no database
+// round trip. A worker writes the records behind to Postgres, which then only
+// serves the lookups Redis misses, such as after a flush. Records older than
+// the TTL are ignored there and periodically deleted. With Redis disabled, or
+// the write queue full, records go to Postgres directly.
+type IdempotencyStore struct {
+	repo            repositories.IdempotencyRepository
+	redisService    *RedisService
+	ttl             time.Duration
+	cleanupInterval time.Duration
+
+	writes chan idempotencyWrite
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewIdempotencyStore(repo repositories.IdempotencyRepository, redisService *RedisService) *IdempotencyStore {
+	store := &IdempotencyStore{
+		repo:            repo,
+		redisService:    redisService,
+		ttl:             GetEnvAsDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		cleanupInterval: GetEnvAsDuration("IDEMPOTENCY_CLEANUP_INTERVAL", time.Hour),
+		writes:          make(chan idempotencyWrite, GetEnvAsInt("IDEMPOTENCY_WRITE_BUFFER", 1024)),
+		stop:            make(chan struct{}),
+	}
+
+	log.Printf("Idempotency Store Config - TTL: %v, Cleanup Interval: %v", store.ttl, store.cleanupInterval)
+	return store
+}
+
+// Start writes records behind to Postgres, and cleans up expired ones every
+// cleanup interval, until Stop is called. An interval of 0 disables the
+// cleanup.
+func (s *IdempotencyStore) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		var cleanup <-chan time.Time
+		if s.cleanupInterval > 0 {
+			ticker := time.NewTicker(s.cleanupInterval)
+			defer ticker.Stop()
+			cleanup = ticker.C
+		}
+
+		for {
+			select {
+			case write := <-s.writes:
+				s.writeBehind(write)
+			case <-cleanup:
+				s.Cleanup(context.Background())
+			case <-s.stop:
+				// Flush what is queued, the records are lost otherwise
+				for {
+					select {
+					case write := <-s.writes:
+						s.writeBehind(write)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop writes the queued records and waits for a running cleanup to finish
+func (s *IdempotencyStore) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *IdempotencyStore) FindByKey(ctx context.Context, key string) (*entities.IdempotencyRecord, error) {
+	record, err := s.redisService.GetIdempotencyRecord(ctx, key)
+	if err == nil {
+		return record, nil
+	}
+	if !errors.Is(err, redis.Nil) && !errors.Is(err, ErrDependencyDisabled) {
+		log.Printf("Failed to read idempotency record from Redis, falling back to Postgres: %v", err)
+	}
+
+	record, err = s.repo.FindByKey(ctx, key)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	age := time.Since(record.CreatedAt)
+	if age >= s.ttl {
+		return nil, nil // Expired, the cleanup deletes it
+	}
+
+	// Serve the next lookups from Redis again
+	if _, err := s.redisService.SetIdempotencyRecord(ctx, record, s.ttl-age, true); err != nil && !errors.Is(err, ErrDependencyDisabled) {
+		log.Printf("Failed to cache idempotency record: %v", err)
+	}
+	return record, nil
+}
+
+func (s *IdempotencyStore) Create(ctx context.Context, record *entities.IdempotencyRecord) (*entities.IdempotencyRecord, error) {
+	created, err := s.redisService.SetIdempotencyRecord(ctx, record, s.ttl, true)
+	if err != nil {
+		if !errors.Is(err, ErrDependencyDisabled) {
+			log.Printf("Failed to store idempotency record in Redis, writing it to Postgres: %v", err)
+		}
+		return s.repo.Create(ctx, record)
+	}
+	if !created {
+		return nil, fmt.Errorf("idempotency key %s is already recorded", record.Key)
+	}
+	return s.queue(ctx, idempotencyWrite{record: record})
+}
+
+func (s *IdempotencyStore) Update(ctx context.Context, record *entities.IdempotencyRecord) (*entities.IdempotencyRecord, error) {
+	ttl := s.ttl - time.Since(record.CreatedAt)
+	if ttl <= 0 {
+		return s.repo.Update(ctx, record)
+	}
+	if _, err := s.redisService.SetIdempotencyRecord(ctx, record, ttl, false); err != nil {
+		if !errors.Is(err, ErrDependencyDisabled) {
+			log.Printf("Failed to store idempotency record in Redis, writing it to Postgres: %v", err)
+		}
+		return s.repo.Update(ctx, record)
+	}
+	return s.queue(ctx, idempotencyWrite{record: record, update: true})
+}
+
+func (s *IdempotencyStore) DeleteCreatedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	return s.repo.DeleteCreatedBefore(ctx, cutoff)
+}
+
+// Cleanup deletes the Postgres records older than the TTL. Errors are logged,
+// the next run retries.
+func (s *IdempotencyStore) Cleanup(ctx context.Context) {
+	// Hold the lock for most of the interval so other instances skip this round.
+	// Without Redis every instance deletes: the delete is safe to repeat, and
+	// skipping it would let the table grow for as long as Redis is down.
+	acquired, err := s.redisService.AcquireCooldown(ctx, idempotencyCleanupLockKey, s.cleanupInterval/2)
+	if err != nil {
+		if !errors.Is(err, ErrDependencyDisabled) {
+			log.Printf("Failed to acquire idempotency cleanup lock, cleaning up anyway: %v", err)
+		}
+	} else if !acquired {
+		return
+	}
+
+	cutoff := time.Now().Add(-s.ttl)
+	deleted, err := s.repo.DeleteCreatedBefore(ctx, cutoff)
+	if err != nil {
+		log.Printf("Failed to clean up idempotency records: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d idempotency records created before %s", deleted, cutoff.Format(time.RFC3339))
+	}
+}
+
+// queue hands write to the worker, or makes it right away when the queue is
+// full
+func (s *IdempotencyStore) queue(ctx context.Context, write idempotencyWrite) (*entities.IdempotencyRecord, error) {
+	select {
+	case s.writes <- write:
+		return write.record, nil
+	default:
+		if write.update {
+			return s.repo.Update(ctx, write.record)
+		}
+		return s.repo.Create(ctx, write.record)
+	}
+}
+
+func (s *IdempotencyStore) writeBehind(write idempotencyWrite) {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyWriteTimeout)
+	defer cancel()
+
+	var err error
+	if write.update {
+		_, err = s.repo.Update(ctx, write.record)
+	} else {
+		_, err = s.repo.Create(ctx, write.record)
+	}
+	if err != nil {
+		log.Printf("Failed to write idempotency record %s behind to Postgres: %v", write.record.Key, err)
+	}
+}
@@ -0,0 +1,95 @@
+package infrastructure
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+
+	// Registered for image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// maxImageDimension rejects images whose header promises more pixels than is
+// sane for an avatar before any pixel data is decoded
+const maxImageDimension = 4096
+
+// DecodeImage decodes a PNG, JPEG or GIF image after checking its dimensions
+func DecodeImage(data []byte) (image.Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("unsupported image format, use PNG, JPEG or GIF")
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension {
+		return nil, entities.ErrInvalidInput.Errorf("image must be at most %dx%d pixels", maxImageDimension, maxImageDimension)
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return nil, entities.ErrInvalidInput.Errorf("image is empty")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid %s image: %v", format, err)
+	}
+	return img, nil
+}
+
+// ResizeSquare center-crops img to a square and scales it to size x size,
+// averaging the source pixels that fall into each target pixel
+func ResizeSquare(img image.Image, size int) *image.NRGBA {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	offsetX := bounds.Min.X + (bounds.Dx()-side)/2
+	offsetY := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		srcY0 := offsetY + y*side/size
+		srcY1 := offsetY + (y+1)*side/size
+		if srcY1 <= srcY0 {
+			srcY1 = srcY0 + 1
+		}
+		for x := 0; x < size; x++ {
+			srcX0 := offsetX + x*side/size
+			srcX1 := offsetX + (x+1)*side/size
+			if srcX1 <= srcX0 {
+				srcX1 = srcX0 + 1
+			}
+
+			var r, g, b, a, count uint64
+			for sy := srcY0; sy < srcY1; sy++ {
+				for sx := srcX0; sx < srcX1; sx++ {
+					pixel := color.NRGBAModel.Convert(img.At(sx, sy)).(color.NRGBA)
+					r += uint64(pixel.R)
+					g += uint64(pixel.G)
+					b += uint64(pixel.B)
+					a += uint64(pixel.A)
+					count++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / count),
+				G: uint8(g / count),
+				B: uint8(b / count),
+				A: uint8(a / count),
+			})
+		}
+	}
+	return dst
+}
+
+// EncodePNG encodes img as a PNG
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
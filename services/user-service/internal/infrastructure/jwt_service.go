@@ -1,36 +1,290 @@
 package infrastructure
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// signingKey is a key the service signs tokens with, identified by its kid
+type signingKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  interface{}
+	activeFrom time.Time
+}
+
+// JWTService signs with the newest key whose activation time has passed.
+// Older keys keep verifying tokens for gracePeriod after they are replaced,
+// so tokens issued just before a rotation stay valid until they expire.
 type JWTService struct {
-	secretKey string
+	keys        []*signingKey // ordered by activeFrom
+	gracePeriod time.Duration
+}
+
+// JWK is a single public key in a JWKS document (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the public key set other services use to verify tokens offline
+type JWKS struct {
+	Keys []JWK `json:"keys"`
 }
 
+// keyScheduleEntry is one key in the JWT_KEYS_FILE rotation schedule
+type keyScheduleEntry struct {
+	Kid            string    `json:"kid"`
+	Algorithm      string    `json:"alg"`
+	PrivateKeyFile string    `json:"private_key_file"`
+	SecretEnv      string    `json:"secret_env"`
+	ActiveFrom     time.Time `json:"active_from"`
+}
+
+// NewJWTService signs with HS256 and JWTSECRETKEY by default. Setting
+// JWT_SIGNING_ALG to RS256 or EdDSA switches to the PEM private key in
+// JWT_PRIVATE_KEY_FILE, whose public half is then published as a JWKS.
+//
+// JWT_KEYS_FILE replaces both with a rotation schedule: a JSON list of keys,
+// each with a kid and an active_from time. Retired keys are still accepted for
+// JWT_KEY_GRACE_PERIOD, which defaults to the token lifetime.
 func NewJWTService() *JWTService {
-	return &JWTService{
-		secretKey: os.Getenv("JWTSECRETKEY"),
+	gracePeriod := GetEnvAsDuration("JWT_KEY_GRACE_PERIOD", tokenLifetime)
+
+	var keys []*signingKey
+	var err error
+	if keysFile := os.Getenv("JWT_KEYS_FILE"); keysFile != "" {
+		keys, err = loadKeySchedule(keysFile)
+	} else {
+		var key *signingKey
+		algorithm := GetEnvAsString("JWT_SIGNING_ALG", "HS256")
+		key, err = loadSigningKey(algorithm, os.Getenv("JWT_PRIVATE_KEY_FILE"), os.Getenv("JWTSECRETKEY"), os.Getenv("JWT_KEY_ID"))
+		keys = []*signingKey{key}
+	}
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing key: %v", err)
+	}
+
+	service := &JWTService{keys: keys, gracePeriod: gracePeriod}
+	current := service.currentKey(time.Now())
+	log.Printf("JWT Service Config - Algorithm: %s, Key ID: %s, Keys: %d, Grace Period: %v",
+		current.method.Alg(), current.kid, len(keys), gracePeriod)
+	return service
+}
+
+func loadKeySchedule(path string) ([]*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []keyScheduleEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid key schedule: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("key schedule is empty")
+	}
+
+	keys := make([]*signingKey, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.Kid == "" {
+			return nil, errors.New("every key in the schedule needs a kid")
+		}
+		if seen[entry.Kid] {
+			return nil, fmt.Errorf("duplicate kid in key schedule: %s", entry.Kid)
+		}
+		seen[entry.Kid] = true
+
+		var secret string
+		if entry.Algorithm == "HS256" {
+			if secret = os.Getenv(entry.SecretEnv); secret == "" {
+				return nil, fmt.Errorf("key %s: secret_env must name a non-empty variable", entry.Kid)
+			}
+		}
+		key, err := loadSigningKey(entry.Algorithm, entry.PrivateKeyFile, secret, entry.Kid)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", entry.Kid, err)
+		}
+		key.activeFrom = entry.ActiveFrom
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].activeFrom.Before(keys[j].activeFrom)
+	})
+
+	return keys, nil
+}
+
+func loadSigningKey(algorithm, privateKeyFile, secret, kid string) (*signingKey, error) {
+	switch algorithm {
+	case "HS256":
+		return &signingKey{kid: kid, method: jwt.SigningMethodHS256, privateKey: []byte(secret), publicKey: []byte(secret)}, nil
+	case "RS256", "EdDSA":
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm: %s", algorithm)
+	}
+
+	if privateKeyFile == "" {
+		return nil, fmt.Errorf("a private key file is required for %s", algorithm)
+	}
+	pemData, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{kid: kid}
+	if algorithm == "RS256" {
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, err
+		}
+		key.method = jwt.SigningMethodRS256
+		key.privateKey = privateKey
+		key.publicKey = &privateKey.PublicKey
+	} else {
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, err
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.privateKey = privateKey
+		key.publicKey = privateKey.(ed25519.PrivateKey).Public()
+	}
+
+	// Derive a stable kid from the public key when none is configured
+	if key.kid == "" {
+		key.kid = publicKeyThumbprint(key.publicKey)
+	}
+
+	return key, nil
+}
+
+// currentKey returns the key new tokens are signed with
+func (j *JWTService) currentKey(now time.Time) *signingKey {
+	current := j.keys[0]
+	for _, key := range j.keys[1:] {
+		if key.activeFrom.After(now) {
+			break
+		}
+		current = key
+	}
+	return current
+}
+
+// retiredAt returns when the key at index i was replaced, or the zero time if
+// it has not been replaced yet
+func (j *JWTService) retiredAt(i int, now time.Time) time.Time {
+	if i+1 < len(j.keys) && !j.keys[i+1].activeFrom.After(now) {
+		return j.keys[i+1].activeFrom
 	}
+	return time.Time{}
 }
 
+// verificationKey looks up the key a token claims to be signed with. Tokens
+// without a kid were issued before rotation and are checked against the
+// current key.
+func (j *JWTService) verificationKey(token *jwt.Token) (interface{}, error) {
+	now := time.Now()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		current := j.currentKey(now)
+		if token.Method.Alg() != current.method.Alg() {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return current.publicKey, nil
+	}
+
+	for i, key := range j.keys {
+		if key.kid != kid {
+			continue
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		if key.activeFrom.After(now) {
+			return nil, fmt.Errorf("signing key %s is not active yet", kid)
+		}
+		if retiredAt := j.retiredAt(i, now); !retiredAt.IsZero() && now.After(retiredAt.Add(j.gracePeriod)) {
+			return nil, fmt.Errorf("signing key %s has been retired", kid)
+		}
+		return key.publicKey, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// TokenClaims are the claims the service puts in every token it issues
+type TokenClaims struct {
+	UserID    string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// DeviceHash is set on tokens that are only valid on one device
+	DeviceHash string
+}
+
+// defaultTokenScope is granted to every token issued at login
+const defaultTokenScope = "user"
+
+const tokenLifetime = time.Hour * 24
+
 func (j *JWTService) GenerateToken(userID string) (string, error) {
-	claims := jwt.MapClaims{
+	return j.signToken(jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	}
+		"scope":   defaultTokenScope,
+	})
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.secretKey))
+// GenerateDeviceBoundToken issues a token with the given scope that carries
+// the hash of the device it was issued to
+func (j *JWTService) GenerateDeviceBoundToken(userID, scope, deviceHash string) (string, error) {
+	return j.signToken(jwt.MapClaims{
+		"user_id": userID,
+		"scope":   scope,
+		"dfp":     deviceHash,
+	})
+}
+
+func (j *JWTService) signToken(claims jwt.MapClaims) (string, error) {
+	now := time.Now()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(tokenLifetime).Unix()
+
+	key := j.currentKey(now)
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.kid != "" {
+		token.Header["kid"] = key.kid
+	}
+	return token.SignedString(key.privateKey)
 }
 
 func (j *JWTService) ValidateToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(j.secretKey), nil
-	})
+	token, err := jwt.Parse(tokenString, j.verificationKey)
 
 	if err != nil {
 		return "", err
@@ -43,3 +297,93 @@ func (j *JWTService) ValidateToken(tokenString string) (string, error) {
 
 	return "", jwt.ErrSignatureInvalid
 }
+
+// ParseToken verifies a token and returns its claims
+func (j *JWTService) ParseToken(tokenString string) (*TokenClaims, error) {
+	token, err := jwt.Parse(tokenString, j.verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	userID, _ := claims["user_id"].(string)
+	if userID == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	result := &TokenClaims{UserID: userID}
+	if scope, ok := claims["scope"].(string); ok && scope != "" {
+		result.Scopes = strings.Fields(scope)
+	} else {
+		// Tokens issued before scopes were added carry none, treat them as user tokens
+		result.Scopes = []string{defaultTokenScope}
+	}
+	result.DeviceHash, _ = claims["dfp"].(string)
+	if issuedAt, err := claims.GetIssuedAt(); err == nil && issuedAt != nil {
+		result.IssuedAt = issuedAt.Time
+	}
+	if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+		result.ExpiresAt = expiresAt.Time
+	}
+
+	return result, nil
+}
+
+// JWKS returns the public signing keys. Scheduled keys are published before
+// they become active and retired ones until their grace window closes, so
+// verifiers that cache the document never miss a key. HS256 keys are left out
+// because their shared secret must never be published.
+func (j *JWTService) JWKS() *JWKS {
+	now := time.Now()
+	jwks := &JWKS{Keys: []JWK{}}
+	for i, key := range j.keys {
+		if retiredAt := j.retiredAt(i, now); !retiredAt.IsZero() && now.After(retiredAt.Add(j.gracePeriod)) {
+			continue
+		}
+		if jwk, ok := toJWK(key); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+func toJWK(key *signingKey) (JWK, bool) {
+	switch publicKey := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: key.kid,
+			Use: "sig",
+			Alg: key.method.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: key.kid,
+			Use: "sig",
+			Alg: key.method.Alg(),
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(publicKey),
+		}, true
+	}
+	return JWK{}, false
+}
+
+// publicKeyThumbprint returns a short fingerprint of a public key for use as kid
+func publicKeyThumbprint(publicKey crypto.PublicKey) string {
+	var material []byte
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		material = append(key.N.Bytes(), big.NewInt(int64(key.E)).Bytes()...)
+	case ed25519.PublicKey:
+		material = key
+	}
+	sum := sha256.Sum256(material)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
@@ -0,0 +1,71 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/domain/repositories"
+)
+
+// MissingUserCache remembers in Redis, for CACHE_MISSING_TTL, the usernames
+// and emails FindByUsername and FindByEmail found no user with, so signups
+// retried with them do not each query Postgres. Creating or updating a user
+// forgets its username and email; changes made around the repository, such
+// as attaching a secondary email, have to call RedisService.ForgetMissingUser.
+// A forgotten value records no miss for the TTL, so a lookup racing the
+// change cannot leave a stale one behind.
+type MissingUserCache struct {
+	repositories.UserRepository
+	redisService *RedisService
+}
+
+func NewMissingUserCache(repo repositories.UserRepository, redisService *RedisService) *MissingUserCache {
+	return &MissingUserCache{UserRepository: repo, redisService: redisService}
+}
+
+func (c *MissingUserCache) FindByUsername(username string) (*entities.User, error) {
+	return c.find(MissingUsername, username, c.UserRepository.FindByUsername)
+}
+
+func (c *MissingUserCache) FindByEmail(email string) (*entities.User, error) {
+	return c.find(MissingEmail, email, c.UserRepository.FindByEmail)
+}
+
+func (c *MissingUserCache) Create(user *entities.ValidatedUser) (*entities.User, error) {
+	created, err := c.UserRepository.Create(user)
+	// Forgotten even on failure, which may be the user existing already
+	c.forget(user.GetUser())
+	return created, err
+}
+
+func (c *MissingUserCache) Update(user *entities.ValidatedUser) (*entities.User, error) {
+	updated, err := c.UserRepository.Update(user)
+	c.forget(user.GetUser())
+	return updated, err
+}
+
+// find serves a lookup from the records of misses, or makes it and records
+// a miss. Redis errors only cost the shortcut.
+func (c *MissingUserCache) find(field, value string, lookup func(string) (*entities.User, error)) (*entities.User, error) {
+	ctx := context.Background()
+	if missing, _ := c.redisService.IsUserMissing(ctx, field, value); missing {
+		return nil, nil
+	}
+
+	user, err := lookup(value)
+	if err != nil || user != nil || value == "" {
+		return user, err
+	}
+	if err := c.redisService.SetUserMissing(ctx, field, value); err != nil && !errors.Is(err, ErrDependencyDisabled) {
+		log.Printf("Failed to cache missing user %s: %v", field, err)
+	}
+	return nil, nil
+}
+
+func (c *MissingUserCache) forget(user *entities.User) {
+	if err := c.redisService.ForgetMissingUser(context.Background(), user.Username, user.Email); err != nil {
+		log.Printf("Failed to forget missing user records: %v", err)
+	}
+}
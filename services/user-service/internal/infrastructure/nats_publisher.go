@@ -0,0 +1,418 @@
+package infrastructure
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+const (
+	// natsDialTimeout bounds connecting to NATS and the protocol handshake
+	natsDialTimeout = 5 * time.Second
+	// natsWriteTimeout bounds writing a single message
+	natsWriteTimeout = 5 * time.Second
+	// natsMaxBackoff caps the wait between attempts to publish an event
+	natsMaxBackoff = 5 * time.Second
+	// natsEventBuffer is how many events may wait to be published
+	natsEventBuffer = 1024
+	// cloudEventsSource identifies the service in the events it publishes
+	cloudEventsSource = "/user-service"
+)
+
+// CloudEvent is a domain event in the structured JSON format of CloudEvents 1.0
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	TraceParent     string      `json:"traceparent,omitempty"` // Distributed tracing extension
+	Data            interface{} `json:"data"`                  // A versioned struct, see event_schemas.go
+}
+
+// newCloudEvent wraps event, with its data at the version it is published at
+func newCloudEvent(event entities.UserEvent, version int, traceParent string) (CloudEvent, error) {
+	data, err := EventData(event, version)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.New().String(),
+		Source:          cloudEventsSource,
+		Type:            event.Type,
+		Subject:         event.UserId.String(),
+		Time:            event.OccurredAt.UTC(),
+		DataContentType: "application/json",
+		TraceParent:     traceParent,
+		Data:            data,
+	}, nil
+}
+
+// newTraceParent starts a W3C trace for a published event, as a sampled
+// traceparent header. The service layer does not pass request contexts on to
+// the event bus, so each event is the root of its own trace, which the
+// services consuming it continue.
+func newTraceParent() string {
+	id := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return "00-" + hex.EncodeToString(id[:16]) + "-" + hex.EncodeToString(id[16:]) + "-01"
+}
+
+// natsConnectOptions is the CONNECT message of the NATS client protocol
+type natsConnectOptions struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	Headers   bool   `json:"headers"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// NATSPublisher publishes the domain events raised on the event bus to NATS
+// as CloudEvents. It speaks just enough of the NATS client protocol to
+// publish: it connects, answers the server's pings and reconnects when the
+// connection breaks. Each message carries a traceparent header, when the
+// server supports headers, so consumers can join the event's trace, and the
+// event's ID as Nats-Msg-Id, so a JetStream stream drops the copies retries
+// may send. Delivery is at most once, as with any core NATS
+// publish; an event is retried while NATS is down, as long as the bus keeps
+// it buffered.
+type NATSPublisher struct {
+	address string
+	connect natsConnectOptions
+	prefix  string // MESSAGING_SUBJECT_PREFIX
+	events  *EventSubscription
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex // Guards conn, writer and headers, the reader answers pings too
+	conn    net.Conn
+	writer  *bufio.Writer
+	headers bool // Whether the server accepts HPUB
+}
+
+// NewNATSPublisher prepares a publisher for the server at natsURL,
+// nats://[user:password@ or token@]host[:port]. Start begins publishing.
+func NewNATSPublisher(natsURL string, bus *EventBus) *NATSPublisher {
+	return &NATSPublisher{
+		address: natsAddress(natsURL),
+		connect: newNATSConnectOptions(natsURL),
+		prefix:  natsSubjectPrefix(),
+		events:  bus.Subscribe(natsEventBuffer),
+		done:    make(chan struct{}),
+	}
+}
+
+// newNATSConnectOptions is the CONNECT message for the server at natsURL,
+// with the credentials the URL carries
+func newNATSConnectOptions(natsURL string) natsConnectOptions {
+	connect := natsConnectOptions{Name: "user-service", Lang: "go", Version: "1.0.0", Protocol: 1, Headers: true}
+	if parsed, err := url.Parse(natsURL); err == nil && parsed.User != nil {
+		if password, ok := parsed.User.Password(); ok {
+			connect.User = parsed.User.Username()
+			connect.Pass = password
+		} else {
+			connect.AuthToken = parsed.User.Username()
+		}
+	}
+	return connect
+}
+
+// natsSubjectPrefix is MESSAGING_SUBJECT_PREFIX, the namespace the shared
+// messaging lib puts subjects in so that environments and tenants can share
+// a NATS cluster, as in staging or prod.acme
+func natsSubjectPrefix() string {
+	return GetEnvAsString("MESSAGING_SUBJECT_PREFIX", "")
+}
+
+// natsSubject puts subject in the namespace of prefix
+func natsSubject(prefix, subject string) string {
+	if prefix == "" {
+		return subject
+	}
+	return prefix + "." + subject
+}
+
+// natsAddress turns nats://host:port, or a bare host, into a dialable address
+func natsAddress(natsURL string) string {
+	address := natsURL
+	if parsed, err := url.Parse(natsURL); err == nil && parsed.Host != "" {
+		address = parsed.Host
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "4222")
+	}
+	return address
+}
+
+// Start publishes events until Stop is called
+func (p *NATSPublisher) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop ends publishing and closes the connection. Events still waiting are
+// given one attempt each.
+func (p *NATSPublisher) Stop() {
+	close(p.done)
+	p.wg.Wait()
+	p.events.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeConn()
+}
+
+func (p *NATSPublisher) run() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			p.flush()
+			return
+		case event := <-p.events.Events():
+			if message, ok := p.message(event); ok {
+				p.publishWithRetry(event, message)
+			}
+		}
+	}
+}
+
+// flush tries once to publish the events still waiting when Stop is called.
+// After a failure the rest are dropped, shutdown does not wait on NATS.
+func (p *NATSPublisher) flush() {
+	var failed error
+	dropped := 0
+	for {
+		select {
+		case event := <-p.events.Events():
+			message, ok := p.message(event)
+			if !ok {
+				continue
+			}
+			if failed == nil {
+				failed = p.publish(message)
+			}
+			if failed != nil {
+				dropped++
+			}
+		default:
+			if dropped > 0 {
+				log.Printf("Dropped %d events on shutdown, NATS could not be reached: %v", dropped, failed)
+			}
+			return
+		}
+	}
+}
+
+// natsMessage is a domain event ready to publish
+type natsMessage struct {
+	subject     string
+	id          string // The CloudEvent ID, sent as Nats-Msg-Id
+	traceParent string
+	payload     []byte // The CloudEvent
+}
+
+// message is the NATS message of a domain event, ok is false for events not
+// published to NATS
+func (p *NATSPublisher) message(event entities.UserEvent) (message natsMessage, ok bool) {
+	version, ok := publishedEventVersions[event.Type]
+	if !ok {
+		return natsMessage{}, false
+	}
+	cloudEvent, err := newCloudEvent(event, version, newTraceParent())
+	if err != nil {
+		log.Printf("Error building %s event: %v", event.Type, err)
+		return natsMessage{}, false
+	}
+	message = natsMessage{subject: natsSubject(p.prefix, event.Type), id: cloudEvent.ID, traceParent: cloudEvent.TraceParent}
+	payload, err := json.Marshal(cloudEvent)
+	if err != nil {
+		log.Printf("Error marshaling %s event: %v", event.Type, err)
+		return natsMessage{}, false
+	}
+	message.payload = payload
+	return message, true
+}
+
+// publishWithRetry publishes an event until it goes through or the publisher
+// stops, backing off between attempts
+func (p *NATSPublisher) publishWithRetry(event entities.UserEvent, message natsMessage) {
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := p.publish(message)
+		if err == nil {
+			return
+		}
+		if attempt == 1 {
+			log.Printf("Error publishing %s event for user %s to NATS, retrying: %v", event.Type, event.UserId, err)
+		}
+
+		select {
+		case <-p.done:
+			log.Printf("Dropped %s event for user %s, NATS could not be reached before shutdown", event.Type, event.UserId)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, natsMaxBackoff)
+	}
+}
+
+// publish sends a message, connecting first when needed
+func (p *NATSPublisher) publish(message natsMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.dial(); err != nil {
+			return err
+		}
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	if p.headers {
+		header := "NATS/1.0\r\nNats-Msg-Id: " + message.id + "\r\ntraceparent: " + message.traceParent + "\r\n\r\n"
+		fmt.Fprintf(p.writer, "HPUB %s %d %d\r\n%s", message.subject, len(header), len(header)+len(message.payload), header)
+	} else {
+		fmt.Fprintf(p.writer, "PUB %s %d\r\n", message.subject, len(message.payload))
+	}
+	p.writer.Write(message.payload)
+	p.writer.WriteString("\r\n")
+	if err := p.writer.Flush(); err != nil {
+		p.closeConn()
+		return err
+	}
+	return nil
+}
+
+// dial connects, p.mu is held
+func (p *NATSPublisher) dial() error {
+	conn, reader, writer, headers, err := dialNATS(p.address, p.connect)
+	if err != nil {
+		return err
+	}
+
+	p.conn = conn
+	p.writer = writer
+	p.headers = headers
+	go p.read(conn, reader)
+	log.Printf("Connected to NATS at %s", p.address)
+	return nil
+}
+
+// read answers the server's pings on conn and drops the connection when it
+// breaks or the server reports an error, so the next publish reconnects
+func (p *NATSPublisher) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			p.mu.Lock()
+			if p.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+				p.writer.WriteString("PONG\r\n")
+				p.writer.Flush()
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("NATS reported an error: %s", line)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == conn {
+		p.closeConn()
+	}
+}
+
+// closeConn drops the connection, p.mu is held
+func (p *NATSPublisher) closeConn() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+		p.writer = nil
+	}
+}
+
+// dialNATS connects to address and completes the handshake: the server's
+// INFO, our CONNECT, and a PING whose PONG confirms the server accepted it.
+// headers tells whether the server supports message headers.
+func dialNATS(address string, connect natsConnectOptions) (conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, headers bool, err error) {
+	dialed, err := net.DialTimeout("tcp", address, natsDialTimeout)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	defer func() {
+		if err != nil {
+			dialed.Close()
+		}
+	}()
+	conn = dialed
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	reader = bufio.NewReader(conn)
+	writer = bufio.NewWriter(conn)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return nil, nil, nil, false, fmt.Errorf("unexpected greeting from NATS: %.40q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+		Headers     bool `json:"headers"`
+	}
+	json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "INFO "))), &info)
+	if info.TLSRequired {
+		return nil, nil, nil, false, fmt.Errorf("NATS server requires TLS, which is not supported")
+	}
+
+	connectJSON, _ := json.Marshal(connect)
+	fmt.Fprintf(writer, "CONNECT %s\r\nPING\r\n", connectJSON)
+	if err := writer.Flush(); err != nil {
+		return nil, nil, nil, false, err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return nil, nil, nil, false, fmt.Errorf("NATS refused the connection: %s", line)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, reader, writer, info.Headers, nil
+}
@@ -0,0 +1,64 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/resend/resend-go/v2"
+)
+
+// OTP delivery channels. Registration OTPs only go by email, as they verify it.
+const (
+	OTPChannelEmail = "email"
+	OTPChannelSMS   = "sms"
+)
+
+// OTPChannel delivers a one-time password to a recipient over one medium
+type OTPChannel interface {
+	Name() string
+	Send(ctx context.Context, recipient string, otp string) error
+}
+
+// emailChannel delivers OTPs through Resend
+type emailChannel struct {
+	sender string
+	client *resend.Client
+}
+
+func newEmailChannel(apiKey, sender string) *emailChannel {
+	return &emailChannel{
+		sender: sender,
+		client: resend.NewClient(apiKey),
+	}
+}
+
+func (c *emailChannel) Name() string {
+	return OTPChannelEmail
+}
+
+func (c *emailChannel) Send(ctx context.Context, recipientEmail string, otp string) error {
+	log.Printf("Sending OTP to: %s", recipientEmail)
+
+	subject, text := otpEmail(otp)
+	params := &resend.SendEmailRequest{
+		From:    c.sender,
+		To:      []string{recipientEmail},
+		Subject: subject,
+		Text:    text,
+	}
+
+	response, err := c.client.Emails.Send(params)
+	if err != nil {
+		log.Printf("Resend error: %+v", err)
+		return err
+	}
+
+	log.Printf("Email sent successfully. ID: %s", response.Id)
+	return nil
+}
+
+// otpEmail is the subject and text of the email carrying otp
+func otpEmail(otp string) (subject, text string) {
+	return "Your OTP Code", fmt.Sprintf("Your OTP code is: %s", otp)
+}
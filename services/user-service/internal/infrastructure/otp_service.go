@@ -10,21 +10,27 @@ import (
 	"os"
 	"time"
 
-	"github.com/resend/resend-go/v2"
+	"user-service-new/internal/domain/entities"
 )
 
 type OTPService struct {
-	EMAIL_API_KEY string
-	EMAIL_SENDER  string
-	OTP_EXPIRY    time.Duration
-	OTP_LENGTH    int
-	client        *resend.Client
+	EMAIL_API_KEY       string
+	EMAIL_SENDER        string
+	OTP_EXPIRY          time.Duration
+	OTP_LENGTH          int
+	OTP_RESEND_COOLDOWN time.Duration
+	OTP_MAX_RESENDS     int
+	channels            map[string]OTPChannel
+	channelLimiters     map[string]*RateLimiter
+	emailQueue          *EmailQueue
 }
 
 func NewOTPService() *OTPService {
 	// Get OTP configuration from environment variables
 	otpExpiry := GetEnvAsDuration("OTP_EXPIRY", 5*time.Minute)
 	otpLength := GetEnvAsInt("OTP_LENGTH", 6)
+	resendCooldown := GetEnvAsDuration("OTP_RESEND_COOLDOWN", time.Minute)
+	maxResends := GetEnvAsInt("OTP_MAX_RESENDS", 3)
 	apiKey := os.Getenv("EMAIL_API_KEY")
 	emailSender := os.Getenv("EMAIL_SENDER")
 
@@ -33,41 +39,103 @@ func NewOTPService() *OTPService {
 	if len(apiKey) > 8 {
 		maskedApiKey = apiKey[:4] + "****" + apiKey[len(apiKey)-4:]
 	}
-	log.Printf("OTP Service Config - API Key: %s, Sender: %s, Expiry: %v, Length: %d", 
-		maskedApiKey, emailSender, otpExpiry, otpLength)
-
-	// Initialize Resend client
-	client := resend.NewClient(apiKey)
-
-	return &OTPService{
-		EMAIL_API_KEY: apiKey,
-		EMAIL_SENDER:  emailSender,
-		OTP_EXPIRY:    otpExpiry,
-		OTP_LENGTH:    otpLength,
-		client:        client,
+	log.Printf("OTP Service Config - API Key: %s, Sender: %s, Expiry: %v, Length: %d, Resend Cooldown: %v, Max Resends: %d", 
+		maskedApiKey, emailSender, otpExpiry, otpLength, resendCooldown, maxResends)
+
+	service := &OTPService{
+		EMAIL_API_KEY:       apiKey,
+		EMAIL_SENDER:        emailSender,
+		OTP_EXPIRY:          otpExpiry,
+		OTP_LENGTH:          otpLength,
+		OTP_RESEND_COOLDOWN: resendCooldown,
+		OTP_MAX_RESENDS:     maxResends,
+		channels:            make(map[string]OTPChannel),
+		channelLimiters:     make(map[string]*RateLimiter),
+	}
+
+	// Email is always available, SMS only when Twilio credentials are set.
+	// Each channel gets its own limiter since SMS costs money per message.
+	service.registerChannel(newEmailChannel(apiKey, emailSender),
+		GetEnvAsDuration("OTP_EMAIL_RATE_LIMIT_WINDOW", 15*time.Minute),
+		GetEnvAsInt("OTP_EMAIL_RATE_LIMIT_MAX_REQUESTS", 5))
+
+	if smsChannel := newTwilioSMSChannel(); smsChannel != nil {
+		service.registerChannel(smsChannel,
+			GetEnvAsDuration("OTP_SMS_RATE_LIMIT_WINDOW", time.Hour),
+			GetEnvAsInt("OTP_SMS_RATE_LIMIT_MAX_REQUESTS", 3))
+		log.Printf("OTP Service Config - SMS channel enabled via Twilio")
+	}
+
+	return service
+}
+
+func (o *OTPService) registerChannel(channel OTPChannel, window time.Duration, limit int) {
+	o.channels[channel.Name()] = channel
+	o.channelLimiters[channel.Name()] = newRateLimiter(window, limit)
+}
+
+// EnableRedisRateLimits shares the channels' rate limits with every instance
+// through store, see RateLimiter.EnableRedis
+func (o *OTPService) EnableRedisRateLimits(store *RedisService) {
+	for name, limiter := range o.channelLimiters {
+		limiter.EnableRedis(store, "otp_"+name)
 	}
 }
 
+// EnableEmailQueue has OTP emails queued for queue's workers to send, rather
+// than sent while the request waits
+func (o *OTPService) EnableEmailQueue(queue *EmailQueue) {
+	o.emailQueue = queue
+}
+
+// AllowChannel applies the channel's own rate limit for a recipient
+func (o *OTPService) AllowChannel(channel, recipient string) bool {
+	limiter, ok := o.channelLimiters[channel]
+	if !ok {
+		return false
+	}
+	return limiter.Allow(channel + ":" + recipient)
+}
+
+// ChannelLimitExceeded returns the rate_limited error for a recipient that
+// ran out of the channel's quota
+func (o *OTPService) ChannelLimitExceeded(channel, recipient, message string) error {
+	limiter, ok := o.channelLimiters[channel]
+	if !ok {
+		return entities.ErrRateLimited.Errorf("%s", message)
+	}
+	return limiter.Exceeded(channel+":"+recipient, message)
+}
+
+// SendOTP delivers an OTP by email
 func (o *OTPService) SendOTP(ctx context.Context, recipientEmail string, otp string) error {
-    log.Printf("Sending OTP to: %s", recipientEmail)
-    
-    params := &resend.SendEmailRequest{
-        From:    o.EMAIL_SENDER, // Use the working sender
-        To:      []string{recipientEmail},
-        Subject: "Your OTP Code",
-        Text:    fmt.Sprintf("Your OTP code is: %s", otp),
-    }
-
-    response, err := o.client.Emails.Send(params) // Try without context first
-    if err != nil {
-        log.Printf("Resend error: %+v", err)
-        return err
-    }
-
-    log.Printf("Email sent successfully. ID: %s", response.Id)
-    return nil
+	return o.SendOTPVia(ctx, OTPChannelEmail, recipientEmail, otp)
 }
 
+// SendOTPVia delivers an OTP over the named channel. Emails are only queued
+// when the email queue is enabled; they are sent directly when queueing fails.
+func (o *OTPService) SendOTPVia(ctx context.Context, channel, recipient string, otp string) error {
+	otpChannel, ok := o.channels[channel]
+	if !ok {
+		return fmt.Errorf("unsupported OTP channel: %s", channel)
+	}
+	if channel == OTPChannelEmail && o.emailQueue != nil {
+		subject, text := otpEmail(otp)
+		err := o.emailQueue.Enqueue(ctx, &EmailJob{
+			To:        recipient,
+			Subject:   subject,
+			Text:      text,
+			ExpiresAt: time.Now().Add(o.OTP_EXPIRY),
+		})
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrDependencyDisabled) {
+			log.Printf("Failed to queue OTP email, sending it directly: %v", err)
+		}
+	}
+	return otpChannel.Send(ctx, recipient, otp)
+}
 
 func (o *OTPService) GenerateOTP(ctx context.Context) string {
 	// Generate OTP using configured length
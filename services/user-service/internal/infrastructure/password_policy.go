@@ -0,0 +1,77 @@
+package infrastructure
+
+import (
+	"bufio"
+	"log"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+	"user-service-new/internal/domain/entities"
+)
+
+// NewPasswordPolicy builds the registration password policy from environment
+// variables. PASSWORD_DENYLIST_FILE may point to a newline separated list of
+// extra passwords to reject on top of the built-in common ones.
+func NewPasswordPolicy() *entities.PasswordPolicy {
+	minLength := GetEnvAsInt("PASSWORD_MIN_LENGTH", 8)
+	requireUpper := GetEnvAsBool("PASSWORD_REQUIRE_UPPER", false)
+	requireLower := GetEnvAsBool("PASSWORD_REQUIRE_LOWER", true)
+	requireDigit := GetEnvAsBool("PASSWORD_REQUIRE_DIGIT", true)
+	requireSymbol := GetEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false)
+
+	var denylist []string
+	if path := os.Getenv("PASSWORD_DENYLIST_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("Failed to open password denylist %s: %v", path, err)
+		} else {
+			defer file.Close()
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				denylist = append(denylist, scanner.Text())
+			}
+			if err := scanner.Err(); err != nil {
+				log.Printf("Failed to read password denylist %s: %v", path, err)
+			}
+		}
+	}
+
+	log.Printf("Password Policy Config - Min Length: %d, Upper: %v, Lower: %v, Digit: %v, Symbol: %v, Extra Denylist Entries: %d",
+		minLength, requireUpper, requireLower, requireDigit, requireSymbol, len(denylist))
+
+	return entities.NewPasswordPolicy(minLength, requireUpper, requireLower, requireDigit, requireSymbol, denylist)
+}
+
+// NewPasswordHasher builds the hasher new passwords are stored with.
+// PASSWORD_HASH_ALGORITHM selects argon2id (default) or bcrypt; existing
+// hashes in the other format are migrated as users log in.
+func NewPasswordHasher() entities.PasswordHasher {
+	algorithm := GetEnvAsString("PASSWORD_HASH_ALGORITHM", "argon2id")
+
+	switch algorithm {
+	case "bcrypt":
+		cost := GetEnvAsInt("BCRYPT_COST", bcrypt.DefaultCost)
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			log.Printf("BCRYPT_COST %d is outside %d-%d, using %d", cost, bcrypt.MinCost, bcrypt.MaxCost, bcrypt.DefaultCost)
+			cost = bcrypt.DefaultCost
+		}
+		log.Printf("Password Hasher Config - Algorithm: bcrypt, Cost: %d", cost)
+		return entities.NewBcryptHasher(cost)
+	case "argon2id":
+	default:
+		log.Printf("Unknown PASSWORD_HASH_ALGORITHM %q, using argon2id", algorithm)
+	}
+
+	memory := GetEnvAsInt("ARGON2_MEMORY_KIB", 64*1024)
+	iterations := GetEnvAsInt("ARGON2_ITERATIONS", 3)
+	parallelism := GetEnvAsInt("ARGON2_PARALLELISM", 2)
+	if memory < 8*1024 || iterations < 1 || parallelism < 1 || parallelism > 255 {
+		log.Printf("Invalid ARGON2_* settings (memory %d KiB, iterations %d, parallelism %d), using defaults", memory, iterations, parallelism)
+		memory, iterations, parallelism = 64*1024, 3, 2
+	}
+
+	log.Printf("Password Hasher Config - Algorithm: argon2id, Memory: %d KiB, Iterations: %d, Parallelism: %d",
+		memory, iterations, parallelism)
+
+	return entities.NewArgon2idHasher(uint32(memory), uint32(iterations), uint8(parallelism))
+}
@@ -1,15 +1,32 @@
 package infrastructure
 
 import (
+	"context"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"user-service-new/internal/domain/entities"
 )
 
+// rateLimitRedisTimeout bounds a rate limit check against Redis, after
+// which the request is checked against this instance's own counts
+const rateLimitRedisTimeout = 500 * time.Millisecond
+
+// RateLimiter allows a number of requests per key within a sliding window.
+// Requests are counted in memory, per instance, unless EnableRedis shares
+// the counts with every instance; while Redis cannot be reached they are
+// counted in memory again.
 type RateLimiter struct {
 	requests map[string][]time.Time
 	window   time.Duration
 	limit    int
 	mutex    sync.RWMutex
+
+	store     *RedisService // Shares the counts when set, see EnableRedis
+	name      string        // Keeps the keys of this limiter apart in Redis
+	redisDown atomic.Bool   // Whether the last check fell back to memory
 }
 
 func NewRateLimiter(window time.Duration, limit int) *RateLimiter {
@@ -17,10 +34,16 @@ func NewRateLimiter(window time.Duration, limit int) *RateLimiter {
 	rateLimitWindow := GetEnvAsDuration("RATE_LIMIT_WINDOW", window)
 	rateLimitMaxRequests := GetEnvAsInt("RATE_LIMIT_MAX_REQUESTS", limit)
 
+	return newRateLimiter(rateLimitWindow, rateLimitMaxRequests)
+}
+
+// newRateLimiter builds a limiter with exactly the given settings, for callers
+// that read their own configuration instead of the RATE_LIMIT_* variables
+func newRateLimiter(window time.Duration, limit int) *RateLimiter {
 	rl := &RateLimiter{
 		requests: make(map[string][]time.Time),
-		window:   rateLimitWindow,
-		limit:    rateLimitMaxRequests,
+		window:   window,
+		limit:    limit,
 	}
 
 	// Start cleanup goroutine
@@ -28,7 +51,23 @@ func NewRateLimiter(window time.Duration, limit int) *RateLimiter {
 	return rl
 }
 
+// EnableRedis counts requests in Redis, under rate_limit:<name>:, so the
+// limit applies to the whole fleet rather than to each instance
+func (rl *RateLimiter) EnableRedis(store *RedisService, name string) {
+	rl.store = store
+	rl.name = name
+}
+
 func (rl *RateLimiter) Allow(key string) bool {
+	if rl.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), rateLimitRedisTimeout)
+		defer cancel()
+		allowed, err := rl.store.AllowRequest(ctx, rl.redisKey(key), rl.window, rl.limit)
+		if rl.redisAvailable(err) {
+			return allowed
+		}
+	}
+
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
 
@@ -62,6 +101,79 @@ func (rl *RateLimiter) Allow(key string) bool {
 	return false
 }
 
+// Remaining returns how many more requests key may make in the current window
+func (rl *RateLimiter) Remaining(key string) int {
+	return rl.limit - len(rl.counted(key, time.Now()))
+}
+
+// GetTimeToReset returns how long until key may make another request, zero
+// when it may right away
+func (rl *RateLimiter) GetTimeToReset(key string) time.Duration {
+	now := time.Now()
+	requests := rl.counted(key, now)
+	if len(requests) < rl.limit {
+		return 0
+	}
+	// A slot frees up once the oldest request counted against the limit leaves the window
+	return requests[len(requests)-rl.limit].Add(rl.window).Sub(now)
+}
+
+// Exceeded returns the rate_limited error for key, with message and the
+// quota key has used up
+func (rl *RateLimiter) Exceeded(key, message string) error {
+	return entities.NewRateLimitError(message, entities.RateLimit{
+		Limit:      rl.limit,
+		Remaining:  max(rl.Remaining(key), 0),
+		ResetAfter: rl.GetTimeToReset(key),
+	})
+}
+
+// counted returns the requests of key counted within the window before now,
+// oldest first, from Redis when it is enabled and reachable
+func (rl *RateLimiter) counted(key string, now time.Time) []time.Time {
+	if rl.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), rateLimitRedisTimeout)
+		defer cancel()
+		requests, err := rl.store.RequestsInWindow(ctx, rl.redisKey(key), rl.window)
+		if rl.redisAvailable(err) {
+			return requests
+		}
+	}
+
+	rl.mutex.RLock()
+	defer rl.mutex.RUnlock()
+	return rl.inWindow(key, now)
+}
+
+func (rl *RateLimiter) redisKey(key string) string {
+	return "rate_limit:" + rl.name + ":" + key
+}
+
+// redisAvailable reports whether a Redis check succeeded, logging when the
+// limiter falls back to memory and when it is back on Redis
+func (rl *RateLimiter) redisAvailable(err error) bool {
+	if err != nil {
+		if !rl.redisDown.Swap(true) && err != ErrDependencyDisabled {
+			log.Printf("Rate limiter %s falls back to per-instance limits, Redis failed: %v", rl.name, err)
+		}
+		return false
+	}
+	if rl.redisDown.Swap(false) {
+		log.Printf("Rate limiter %s is back on Redis", rl.name)
+	}
+	return true
+}
+
+// inWindow returns the requests of key made within the window before now
+func (rl *RateLimiter) inWindow(key string, now time.Time) []time.Time {
+	windowStart := now.Add(-rl.window)
+	requests := rl.requests[key]
+	for len(requests) > 0 && !requests[0].After(windowStart) {
+		requests = requests[1:]
+	}
+	return requests
+}
+
 func (rl *RateLimiter) cleanupStaleEntries() {
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
@@ -0,0 +1,94 @@
+package infrastructure
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisBatch queues writes and sends them to Redis in a single round trip,
+// for requests making several, such as a login that also drops a challenge
+// or a cached profile. On a cluster the writes are split by slot; they are
+// not atomic either way. With Redis disabled the writes are dropped.
+type RedisBatch struct {
+	r    *RedisService
+	pipe redis.Pipeliner
+	// namespaces has the cache namespace of each queued write, empty for
+	// writes not counted in the cache metrics
+	namespaces []string
+}
+
+// Batch starts a batch of writes, sent by Exec
+func (r *RedisService) Batch() *RedisBatch {
+	batch := &RedisBatch{r: r}
+	if r.client != nil {
+		batch.pipe = r.client.Pipeline()
+	}
+	return batch
+}
+
+func (b *RedisBatch) SetToken(ctx context.Context, token, userID string, ttl time.Duration) {
+	if b.pipe == nil {
+		return
+	}
+	b.pipe.Set(ctx, b.r.key(b.r.cache.Token.Key(token)), userID, ttl)
+	b.namespaces = append(b.namespaces, CacheToken)
+}
+
+func (b *RedisBatch) DeleteOTP(ctx context.Context, email string) {
+	b.DeleteKey(ctx, b.r.cache.OTP.Key(email))
+}
+
+func (b *RedisBatch) DeleteUserData(ctx context.Context, email string) {
+	b.DeleteKey(ctx, b.r.cache.UserData.Key(email))
+}
+
+func (b *RedisBatch) DeleteProfile(ctx context.Context, userID string) {
+	b.DeleteKey(ctx, b.r.cache.Profile.Key(userID))
+}
+
+// ForgetMissingUser queues RedisService.ForgetMissingUser
+func (b *RedisBatch) ForgetMissingUser(ctx context.Context, username, email string) {
+	if username != "" {
+		b.forgetMissingUser(ctx, MissingUsername, username)
+	}
+	if email != "" {
+		b.forgetMissingUser(ctx, MissingEmail, email)
+	}
+}
+
+func (b *RedisBatch) forgetMissingUser(ctx context.Context, field, value string) {
+	if b.pipe == nil {
+		return
+	}
+	b.pipe.Set(ctx, b.r.key(b.r.missingUserKey(field, value)), missingUserForgotten, b.r.cache.Missing.TTL)
+	b.namespaces = append(b.namespaces, CacheMissing)
+}
+
+func (b *RedisBatch) DeleteKey(ctx context.Context, key string) {
+	if b.pipe == nil {
+		return
+	}
+	b.pipe.Del(ctx, b.r.key(key))
+	b.namespaces = append(b.namespaces, "")
+}
+
+// Exec sends the queued writes and returns the first error. Each write
+// counted in the cache metrics is counted with the latency of the batch.
+func (b *RedisBatch) Exec(ctx context.Context) error {
+	if b.pipe == nil || len(b.namespaces) == 0 {
+		return nil
+	}
+	start := time.Now()
+	cmds, err := b.pipe.Exec(ctx)
+	elapsed := time.Since(start)
+
+	for i, cmd := range cmds {
+		if i < len(b.namespaces) && b.namespaces[i] != "" {
+			b.r.metrics.observeWrite(b.namespaces[i], elapsed, cmd.Err())
+		}
+	}
+	b.namespaces = nil
+	return err
+}
@@ -0,0 +1,149 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisBreaker is a go-redis hook that bounds every command, retries
+// included, by a timeout and runs it through a circuit breaker. While Redis
+// is slow or unreachable the circuit opens and commands fail right away with
+// ErrCircuitOpen, so callers fall back to Postgres or memory instead of
+// waiting on Redis.
+type redisBreaker struct {
+	breaker  *CircuitBreaker
+	timeout  time.Duration            // REDIS_OPERATION_TIMEOUT
+	timeouts map[string]time.Duration // By command, REDIS_OPERATION_TIMEOUTS
+}
+
+type redisCancelKey struct{}
+
+func newRedisBreaker() *redisBreaker {
+	b := &redisBreaker{
+		breaker: NewCircuitBreaker(
+			GetEnvAsInt("REDIS_BREAKER_MAX_FAILURES", 5),
+			GetEnvAsDuration("REDIS_BREAKER_COOLDOWN", 10*time.Second),
+		),
+		timeout:  GetEnvAsDuration("REDIS_OPERATION_TIMEOUT", 250*time.Millisecond),
+		timeouts: make(map[string]time.Duration),
+	}
+	// Commands taking longer than the others, command=duration
+	for _, entry := range GetEnvAsList("REDIS_OPERATION_TIMEOUTS") {
+		name, value, _ := strings.Cut(entry, "=")
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			log.Printf("Ignoring Redis operation timeout %q: %v", entry, err)
+			continue
+		}
+		b.timeouts[strings.ToLower(strings.TrimSpace(name))] = timeout
+	}
+	b.breaker.OnStateChange(func(from, to string) {
+		log.Printf("Redis circuit breaker went from %s to %s", from, to)
+	})
+
+	log.Printf("Redis Breaker Config - Operation Timeout: %v, Overrides: %v", b.timeout, b.timeouts)
+	return b
+}
+
+// operationTimeout is how long the commands named may take together
+func (b *redisBreaker) operationTimeout(names ...string) time.Duration {
+	timeout := b.timeout
+	for _, name := range names {
+		if override, ok := b.timeouts[name]; ok && override > timeout {
+			timeout = override
+		}
+	}
+	return timeout
+}
+
+func (b *redisBreaker) before(ctx context.Context, timeout time.Duration) (context.Context, error) {
+	if !b.breaker.allow() {
+		return ctx, ErrCircuitOpen
+	}
+	if timeout <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return context.WithValue(ctx, redisCancelKey{}, cancel), nil
+}
+
+func (b *redisBreaker) after(ctx context.Context, err error) {
+	if errors.Is(err, ErrCircuitOpen) {
+		return // Rejected by before, never sent
+	}
+	if cancel, ok := ctx.Value(redisCancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+	if !redisFailure(err) {
+		err = nil
+	}
+	b.breaker.record(err)
+}
+
+func (b *redisBreaker) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return b.before(ctx, b.operationTimeout(cmd.Name())+blockDuration(cmd))
+}
+
+func (b *redisBreaker) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	b.after(ctx, cmd.Err())
+	return nil
+}
+
+func (b *redisBreaker) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+	return b.before(ctx, b.operationTimeout(names...))
+}
+
+func (b *redisBreaker) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && (err == nil || redisFailure(cmdErr)) {
+			err = cmdErr
+		}
+	}
+	b.after(ctx, err)
+	return nil
+}
+
+// blockDuration is how long a blocking stream read may wait for entries, on
+// top of the time the command takes
+func blockDuration(cmd redis.Cmder) time.Duration {
+	first := 1
+	switch cmd.Name() {
+	case "xread":
+	case "xreadgroup":
+		first = 4 // After the group and consumer names
+	default:
+		return 0
+	}
+	args := cmd.Args()
+	for i := first; i+1 < len(args); i++ {
+		switch args[i] {
+		case "streams":
+			return 0 // Stream names follow, one might be called block
+		case "block":
+			if ms, ok := args[i+1].(int64); ok {
+				return time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return 0
+}
+
+// redisFailure reports whether err means Redis failed to answer, as opposed
+// to answering with a missing key or an error reply
+func redisFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var reply redis.Error
+	return !errors.As(err, &reply)
+}
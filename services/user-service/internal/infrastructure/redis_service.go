@@ -2,20 +2,73 @@ package infrastructure
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"user-service-new/internal/domain/entities"
 )
 
+// RedisService stores the service's shared state in Redis. REDIS_MODE picks
+// a single node (the default), a cluster or a master watched by Sentinel.
+// Commands are retried with backoff, REDIS_MAX_RETRIES times, on network
+// errors and while a failover is under way, and cluster clients follow
+// slots as they move. Every command touches one key, or keys of one slot,
+// so none fails with CROSSSLOT on a cluster. Every command is bounded by a
+// timeout and goes through a circuit breaker, see redisBreaker. Every key
+// starts with the CACHE_KEY_PREFIX of CacheConfig.
 type RedisService struct {
-	client *redis.Client
+	client  redis.UniversalClient
+	breaker *redisBreaker
+	metrics *cacheMetrics
+	cache   CacheConfig
+	health  *redisHealth // Set by StartHealthCheck
+}
+
+// redisHealth is the outcome of the periodic PING of StartHealthCheck
+type redisHealth struct {
+	mu    sync.Mutex
+	ready bool
+	err   error
+
+	stop chan struct{}
+	wg   sync.WaitGroup
 }
 
 func NewRedisService() *RedisService {
+	service := connectRedisFromEnv()
+	service.cache = LoadCacheConfig()
+	return service
+}
+
+// connectRedisFromEnv connects to Redis as REDIS_MODE says
+func connectRedisFromEnv() *RedisService {
+	conn, err := loadRedisConnOptions()
+	if err != nil {
+		fmt.Printf("Warning: invalid Redis connection settings: %v\n", err)
+		fmt.Printf("Redis will be disabled. Some features may not work properly.\n")
+		return &RedisService{client: nil}
+	}
+
+	switch mode := strings.ToLower(GetEnvAsString("REDIS_MODE", "single")); mode {
+	case "cluster":
+		return newRedisCluster(conn)
+	case "sentinel":
+		return newRedisSentinel(conn)
+	case "single":
+	default:
+		fmt.Printf("Warning: unknown REDIS_MODE %q, connecting to a single Redis node\n", mode)
+	}
+
 	// Get Redis configuration from environment variables
 	host := os.Getenv("REDIS_HOST")
 	if host == "" {
@@ -33,12 +86,15 @@ func NewRedisService() *RedisService {
 	}
 
 	db := GetEnvAsInt("REDIS_DB", 0)
+	maxRetries, minRetryBackoff, maxRetryBackoff := redisRetries()
 
 	// Alternative: Use REDIS_URL if provided
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL != "" {
 		opt, err := redis.ParseURL(redisURL)
 		if err == nil {
+			opt.MaxRetries, opt.MinRetryBackoff, opt.MaxRetryBackoff = maxRetries, minRetryBackoff, maxRetryBackoff
+			conn.apply(opt)
 			client := redis.NewClient(opt)
 			// Test connection
 			ctx := context.Background()
@@ -46,63 +102,317 @@ func NewRedisService() *RedisService {
 				fmt.Printf("Warning: Redis connection failed with REDIS_URL: %v\n", err)
 			} else {
 				fmt.Printf("Connected to Redis using REDIS_URL: %s\n", redisURL)
-				return &RedisService{client: client}
+				return newConnectedRedis(client)
 			}
 		}
 	}
 
 	// Use individual environment variables
-	client := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", host, port),
-		Password: password,
-		DB:       db,
+	opt := &redis.Options{
+		Addr:            fmt.Sprintf("%s:%s", host, port),
+		Password:        password,
+		DB:              db,
+		MaxRetries:      maxRetries,
+		MinRetryBackoff: minRetryBackoff,
+		MaxRetryBackoff: maxRetryBackoff,
+	}
+	conn.apply(opt)
+	client := redis.NewClient(opt)
+	return connectRedis(client, fmt.Sprintf("Redis at %s:%s", host, port))
+}
+
+// newRedisCluster connects to the cluster whose nodes REDIS_ADDRS lists,
+// any of them is enough to discover the others
+func newRedisCluster(conn redisConnOptions) *RedisService {
+	addrs := redisAddrs("REDIS_ADDRS")
+	maxRetries, minRetryBackoff, maxRetryBackoff := redisRetries()
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:           addrs,
+		Username:        conn.username,
+		Password:        os.Getenv("REDIS_PASSWORD"),
+		MaxRedirects:    GetEnvAsInt("REDIS_MAX_REDIRECTS", 3),
+		MaxRetries:      maxRetries,
+		MinRetryBackoff: minRetryBackoff,
+		MaxRetryBackoff: maxRetryBackoff,
+		DialTimeout:     conn.dialTimeout,
+		ReadTimeout:     conn.readTimeout,
+		WriteTimeout:    conn.writeTimeout,
+		PoolSize:        conn.poolSize,
+		MinIdleConns:    conn.minIdleConns,
+		MaxConnAge:      conn.maxConnAge,
+		PoolTimeout:     conn.poolTimeout,
+		IdleTimeout:     conn.idleTimeout,
+		TLSConfig:       conn.tls,
 	})
+	return connectRedis(client, fmt.Sprintf("Redis cluster at %s", strings.Join(addrs, ",")))
+}
+
+// newRedisSentinel connects to the master REDIS_MASTER_NAME, as the
+// sentinels REDIS_SENTINEL_ADDRS lists report it, and follows it across
+// failovers
+func newRedisSentinel(conn redisConnOptions) *RedisService {
+	masterName := os.Getenv("REDIS_MASTER_NAME")
+	if masterName == "" {
+		fmt.Printf("Warning: REDIS_MODE=sentinel needs REDIS_MASTER_NAME\n")
+		fmt.Printf("Redis will be disabled. Some features may not work properly.\n")
+		return &RedisService{client: nil}
+	}
+
+	addrs := redisAddrs("REDIS_SENTINEL_ADDRS")
+	maxRetries, minRetryBackoff, maxRetryBackoff := redisRetries()
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:       masterName,
+		SentinelAddrs:    addrs,
+		SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		Username:         conn.username,
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		DB:               GetEnvAsInt("REDIS_DB", 0),
+		MaxRetries:       maxRetries,
+		MinRetryBackoff:  minRetryBackoff,
+		MaxRetryBackoff:  maxRetryBackoff,
+		DialTimeout:      conn.dialTimeout,
+		ReadTimeout:      conn.readTimeout,
+		WriteTimeout:     conn.writeTimeout,
+		PoolSize:         conn.poolSize,
+		MinIdleConns:     conn.minIdleConns,
+		MaxConnAge:       conn.maxConnAge,
+		PoolTimeout:      conn.poolTimeout,
+		IdleTimeout:      conn.idleTimeout,
+		TLSConfig:        conn.tls,
+	})
+	return connectRedis(client, fmt.Sprintf("Redis master %s through sentinels at %s", masterName, strings.Join(addrs, ",")))
+}
+
+// redisConnOptions are the connection settings shared by every REDIS_MODE.
+// Zero values leave go-redis' defaults.
+type redisConnOptions struct {
+	username     string // ACL user, REDIS_USERNAME
+	tls          *tls.Config
+	poolSize     int
+	minIdleConns int
+	dialTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	poolTimeout  time.Duration
+	idleTimeout  time.Duration
+	maxConnAge   time.Duration
+}
+
+func loadRedisConnOptions() (redisConnOptions, error) {
+	tlsConfig, err := NewRedisTLSConfig()
+	if err != nil {
+		return redisConnOptions{}, err
+	}
+	conn := redisConnOptions{
+		username:     os.Getenv("REDIS_USERNAME"),
+		tls:          tlsConfig,
+		poolSize:     GetEnvAsInt("REDIS_POOL_SIZE", 0),
+		minIdleConns: GetEnvAsInt("REDIS_MIN_IDLE_CONNS", 0),
+		dialTimeout:  GetEnvAsDuration("REDIS_DIAL_TIMEOUT", 0),
+		readTimeout:  GetEnvAsDuration("REDIS_READ_TIMEOUT", 0),
+		writeTimeout: GetEnvAsDuration("REDIS_WRITE_TIMEOUT", 0),
+		poolTimeout:  GetEnvAsDuration("REDIS_POOL_TIMEOUT", 0),
+		idleTimeout:  GetEnvAsDuration("REDIS_IDLE_TIMEOUT", 0),
+		maxConnAge:   GetEnvAsDuration("REDIS_MAX_CONN_AGE", 0),
+	}
+
+	log.Printf("Redis Connection Config - Username: %s, TLS: %v, Pool Size: %d, Min Idle Conns: %d, Dial Timeout: %v, Read Timeout: %v, Write Timeout: %v, Pool Timeout: %v, Idle Timeout: %v, Max Conn Age: %v",
+		valueOrNone(conn.username), conn.tls != nil, conn.poolSize, conn.minIdleConns, conn.dialTimeout, conn.readTimeout, conn.writeTimeout, conn.poolTimeout, conn.idleTimeout, conn.maxConnAge)
+	return conn, nil
+}
+
+// apply sets the settings on the options of a single node, keeping those
+// REDIS_URL set unless overridden
+func (c redisConnOptions) apply(opt *redis.Options) {
+	if c.username != "" {
+		opt.Username = c.username
+	}
+	if c.tls != nil {
+		opt.TLSConfig = c.tls
+	}
+	if c.poolSize > 0 {
+		opt.PoolSize = c.poolSize
+	}
+	if c.minIdleConns > 0 {
+		opt.MinIdleConns = c.minIdleConns
+	}
+	if c.dialTimeout > 0 {
+		opt.DialTimeout = c.dialTimeout
+	}
+	if c.readTimeout != 0 {
+		opt.ReadTimeout = c.readTimeout
+	}
+	if c.writeTimeout != 0 {
+		opt.WriteTimeout = c.writeTimeout
+	}
+	if c.poolTimeout > 0 {
+		opt.PoolTimeout = c.poolTimeout
+	}
+	if c.idleTimeout != 0 {
+		opt.IdleTimeout = c.idleTimeout
+	}
+	if c.maxConnAge > 0 {
+		opt.MaxConnAge = c.maxConnAge
+	}
+}
+
+// redisAddrs reads a comma separated list of host:port addresses, falling
+// back to REDIS_HOST and REDIS_PORT
+func redisAddrs(key string) []string {
+	if addrs := GetEnvAsList(key); len(addrs) > 0 {
+		return addrs
+	}
+	return []string{GetEnvAsString("REDIS_HOST", "localhost") + ":" + GetEnvAsString("REDIS_PORT", "6379")}
+}
 
+// redisRetries is how often, and with what backoff, commands are retried
+func redisRetries() (maxRetries int, minBackoff, maxBackoff time.Duration) {
+	return GetEnvAsInt("REDIS_MAX_RETRIES", 3),
+		GetEnvAsDuration("REDIS_MIN_RETRY_BACKOFF", 8*time.Millisecond),
+		GetEnvAsDuration("REDIS_MAX_RETRY_BACKOFF", 512*time.Millisecond)
+}
+
+// connectRedis tests the connection of client, and disables Redis when it
+// fails
+func connectRedis(client redis.UniversalClient, description string) *RedisService {
 	// Test connection
 	ctx := context.Background()
 	if err := client.Ping(ctx).Err(); err != nil {
 		fmt.Printf("Warning: Redis connection failed: %v\n", err)
 		fmt.Printf("Redis will be disabled. Some features may not work properly.\n")
+		client.Close()
 		// Return a mock Redis service that doesn't fail
 		return &RedisService{client: nil}
 	}
 
-	fmt.Printf("Connected to Redis at %s:%s\n", host, port)
-	return &RedisService{
-		client: client,
+	fmt.Printf("Connected to %s\n", description)
+	return newConnectedRedis(client)
+}
+
+// newConnectedRedis guards the commands of client with a redisBreaker
+func newConnectedRedis(client redis.UniversalClient) *RedisService {
+	breaker := newRedisBreaker()
+	client.AddHook(breaker)
+	return &RedisService{client: client, breaker: breaker, metrics: newCacheMetrics()}
+}
+
+// Cache returns the key prefixes and TTLs of the cache namespaces
+func (r *RedisService) Cache() CacheConfig {
+	return r.cache
+}
+
+// key puts the environment prefix in front of key
+func (r *RedisService) key(key string) string {
+	return r.cache.KeyPrefix + key
+}
+
+// get reads key, counting the read in the metrics of namespace
+func (r *RedisService) get(ctx context.Context, namespace, key string) (string, error) {
+	start := time.Now()
+	value, err := r.client.Get(ctx, r.key(key)).Result()
+	r.metrics.observeRead(namespace, time.Since(start), err)
+	return value, err
+}
+
+// set writes key, counting the write in the metrics of namespace
+func (r *RedisService) set(ctx context.Context, namespace, key string, value interface{}, ttl time.Duration) error {
+	start := time.Now()
+	err := r.client.Set(ctx, r.key(key), value, ttl).Err()
+	r.metrics.observeWrite(namespace, time.Since(start), err)
+	return err
+}
+
+// scan calls fn with the keys matching pattern, environment prefix included
+// in both. A cluster is scanned on every master, each holding its own slots.
+func (r *RedisService) scan(ctx context.Context, pattern string, fn func(key string) error) error {
+	pattern = r.key(pattern)
+	var mu sync.Mutex // Masters are scanned concurrently
+	scanNode := func(ctx context.Context, node redis.Cmdable) error {
+		iter := node.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			err := fn(iter.Val())
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node)
+		})
 	}
+	return scanNode(ctx, r.client)
 }
 
 func (r *RedisService) SetToken(ctx context.Context, token, userID string, ttl time.Duration) error {
 	if r.client == nil {
 		return nil // Redis disabled
 	}
-	return r.client.Set(ctx, "token:"+token, userID, ttl).Err()
+	return r.set(ctx, CacheToken, r.cache.Token.Key(token), userID, ttl)
 }
 
 func (r *RedisService) GetToken(ctx context.Context, token string) (string, error) {
 	if r.client == nil {
 		return "", redis.Nil // Redis disabled, return nil as if key doesn't exist
 	}
-	result, err := r.client.Get(ctx, "token:"+token).Result()
+	result, err := r.get(ctx, CacheToken, r.cache.Token.Key(token))
 	if err != nil {
 		return "", err
 	}
 	return result, nil
 }
 
-func (r *RedisService) SetOTP(ctx context.Context, key, otp string, ttl time.Duration) error {
+// DeleteTokens forgets stored tokens, so they stop being accepted
+func (r *RedisService) DeleteTokens(ctx context.Context, tokens []string) error {
+	if r.client == nil || len(tokens) == 0 {
+		return nil // Redis disabled
+	}
+	// One DEL per key, as the keys of a cluster live in different slots
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, token := range tokens {
+			pipe.Del(ctx, r.key(r.cache.Token.Key(token)))
+		}
+		return nil
+	})
+	return err
+}
+
+// GetTokenTTL returns how long a stored token has left, or redis.Nil if it is not stored
+func (r *RedisService) GetTokenTTL(ctx context.Context, token string) (time.Duration, error) {
+	if r.client == nil {
+		return 0, redis.Nil // Redis disabled, return nil as if key doesn't exist
+	}
+	ttl, err := r.client.TTL(ctx, r.key(r.cache.Token.Key(token))).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl == -2 {
+		return 0, redis.Nil
+	}
+	return ttl, nil
+}
+
+func (r *RedisService) SetOTP(ctx context.Context, email, otp string, ttl time.Duration) error {
 	if r.client == nil {
 		return nil // Redis disabled
 	}
-	return r.client.Set(ctx, key, otp, ttl).Err()
+	return r.set(ctx, CacheOTP, r.cache.OTP.Key(email), otp, ttl)
 }
 
-func (r *RedisService) GetOTP(ctx context.Context, key string) (string, error) {
+func (r *RedisService) GetOTP(ctx context.Context, email string) (string, error) {
 	if r.client == nil {
 		return "", redis.Nil // Redis disabled, return nil as if key doesn't exist
 	}
-	return r.client.Get(ctx, key).Result()
+	return r.get(ctx, CacheOTP, r.cache.OTP.Key(email))
+}
+
+func (r *RedisService) DeleteOTP(ctx context.Context, email string) error {
+	return r.DeleteKey(ctx, r.cache.OTP.Key(email))
 }
 
 func (r *RedisService) SetUserData(ctx context.Context, email string, user *entities.User, ttl time.Duration) error {
@@ -113,14 +423,14 @@ func (r *RedisService) SetUserData(ctx context.Context, email string, user *enti
 	if err != nil {
 		return err
 	}
-	return r.client.Set(ctx, "user:"+email, userData, ttl).Err()
+	return r.set(ctx, CacheUserData, r.cache.UserData.Key(email), userData, ttl)
 }
 
 func (r *RedisService) GetUserData(ctx context.Context, email string) (*entities.User, error) {
 	if r.client == nil {
 		return nil, redis.Nil // Redis disabled, return nil as if key doesn't exist
 	}
-	userData, err := r.client.Get(ctx, "user:"+email).Result()
+	userData, err := r.get(ctx, CacheUserData, r.cache.UserData.Key(email))
 	if err != nil {
 		return nil, err
 	}
@@ -133,6 +443,10 @@ func (r *RedisService) GetUserData(ctx context.Context, email string) (*entities
 	return &user, nil
 }
 
+func (r *RedisService) DeleteUserData(ctx context.Context, email string) error {
+	return r.DeleteKey(ctx, r.cache.UserData.Key(email))
+}
+
 func (r *RedisService) SetProfile(ctx context.Context, userID string, user *entities.User, ttl time.Duration) error {
 	if r.client == nil {
 		return nil // Redis disabled
@@ -141,14 +455,18 @@ func (r *RedisService) SetProfile(ctx context.Context, userID string, user *enti
 	if err != nil {
 		return err
 	}
-	return r.client.Set(ctx, "profile:"+userID, userData, ttl).Err()
+	return r.set(ctx, CacheProfile, r.cache.Profile.Key(userID), userData, ttl)
+}
+
+func (r *RedisService) DeleteProfile(ctx context.Context, userID string) error {
+	return r.DeleteKey(ctx, r.cache.Profile.Key(userID))
 }
 
 func (r *RedisService) GetProfile(ctx context.Context, userID string) (*entities.User, error) {
 	if r.client == nil {
 		return nil, redis.Nil // Redis disabled, return nil as if key doesn't exist
 	}
-	userData, err := r.client.Get(ctx, "profile:"+userID).Result()
+	userData, err := r.get(ctx, CacheProfile, r.cache.Profile.Key(userID))
 	if err != nil {
 		return nil, err
 	}
@@ -161,16 +479,677 @@ func (r *RedisService) GetProfile(ctx context.Context, userID string) (*entities
 	return &user, nil
 }
 
+// Lookups MissingUserCache records misses of
+const (
+	MissingUsername = "username"
+	MissingEmail    = "email"
+)
+
+// Values of the records of MissingUserCache. Forgetting a miss overwrites its
+// record with missingUserForgotten rather than deleting it, so a lookup that
+// started before the user was created cannot record its miss afterwards.
+const (
+	missingUserRecorded  = "1"
+	missingUserForgotten = "0"
+)
+
+func (r *RedisService) missingUserKey(field, value string) string {
+	return r.cache.Missing.Key(field + ":" + value)
+}
+
+// IsUserMissing reports whether looking a user up by field, MissingUsername
+// or MissingEmail, found none with value lately
+func (r *RedisService) IsUserMissing(ctx context.Context, field, value string) (bool, error) {
+	if r.client == nil {
+		return false, ErrDependencyDisabled
+	}
+	record, err := r.get(ctx, CacheMissing, r.missingUserKey(field, value))
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil && record == missingUserRecorded, err
+}
+
+// SetUserMissing records that no user has value as field, unless the value
+// was forgotten within the TTL
+func (r *RedisService) SetUserMissing(ctx context.Context, field, value string) error {
+	if r.client == nil {
+		return ErrDependencyDisabled
+	}
+	start := time.Now()
+	err := r.client.SetNX(ctx, r.key(r.missingUserKey(field, value)), missingUserRecorded, r.cache.Missing.TTL).Err()
+	r.metrics.observeWrite(CacheMissing, time.Since(start), err)
+	return err
+}
+
+// ForgetMissingUser drops the records that no user has username or email,
+// skipping empty ones, in one round trip
+func (r *RedisService) ForgetMissingUser(ctx context.Context, username, email string) error {
+	batch := r.Batch()
+	batch.ForgetMissingUser(ctx, username, email)
+	return batch.Exec(ctx)
+}
+
+// PendingEmailChange is an email address waiting for OTP confirmation
+type PendingEmailChange struct {
+	Email string `json:"email"`
+	OTP   string `json:"otp"`
+}
+
+func (r *RedisService) SetPendingEmailChange(ctx context.Context, userID string, pending *PendingEmailChange, ttl time.Duration) error {
+	if r.client == nil {
+		return nil // Redis disabled
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key("email_change:"+userID), data, ttl).Err()
+}
+
+func (r *RedisService) GetPendingEmailChange(ctx context.Context, userID string) (*PendingEmailChange, error) {
+	if r.client == nil {
+		return nil, redis.Nil // Redis disabled, return nil as if key doesn't exist
+	}
+	data, err := r.client.Get(ctx, r.key("email_change:"+userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending PendingEmailChange
+	if err := json.Unmarshal([]byte(data), &pending); err != nil {
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+// SetPendingEmailAddition holds a secondary address until the OTP sent to it
+// is confirmed. It reuses PendingEmailChange, only the key differs.
+func (r *RedisService) SetPendingEmailAddition(ctx context.Context, userID string, pending *PendingEmailChange, ttl time.Duration) error {
+	if r.client == nil {
+		return nil // Redis disabled
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key("email_add:"+userID), data, ttl).Err()
+}
+
+func (r *RedisService) GetPendingEmailAddition(ctx context.Context, userID string) (*PendingEmailChange, error) {
+	if r.client == nil {
+		return nil, redis.Nil // Redis disabled, return nil as if key doesn't exist
+	}
+	data, err := r.client.Get(ctx, r.key("email_add:"+userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending PendingEmailChange
+	if err := json.Unmarshal([]byte(data), &pending); err != nil {
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+// PendingAccountUpgrade holds the credentials a guest wants to attach until
+// the OTP sent to the email is confirmed. The password is already hashed.
+type PendingAccountUpgrade struct {
+	Username     string `json:"username,omitempty"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+	OTP          string `json:"otp"`
+	TermsVersion int    `json:"terms_version,omitempty"`
+}
+
+func (r *RedisService) SetPendingAccountUpgrade(ctx context.Context, userID string, pending *PendingAccountUpgrade, ttl time.Duration) error {
+	if r.client == nil {
+		return nil // Redis disabled
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key("account_upgrade:"+userID), data, ttl).Err()
+}
+
+func (r *RedisService) GetPendingAccountUpgrade(ctx context.Context, userID string) (*PendingAccountUpgrade, error) {
+	if r.client == nil {
+		return nil, redis.Nil // Redis disabled, return nil as if key doesn't exist
+	}
+	data, err := r.client.Get(ctx, r.key("account_upgrade:"+userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending PendingAccountUpgrade
+	if err := json.Unmarshal([]byte(data), &pending); err != nil {
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+// LoginChallenge is a login that passed the password check and is waiting for
+// an OTP before a token is issued
+type LoginChallenge struct {
+	UserID            string `json:"user_id"`
+	OTP               string `json:"otp"`
+	DeviceFingerprint string `json:"device_fingerprint,omitempty"`
+	UserAgent         string `json:"user_agent,omitempty"`
+	IPAddress         string `json:"ip_address,omitempty"`
+	Country           string `json:"country,omitempty"`
+}
+
+func (r *RedisService) SetLoginChallenge(ctx context.Context, challengeID string, challenge *LoginChallenge, ttl time.Duration) error {
+	if r.client == nil {
+		return nil // Redis disabled
+	}
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key("login_challenge:"+challengeID), data, ttl).Err()
+}
+
+func (r *RedisService) GetLoginChallenge(ctx context.Context, challengeID string) (*LoginChallenge, error) {
+	if r.client == nil {
+		return nil, redis.Nil // Redis disabled, return nil as if key doesn't exist
+	}
+	data, err := r.client.Get(ctx, r.key("login_challenge:"+challengeID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge LoginChallenge
+	if err := json.Unmarshal([]byte(data), &challenge); err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// TakeLoginChallenge returns the login challenge and deletes it, so of
+// concurrent attempts only one gets it
+func (r *RedisService) TakeLoginChallenge(ctx context.Context, challengeID string) (*LoginChallenge, error) {
+	if r.client == nil {
+		return nil, redis.Nil // Redis disabled, return nil as if key doesn't exist
+	}
+	data, err := r.client.GetDel(ctx, r.key("login_challenge:"+challengeID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge LoginChallenge
+	if err := json.Unmarshal([]byte(data), &challenge); err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// SagaState is the progress of a saga, kept while it runs so that one
+// interrupted by a crash or a failed compensation can be finished or rolled
+// back later
+type SagaState struct {
+	Name      string            `json:"name"`
+	ID        string            `json:"id"`                // What the saga is about, one saga of a name at a time
+	Completed []string          `json:"completed"`         // Steps done, in order
+	Current   string            `json:"current,omitempty"` // Step running, which may or may not have taken effect
+	Status    string            `json:"status"`
+	Data      map[string]string `json:"data,omitempty"` // What steps hand to later steps and compensations
+	Error     string            `json:"error,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+func sagaStateKey(name, id string) string {
+	return "saga:" + name + ":" + id
+}
+
+func (r *RedisService) SetSagaState(ctx context.Context, state *SagaState, ttl time.Duration) error {
+	if r.client == nil {
+		return nil // Redis disabled
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key(sagaStateKey(state.Name, state.ID)), data, ttl).Err()
+}
+
+func (r *RedisService) DeleteSagaState(ctx context.Context, name, id string) error {
+	if r.client == nil {
+		return nil // Redis disabled
+	}
+	return r.client.Del(ctx, r.key(sagaStateKey(name, id))).Err()
+}
+
+// ListSagaStates returns the state of every saga that has not finished
+func (r *RedisService) ListSagaStates(ctx context.Context) ([]*SagaState, error) {
+	if r.client == nil {
+		return nil, nil // Redis disabled
+	}
+
+	var states []*SagaState
+	err := r.scan(ctx, "saga:*", func(key string) error {
+		data, err := r.client.Get(ctx, key).Result()
+		if err != nil {
+			if err == redis.Nil {
+				return nil // Finished since the scan saw it
+			}
+			return err
+		}
+		var state SagaState
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return err
+		}
+		states = append(states, &state)
+		return nil
+	})
+	return states, err
+}
+
+func idempotencyRecordKey(key string) string {
+	return "idempotency:" + key
+}
+
+// SetIdempotencyRecord stores record under its key for ttl. With onlyNew a
+// record already stored under the key is kept and false returned.
+func (r *RedisService) SetIdempotencyRecord(ctx context.Context, record *entities.IdempotencyRecord, ttl time.Duration, onlyNew bool) (bool, error) {
+	if r.client == nil {
+		return false, ErrDependencyDisabled
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	if onlyNew {
+		return r.client.SetNX(ctx, r.key(idempotencyRecordKey(record.Key)), data, ttl).Result()
+	}
+	return true, r.client.Set(ctx, r.key(idempotencyRecordKey(record.Key)), data, ttl).Err()
+}
+
+// GetIdempotencyRecord returns the record stored under key, redis.Nil when
+// there is none
+func (r *RedisService) GetIdempotencyRecord(ctx context.Context, key string) (*entities.IdempotencyRecord, error) {
+	if r.client == nil {
+		return nil, ErrDependencyDisabled
+	}
+	data, err := r.client.Get(ctx, r.key(idempotencyRecordKey(key))).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var record entities.IdempotencyRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// emailJobsStream is the stream of the email delivery queue
+const emailJobsStream = "email_jobs"
+
+// EmailJob is an email waiting in the delivery queue
+type EmailJob struct {
+	ID        string    `json:"-"` // Stream entry ID, set when read
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	Text      string    `json:"text"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // Not worth sending after, zero for never
+}
+
+// EnqueueEmailJob appends job to the email delivery stream, which keeps about
+// maxLen jobs
+func (r *RedisService) EnqueueEmailJob(ctx context.Context, job *EmailJob, maxLen int64) error {
+	if r.client == nil {
+		return ErrDependencyDisabled
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.key(emailJobsStream),
+		MaxLen: maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"job": data},
+	}).Err()
+}
+
+// ReadEmailJobs hands consumer of group up to count jobs no consumer of the
+// group was handed yet, waiting up to block for one. The group is created,
+// along with the stream, when missing. It returns redis.Nil when no job came.
+func (r *RedisService) ReadEmailJobs(ctx context.Context, group, consumer string, count int64, block time.Duration) ([]*EmailJob, error) {
+	if r.client == nil {
+		return nil, ErrDependencyDisabled
+	}
+	args := &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{r.key(emailJobsStream), ">"},
+		Count:    count,
+		Block:    block,
+	}
+	streams, err := r.client.XReadGroup(ctx, args).Result()
+	if err != nil && isNoGroup(err) {
+		// Start from the beginning, so jobs queued before are not skipped
+		err = r.client.XGroupCreateMkStream(ctx, r.key(emailJobsStream), group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+		streams, err = r.client.XReadGroup(ctx, args).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*EmailJob
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			jobs = append(jobs, emailJobFromMessage(message))
+		}
+	}
+	return jobs, nil
+}
+
+// PendingEmailJobs returns up to count jobs handed to consumers of group that
+// were not acknowledged, with how long ago and how many times they were
+// handed out
+func (r *RedisService) PendingEmailJobs(ctx context.Context, group string, count int64) ([]redis.XPendingExt, error) {
+	if r.client == nil {
+		return nil, ErrDependencyDisabled
+	}
+	return r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: r.key(emailJobsStream),
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+}
+
+// ClaimEmailJob hands the pending job id over to consumer, unless it was
+// handed out again less than minIdle ago. It returns redis.Nil when it was,
+// or when the job is gone from the stream.
+func (r *RedisService) ClaimEmailJob(ctx context.Context, group, consumer, id string, minIdle time.Duration) (*EmailJob, error) {
+	if r.client == nil {
+		return nil, ErrDependencyDisabled
+	}
+	messages, err := r.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   r.key(emailJobsStream),
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: []string{id},
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range messages {
+		if message.Values != nil {
+			return emailJobFromMessage(message), nil
+		}
+	}
+	return nil, redis.Nil
+}
+
+// AckEmailJob removes the job id from the stream once delivered or given up on
+func (r *RedisService) AckEmailJob(ctx context.Context, group, id string) error {
+	if r.client == nil {
+		return ErrDependencyDisabled
+	}
+	key := r.key(emailJobsStream)
+	pipe := r.client.TxPipeline()
+	pipe.XAck(ctx, key, group, id)
+	pipe.XDel(ctx, key, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// emailJobFromMessage decodes a stream entry. An entry that does not decode
+// becomes a job without recipient, which the queue drops.
+func emailJobFromMessage(message redis.XMessage) *EmailJob {
+	job := &EmailJob{}
+	if data, ok := message.Values["job"].(string); ok {
+		json.Unmarshal([]byte(data), job)
+	}
+	job.ID = message.ID
+	return job
+}
+
+// AcquireCooldown claims key for ttl and reports false while a previous claim is still active
+func (r *RedisService) AcquireCooldown(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if r.client == nil {
+		return true, nil // Redis disabled
+	}
+	return r.client.SetNX(ctx, r.key(key), 1, ttl).Result()
+}
+
+// IncrementCounter increments key and starts its ttl on the first increment
+func (r *RedisService) IncrementCounter(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if r.client == nil {
+		return 1, nil // Redis disabled
+	}
+	key = r.key(key)
+	count, err := r.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := r.client.Expire(ctx, key, ttl).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// DeleteKeysWithoutExpiry removes keys matching pattern that have no TTL, such
+// as pending registrations written before expiries were set. Keys with a TTL
+// are left to expire on their own.
+func (r *RedisService) DeleteKeysWithoutExpiry(ctx context.Context, pattern string) (int, error) {
+	if r.client == nil {
+		return 0, nil // Redis disabled
+	}
+
+	deleted := 0
+	err := r.scan(ctx, pattern, func(key string) error {
+		ttl, err := r.client.TTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		// -1 means the key exists without an expiry
+		if ttl != -1 {
+			return nil
+		}
+		if err := r.client.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	return deleted, err
+}
+
+// GetTTL returns how long key has left, zero when it is gone or never expires
+func (r *RedisService) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	if r.client == nil {
+		return 0, nil // Redis disabled
+	}
+	ttl, err := r.client.TTL(ctx, r.key(key)).Result()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return ttl, nil
+}
+
 func (r *RedisService) DeleteKey(ctx context.Context, key string) error {
 	if r.client == nil {
 		return nil // Redis disabled
 	}
-	return r.client.Del(ctx, key).Err()
+	return r.client.Del(ctx, r.key(key)).Err()
+}
+
+// slidingWindowScript counts a request in the sorted set of the requests
+// within a window, unless the limit is reached. KEYS[1] is the set, ARGV the
+// time in milliseconds, the window in milliseconds, the limit and a unique
+// member for the request. It returns 1 when the request is allowed.
+var slidingWindowScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', tonumber(ARGV[1]) - tonumber(ARGV[2]))
+local allowed = 0
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[4])
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return allowed
+`)
+
+// AllowRequest counts a request against key, unless limit requests were
+// counted within the window before it. The check and the count are atomic,
+// so every instance sharing Redis shares the limit.
+func (r *RedisService) AllowRequest(ctx context.Context, key string, window time.Duration, limit int) (bool, error) {
+	if r.client == nil {
+		return false, ErrDependencyDisabled
+	}
+	now := time.Now()
+	member := strconv.FormatInt(now.UnixNano(), 10) + ":" + uuid.New().String()
+	allowed, err := slidingWindowScript.Run(ctx, r.client, []string{r.key(key)}, now.UnixMilli(), window.Milliseconds(), limit, member).Int()
+	if err != nil {
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+// RequestsInWindow returns when the requests counted against key within
+// window were made, oldest first
+func (r *RedisService) RequestsInWindow(ctx context.Context, key string, window time.Duration) ([]time.Time, error) {
+	if r.client == nil {
+		return nil, ErrDependencyDisabled
+	}
+	now := time.Now()
+	scores, err := r.client.ZRangeByScoreWithScores(ctx, r.key(key), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(now.Add(-window).UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]time.Time, 0, len(scores))
+	for _, score := range scores {
+		requests = append(requests, time.UnixMilli(int64(score.Score)))
+	}
+	return requests, nil
+}
+
+// Ping checks that Redis answers, for health checks
+func (r *RedisService) Ping(ctx context.Context) error {
+	if r.client == nil {
+		return ErrDependencyDisabled
+	}
+	return r.client.Ping(ctx).Err()
+}
+
+// StartHealthCheck pings Redis every REDIS_HEALTH_CHECK_INTERVAL (default
+// 5s, 0 disables) until Close, flipping Ready as the pings fail and recover
+func (r *RedisService) StartHealthCheck() {
+	interval := GetEnvAsDuration("REDIS_HEALTH_CHECK_INTERVAL", 5*time.Second)
+	if r.client == nil || interval <= 0 {
+		return
+	}
+	// Redis answered at startup
+	health := &redisHealth{ready: true, stop: make(chan struct{})}
+	r.health = health
+
+	health.wg.Add(1)
+	go func() {
+		defer health.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.checkHealth(interval)
+			case <-health.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *RedisService) checkHealth(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err := r.client.Ping(ctx).Err()
+	cancel()
+
+	r.health.mu.Lock()
+	wasReady := r.health.ready
+	r.health.ready, r.health.err = err == nil, err
+	r.health.mu.Unlock()
+
+	switch {
+	case wasReady && err != nil:
+		log.Printf("Redis is not ready: %v", err)
+	case !wasReady && err == nil:
+		log.Printf("Redis is ready again")
+	}
+}
+
+// Ready reports whether the last periodic PING succeeded. Without the
+// periodic check it reports whether Redis is enabled.
+func (r *RedisService) Ready() bool {
+	if r.client == nil {
+		return false
+	}
+	if r.health == nil {
+		return true
+	}
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	return r.health.ready
+}
+
+// HealthCheck reports the error of the last periodic PING, for readiness,
+// without a round trip. Without the periodic check it pings.
+func (r *RedisService) HealthCheck(ctx context.Context) error {
+	if r.client == nil {
+		return ErrDependencyDisabled
+	}
+	if r.health == nil {
+		return r.Ping(ctx)
+	}
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	return r.health.err
+}
+
+// BreakerStats reports the circuit breaker guarding Redis, for metrics. Its
+// state is HealthDisabled when Redis is.
+func (r *RedisService) BreakerStats() CircuitBreakerStats {
+	if r.client == nil {
+		return CircuitBreakerStats{State: HealthDisabled}
+	}
+	return r.breaker.breaker.Stats()
+}
+
+// CacheMetrics reports the hits, misses, writes, errors and latencies of
+// each cache namespace, for metrics. It is empty while Redis is disabled.
+func (r *RedisService) CacheMetrics() map[string]interface{} {
+	if r.client == nil {
+		return map[string]interface{}{}
+	}
+	return r.metrics.snapshot()
 }
 
 func (r *RedisService) Close() error {
 	if r.client == nil {
 		return nil // Redis disabled
 	}
+	if r.health != nil {
+		close(r.health.stop)
+		r.health.wg.Wait()
+	}
 	return r.client.Close()
 }
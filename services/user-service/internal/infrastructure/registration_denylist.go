@@ -0,0 +1,74 @@
+package infrastructure
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// NewRegistrationDenylist builds the denylist from the built-in lists plus the
+// newline separated files in RESERVED_USERNAMES_FILE and
+// DISPOSABLE_EMAIL_DOMAINS_FILE. Lines starting with # are ignored.
+func NewRegistrationDenylist() *entities.RegistrationDenylist {
+	reservedUsernames := readListFile(os.Getenv("RESERVED_USERNAMES_FILE"))
+	blockedDomains := readListFile(os.Getenv("DISPOSABLE_EMAIL_DOMAINS_FILE"))
+
+	denylist := entities.NewRegistrationDenylist(reservedUsernames, blockedDomains)
+	usernames, domains := denylist.Size()
+	log.Printf("Registration Denylist Config - Reserved Usernames: %d, Blocked Email Domains: %d", usernames, domains)
+
+	return denylist
+}
+
+// WatchRegistrationDenylist reloads the denylist files on SIGHUP and every
+// DENYLIST_RELOAD_INTERVAL (0 disables the timer) for the life of the process
+func WatchRegistrationDenylist() {
+	interval := GetEnvAsDuration("DENYLIST_RELOAD_INTERVAL", 5*time.Minute)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+
+	go func() {
+		for {
+			select {
+			case <-hangup:
+				log.Println("SIGHUP received, reloading registration denylist")
+			case <-tick:
+			}
+			entities.SetRegistrationDenylist(NewRegistrationDenylist())
+		}
+	}()
+}
+
+func readListFile(path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open list %s: %v", path, err)
+		return nil
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entries = append(entries, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Failed to read list %s: %v", path, err)
+	}
+	return entries
+}
@@ -0,0 +1,25 @@
+package infrastructure
+
+import (
+	"log"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// NewTermsPolicy reads the published terms of service version from
+// TERMS_VERSION (0 disables tracking) and the oldest version that still
+// allows a login from TERMS_REQUIRED_VERSION, which defaults to the current one
+func NewTermsPolicy() entities.TermsPolicy {
+	current := GetEnvAsInt("TERMS_VERSION", 0)
+	required := GetEnvAsInt("TERMS_REQUIRED_VERSION", current)
+	if required > current {
+		log.Printf("TERMS_REQUIRED_VERSION %d is newer than TERMS_VERSION %d, using %d", required, current, current)
+		required = current
+	}
+
+	log.Printf("Terms Policy Config - Current Version: %d, Required Version: %d", current, required)
+	return entities.TermsPolicy{
+		CurrentVersion:  current,
+		RequiredVersion: required,
+	}
+}
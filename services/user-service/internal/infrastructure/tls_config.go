@@ -0,0 +1,109 @@
+package infrastructure
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// NewTCPTLSConfig loads the TCP listener's certificate from TCP_TLS_CERT_FILE
+// and TCP_TLS_KEY_FILE. With TCP_TLS_CLIENT_CA_FILE set, client certificates
+// signed by that CA are verified when presented; the listener still accepts
+// clients without one so public methods keep working. It returns nil when TLS
+// is not configured.
+func NewTCPTLSConfig() (*tls.Config, error) {
+	certFile := GetEnvAsString("TCP_TLS_CERT_FILE", "")
+	keyFile := GetEnvAsString("TCP_TLS_KEY_FILE", "")
+	clientCAFile := GetEnvAsString("TCP_TLS_CLIENT_CA_FILE", "")
+
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("TCP_TLS_CLIENT_CA_FILE requires TCP_TLS_CERT_FILE and TCP_TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TCP TLS certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pemData, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TCP_TLS_CLIENT_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("TCP_TLS_CLIENT_CA_FILE contains no certificates")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	log.Printf("TCP TLS Config - Certificate: %s, Client CA: %s", certFile, valueOrNone(clientCAFile))
+	return config, nil
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// NewRedisTLSConfig makes the TLS configuration of connections to Redis, as
+// managed providers require. REDIS_TLS_ENABLED turns it on, verifying the
+// server against the system roots, or REDIS_TLS_CA_FILE when set.
+// REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE add a client certificate, and
+// REDIS_TLS_SERVER_NAME overrides the name verified. It returns nil when TLS
+// is off.
+func NewRedisTLSConfig() (*tls.Config, error) {
+	caFile := GetEnvAsString("REDIS_TLS_CA_FILE", "")
+	certFile := GetEnvAsString("REDIS_TLS_CERT_FILE", "")
+	keyFile := GetEnvAsString("REDIS_TLS_KEY_FILE", "")
+	if !GetEnvAsBool("REDIS_TLS_ENABLED", false) {
+		if caFile != "" || certFile != "" || keyFile != "" {
+			return nil, errors.New("REDIS_TLS_CA_FILE, REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE require REDIS_TLS_ENABLED=true")
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         GetEnvAsString("REDIS_TLS_SERVER_NAME", ""),
+		InsecureSkipVerify: GetEnvAsBool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+	}
+
+	if caFile != "" {
+		pemData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDIS_TLS_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("REDIS_TLS_CA_FILE contains no certificates")
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Redis TLS client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	log.Printf("Redis TLS Config - CA: %s, Client Certificate: %s, Server Name: %s, Skip Verify: %v",
+		valueOrNone(caFile), valueOrNone(certFile), valueOrNone(config.ServerName), config.InsecureSkipVerify)
+	return config, nil
+}
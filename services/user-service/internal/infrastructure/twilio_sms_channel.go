@@ -0,0 +1,81 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const twilioAPIBaseURL = "https://api.twilio.com/2010-04-01"
+
+// twilioSMSChannel delivers OTPs as text messages through the Twilio REST API
+type twilioSMSChannel struct {
+	accountSID string
+	authToken  string
+	fromNumber string
+	httpClient *http.Client
+}
+
+// newTwilioSMSChannel returns nil when Twilio is not configured
+func newTwilioSMSChannel() *twilioSMSChannel {
+	accountSID := GetEnvAsString("TWILIO_ACCOUNT_SID", "")
+	authToken := GetEnvAsString("TWILIO_AUTH_TOKEN", "")
+	fromNumber := GetEnvAsString("TWILIO_FROM_NUMBER", "")
+	if accountSID == "" || authToken == "" || fromNumber == "" {
+		return nil
+	}
+
+	return &twilioSMSChannel{
+		accountSID: accountSID,
+		authToken:  authToken,
+		fromNumber: fromNumber,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *twilioSMSChannel) Name() string {
+	return OTPChannelSMS
+}
+
+func (c *twilioSMSChannel) Send(ctx context.Context, phoneNumber string, otp string) error {
+	log.Printf("Sending OTP via SMS to: %s", maskPhoneNumber(phoneNumber))
+
+	form := url.Values{}
+	form.Set("To", phoneNumber)
+	form.Set("From", c.fromNumber)
+	form.Set("Body", fmt.Sprintf("Your OTP code is: %s", otp))
+
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Messages.json", twilioAPIBaseURL, c.accountSID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.accountSID, c.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("twilio request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("twilio returned %d: %s", resp.StatusCode, body)
+	}
+
+	log.Printf("SMS sent successfully to: %s", maskPhoneNumber(phoneNumber))
+	return nil
+}
+
+func maskPhoneNumber(phoneNumber string) string {
+	if len(phoneNumber) <= 4 {
+		return "****"
+	}
+	return strings.Repeat("*", len(phoneNumber)-4) + phoneNumber[len(phoneNumber)-4:]
+}
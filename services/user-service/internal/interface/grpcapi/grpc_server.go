@@ -0,0 +1,304 @@
+// Package grpcapi serves the UserService of tcp/pb/user_service.proto over
+// gRPC. The protobuf and gRPC runtimes are not dependencies of this service,
+// so it speaks the gRPC wire format over HTTP/2 itself, unary calls only, and
+// hands every call to the TCP method of the same name.
+package grpcapi
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/interface/httpapi"
+	"user-service-new/internal/interface/tcp"
+	"user-service-new/internal/interface/tcp/pb"
+)
+
+const (
+	// servicePath prefixes the paths of the UserService methods
+	servicePath = "/userservice.v1.UserService/"
+	// messageHeaderSize is the compressed flag and length in front of a message
+	messageHeaderSize = 5
+	// maxMessageSize is the gRPC default limit on received messages
+	maxMessageSize = 4 * 1024 * 1024
+)
+
+// gRPC status codes
+const (
+	codeOK                 = 0
+	codeCanceled           = 1
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeDeadlineExceeded   = 4
+	codeNotFound           = 5
+	codeAlreadyExists      = 6
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeUnimplemented      = 12
+	codeInternal           = 13
+	codeUnavailable        = 14
+	codeUnauthenticated    = 16
+)
+
+// rpc is a UserService method and the TCP method it runs
+type rpc struct {
+	method   string
+	request  func() pb.Message
+	response func() pb.Message
+}
+
+var rpcs = map[string]rpc{
+	"Register": {
+		method:   "register",
+		request:  func() pb.Message { return &pb.RegisterRequest{} },
+		response: func() pb.Message { return &pb.RegisterResponse{} },
+	},
+	"Login": {
+		method:   "login",
+		request:  func() pb.Message { return &pb.LoginRequest{} },
+		response: func() pb.Message { return &pb.LoginResponse{} },
+	},
+	// SendOTP only resends the OTP of a pending registration, to its email;
+	// Register sends the first one
+	"SendOTP": {
+		method:   "otp.resend",
+		request:  func() pb.Message { return &pb.SendOTPRequest{} },
+		response: func() pb.Message { return &pb.SendOTPResponse{} },
+	},
+	"VerifyOTP": {
+		method:   "verify",
+		request:  func() pb.Message { return &pb.VerifyOTPRequest{} },
+		response: func() pb.Message { return &pb.VerifyOTPResponse{} },
+	},
+	"GetProfile": {
+		method:   "profile",
+		request:  func() pb.Message { return &pb.ProfileRequest{} },
+		response: func() pb.Message { return &pb.ProfileResponse{} },
+	},
+}
+
+// GRPCServer serves gRPC calls with the methods of a TCP handler
+type GRPCServer struct {
+	tcpHandler *tcp.TCPHandler
+	server     *http.Server
+}
+
+// NewGRPCServer creates a gRPC server calling the methods of tcpHandler
+func NewGRPCServer(tcpHandler *tcp.TCPHandler) *GRPCServer {
+	return &GRPCServer{tcpHandler: tcpHandler}
+}
+
+// Start begins serving gRPC over cleartext HTTP/2 on address
+func (s *GRPCServer) Start(address string) error {
+	s.server = &http.Server{
+		Addr:              address,
+		Handler:           h2c.NewHandler(http.HandlerFunc(s.serveRPC), &http2.Server{}),
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       5 * time.Minute,
+	}
+
+	log.Printf("gRPC server listening on %s", address)
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to start gRPC server: %v", err)
+	}
+	return nil
+}
+
+// Stop waits for in-flight calls to finish, up to the context deadline
+func (s *GRPCServer) Stop(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error shutting down gRPC server: %v", err)
+	}
+	log.Println("gRPC server stopped")
+	return nil
+}
+
+// serveRPC answers a unary call. gRPC reports every outcome in the trailers
+// of a 200 response, errors included.
+func (s *GRPCServer) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 {
+		http.Error(w, "gRPC needs HTTP/2 POST requests", http.StatusBadRequest)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+proto") {
+		http.Error(w, "unsupported content type "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message, Error-Code")
+
+	name, found := strings.CutPrefix(r.URL.Path, servicePath)
+	call, ok := rpcs[name]
+	if !found || !ok {
+		writeStatus(w, codeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+
+	ctx := r.Context()
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		duration, err := parseTimeout(timeout)
+		if err != nil {
+			writeStatus(w, codeInvalidArgument, err.Error())
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	request := call.request()
+	if code, err := readMessage(r.Body, request); err != nil {
+		writeStatus(w, code, err.Error())
+		return
+	}
+	content, err := json.Marshal(request)
+	if err != nil {
+		writeStatus(w, codeInternal, err.Error())
+		return
+	}
+
+	result, err := s.tcpHandler.Call(ctx, call.method, r.Header.Get("X-Api-Key"), content)
+	if err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			writeStatus(w, codeDeadlineExceeded, err.Error())
+			return
+		case context.Canceled:
+			writeStatus(w, codeCanceled, err.Error())
+			return
+		}
+		// The stable code of the error rides along as trailer metadata
+		w.Header().Set("Error-Code", entities.ErrorCode(err))
+		writeStatus(w, errorCode(err), err.Error())
+		return
+	}
+
+	// The TCP methods answer with JSON shaped like the response messages
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		writeStatus(w, codeInternal, err.Error())
+		return
+	}
+	response := call.response()
+	if err := json.Unmarshal(jsonData, response); err != nil {
+		writeStatus(w, codeInternal, err.Error())
+		return
+	}
+
+	message := response.Marshal()
+	frame := make([]byte, messageHeaderSize, messageHeaderSize+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		log.Printf("Error writing gRPC response: %v", err)
+		return
+	}
+	writeStatus(w, codeOK, "")
+}
+
+// readMessage reads the single request message of a unary call into message
+func readMessage(body io.Reader, message pb.Message) (int, error) {
+	var header [messageHeaderSize]byte
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		return codeInvalidArgument, fmt.Errorf("reading request message: %v", err)
+	}
+	if header[0] != 0 {
+		return codeUnimplemented, fmt.Errorf("compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxMessageSize {
+		return codeResourceExhausted, fmt.Errorf("request message of %d bytes exceeds the %d byte limit", length, maxMessageSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return codeInvalidArgument, fmt.Errorf("reading request message: %v", err)
+	}
+	if err := message.Unmarshal(data); err != nil {
+		return codeInvalidArgument, err
+	}
+	return codeOK, nil
+}
+
+// writeStatus ends the call with code and message in the trailers
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(message))
+	}
+}
+
+// encodeMessage percent-encodes a status message as the gRPC spec asks
+func encodeMessage(message string) string {
+	return strings.ReplaceAll(url.PathEscape(message), "+", "%2B")
+}
+
+// errorCode maps a method error to a gRPC status code by way of the HTTP
+// status the REST API would answer with
+func errorCode(err error) int {
+	switch httpapi.ErrorStatus(err) {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codeInvalidArgument
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codePermissionDenied
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusConflict:
+		return codeAlreadyExists
+	case http.StatusGone:
+		return codeFailedPrecondition
+	case http.StatusTooManyRequests:
+		return codeResourceExhausted
+	case http.StatusRequestEntityTooLarge:
+		return codeResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	case http.StatusInternalServerError:
+		return codeInternal
+	}
+	return codeUnknown
+}
+
+// parseTimeout reads a grpc-timeout header: up to 8 digits and a unit
+func parseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid grpc-timeout unit in %q", value)
+	}
+	return time.Duration(amount) * unit, nil
+}
@@ -0,0 +1,294 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/application/query"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/interface/tcp"
+)
+
+// adminRoutes are the user management endpoints, the HTTP counterparts of the
+// admin.* TCP methods. They authenticate the calling service by API key.
+func (h *HTTPHandler) adminRoutes() []route {
+	adminErrors := []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound}
+	return []route{
+		{
+			method:  http.MethodGet,
+			path:    "/v1/admin/users",
+			handler: h.handleListUsers,
+			summary: "List users a page at a time",
+			admin:   true,
+			query: []queryParameter{
+				{name: "search", description: "Only users whose username or email contains it"},
+				{name: "verified", description: "Only verified, or only unverified, users", kind: "boolean"},
+				{name: "createdAfter", description: "Only users created at or after this RFC 3339 time"},
+				{name: "createdBefore", description: "Only users created before this RFC 3339 time"},
+				{name: "sort", description: "created_at, -created_at (the default), username or -username"},
+				{name: "cursor", description: "The nextCursor of the previous page"},
+				{name: "limit", description: "Users per page, 20 by default and at most 100", kind: "integer"},
+			},
+			responses: []routeResponse{{http.StatusOK, "Users", usersResponse{}}},
+			errors:    adminErrors,
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/v1/admin/users/{userID}/sessions",
+			handler:   h.handleListSessions,
+			summary:   "List the unexpired tokens of a user",
+			admin:     true,
+			responses: []routeResponse{{http.StatusOK, "Sessions", sessionsResponse{}}},
+			errors:    adminErrors,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/v1/admin/users/{userID}/audit",
+			handler: h.handleAuditTrail,
+			summary: "List a user's audit log a page at a time, newest first",
+			admin:   true,
+			query: []queryParameter{
+				{name: "cursor", description: "The nextCursor of the previous page"},
+				{name: "limit", description: "Events per page, 50 by default and at most 200", kind: "integer"},
+			},
+			responses: []routeResponse{{http.StatusOK, "Audit events", auditResponse{}}},
+			errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		},
+		{
+			method:    http.MethodPost,
+			path:      "/v1/admin/users/{userID}/logout",
+			handler:   h.handleForceLogout,
+			summary:   "Revoke every token of a user",
+			admin:     true,
+			responses: []routeResponse{{http.StatusOK, "Tokens revoked", logoutResponse{}}},
+			errors:    adminErrors,
+		},
+		{
+			method:    http.MethodPost,
+			path:      "/v1/admin/users/{userID}/status",
+			handler:   h.handleChangeUserStatus,
+			summary:   "Suspend, ban or reinstate a user",
+			admin:     true,
+			request:   tcp.AdminUserStatusRequest{},
+			responses: []routeResponse{{http.StatusOK, "Status changed", userResponse{}}},
+			errors:    adminErrors,
+		},
+		{
+			method:    http.MethodPost,
+			path:      "/v1/admin/verifications/resend",
+			handler:   h.handleResendVerification,
+			summary:   "Email a pending registration its OTP again, without the registrant's cooldown",
+			admin:     true,
+			request:   tcp.AdminVerificationResendRequest{},
+			responses: []routeResponse{{http.StatusAccepted, "OTP sent", statusResponse{}}},
+			errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusGone},
+		},
+	}
+}
+
+type usersResponse struct {
+	Status     string               `json:"status"`
+	Users      []*common.UserResult `json:"users"`
+	NextCursor string               `json:"nextCursor,omitempty"` // Absent on the last page
+}
+
+type sessionsResponse struct {
+	Status   string                  `json:"status"`
+	Sessions []*common.SessionResult `json:"sessions"`
+}
+
+type auditResponse struct {
+	Status     string                     `json:"status"`
+	Events     []*common.AuditEventResult `json:"events"`
+	NextCursor string                     `json:"nextCursor,omitempty"` // Absent on the last page
+}
+
+type logoutResponse struct {
+	Status          string `json:"status"`
+	RevokedSessions int    `json:"revokedSessions"`
+}
+
+// handleListUsers returns a page of users matching the query parameters
+func (h *HTTPHandler) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	parameters := r.URL.Query()
+	listQuery := &query.ListUsersQuery{
+		Search: parameters.Get("search"),
+		Sort:   parameters.Get("sort"),
+		Cursor: parameters.Get("cursor"),
+	}
+	if value := parameters.Get("verified"); value != "" {
+		verified, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, entities.ErrInvalidInput.Errorf("invalid verified, expected true or false"))
+			return
+		}
+		listQuery.Verified = &verified
+	}
+	for name, target := range map[string]**time.Time{"createdAfter": &listQuery.CreatedAfter, "createdBefore": &listQuery.CreatedBefore} {
+		value := parameters.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, entities.ErrInvalidInput.Errorf("invalid %s, expected an RFC 3339 time", name))
+			return
+		}
+		*target = &parsed
+	}
+	if value := parameters.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			writeError(w, entities.ErrInvalidInput.Errorf("invalid limit"))
+			return
+		}
+		listQuery.Limit = limit
+	}
+
+	result, err := h.adminService.ListUsers(listQuery)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usersResponse{Status: "success", Users: result.Result, NextCursor: result.NextCursor})
+}
+
+// handleListSessions describes the unexpired tokens of a user
+func (h *HTTPHandler) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := h.adminService.ListSessions(userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessionsResponse{Status: "success", Sessions: result.Result})
+}
+
+// handleAuditTrail returns a page of a user's audit log
+func (h *HTTPHandler) handleAuditTrail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	auditQuery := &query.AuditTrailQuery{UserID: userID, Cursor: r.URL.Query().Get("cursor")}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			writeError(w, entities.ErrInvalidInput.Errorf("invalid limit"))
+			return
+		}
+		auditQuery.Limit = limit
+	}
+
+	result, err := h.adminService.GetAuditTrail(auditQuery)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, auditResponse{Status: "success", Events: result.Result, NextCursor: result.NextCursor})
+}
+
+// handleForceLogout revokes every token of a user
+func (h *HTTPHandler) handleForceLogout(w http.ResponseWriter, r *http.Request) {
+	caller := adminCallerFromContext(r.Context())
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := h.adminService.ForceLogout(&command.ForceLogoutCommand{
+		UserID:      userID,
+		RequestedBy: caller,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, logoutResponse{Status: "success", RevokedSessions: result.RevokedSessions})
+}
+
+// handleChangeUserStatus suspends, bans or reinstates a user
+func (h *HTTPHandler) handleChangeUserStatus(w http.ResponseWriter, r *http.Request) {
+	caller := adminCallerFromContext(r.Context())
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var request tcp.AdminUserStatusRequest
+	if _, ok := decodeBody(w, r, &request); !ok {
+		return
+	}
+	if request.Status == "" {
+		writeError(w, entities.ErrInvalidInput.Errorf("status is required"))
+		return
+	}
+
+	result, err := h.userService.ChangeUserStatus(&command.ChangeUserStatusCommand{
+		UserID:    userID,
+		Status:    request.Status,
+		Reason:    request.Reason,
+		ChangedBy: caller,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, userResponse{Status: "success", User: result.User})
+}
+
+// handleResendVerification emails a pending registration its OTP again
+func (h *HTTPHandler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	caller := adminCallerFromContext(r.Context())
+
+	var request tcp.AdminVerificationResendRequest
+	if _, ok := decodeBody(w, r, &request); !ok {
+		return
+	}
+
+	result, err := h.adminService.ResendVerification(&command.ResendVerificationCommand{
+		Email:       request.Email,
+		RequestedBy: caller,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, statusResponse{Status: "success", Message: result.Message})
+}
+
+// authenticateAdmin validates the X-API-Key header of r and returns the name
+// of the calling service. It answers 401 or 403 itself and reports false when
+// the key is not valid or its service is not an admin service.
+func (h *HTTPHandler) authenticateAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key, err := h.apiKeyService.ValidateAPIKey(r.Header.Get("X-API-Key"))
+	if err != nil {
+		writeError(w, entities.ErrUnauthorized.Errorf("unauthorized: %v", err))
+		return "", false
+	}
+	if len(h.adminServices) > 0 && !h.adminServices[key.Name] {
+		writeError(w, entities.ErrForbidden.Errorf("%s may not use the admin API", key.Name))
+		return "", false
+	}
+	return key.Name, true
+}
+
+// pathUserID parses the {userID} of r's path. It answers 400 itself and
+// reports false when it is not a UUID.
+func pathUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		writeError(w, entities.ErrInvalidInput.Errorf("invalid userID format"))
+		return uuid.Nil, false
+	}
+	return userID, true
+}
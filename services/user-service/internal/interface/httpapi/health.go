@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"user-service-new/internal/infrastructure"
+)
+
+// HealthServer serves the probes of orchestrators on a port of its own, so
+// they keep answering however busy the API ports are
+type HealthServer struct {
+	checker   *infrastructure.HealthChecker
+	server    *http.Server
+	draining  atomic.Bool
+	startTime time.Time
+}
+
+// NewHealthServer creates a health server reporting the checks of checker
+func NewHealthServer(checker *infrastructure.HealthChecker) *HealthServer {
+	return &HealthServer{checker: checker, startTime: time.Now()}
+}
+
+// Start begins serving the probes on address
+func (s *HealthServer) Start(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /livez", s.handleLivez)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+
+	s.server = &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	log.Printf("Health server listening on %s", address)
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to start health server: %v", err)
+	}
+	return nil
+}
+
+// Drain makes /readyz fail, so traffic moves elsewhere while the service
+// shuts down. The other probes keep passing.
+func (s *HealthServer) Drain() {
+	s.draining.Store(true)
+}
+
+// Stop waits for in-flight probes to finish, up to the context deadline
+func (s *HealthServer) Stop(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error shutting down health server: %v", err)
+	}
+	return nil
+}
+
+// handleHealthz reports that the process is up
+func (s *HealthServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, statusResponse{Status: infrastructure.HealthUp})
+}
+
+// handleLivez reports that the process is serving, with its uptime. It does
+// not look at dependencies: restarting the service does not bring them back.
+func (s *HealthServer) handleLivez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Status        string `json:"status"`
+		UptimeSeconds int64  `json:"uptimeSeconds"`
+	}{
+		Status:        infrastructure.HealthUp,
+		UptimeSeconds: int64(time.Since(s.startTime).Seconds()),
+	})
+}
+
+// handleReadyz answers 200 when every dependency can be reached and 503
+// otherwise, or while draining, with the status of each
+func (s *HealthServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.checker.Check(r.Context())
+	status := http.StatusOK
+	if s.draining.Load() {
+		report.Status = "draining"
+		status = http.StatusServiceUnavailable
+	}
+	if report.Status == infrastructure.HealthDown {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,202 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/interfaces"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/infrastructure"
+)
+
+// HTTPHandler serves the service's HTTP endpoints
+type HTTPHandler struct {
+	userService   interfaces.UserService
+	avatarService interfaces.AvatarService
+	inviteService interfaces.InviteService
+	apiKeyService interfaces.APIKeyService
+	adminService  interfaces.AdminService
+	adminServices map[string]bool // API key names allowed the admin API, empty for every valid key
+	middleware    []Middleware    // Added by Use, run for every endpoint
+
+	trustedProxies []*net.IPNet // Peers whose X-Forwarded-For and country header are believed
+	countryHeader  string       // Header a trusted proxy puts the client's country in
+
+	mu             sync.Mutex // Guards the fields below, Stop may run before or during Start
+	server         *http.Server
+	cancelRequests context.CancelFunc // Ends the contexts of the requests in flight
+	stopped        bool
+}
+
+// NewHTTPHandler creates a new HTTP handler
+func NewHTTPHandler(userService interfaces.UserService, avatarService interfaces.AvatarService, inviteService interfaces.InviteService, apiKeyService interfaces.APIKeyService, adminService interfaces.AdminService) *HTTPHandler {
+	return &HTTPHandler{
+		userService:   userService,
+		avatarService: avatarService,
+		inviteService: inviteService,
+		apiKeyService: apiKeyService,
+		adminService:  adminService,
+	}
+}
+
+// EnableAdminServices restricts the admin API to the API keys of the named
+// services. Without it every valid API key may use it.
+func (h *HTTPHandler) EnableAdminServices(services []string) {
+	h.adminServices = make(map[string]bool, len(services))
+	for _, service := range services {
+		h.adminServices[service] = true
+	}
+}
+
+// EnableTrustedProxies believes the X-Forwarded-For header, and the client
+// country in countryHeader when not empty, of requests from proxies in
+// networks. Without it the client is the peer of the connection.
+func (h *HTTPHandler) EnableTrustedProxies(networks []*net.IPNet, countryHeader string) {
+	h.trustedProxies = networks
+	h.countryHeader = countryHeader
+}
+
+// ParseTrustedProxies parses a list of proxy addresses and CIDR networks
+func ParseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: not an address or CIDR network", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Start begins serving HTTP requests on address
+func (h *HTTPHandler) Start(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/jwks.json", h.handleJWKS)
+	mux.HandleFunc("GET /avatars/{userID}/{size}", h.handleAvatar)
+	h.registerREST(mux)
+	h.registerDocs(mux)
+
+	// Requests get their context from baseCtx, which Stop cancels for those
+	// still running when its deadline passes
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:              address,
+		Handler:           chain(mux, append([]Middleware{assignRequestID, h.logAccess, recoverPanics}, h.middleware...)...),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       time.Minute,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+
+	h.mu.Lock()
+	if h.stopped {
+		h.mu.Unlock()
+		cancelRequests()
+		return nil
+	}
+	h.server, h.cancelRequests = server, cancelRequests
+	h.mu.Unlock()
+
+	log.Printf("HTTP server listening on %s", address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to start HTTP server: %v", err)
+	}
+	return nil
+}
+
+// Stop stops accepting requests and waits for those in flight to finish, up
+// to the context deadline. Requests still running then have their contexts
+// canceled and their connections closed. A handler stopped before Start
+// never serves.
+func (h *HTTPHandler) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	h.stopped = true
+	server, cancelRequests := h.server, h.cancelRequests
+	h.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	defer cancelRequests()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("error shutting down HTTP server: %v", err)
+	}
+	log.Println("HTTP server stopped")
+	return nil
+}
+
+// handleJWKS publishes the token signing keys so other services can verify tokens offline
+func (h *HTTPHandler) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	result, err := h.userService.GetJWKS()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAvatar serves the stored avatar variant closest to the requested size
+func (h *HTTPHandler) handleAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		writeError(w, entities.ErrInvalidInput.Errorf("invalid userID format"))
+		return
+	}
+	size, err := strconv.Atoi(r.PathValue("size"))
+	if err != nil || size <= 0 {
+		writeError(w, entities.ErrInvalidInput.Errorf("invalid size"))
+		return
+	}
+
+	result, err := h.avatarService.GetAvatar(userID, size)
+	if err != nil {
+		if errors.Is(err, infrastructure.ErrObjectNotFound) {
+			writeError(w, entities.ErrNotFound.Errorf("avatar not found"))
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	// URLs handed out carry a version, so a versioned request can be cached for good
+	if r.URL.Query().Get("v") != "" {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.Header().Set("Content-Type", result.ContentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(result.Data); err != nil {
+		log.Printf("Error writing avatar response: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing HTTP response: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/query"
+)
+
+// Middleware wraps an HTTP handler, e.g. to check or log its requests
+type Middleware func(http.Handler) http.Handler
+
+// maxRequestIDLength bounds the X-Request-ID a client may choose
+const maxRequestIDLength = 128
+
+// chain wraps handler in middleware, with the first one outermost
+func chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Use adds middleware for every endpoint of the handler, after the built-in
+// request ID, access log and panic recovery. It has to be called before
+// Start.
+func (h *HTTPHandler) Use(middleware ...Middleware) {
+	h.middleware = append(h.middleware, middleware...)
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the request being served
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// assignRequestID gives every request an ID, the client's X-Request-ID when
+// it sent a usable one, and echoes it in the response
+func assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID)))
+	})
+}
+
+// validRequestID keeps request IDs to printable ASCII, so they cannot forge
+// lines of the access log
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < 0x21 || requestID[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLogEntry is one line of the access log
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"requestId"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMs float64 `json:"durationMs"`
+	RemoteAddr string  `json:"remoteAddr"`
+	UserAgent  string  `json:"userAgent,omitempty"`
+}
+
+// logAccess logs every request as a line of JSON once it is answered
+func (h *HTTPHandler) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		entry, _ := json.Marshal(accessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			RequestID:  RequestIDFromContext(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     recorder.status,
+			Bytes:      recorder.bytes,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			RemoteAddr: h.clientIP(r),
+			UserAgent:  r.UserAgent(),
+		})
+		log.Printf("HTTP access %s", entry)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500 problem, so one bad
+// request cannot take the server down with it
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			log.Printf("Panic in HTTP %s %s (request %s): %v\n%s", r.Method, r.URL.Path, RequestIDFromContext(r.Context()), recovered, debug.Stack())
+			// A response already under way cannot become a problem
+			if recorder, ok := w.(*statusRecorder); ok && recorder.status != 0 {
+				return
+			}
+			writeError(w, fmt.Errorf("internal error"))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+type sessionKey struct{}
+
+// sessionFromContext returns the session of the bearer token requireToken
+// accepted
+func sessionFromContext(ctx context.Context) *query.TokenIntrospectionQueryResult {
+	session, _ := ctx.Value(sessionKey{}).(*query.TokenIntrospectionQueryResult)
+	return session
+}
+
+// requireToken lets through only requests with an active bearer token, see
+// authenticate, and hands its session on
+func (h *HTTPHandler) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+	})
+}
+
+type adminCallerKey struct{}
+
+// adminCallerFromContext returns the admin service requireAdmin accepted
+func adminCallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(adminCallerKey{}).(string)
+	return caller
+}
+
+// requireAdmin lets through only requests with the API key of an admin
+// service, see authenticateAdmin, and hands the service's name on
+func (h *HTTPHandler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := h.authenticateAdmin(w, r)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminCallerKey{}, caller)))
+	})
+}
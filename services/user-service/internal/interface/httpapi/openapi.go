@@ -0,0 +1,306 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openAPIVersion is the version of the API the document describes
+const openAPIVersion = "1.0.0"
+
+// swaggerUIPage renders /openapi.json with Swagger UI, loaded from a CDN so
+// the service does not have to ship its assets
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>User Service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uuidType = reflect.TypeOf(uuid.UUID{})
+)
+
+// registerDocs serves the OpenAPI document of the REST API and Swagger UI
+func (h *HTTPHandler) registerDocs(mux *http.ServeMux) {
+	var (
+		once     sync.Once
+		document []byte
+	)
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		// The routes do not change, so the document is built once
+		once.Do(func() {
+			document, _ = json.MarshalIndent(buildOpenAPI(h.restRoutes()), "", "  ")
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})
+	mux.HandleFunc("GET /docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerUIPage))
+	})
+}
+
+// buildOpenAPI describes routes as an OpenAPI 3 document. Request and
+// response schemas are derived from the JSON tags of their types.
+func buildOpenAPI(routes []route) map[string]interface{} {
+	schemas := &schemaBuilder{components: map[string]interface{}{}}
+	problemSchema := schemas.schema(reflect.TypeOf(problemResponse{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		operation := map[string]interface{}{
+			"summary":     route.summary,
+			"operationId": operationID(route),
+		}
+
+		parameters := pathParameters(route.path)
+		for _, parameter := range route.query {
+			schemaType := parameter.kind
+			if schemaType == "" {
+				schemaType = "string"
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name":        parameter.name,
+				"in":          "query",
+				"description": parameter.description,
+				"schema":      map[string]interface{}{"type": schemaType},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemas.requestSchema(reflect.TypeOf(route.request))),
+			}
+		}
+		if route.auth {
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+		}
+		if route.admin {
+			operation["security"] = []map[string][]string{{"apiKeyAuth": {}}}
+		}
+
+		responses := map[string]interface{}{}
+		for _, response := range route.responses {
+			description := map[string]interface{}{"description": response.description}
+			if response.body != nil {
+				description["content"] = jsonContent(schemas.schema(reflect.TypeOf(response.body)))
+			}
+			responses[strconv.Itoa(response.status)] = description
+		}
+		for _, status := range append(route.errors, http.StatusInternalServerError) {
+			response := map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     problemContent(problemSchema),
+			}
+			if status == http.StatusTooManyRequests {
+				response["headers"] = rateLimitHeaders()
+			}
+			responses[strconv.Itoa(status)] = response
+		}
+		operation["responses"] = responses
+
+		if paths[route.path] == nil {
+			paths[route.path] = map[string]interface{}{}
+		}
+		paths[route.path][strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "User Service API",
+			"version":     openAPIVersion,
+			"description": "REST API of the user service, the HTTP counterpart of its TCP methods",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				"apiKeyAuth": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-API-Key",
+				},
+			},
+		},
+	}
+}
+
+// operationID names a route after its method and path, POST /v1/users/verify
+// becoming postV1UsersVerify
+func operationID(route route) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(route.method))
+	for _, segment := range strings.Split(route.path, "/") {
+		segment = strings.Trim(segment, "{}")
+		if segment == "" {
+			continue
+		}
+		id.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return id.String()
+}
+
+// pathParameters lists the {wildcards} of a path
+func pathParameters(path string) []map[string]interface{} {
+	var parameters []map[string]interface{}
+	for _, segment := range strings.Split(path, "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":     strings.Trim(segment, "{}"),
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	return parameters
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// rateLimitHeaders describes the headers of a 429
+func rateLimitHeaders() map[string]interface{} {
+	header := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"schema":      map[string]interface{}{"type": "integer"},
+		}
+	}
+	return map[string]interface{}{
+		"X-RateLimit-Limit":     header("Requests allowed per window"),
+		"X-RateLimit-Remaining": header("Requests left in the window"),
+		"X-RateLimit-Reset":     header("Seconds until another request is allowed"),
+		"Retry-After":           header("Seconds until another request is allowed"),
+	}
+}
+
+// problemContent is the RFC 7807 content of error responses
+func problemContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/problem+json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaBuilder turns Go types into JSON schemas, adding each named struct to
+// the components once and referring to it from everywhere else
+type schemaBuilder struct {
+	components map[string]interface{}
+	inRequest  bool // Request fields are all optional, the service reports what is missing
+}
+
+// requestSchema describes a request body
+func (b *schemaBuilder) requestSchema(t reflect.Type) map[string]interface{} {
+	b.inRequest = true
+	defer func() { b.inRequest = false }()
+	return b.schema(t)
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schema(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes byte slices as base64
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			// Reserve the name first, so recursive types refer to themselves
+			b.components[t.Name()] = nil
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// object describes the JSON object encoding/json makes of struct type t
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+		// Fields that are always written are required in responses
+		if !b.inRequest && !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,725 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/application/query"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/interface/tcp"
+)
+
+// maxRequestBodySize bounds the JSON body of REST requests
+const maxRequestBodySize = 1 << 20
+
+// route is an endpoint of the REST API and what the OpenAPI document says
+// about it
+type route struct {
+	method    string
+	path      string
+	handler   http.HandlerFunc
+	summary   string
+	auth      bool // Needs a bearer token
+	admin     bool // Needs the API key of an admin service
+	query     []queryParameter
+	request   interface{}     // Zero value of the body type, nil without a body
+	responses []routeResponse // Successful responses
+	errors    []int           // Error statuses, answered with problemResponse
+}
+
+type queryParameter struct {
+	name        string
+	description string
+	kind        string // JSON schema type, string when empty
+}
+
+type routeResponse struct {
+	status      int
+	description string
+	body        interface{} // nil for responses without a body
+}
+
+// restRoutes are the endpoints of the JSON REST API, the HTTP counterpart of
+// the TCP methods for clients that cannot speak the binary framing. Request
+// bodies use the same fields as the TCP payloads, except where the client
+// could claim what the server has to find out itself.
+func (h *HTTPHandler) restRoutes() []route {
+	routes := []route{
+		{
+			method:  http.MethodPost,
+			path:    "/v1/users",
+			handler: h.handleRegister,
+			summary: "Register, sending an OTP to confirm the email, or right away with an invite token",
+			request: tcp.RegisterRequest{},
+			responses: []routeResponse{
+				{http.StatusAccepted, "OTP sent", statusResponse{}},
+				{http.StatusCreated, "Account created from an invite", userResponse{}},
+			},
+			errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+		},
+		{
+			method:    http.MethodPost,
+			path:      "/v1/users/verify",
+			handler:   h.handleVerify,
+			summary:   "Create the account of a registration with its OTP",
+			request:   tcp.VerifyRequest{},
+			responses: []routeResponse{{http.StatusCreated, "Account created", userResponse{}}},
+			errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusGone, http.StatusTooManyRequests},
+		},
+		{
+			method:    http.MethodPost,
+			path:      "/v1/otp/resend",
+			handler:   h.handleResendOTP,
+			summary:   "Send a pending registration its OTP again",
+			request:   tcp.OtpResendRequest{},
+			responses: []routeResponse{{http.StatusAccepted, "OTP sent", resendResponse{}}},
+			errors:    []int{http.StatusBadRequest, http.StatusGone, http.StatusTooManyRequests},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/v1/sessions",
+			handler: h.handleLogin,
+			summary: "Log in",
+			request: loginRequest{},
+			responses: []routeResponse{
+				{http.StatusOK, "Logged in", loginResponse{}},
+				{http.StatusAccepted, "The login has to be confirmed with the OTP sent to the user", loginResponse{}},
+			},
+			errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests},
+		},
+		{
+			method:    http.MethodPost,
+			path:      "/v1/sessions/verify",
+			handler:   h.handleVerifyLogin,
+			summary:   "Complete a login challenge with its OTP",
+			request:   tcp.LoginVerifyRequest{},
+			responses: []routeResponse{{http.StatusOK, "Logged in", loginResponse{}}},
+			errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusGone, http.StatusTooManyRequests},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/v1/sessions/current",
+			handler:   h.handleCurrentSession,
+			summary:   "Describe the bearer token",
+			auth:      true,
+			responses: []routeResponse{{http.StatusOK, "Active token", sessionResponse{}}},
+			errors:    []int{http.StatusUnauthorized},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/v1/users/me",
+			handler: h.handleProfile,
+			summary: "Get the profile of the token's user",
+			auth:    true,
+			responses: []routeResponse{
+				{http.StatusOK, "Profile", userResponse{}},
+				{http.StatusNotModified, "Unchanged since the ETag of If-None-Match", nil},
+			},
+			errors: []int{http.StatusUnauthorized, http.StatusNotFound},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/v2/users/me",
+			handler: h.handleProfileV2,
+			summary: "Get the profile and account of the token's user apart",
+			auth:    true,
+			responses: []routeResponse{
+				{http.StatusOK, "Profile", userV2Response{}},
+				{http.StatusNotModified, "Unchanged since the ETag of If-None-Match", nil},
+			},
+			errors: []int{http.StatusUnauthorized, http.StatusNotFound},
+		},
+		{
+			method:    http.MethodPatch,
+			path:      "/v1/users/me",
+			handler:   h.handleUpdateProfile,
+			summary:   "Change the profile fields present in the body",
+			auth:      true,
+			request:   tcp.ProfileUpdateRequest{},
+			responses: []routeResponse{{http.StatusOK, "Updated profile", profileUpdateResponse{}}},
+			errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict, http.StatusUnprocessableEntity},
+		},
+		{
+			method:    http.MethodGet,
+			path:      "/v1/users/me/devices",
+			handler:   h.handleListDevices,
+			summary:   "List the devices the user has logged in from",
+			auth:      true,
+			responses: []routeResponse{{http.StatusOK, "Devices", devicesResponse{}}},
+			errors:    []int{http.StatusUnauthorized},
+		},
+		{
+			method:    http.MethodDelete,
+			path:      "/v1/users/me/devices/{deviceID}",
+			handler:   h.handleRevokeDevice,
+			summary:   "Forget a device so its next login is challenged again",
+			auth:      true,
+			responses: []routeResponse{{http.StatusOK, "Device revoked", statusResponse{}}},
+			errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound},
+		},
+	}
+	return append(routes, h.adminRoutes()...)
+}
+
+// loginRequest is the "login" payload without the address, user agent and
+// country of the client, which are taken from the connection
+type loginRequest struct {
+	Username          string `json:"username"`
+	Password          string `json:"password"`
+	RecoveryCode      string `json:"recoveryCode"`
+	DeviceFingerprint string `json:"deviceFingerprint"`
+	Terms             int    `json:"acceptedTermsVersion"`
+}
+
+// Response bodies of the REST API
+
+type statusResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// problemResponse is an RFC 7807 problem, extended with the error code, the
+// policy violations of a 422 and the exhausted quota of a 429
+type problemResponse struct {
+	Type      string                      `json:"type"`
+	Title     string                      `json:"title"`
+	Status    int                         `json:"status"`
+	Detail    string                      `json:"detail"`
+	Code      string                      `json:"code"`
+	Errors    []*entities.ValidationError `json:"errors,omitempty"`
+	RateLimit *rateLimitResponse          `json:"rateLimit,omitempty"`
+}
+
+// rateLimitResponse repeats the X-RateLimit-* headers of a 429
+type rateLimitResponse struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	Reset     int64 `json:"reset"` // Seconds until another request is allowed
+}
+
+type userResponse struct {
+	Status string             `json:"status"`
+	User   *common.UserResult `json:"user"`
+}
+
+type userV2Response struct {
+	Status string               `json:"status"`
+	User   *common.UserResultV2 `json:"user"`
+}
+
+type resendResponse struct {
+	Status           string `json:"status"`
+	Message          string `json:"message"`
+	RemainingResends int    `json:"remainingResends"`
+}
+
+type loginResponse struct {
+	Status      string             `json:"status"` // "success", or "challenge" with a challengeId
+	Token       string             `json:"token,omitempty"`
+	ChallengeID string             `json:"challengeId,omitempty"`
+	User        *common.UserResult `json:"user,omitempty"`
+}
+
+type sessionResponse struct {
+	Status  string                               `json:"status"`
+	Session *query.TokenIntrospectionQueryResult `json:"session"`
+}
+
+type profileUpdateResponse struct {
+	Status             string             `json:"status"`
+	User               *common.UserResult `json:"user"`
+	EmailChangePending bool               `json:"emailChangePending"`
+}
+
+type devicesResponse struct {
+	Status  string                 `json:"status"`
+	Devices []*common.DeviceResult `json:"devices"`
+}
+
+// registerREST adds the REST API to mux, its auth and admin routes behind
+// the middleware that authenticates them
+func (h *HTTPHandler) registerREST(mux *http.ServeMux) {
+	for _, route := range h.restRoutes() {
+		var handler http.Handler = route.handler
+		switch {
+		case route.admin:
+			handler = h.requireAdmin(handler)
+		case route.auth:
+			handler = h.requireToken(handler)
+		}
+		mux.Handle(route.method+" "+route.path, handler)
+	}
+}
+
+// handleRegister sends the registration OTP, or creates the account right
+// away for an invited email
+func (h *HTTPHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var request tcp.RegisterRequest
+	body, ok := decodeBody(w, r, &request)
+	if !ok {
+		return
+	}
+	if request.Username == "" || request.Password == "" || request.Email == "" {
+		writeError(w, entities.ErrInvalidInput.Errorf("username, email and password are required"))
+		return
+	}
+
+	if request.Invite != "" {
+		result, err := h.inviteService.RegisterWithInvite(&command.RegisterWithInviteCommand{
+			Token:                request.Invite,
+			Username:             request.Username,
+			Email:                request.Email,
+			Password:             request.Password,
+			AcceptedTermsVersion: request.Terms,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, userResponse{Status: "success", User: result.Result})
+		return
+	}
+
+	result, err := h.userService.SendOTP(&command.SendOTPCommand{
+		Username:             request.Username,
+		Email:                request.Email,
+		Password:             request.Password,
+		Channel:              request.Channel,
+		AcceptedTermsVersion: request.Terms,
+		IdempotencyKey:       idempotencyKey(r, body),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, statusResponse{Status: "success", Message: result.Message})
+}
+
+// handleVerify creates the account of a registration with its OTP
+func (h *HTTPHandler) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var request tcp.VerifyRequest
+	body, ok := decodeBody(w, r, &request)
+	if !ok {
+		return
+	}
+	if request.Email == "" || request.OTP == "" {
+		writeError(w, entities.ErrInvalidInput.Errorf("email and OTP are required"))
+		return
+	}
+
+	result, err := h.userService.VerifyOTP(&command.VerifyOTPCommand{
+		Email:          request.Email,
+		OTP:            request.OTP,
+		IdempotencyKey: idempotencyKey(r, body),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, userResponse{Status: "success", User: result.Result})
+}
+
+// handleResendOTP sends a pending registration its OTP again
+func (h *HTTPHandler) handleResendOTP(w http.ResponseWriter, r *http.Request) {
+	var request tcp.OtpResendRequest
+	if _, ok := decodeBody(w, r, &request); !ok {
+		return
+	}
+	if request.Email == "" {
+		writeError(w, entities.ErrInvalidInput.Errorf("email is required"))
+		return
+	}
+
+	result, err := h.userService.ResendOTP(&command.ResendOTPCommand{
+		Email: request.Email,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, resendResponse{
+		Status:           "success",
+		Message:          result.Message,
+		RemainingResends: result.RemainingResends,
+	})
+}
+
+// handleLogin answers with a token, or with 202 and a challenge ID when the
+// login has to be confirmed with an OTP
+func (h *HTTPHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var request loginRequest
+	if _, ok := decodeBody(w, r, &request); !ok {
+		return
+	}
+	if request.Username == "" || request.Password == "" {
+		writeError(w, entities.ErrInvalidInput.Errorf("missing username or password"))
+		return
+	}
+
+	result, err := h.userService.LoginUser(&command.LoginUserCommand{
+		Username:             request.Username,
+		Password:             request.Password,
+		RecoveryCode:         request.RecoveryCode,
+		DeviceFingerprint:    request.DeviceFingerprint,
+		UserAgent:            r.UserAgent(),
+		IPAddress:            h.clientIP(r),
+		Country:              h.clientCountry(r),
+		AcceptedTermsVersion: request.Terms,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeLogin(w, result)
+}
+
+// handleVerifyLogin completes a login challenge with its OTP
+func (h *HTTPHandler) handleVerifyLogin(w http.ResponseWriter, r *http.Request) {
+	var request tcp.LoginVerifyRequest
+	if _, ok := decodeBody(w, r, &request); !ok {
+		return
+	}
+	if request.ChallengeID == "" || request.OTP == "" {
+		writeError(w, entities.ErrInvalidInput.Errorf("challengeId and OTP are required"))
+		return
+	}
+
+	result, err := h.userService.VerifyLogin(&command.VerifyLoginCommand{
+		ChallengeID: request.ChallengeID,
+		OTP:         request.OTP,
+		TrustDevice: request.TrustDevice,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeLogin(w, result)
+}
+
+func writeLogin(w http.ResponseWriter, result *command.LoginUserCommandResult) {
+	if result.ChallengeRequired {
+		writeJSON(w, http.StatusAccepted, loginResponse{Status: "challenge", ChallengeID: result.ChallengeID})
+		return
+	}
+	writeJSON(w, http.StatusOK, loginResponse{Status: "success", Token: result.Token, User: result.User})
+}
+
+// handleCurrentSession describes the bearer token of the request
+func (h *HTTPHandler) handleCurrentSession(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+	writeJSON(w, http.StatusOK, sessionResponse{Status: "success", Session: session})
+}
+
+// handleProfile returns the profile of the bearer token's user
+func (h *HTTPHandler) handleProfile(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+
+	result, err := h.userService.GetProfile(session.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if notModified(w, r, result.Result.ETag()) {
+		return
+	}
+	writeJSON(w, http.StatusOK, userResponse{Status: "success", User: result.Result})
+}
+
+// handleProfileV2 returns the user of the token in the shape of API
+// version 2
+func (h *HTTPHandler) handleProfileV2(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+
+	result, err := h.userService.GetProfile(session.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if notModified(w, r, result.Result.ETag()) {
+		return
+	}
+	writeJSON(w, http.StatusOK, userV2Response{Status: "success", User: common.NewUserResultV2(result.Result)})
+}
+
+// notModified sets the ETag of a conditional read and answers 304 itself
+// when the request's If-None-Match names it. Caches have to revalidate the
+// private response before every reuse.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !common.ETagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// handleUpdateProfile changes the fields present in the body
+func (h *HTTPHandler) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+
+	var request tcp.ProfileUpdateRequest
+	if _, ok := decodeBody(w, r, &request); !ok {
+		return
+	}
+	if request.UserID != "" && request.UserID != session.UserID.String() {
+		writeError(w, entities.ErrForbidden.Errorf("userID does not match the authenticated user"))
+		return
+	}
+	if request.Username == nil && request.Email == nil && request.DisplayName == nil && request.Bio == nil {
+		writeError(w, entities.ErrInvalidInput.Errorf("at least one of username, email, displayName or bio is required"))
+		return
+	}
+
+	result, err := h.userService.UpdateProfile(&command.UpdateProfileCommand{
+		UserID:      session.UserID,
+		Username:    request.Username,
+		Email:       request.Email,
+		DisplayName: request.DisplayName,
+		Bio:         request.Bio,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profileUpdateResponse{
+		Status:             "success",
+		User:               result.Result,
+		EmailChangePending: result.EmailChangePending,
+	})
+}
+
+// handleListDevices returns the devices the user has logged in from
+func (h *HTTPHandler) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+
+	result, err := h.userService.ListDevices(session.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, devicesResponse{Status: "success", Devices: result.Result})
+}
+
+// handleRevokeDevice forgets a device so its next login is challenged again
+func (h *HTTPHandler) handleRevokeDevice(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+	deviceID, err := uuid.Parse(r.PathValue("deviceID"))
+	if err != nil {
+		writeError(w, entities.ErrInvalidInput.Errorf("invalid deviceID format"))
+		return
+	}
+
+	result, err := h.userService.RevokeDevice(&command.RevokeDeviceCommand{
+		UserID:   session.UserID,
+		DeviceID: deviceID,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{Status: "success", Message: result.Message})
+}
+
+// authenticate introspects the bearer token of r. It answers 401 itself and
+// reports false when the token is missing or not active.
+func (h *HTTPHandler) authenticate(w http.ResponseWriter, r *http.Request) (*query.TokenIntrospectionQueryResult, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, entities.ErrUnauthorized.Errorf("bearer token required"))
+		return nil, false
+	}
+
+	result, err := h.userService.IntrospectToken(&query.IntrospectTokenQuery{
+		Token:             token,
+		DeviceFingerprint: r.Header.Get("X-Device-Fingerprint"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+	if !result.Active {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, entities.ErrUnauthorized.Errorf("token is not active"))
+		return nil, false
+	}
+	return result, true
+}
+
+// decodeBody reads the JSON body of r into value and returns it as well. It
+// answers 400 itself and reports false when the body cannot be decoded.
+func decodeBody(w http.ResponseWriter, r *http.Request, value interface{}) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	if err != nil {
+		writeError(w, entities.ErrPayloadTooLarge.Errorf("request body exceeds %d bytes", maxRequestBodySize))
+		return nil, false
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		writeError(w, entities.ErrInvalidInput.Errorf("invalid input data: %v", err))
+		return nil, false
+	}
+	return body, true
+}
+
+// idempotencyKey scopes the Idempotency-Key header of r to its route and
+// body, like the TCP idempotency extension, "" without the header
+func idempotencyKey(r *http.Request, body []byte) string {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return ""
+	}
+
+	sum := sha256.New()
+	sum.Write([]byte(key))
+	sum.Write([]byte{0})
+	sum.Write(body)
+	return "http:" + r.URL.Path + ":" + hex.EncodeToString(sum.Sum(nil))
+}
+
+// clientIP is the address of the client r came from, without the port.
+// X-Forwarded-For is only believed from trusted proxies: it is read from the
+// right, past the proxies, up to the first address no proxy vouches for.
+func (h *HTTPHandler) clientIP(r *http.Request) string {
+	ip := peerIP(r)
+	if !h.trustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !h.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// clientCountry is the ISO country code a trusted proxy resolved for the
+// client of r, "" when none did
+func (h *HTTPHandler) clientCountry(r *http.Request) string {
+	if h.countryHeader == "" || !h.trustedProxy(peerIP(r)) {
+		return ""
+	}
+	country := strings.ToUpper(strings.TrimSpace(r.Header.Get(h.countryHeader)))
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return ""
+	}
+	return country
+}
+
+// trustedProxy reports whether ip belongs to a proxy of EnableTrustedProxies
+func (h *HTTPHandler) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range h.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP is the address of the connection r came on, without the port
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// problemTypePrefix makes the type URI of a problem from its error code
+const problemTypePrefix = "urn:user-service:problem:"
+
+// writeError answers with an RFC 7807 problem for err, its status and type
+// following from the error's code. Validation errors carry their violations
+// like on TCP. Errors without a code may tell of the database or other
+// internals, so they are logged and the client only learns they happened.
+func writeError(w http.ResponseWriter, err error) {
+	status := ErrorStatus(err)
+	code := entities.ErrorCode(err)
+	detail := err.Error()
+	if code == entities.CodeInternal {
+		log.Printf("HTTP internal error: %v", err)
+		detail = "internal error"
+	}
+	problem := problemResponse{
+		Type:   problemTypePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+	var validationErr *entities.ValidationError
+	if errors.As(err, &validationErr) {
+		problem.Errors = []*entities.ValidationError{validationErr}
+	}
+	if limit := entities.RateLimitOf(err); limit != nil {
+		problem.RateLimit = &rateLimitResponse{
+			Limit:     limit.Limit,
+			Remaining: limit.Remaining,
+			Reset:     limit.ResetSeconds(),
+		}
+		reset := strconv.FormatInt(limit.ResetSeconds(), 10)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+		w.Header().Set("X-RateLimit-Reset", reset)
+		w.Header().Set("Retry-After", reset)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Printf("Error writing HTTP response: %v", err)
+	}
+}
+
+// ErrorStatus maps the code of an error to an HTTP status; errors without
+// one are server errors
+func ErrorStatus(err error) int {
+	switch entities.ErrorCode(err) {
+	case entities.CodeInvalidInput:
+		return http.StatusBadRequest
+	case entities.CodeValidationFailed:
+		return http.StatusUnprocessableEntity
+	case entities.CodeUnauthorized,
+		entities.CodeInvalidCredentials,
+		entities.CodeInvalidOTP,
+		entities.CodeInvalidRecoveryCode:
+		return http.StatusUnauthorized
+	case entities.CodeForbidden, entities.CodeAccountInactive:
+		return http.StatusForbidden
+	case entities.CodeNotFound, entities.CodeUserNotFound:
+		return http.StatusNotFound
+	case entities.CodeConflict, entities.CodeUserExists, entities.CodeUsernameExists, entities.CodeEmailExists:
+		return http.StatusConflict
+	case entities.CodeExpired, entities.CodeOTPExpired, entities.CodeRegistrationExpired:
+		return http.StatusGone
+	case entities.CodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case entities.CodeRateLimited:
+		return http.StatusTooManyRequests
+	case entities.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
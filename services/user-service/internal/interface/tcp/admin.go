@@ -0,0 +1,185 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/application/query"
+	"user-service-new/internal/domain/entities"
+)
+
+// handleListUsers returns a page of users matching the request's filters,
+// with a cursor to the next page
+func (h *TCPHandler) handleListUsers(ctx context.Context, content []byte) (interface{}, error) {
+	var request AdminUsersListRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	listQuery := &query.ListUsersQuery{
+		Search:   request.Search,
+		Verified: request.Verified,
+		Sort:     request.Sort,
+		Cursor:   request.Cursor,
+		Limit:    request.Limit,
+	}
+	var err error
+	if listQuery.CreatedAfter, err = parseOptionalTime("createdAfter", request.CreatedAfter); err != nil {
+		return nil, err
+	}
+	if listQuery.CreatedBefore, err = parseOptionalTime("createdBefore", request.CreatedBefore); err != nil {
+		return nil, err
+	}
+
+	result, err := h.adminService.ListUsers(listQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error in listing users: %w", err)
+	}
+
+	return struct {
+		Status     string               `json:"status"`
+		Users      []*common.UserResult `json:"users"`
+		NextCursor string               `json:"nextCursor,omitempty"`
+	}{
+		Status:     "success",
+		Users:      result.Result,
+		NextCursor: result.NextCursor,
+	}, nil
+}
+
+// parseOptionalTime parses an RFC 3339 time, nil when value is empty
+func parseOptionalTime(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid %s format, expected RFC 3339: %v", name, err)
+	}
+	return &parsed, nil
+}
+
+// handleListSessions describes the unexpired tokens of a user
+func (h *TCPHandler) handleListSessions(ctx context.Context, content []byte) (interface{}, error) {
+	var request AdminUserSessionsRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.adminService.ListSessions(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error in listing sessions: %w", err)
+	}
+
+	return struct {
+		Status   string                  `json:"status"`
+		Sessions []*common.SessionResult `json:"sessions"`
+	}{
+		Status:   "success",
+		Sessions: result.Result,
+	}, nil
+}
+
+// handleForceLogout revokes every token of a user
+func (h *TCPHandler) handleForceLogout(ctx context.Context, content []byte) (interface{}, error) {
+	var request AdminUserLogoutRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.adminService.ForceLogout(&command.ForceLogoutCommand{
+		UserID:      userID,
+		RequestedBy: callerName(ctx),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in logging out user: %w", err)
+	}
+
+	return struct {
+		Status          string `json:"status"`
+		RevokedSessions int    `json:"revokedSessions"`
+	}{
+		Status:          "success",
+		RevokedSessions: result.RevokedSessions,
+	}, nil
+}
+
+// handleAuditTrail returns a page of a user's audit log, newest first, with
+// a cursor to the next page
+func (h *TCPHandler) handleAuditTrail(ctx context.Context, content []byte) (interface{}, error) {
+	var request AdminUserAuditRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.adminService.GetAuditTrail(&query.AuditTrailQuery{
+		UserID: userID,
+		Cursor: request.Cursor,
+		Limit:  request.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in listing audit events: %w", err)
+	}
+
+	return struct {
+		Status     string                     `json:"status"`
+		Events     []*common.AuditEventResult `json:"events"`
+		NextCursor string                     `json:"nextCursor,omitempty"`
+	}{
+		Status:     "success",
+		Events:     result.Result,
+		NextCursor: result.NextCursor,
+	}, nil
+}
+
+// handleResendVerification emails a pending registration its OTP again
+func (h *TCPHandler) handleResendVerification(ctx context.Context, content []byte) (interface{}, error) {
+	var request AdminVerificationResendRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	result, err := h.adminService.ResendVerification(&command.ResendVerificationCommand{
+		Email:       request.Email,
+		RequestedBy: callerName(ctx),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in resending verification: %w", err)
+	}
+
+	return struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: result.Message,
+	}, nil
+}
+
+// callerName names the authenticated caller for audit logs, empty if none
+func callerName(ctx context.Context) string {
+	if caller := callerFromContext(ctx); caller != nil {
+		return caller.Name
+	}
+	return ""
+}
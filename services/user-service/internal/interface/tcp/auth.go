@@ -0,0 +1,185 @@
+package tcp
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// privilegedMethods lists the methods that require an authenticated calling
+// service, by API key in the frame header or by client certificate.
+// Everything else stays reachable by anonymous callers, "profile" included
+// until the api-gateway, which calls it in version 1 frames without an API
+// key, moves to version 2.
+var privilegedMethods = map[string]bool{
+	"profile.update":            true,
+	"profile.avatar":            true,
+	"email.change":              true,
+	"email.confirm":             true,
+	"emails.list":               true,
+	"emails.add":                true,
+	"emails.confirm":            true,
+	"emails.primary":            true,
+	"emails.remove":             true,
+	"recovery.regenerate":       true,
+	"devices.list":              true,
+	"devices.revoke":            true,
+	"security.history":          true,
+	"terms.accept":              true,
+	"events.subscribe":          true,
+	"events.unsubscribe":        true,
+	"apikey.rotate":             true,
+	"token.introspect":          true,
+	"admin.user.status":         true,
+	"admin.users.list":          true,
+	"admin.user.sessions":       true,
+	"admin.user.logout":         true,
+	"admin.user.audit":          true,
+	"admin.verification.resend": true,
+	"invite.create":             true,
+	"account.upgrade":           true,
+	"account.upgrade.confirm":   true,
+}
+
+// isAdminMethod reports whether method belongs to the admin surface, which
+// EnableAdminServices can restrict to some callers
+func isAdminMethod(method string) bool {
+	return strings.HasPrefix(method, "admin.")
+}
+
+// EnableAdminServices restricts the admin methods to the named calling
+// services. Without it every authenticated caller may use them.
+func (h *TCPHandler) EnableAdminServices(services []string) {
+	h.adminServices = make(map[string]bool, len(services))
+	for _, service := range services {
+		h.adminServices[service] = true
+	}
+}
+
+// Caller is the authenticated service behind a privileged request
+type Caller struct {
+	// Name is the service identity handlers authorize against
+	Name string
+	// APIKey is set when the frame carried a valid API key
+	APIKey *entities.APIKey
+	// CertificateIdentity is the certificate name (URI or DNS SAN, or CN) the
+	// caller was identified by, empty without a client certificate
+	CertificateIdentity string
+	// EncryptionKeyID is the pre-shared key the caller's frames were
+	// encrypted with, empty without frame encryption
+	EncryptionKeyID string
+}
+
+// CertificateIdentities maps a certificate name (URI SAN, DNS SAN or CN) to
+// the service identity it authenticates as. An empty map accepts every
+// verified certificate under its own name.
+type CertificateIdentities map[string]string
+
+// ParseCertificateIdentities parses "name=service" pairs separated by commas,
+// e.g. "spiffe://corp/ns/orders=order-service,billing.internal=billing"
+func ParseCertificateIdentities(value string) (CertificateIdentities, error) {
+	identities := CertificateIdentities{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, service, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(name) == "" || strings.TrimSpace(service) == "" {
+			return nil, fmt.Errorf("invalid certificate identity %q, expected name=service", pair)
+		}
+		identities[strings.TrimSpace(name)] = strings.TrimSpace(service)
+	}
+	return identities, nil
+}
+
+// Resolve returns the service identity of a verified client certificate,
+// checking URI SANs, then DNS SANs, then the subject CN
+func (m CertificateIdentities) Resolve(cert *x509.Certificate) (*Caller, bool) {
+	var names []string
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+
+	for _, name := range names {
+		if len(m) == 0 {
+			return &Caller{Name: name, CertificateIdentity: name}, true
+		}
+		if service, ok := m[name]; ok {
+			return &Caller{Name: service, CertificateIdentity: name}, true
+		}
+	}
+	return nil, false
+}
+
+type callerKey struct{}
+
+type peerKey struct{}
+
+// withCaller attaches the authenticated calling service to the request context
+func withCaller(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// callerFromContext returns the authenticated calling service, if any
+func callerFromContext(ctx context.Context) *Caller {
+	caller, _ := ctx.Value(callerKey{}).(*Caller)
+	return caller
+}
+
+// withPeer attaches the identity of the connection's client certificate
+func withPeer(ctx context.Context, peer *Caller) context.Context {
+	if peer == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+func peerFromContext(ctx context.Context) *Caller {
+	peer, _ := ctx.Value(peerKey{}).(*Caller)
+	return peer
+}
+
+type apiKeyKey struct{}
+
+// withAPIKey attaches the API key the request's frame carried, for handlers
+// that dispatch further calls on the caller's behalf
+func withAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, apiKey)
+}
+
+func apiKeyFromContext(ctx context.Context) string {
+	apiKey, _ := ctx.Value(apiKeyKey{}).(string)
+	return apiKey
+}
+
+// authorize authenticates the caller of a privileged method. A client
+// certificate identifies the service on its own; an API key sent alongside it
+// is still validated so key-bound methods such as apikey.rotate work.
+func (h *TCPHandler) authorize(ctx context.Context, apiKey string) (*Caller, error) {
+	peer := peerFromContext(ctx)
+	if peer == nil && h.requireClientCert {
+		return nil, entities.ErrUnauthorized.Errorf("client certificate required")
+	}
+	if peer != nil && apiKey == "" {
+		return peer, nil
+	}
+
+	key, err := h.apiKeyService.ValidateAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if peer != nil {
+		caller := *peer
+		caller.APIKey = key
+		return &caller, nil
+	}
+	return &Caller{Name: key.Name, APIKey: key}, nil
+}
@@ -0,0 +1,160 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// maxBatchSize caps the sub-requests of a batch frame
+const maxBatchSize = 50
+
+// batchTask is a sub-request of a batch. It runs on whichever goroutine claims
+// it first, an idle worker or the worker handling the batch, so a batch
+// never waits on a worker pool that is busy with other batches.
+type batchTask struct {
+	claimed int32
+	run     func()
+	wg      *sync.WaitGroup
+}
+
+func (t *batchTask) tryRun() {
+	if atomic.CompareAndSwapInt32(&t.claimed, 0, 1) {
+		defer t.wg.Done()
+		t.run()
+	}
+}
+
+// batchResponse is the answer to one sub-request, in request order
+type batchResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Method   string          `json:"method"`
+	Response json.RawMessage `json:"response"`
+}
+
+// handleBatch runs the sub-requests of a "batch" call concurrently and
+// collects their responses. Each sub-request is authorized on its own with
+// the credentials of the enclosing frame.
+func (h *TCPHandler) handleBatch(ctx context.Context, content []byte) (interface{}, error) {
+	apiKey := apiKeyFromContext(ctx)
+
+	var requests []struct {
+		ID      string          `json:"id"`
+		Method  string          `json:"method"`
+		Content json.RawMessage `json:"content"`
+	}
+
+	if err := json.Unmarshal(content, &requests); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if len(requests) == 0 {
+		return nil, entities.ErrInvalidInput.Errorf("batch is empty")
+	}
+	if len(requests) > maxBatchSize {
+		return nil, entities.ErrInvalidInput.Errorf("batch has %d requests, at most %d are allowed", len(requests), maxBatchSize)
+	}
+
+	responses := make([]batchResponse, len(requests))
+	tasks := make([]*batchTask, len(requests))
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		responses[i] = batchResponse{ID: request.ID, Method: request.Method}
+
+		wg.Add(1)
+		tasks[i] = &batchTask{
+			wg: &wg,
+			run: func() {
+				responses[i].Response = h.runBatchRequest(ctx, apiKey, request.Method, request.Content)
+			},
+		}
+
+		// Offer the task to idle workers, a full queue leaves it to this one
+		select {
+		case h.batchQueue <- tasks[i]:
+		default:
+		}
+	}
+
+	// Run whatever no worker has picked up yet, then wait for the rest
+	for _, task := range tasks {
+		task.tryRun()
+	}
+	wg.Wait()
+
+	return struct {
+		Status    string          `json:"status"`
+		Responses []batchResponse `json:"responses"`
+	}{
+		Status:    "success",
+		Responses: responses,
+	}, nil
+}
+
+// runBatchRequest dispatches one sub-request and returns its response as the
+// JSON a standalone call would have received
+func (h *TCPHandler) runBatchRequest(ctx context.Context, apiKey, method string, content []byte) json.RawMessage {
+	var result interface{}
+	var err error
+	switch {
+	case methodName(method) == "batch":
+		err = entities.ErrInvalidInput.Errorf("batches cannot be nested")
+	case ctx.Err() != nil:
+		err = entities.ErrUnavailable.Errorf("batch timed out before %s ran", method)
+	default:
+		if len(content) == 0 {
+			content = []byte("{}")
+		}
+		result, err = h.dispatch(ctx, method, apiKey, content)
+	}
+
+	if err != nil {
+		result = errorBody(err)
+	}
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		jsonData, _ = json.Marshal(errorBody(fmt.Errorf("error marshaling response: %v", err)))
+	}
+	return jsonData
+}
+
+// errorBody is the payload sendError writes for err: its message and code,
+// the failed rules of a validation error and the exhausted quota of a rate
+// limited one
+func errorBody(err error) interface{} {
+	body := struct {
+		Status    string                      `json:"status"`
+		Code      string                      `json:"code"`
+		Message   string                      `json:"message"`
+		Errors    []*entities.ValidationError `json:"errors,omitempty"`
+		RateLimit *rateLimitBody              `json:"rateLimit,omitempty"`
+	}{
+		Status:  "error",
+		Code:    entities.ErrorCode(err),
+		Message: err.Error(),
+	}
+	var validationErr *entities.ValidationError
+	if errors.As(err, &validationErr) {
+		body.Errors = []*entities.ValidationError{validationErr}
+	}
+	if limit := entities.RateLimitOf(err); limit != nil {
+		body.RateLimit = &rateLimitBody{
+			Limit:     limit.Limit,
+			Remaining: limit.Remaining,
+			Reset:     limit.ResetSeconds(),
+		}
+	}
+	return body
+}
+
+// rateLimitBody tells a rate limited client its quota and when to retry
+type rateLimitBody struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	Reset     int64 `json:"reset"` // Seconds until another request is allowed
+}
@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+)
+
+const (
+	// responseBufferSize is the capacity of a fresh response buffer, enough
+	// for most responses
+	responseBufferSize = 4096
+	// maxPooledBufferSize keeps the buffers of rare large responses, avatars
+	// for instance, from being held by the pool
+	maxPooledBufferSize = 64 * 1024
+)
+
+// bufferPool recycles the buffers responses are framed in. A frame handed to
+// a connWriter belongs to it, and goes back to the pool once it is written.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, 0, responseBufferSize)
+		return &buffer
+	},
+}
+
+// rentBuffer returns an empty buffer from the pool
+func rentBuffer() []byte {
+	return (*bufferPool.Get().(*[]byte))[:0]
+}
+
+// returnBuffer puts buffer back in the pool. It must not be used afterwards.
+func returnBuffer(buffer []byte) {
+	if cap(buffer) == 0 || cap(buffer) > maxPooledBufferSize {
+		return
+	}
+	buffer = buffer[:0]
+	bufferPool.Put(&buffer)
+}
+
+// appendWriter lets json.Encoder write into a rented buffer
+type appendWriter []byte
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	*w = append(*w, p...)
+	return len(p), nil
+}
+
+// buildResponse frames result as the answer to request. The JSON is encoded
+// straight into a rented buffer behind the frame header, so the usual plain
+// JSON response takes no allocation of its own; version 3 responses that
+// have to be re-encoded, compressed or split into chunks are reframed by
+// createBinaryResponse.
+func (h *TCPHandler) buildResponse(request *frame, result interface{}) ([]byte, error) {
+	response := appendWriter(rentBuffer())
+	if request.version == protocolVersion3 {
+		response = appendV3Frame(response, request.requestID, 0, nil, nil)
+	} else {
+		response = append(response, magicByte1, magicByte2, protocolVersion)
+		response = append(response, request.requestID...)
+		response = binary.LittleEndian.AppendUint32(response, 0)
+	}
+	headerLen := len(response)
+
+	if err := json.NewEncoder(&response).Encode(result); err != nil {
+		returnBuffer(response)
+		return nil, err
+	}
+	// Encode terminates the value with a newline, which Marshal does not
+	response = response[:len(response)-1]
+	jsonData := response[headerLen:]
+
+	if request.version == protocolVersion3 && h.needsReframing(request, jsonData) {
+		reframed := h.createBinaryResponse(request, jsonData)
+		returnBuffer(response)
+		return reframed, nil
+	}
+
+	binary.LittleEndian.PutUint32(response[headerLen-contentLenSize:headerLen], uint32(len(jsonData)))
+	return response, nil
+}
+
+// needsReframing reports whether a version 3 response differs from plain
+// JSON in a single frame
+func (h *TCPHandler) needsReframing(request *frame, jsonData []byte) bool {
+	if request.flags.encoding() != encodingJSON || request.cipher != nil {
+		return true
+	}
+	if responseCompression(request) != compressionNone && len(jsonData) >= compressionMinSize {
+		return true
+	}
+	limit := maxBufferSize
+	if request.responseLimit > 0 {
+		limit = request.responseLimit
+	}
+	return v3ResponseOverhead+len(jsonData) > limit
+}
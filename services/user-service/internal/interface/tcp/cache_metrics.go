@@ -0,0 +1,14 @@
+package tcp
+
+// EnableCacheMetrics makes GetMetrics report the cache statistics metrics
+// returns, by cache namespace
+func (h *TCPHandler) EnableCacheMetrics(metrics func() map[string]interface{}) {
+	h.cacheMetrics = metrics
+}
+
+func (h *TCPHandler) cacheStats() map[string]interface{} {
+	if h.cacheMetrics == nil {
+		return map[string]interface{}{}
+	}
+	return h.cacheMetrics()
+}
@@ -0,0 +1,20 @@
+package tcp
+
+import "user-service-new/internal/infrastructure"
+
+// EnableCircuitBreakerMetrics makes GetMetrics report the circuit breaker
+// whose stats returns, under name
+func (h *TCPHandler) EnableCircuitBreakerMetrics(name string, stats func() infrastructure.CircuitBreakerStats) {
+	if h.circuitBreakers == nil {
+		h.circuitBreakers = make(map[string]func() infrastructure.CircuitBreakerStats)
+	}
+	h.circuitBreakers[name] = stats
+}
+
+func (h *TCPHandler) circuitBreakerStats() map[string]infrastructure.CircuitBreakerStats {
+	stats := make(map[string]infrastructure.CircuitBreakerStats, len(h.circuitBreakers))
+	for name, breakerStats := range h.circuitBreakers {
+		stats[name] = breakerStats()
+	}
+	return stats
+}
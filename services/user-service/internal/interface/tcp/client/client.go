@@ -0,0 +1,146 @@
+// Package client calls the user-service over its TCP protocol. The typed
+// method stubs are generated from the schema of the server's methods, see
+// cmd/tcpgen.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	magicByte1       = 0x55
+	magicByte2       = 0x57
+	protocolVersion2 = 0x02 // Requests carry the API key, responses use the version 1 layout
+	maxContentSize   = 10 * 1024 * 1024
+)
+
+// Client sends requests over one connection, one at a time
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	apiKey string
+}
+
+// Dial connects to the server at addr. apiKey authorizes privileged methods
+// and may be empty for public ones.
+func Dial(ctx context.Context, addr, apiKey string) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, reader: bufio.NewReader(conn), apiKey: apiKey}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Error is a response with the status "error"
+type Error struct {
+	Code      string          `json:"code"` // Stable error code, e.g. user_not_found
+	Message   string          `json:"message"`
+	Errors    json.RawMessage `json:"errors,omitempty"`    // Validation errors, if any
+	RateLimit *RateLimit      `json:"rateLimit,omitempty"` // Set on rate_limited errors
+}
+
+// RateLimit is the quota a rate limited call ran out of
+type RateLimit struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	Reset     int64 `json:"reset"` // Seconds until another call is allowed
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Call sends request as the JSON content of method and returns the response
+// content. A response with the status "error" is returned as an *Error.
+func (c *Client) Call(ctx context.Context, method string, request interface{}) (json.RawMessage, error) {
+	content, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(method) > 255 || len(c.apiKey) > 255 {
+		return nil, fmt.Errorf("method name and API key are limited to 255 bytes")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	c.conn.SetDeadline(deadline)
+
+	requestID := uuid.New()
+	frame := []byte{magicByte1, magicByte2, protocolVersion2}
+	frame = append(frame, requestID[:]...)
+	frame = append(frame, byte(len(method)))
+	frame = append(frame, method...)
+	frame = append(frame, byte(len(c.apiKey)))
+	frame = append(frame, c.apiKey...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(content)))
+	frame = append(frame, content...)
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	response, err := c.readResponse(requestID[:])
+	if err != nil {
+		// The connection is out of step with the server, don't reuse it
+		c.conn.Close()
+		return nil, err
+	}
+
+	var status struct {
+		Status string `json:"status"`
+		Error
+	}
+	if err := json.Unmarshal(response, &status); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if status.Status == "error" {
+		return nil, &status.Error
+	}
+	return response, nil
+}
+
+// readResponse reads the response to requestID, a version 1 frame
+func (c *Client) readResponse(requestID []byte) ([]byte, error) {
+	header := make([]byte, 3+len(requestID)+4)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+	if header[0] != magicByte1 || header[1] != magicByte2 {
+		return nil, fmt.Errorf("invalid magic bytes in response")
+	}
+	if !bytes.Equal(header[3:3+len(requestID)], requestID) {
+		return nil, fmt.Errorf("response for another request")
+	}
+
+	contentLen := binary.LittleEndian.Uint32(header[3+len(requestID):])
+	if contentLen > maxContentSize {
+		return nil, fmt.Errorf("response of %d bytes exceeds the %d byte limit", contentLen, maxContentSize)
+	}
+	content := make([]byte, contentLen)
+	if _, err := io.ReadFull(c.reader, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
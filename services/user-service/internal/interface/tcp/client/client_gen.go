@@ -0,0 +1,210 @@
+// Code generated by tcpgen from methods.json. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	"user-service-new/internal/interface/tcp"
+)
+
+// Ping calls "ping"
+func (c *Client) Ping(ctx context.Context) (json.RawMessage, error) {
+	return c.Call(ctx, "ping", struct{}{})
+}
+
+// Health calls "health"
+func (c *Client) Health(ctx context.Context) (json.RawMessage, error) {
+	return c.Call(ctx, "health", struct{}{})
+}
+
+// Batch calls "batch"
+func (c *Client) Batch(ctx context.Context, content json.RawMessage) (json.RawMessage, error) {
+	return c.Call(ctx, "batch", content)
+}
+
+// Register calls "register"
+func (c *Client) Register(ctx context.Context, request *tcp.RegisterRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "register", request)
+}
+
+// Verify calls "verify"
+func (c *Client) Verify(ctx context.Context, request *tcp.VerifyRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "verify", request)
+}
+
+// OtpResend calls "otp.resend"
+func (c *Client) OtpResend(ctx context.Context, request *tcp.OtpResendRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "otp.resend", request)
+}
+
+// AccountGuest calls "account.guest"
+func (c *Client) AccountGuest(ctx context.Context, request *tcp.AccountGuestRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "account.guest", request)
+}
+
+// AccountUpgrade calls "account.upgrade"
+func (c *Client) AccountUpgrade(ctx context.Context, request *tcp.AccountUpgradeRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "account.upgrade", request)
+}
+
+// AccountUpgradeConfirm calls "account.upgrade.confirm"
+func (c *Client) AccountUpgradeConfirm(ctx context.Context, request *tcp.AccountUpgradeConfirmRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "account.upgrade.confirm", request)
+}
+
+// InviteCreate calls "invite.create"
+func (c *Client) InviteCreate(ctx context.Context, request *tcp.InviteCreateRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "invite.create", request)
+}
+
+// TermsAccept calls "terms.accept"
+func (c *Client) TermsAccept(ctx context.Context, request *tcp.TermsAcceptRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "terms.accept", request)
+}
+
+// Login calls "login"
+func (c *Client) Login(ctx context.Context, request *tcp.LoginRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "login", request)
+}
+
+// LoginVerify calls "login.verify"
+func (c *Client) LoginVerify(ctx context.Context, request *tcp.LoginVerifyRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "login.verify", request)
+}
+
+// RecoveryRegenerate calls "recovery.regenerate"
+func (c *Client) RecoveryRegenerate(ctx context.Context, request *tcp.RecoveryRegenerateRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "recovery.regenerate", request)
+}
+
+// Auth calls "auth"
+func (c *Client) Auth(ctx context.Context, request *tcp.AuthRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "auth", request)
+}
+
+// Jwks calls "jwks"
+func (c *Client) Jwks(ctx context.Context) (json.RawMessage, error) {
+	return c.Call(ctx, "jwks", struct{}{})
+}
+
+// TokenIntrospect calls "token.introspect"
+func (c *Client) TokenIntrospect(ctx context.Context, request *tcp.TokenIntrospectRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "token.introspect", request)
+}
+
+// ApikeyRotate calls "apikey.rotate"
+func (c *Client) ApikeyRotate(ctx context.Context) (json.RawMessage, error) {
+	return c.Call(ctx, "apikey.rotate", struct{}{})
+}
+
+// Profile calls "profile"
+func (c *Client) Profile(ctx context.Context, request *tcp.ProfileRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "profile", request)
+}
+
+// ProfileV2 calls "v2/profile"
+func (c *Client) ProfileV2(ctx context.Context, request *tcp.ProfileV2Request) (json.RawMessage, error) {
+	return c.Call(ctx, "v2/profile", request)
+}
+
+// ProfileUpdate calls "profile.update"
+func (c *Client) ProfileUpdate(ctx context.Context, request *tcp.ProfileUpdateRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "profile.update", request)
+}
+
+// ProfileAvatar calls "profile.avatar"
+func (c *Client) ProfileAvatar(ctx context.Context, request *tcp.ProfileAvatarRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "profile.avatar", request)
+}
+
+// EmailChange calls "email.change"
+func (c *Client) EmailChange(ctx context.Context, request *tcp.EmailChangeRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "email.change", request)
+}
+
+// EmailConfirm calls "email.confirm"
+func (c *Client) EmailConfirm(ctx context.Context, request *tcp.EmailConfirmRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "email.confirm", request)
+}
+
+// EmailsList calls "emails.list"
+func (c *Client) EmailsList(ctx context.Context, request *tcp.EmailsListRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "emails.list", request)
+}
+
+// EmailsAdd calls "emails.add"
+func (c *Client) EmailsAdd(ctx context.Context, request *tcp.EmailsAddRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "emails.add", request)
+}
+
+// EmailsConfirm calls "emails.confirm"
+func (c *Client) EmailsConfirm(ctx context.Context, request *tcp.EmailsConfirmRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "emails.confirm", request)
+}
+
+// EmailsPrimary calls "emails.primary"
+func (c *Client) EmailsPrimary(ctx context.Context, request *tcp.EmailsPrimaryRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "emails.primary", request)
+}
+
+// EmailsRemove calls "emails.remove"
+func (c *Client) EmailsRemove(ctx context.Context, request *tcp.EmailsRemoveRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "emails.remove", request)
+}
+
+// DevicesList calls "devices.list"
+func (c *Client) DevicesList(ctx context.Context, request *tcp.DevicesListRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "devices.list", request)
+}
+
+// DevicesRevoke calls "devices.revoke"
+func (c *Client) DevicesRevoke(ctx context.Context, request *tcp.DevicesRevokeRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "devices.revoke", request)
+}
+
+// SecurityHistory calls "security.history"
+func (c *Client) SecurityHistory(ctx context.Context, request *tcp.SecurityHistoryRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "security.history", request)
+}
+
+// EventsSubscribe calls "events.subscribe"
+func (c *Client) EventsSubscribe(ctx context.Context, request *tcp.EventsSubscribeRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "events.subscribe", request)
+}
+
+// EventsUnsubscribe calls "events.unsubscribe"
+func (c *Client) EventsUnsubscribe(ctx context.Context, request *tcp.EventsUnsubscribeRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "events.unsubscribe", request)
+}
+
+// AdminUserStatus calls "admin.user.status"
+func (c *Client) AdminUserStatus(ctx context.Context, request *tcp.AdminUserStatusRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "admin.user.status", request)
+}
+
+// AdminUsersList calls "admin.users.list"
+func (c *Client) AdminUsersList(ctx context.Context, request *tcp.AdminUsersListRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "admin.users.list", request)
+}
+
+// AdminUserSessions calls "admin.user.sessions"
+func (c *Client) AdminUserSessions(ctx context.Context, request *tcp.AdminUserSessionsRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "admin.user.sessions", request)
+}
+
+// AdminUserLogout calls "admin.user.logout"
+func (c *Client) AdminUserLogout(ctx context.Context, request *tcp.AdminUserLogoutRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "admin.user.logout", request)
+}
+
+// AdminUserAudit calls "admin.user.audit"
+func (c *Client) AdminUserAudit(ctx context.Context, request *tcp.AdminUserAuditRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "admin.user.audit", request)
+}
+
+// AdminVerificationResend calls "admin.verification.resend"
+func (c *Client) AdminVerificationResend(ctx context.Context, request *tcp.AdminVerificationResendRequest) (json.RawMessage, error) {
+	return c.Call(ctx, "admin.verification.resend", request)
+}
@@ -0,0 +1,142 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"user-service-new/internal/interface/tcp/pb"
+
+	"user-service-new/internal/domain/entities"
+)
+
+const (
+	encodingMsgPack  byte = 1
+	encodingProtobuf byte = 2
+)
+
+// payloadCodec translates between a payload encoding and the JSON the
+// method handlers read and write
+type payloadCodec interface {
+	// decodeRequest converts the request content of method to JSON
+	decodeRequest(method string, data []byte) ([]byte, error)
+	// encodeResponse converts the JSON response of method to this encoding
+	encodeResponse(method string, jsonData []byte) ([]byte, error)
+}
+
+// defaultCodecs is the codec registry of a new TCPHandler, keyed by the
+// encoding bits of the version 3 flags byte
+func defaultCodecs() map[byte]payloadCodec {
+	return map[byte]payloadCodec{
+		encodingJSON:     jsonCodec{},
+		encodingMsgPack:  msgpackCodec{},
+		encodingProtobuf: newProtobufCodec(),
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) decodeRequest(method string, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (jsonCodec) encodeResponse(method string, jsonData []byte) ([]byte, error) {
+	return jsonData, nil
+}
+
+// msgpackCodec carries any method's payload as MessagePack
+type msgpackCodec struct{}
+
+func (msgpackCodec) decodeRequest(method string, data []byte) ([]byte, error) {
+	jsonData, err := msgpackToJSON(data)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid MessagePack payload: %v", err)
+	}
+	return jsonData, nil
+}
+
+func (msgpackCodec) encodeResponse(method string, jsonData []byte) ([]byte, error) {
+	return jsonToMsgpack(jsonData)
+}
+
+// protobufMessages are the request and response types of a method
+type protobufMessages struct {
+	request  func() pb.Message
+	response func() pb.Message
+}
+
+// protobufCodec serves the methods that have messages in pb/user_service.proto,
+// in API version 1
+type protobufCodec struct {
+	methods map[string]protobufMessages
+}
+
+func newProtobufCodec() *protobufCodec {
+	return &protobufCodec{
+		methods: map[string]protobufMessages{
+			"register": {
+				request:  func() pb.Message { return &pb.RegisterRequest{} },
+				response: func() pb.Message { return &pb.RegisterResponse{} },
+			},
+			"login": {
+				request:  func() pb.Message { return &pb.LoginRequest{} },
+				response: func() pb.Message { return &pb.LoginResponse{} },
+			},
+			"profile": {
+				request:  func() pb.Message { return &pb.ProfileRequest{} },
+				response: func() pb.Message { return &pb.ProfileResponse{} },
+			},
+			"verify": {
+				request:  func() pb.Message { return &pb.VerifyOTPRequest{} },
+				response: func() pb.Message { return &pb.VerifyOTPResponse{} },
+			},
+			"otp.resend": {
+				request:  func() pb.Message { return &pb.SendOTPRequest{} },
+				response: func() pb.Message { return &pb.SendOTPResponse{} },
+			},
+		},
+	}
+}
+
+func (c *protobufCodec) messages(method string) (protobufMessages, error) {
+	name, version, err := splitMethod(method)
+	if err != nil {
+		return protobufMessages{}, err
+	}
+	messages, ok := c.methods[versionedMethod(name, version)]
+	if !ok {
+		return protobufMessages{}, entities.ErrInvalidInput.Errorf("protobuf encoding is not available for method %s", method)
+	}
+	return messages, nil
+}
+
+func (c *protobufCodec) decodeRequest(method string, data []byte) ([]byte, error) {
+	messages, err := c.messages(method)
+	if err != nil {
+		return nil, err
+	}
+	request := messages.request()
+	if err := request.Unmarshal(data); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid protobuf payload: %v", err)
+	}
+	return json.Marshal(request)
+}
+
+func (c *protobufCodec) encodeResponse(method string, jsonData []byte) ([]byte, error) {
+	messages, err := c.messages(method)
+	if err != nil {
+		return nil, err
+	}
+	response := messages.response()
+	if err := json.Unmarshal(jsonData, response); err != nil {
+		return nil, err
+	}
+	return response.Marshal(), nil
+}
+
+// unmarshalJSONNumbers decodes JSON keeping numbers as json.Number, so
+// integers survive the trip into other encodings exactly
+func unmarshalJSONNumbers(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
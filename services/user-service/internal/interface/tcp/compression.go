@@ -0,0 +1,112 @@
+package tcp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"user-service-new/internal/domain/entities"
+)
+
+const (
+	compressionGzip byte = 1
+	// compressionZstd is reserved for zstd, which this build does not include
+	compressionZstd byte = 2
+
+	// compressionMinSize is the smallest response worth compressing, below it
+	// the gzip header and CPU time outweigh the saving
+	compressionMinSize = 1024
+)
+
+// Writers and readers keep sizeable internal state, reuse them across frames
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+			return w
+		},
+	}
+	gzipReaderPool sync.Pool
+)
+
+// compressionSupported reports whether payloads compressed with compression
+// can be read and written
+func compressionSupported(compression byte) bool {
+	return compression == compressionNone || compression == compressionGzip
+}
+
+// compressPayload compresses data with compression
+func compressPayload(compression byte, data []byte) ([]byte, error) {
+	switch compression {
+	case compressionNone:
+		return data, nil
+	case compressionGzip:
+		var buf bytes.Buffer
+		buf.Grow(len(data) / 2)
+
+		w := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(w)
+		w.Reset(&buf)
+
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, entities.ErrInvalidInput.Errorf("unsupported compression: %d", compression)
+}
+
+// decompressPayload reverses compressPayload. The output is capped at
+// maxBufferSize so a small frame cannot expand into an unbounded allocation.
+func decompressPayload(compression byte, data []byte) ([]byte, error) {
+	switch compression {
+	case compressionNone:
+		return data, nil
+	case compressionGzip:
+		var r *gzip.Reader
+		if pooled, ok := gzipReaderPool.Get().(*gzip.Reader); ok {
+			r = pooled
+			if err := r.Reset(bytes.NewReader(data)); err != nil {
+				return nil, entities.ErrInvalidInput.Errorf("invalid gzip payload: %v", err)
+			}
+		} else {
+			var err error
+			if r, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+				return nil, entities.ErrInvalidInput.Errorf("invalid gzip payload: %v", err)
+			}
+		}
+		defer gzipReaderPool.Put(r)
+
+		decompressed, err := io.ReadAll(io.LimitReader(r, maxBufferSize+1))
+		if err != nil {
+			return nil, entities.ErrInvalidInput.Errorf("invalid gzip payload: %v", err)
+		}
+		if len(decompressed) > maxBufferSize {
+			return nil, entities.ErrPayloadTooLarge.Errorf("decompressed payload exceeds the %d byte limit", maxBufferSize)
+		}
+		return decompressed, nil
+	}
+	return nil, entities.ErrInvalidInput.Errorf("unsupported compression: %d", compression)
+}
+
+// responseCompression picks the compression for the answer to request: the
+// one the request itself used, else the first supported code the client
+// listed in its accept-compression extension
+func responseCompression(request *frame) byte {
+	if request == nil || request.version != protocolVersion3 {
+		return compressionNone
+	}
+	if request.flags.compression() != compressionNone {
+		return request.flags.compression()
+	}
+	for _, compression := range request.extensions[extensionAcceptCompression] {
+		if compression != compressionNone && compressionSupported(compression) {
+			return compression
+		}
+	}
+	return compressionNone
+}
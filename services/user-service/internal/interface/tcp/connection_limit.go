@@ -0,0 +1,75 @@
+package tcp
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ipConnectionLimiter counts the open connections of every source IP, so one
+// misbehaving client cannot take the whole connection pool
+type ipConnectionLimiter struct {
+	mu     sync.Mutex
+	max    int // Zero disables the cap
+	counts map[string]int
+}
+
+func newIPConnectionLimiter(max int) *ipConnectionLimiter {
+	return &ipConnectionLimiter{max: max, counts: make(map[string]int)}
+}
+
+// acquire takes a connection slot for ip, reporting false when it has none left
+func (l *ipConnectionLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.counts[ip] >= l.max {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+func (l *ipConnectionLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[ip] <= 1 {
+		delete(l.counts, ip)
+		return
+	}
+	l.counts[ip]--
+}
+
+// remoteIP is the address a connection came from, without the port
+func remoteIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// denyConnection tells a client over its address's connection cap why it is
+// being disconnected. The client has sent nothing yet, so the answer is a
+// version 1 frame with the all-zero ID.
+func (h *TCPHandler) denyConnection(conn net.Conn, ip string) {
+	atomic.AddUint64(&h.metrics.deniedConnections, 1)
+	log.Printf("Refusing connection from %s, it has %d open connections", ip, h.ipLimiter.max)
+
+	jsonData, _ := json.Marshal(map[string]string{
+		"status":  "error",
+		"message": "Too many connections from this address",
+	})
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	response := h.createBinaryResponse(nil, jsonData)
+	conn.Write(response)
+	returnBuffer(response)
+}
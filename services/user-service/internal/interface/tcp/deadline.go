@@ -0,0 +1,34 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"time"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// timeoutExtensionSize is the size of the timeout extension value, a little
+// endian count of milliseconds
+const timeoutExtensionSize = 4
+
+// requestDeadline is when the client of request stops waiting for the answer,
+// counted from received. Requests without the timeout extension have no
+// deadline in the queue and get handlerTimeout once a worker takes them.
+func (h *TCPHandler) requestDeadline(request *frame, received time.Time) (time.Time, error) {
+	value, ok := request.extensions[extensionTimeout]
+	if !ok {
+		return time.Time{}, nil
+	}
+	if len(value) != timeoutExtensionSize {
+		return time.Time{}, entities.ErrInvalidInput.Errorf("invalid timeout extension: %d bytes", len(value))
+	}
+
+	timeout := time.Duration(binary.LittleEndian.Uint32(value)) * time.Millisecond
+	if timeout == 0 {
+		return time.Time{}, entities.ErrInvalidInput.Errorf("timeout must be positive")
+	}
+	if timeout > h.maxRequestTimeout {
+		timeout = h.maxRequestTimeout
+	}
+	return received.Add(timeout), nil
+}
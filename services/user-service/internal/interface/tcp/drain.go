@@ -0,0 +1,137 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// drainPollInterval is how often Stop checks whether the queues are empty
+const drainPollInterval = 10 * time.Millisecond
+
+// goAwayNotice is the content of the frame sent to every client on shutdown
+type goAwayNotice struct {
+	Type         string `json:"type"`
+	Reason       string `json:"reason"`
+	DrainTimeout int64  `json:"drainTimeout"` // Milliseconds until the connection is closed
+}
+
+// trackedConnection is an open connection Stop has to notify and close
+type trackedConnection struct {
+	conn     net.Conn
+	writer   *connWriter
+	speaksV3 int32 // Atomic, set once the client sent a version 3 frame
+}
+
+// connectionSet holds the open connections
+type connectionSet struct {
+	mu          sync.Mutex
+	connections map[net.Conn]*trackedConnection
+}
+
+func newConnectionSet() *connectionSet {
+	return &connectionSet{connections: make(map[net.Conn]*trackedConnection)}
+}
+
+func (s *connectionSet) add(w *connWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections[w.conn] = &trackedConnection{conn: w.conn, writer: w}
+}
+
+func (s *connectionSet) remove(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.connections, conn)
+}
+
+// markV3 records that the client of conn reads version 3 frames
+func (s *connectionSet) markV3(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tracked, ok := s.connections[conn]; ok {
+		atomic.StoreInt32(&tracked.speaksV3, 1)
+	}
+}
+
+func (s *connectionSet) snapshot() []*trackedConnection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tracked := make([]*trackedConnection, 0, len(s.connections))
+	for _, connection := range s.connections {
+		tracked = append(tracked, connection)
+	}
+	return tracked
+}
+
+// closeAll flushes and closes every open connection, which ends their readers
+func (s *connectionSet) closeAll() {
+	var wg sync.WaitGroup
+	for _, tracked := range s.snapshot() {
+		wg.Add(1)
+		go func(tracked *trackedConnection) {
+			defer wg.Done()
+			tracked.writer.close()
+			tracked.conn.Close()
+		}(tracked)
+	}
+	wg.Wait()
+}
+
+// isDraining reports whether Stop has begun, after which new frames are refused
+func (h *TCPHandler) isDraining() bool {
+	select {
+	case <-h.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain sends every client a GOAWAY frame and waits, up to drainTimeout, for
+// the queued and running requests to finish
+func (h *TCPHandler) drain() {
+	notice, _ := json.Marshal(goAwayNotice{
+		Type:         "goaway",
+		Reason:       "shutdown",
+		DrainTimeout: h.drainTimeout.Milliseconds(),
+	})
+
+	for _, tracked := range h.connections.snapshot() {
+		tracked.writer.write(createGoAwayFrame(atomic.LoadInt32(&tracked.speaksV3) == 1, notice))
+	}
+
+	deadline := time.Now().Add(h.drainTimeout)
+	for h.pendingRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	if pending := h.pendingRequests(); pending > 0 {
+		log.Printf("Drain timeout reached, abandoning %d requests", pending)
+	}
+}
+
+// pendingRequests counts the queued and running requests
+func (h *TCPHandler) pendingRequests() int {
+	return len(h.messageQueue) + len(h.priorityQueue) + len(h.batchQueue) + int(atomic.LoadInt32(&h.activeRequests))
+}
+
+// createGoAwayFrame builds the shutdown notice. Like other server initiated
+// frames it carries the all-zero ID; version 3 clients get a push frame, older
+// ones the version 1 response layout.
+func createGoAwayFrame(v3 bool, jsonData []byte) []byte {
+	if v3 {
+		return createPushFrame(uuid.Nil, jsonData, nil)
+	}
+
+	frame := append(rentBuffer(), magicByte1, magicByte2, protocolVersion)
+	frame = append(frame, uuid.Nil[:]...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(jsonData)))
+	return append(frame, jsonData...)
+}
@@ -0,0 +1,195 @@
+package tcp
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"user-service-new/internal/domain/entities"
+)
+
+const (
+	// encryptionChaCha20Poly1305 is the name a hello asks for frame encryption with
+	encryptionChaCha20Poly1305 = "chacha20-poly1305"
+	// sessionNonceSize is the size of the random nonce each side contributes
+	// to the session keys
+	sessionNonceSize = 16
+	// counterExtensionSize is the size of the encryption extension value, the
+	// little endian counter the frame's nonce is made of
+	counterExtensionSize = 8
+)
+
+// Labels that derive one session key per direction, so the two sides never
+// use the same key and nonce
+var (
+	clientToServerLabel = []byte("user-service tcp client to server")
+	serverToClientLabel = []byte("user-service tcp server to client")
+)
+
+// EncryptionKeys maps the key IDs callers name in their hello to their
+// pre-shared 32 byte keys. A key ID is the service identity of its caller.
+type EncryptionKeys map[string][]byte
+
+// ParseEncryptionKeys reads TCP_ENCRYPTION_KEYS, a comma separated list of
+// service=key pairs with base64 encoded 32 byte keys
+func ParseEncryptionKeys(value string) (EncryptionKeys, error) {
+	keys := EncryptionKeys{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		service, encoded, found := strings.Cut(pair, "=")
+		service = strings.TrimSpace(service)
+		if !found || service == "" {
+			return nil, fmt.Errorf("invalid encryption key %q, expected service=base64 key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key for %s: %v", service, err)
+		}
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("encryption key for %s has %d bytes, %d are needed", service, len(key), chacha20poly1305.KeySize)
+		}
+		keys[service] = key
+	}
+	return keys, nil
+}
+
+// EnableEncryption lets the callers holding one of keys negotiate encrypted
+// frame content in their hello, for deployments that cannot serve TLS
+func (h *TCPHandler) EnableEncryption(keys EncryptionKeys) {
+	h.encryptionKeys = keys
+}
+
+// frameCipher encrypts the content of the frames of one connection. Every
+// frame carries its own counter in the encryption extension; counters of
+// received frames have to increase, so a frame cannot be replayed on the
+// connection, and sent counters are never reused.
+type frameCipher struct {
+	caller   *Caller
+	receive  cipher.AEAD // Client to server
+	send     cipher.AEAD // Server to client
+	received uint64      // Counter of the last frame opened, only the reader touches it
+	sent     uint64      // Atomic, workers and pushes seal concurrently
+}
+
+// newFrameCipher derives the session keys of keyID's pre-shared key from the
+// nonces of both sides
+func newFrameCipher(keyID string, key, clientNonce, serverNonce []byte) (*frameCipher, error) {
+	derive := func(label []byte) (cipher.AEAD, error) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(label)
+		mac.Write(clientNonce)
+		mac.Write(serverNonce)
+		return chacha20poly1305.New(mac.Sum(nil))
+	}
+
+	receive, err := derive(clientToServerLabel)
+	if err != nil {
+		return nil, err
+	}
+	send, err := derive(serverToClientLabel)
+	if err != nil {
+		return nil, err
+	}
+	return &frameCipher{
+		caller:  &Caller{Name: keyID, EncryptionKeyID: keyID},
+		receive: receive,
+		send:    send,
+	}, nil
+}
+
+// negotiateEncryption sets up the cipher a hello asked for and fills in the
+// server's side of the response
+func (h *TCPHandler) negotiateEncryption(hello helloRequest, response *helloResponse) (*frameCipher, error) {
+	if hello.Encryption != encryptionChaCha20Poly1305 {
+		return nil, entities.ErrInvalidInput.Errorf("unsupported encryption: %s, the server supports %s", hello.Encryption, encryptionChaCha20Poly1305)
+	}
+	key, ok := h.encryptionKeys[hello.KeyID]
+	if !ok {
+		return nil, entities.ErrUnauthorized.Errorf("unauthorized: unknown encryption key %q", hello.KeyID)
+	}
+	if len(hello.Nonce) != sessionNonceSize {
+		return nil, entities.ErrInvalidInput.Errorf("encryption needs a %d byte nonce", sessionNonceSize)
+	}
+
+	serverNonce := make([]byte, sessionNonceSize)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+	frameCipher, err := newFrameCipher(hello.KeyID, key, hello.Nonce, serverNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Encryption = encryptionChaCha20Poly1305
+	response.Nonce = serverNonce
+	return frameCipher, nil
+}
+
+// open decrypts the content of request in place of the ciphertext. The
+// request ID and method are authenticated along with it.
+func (c *frameCipher) open(request *frame) error {
+	value, ok := request.extensions[extensionEncryption]
+	if !ok {
+		return entities.ErrInvalidInput.Errorf("frames have to be encrypted after the hello")
+	}
+	if len(value) != counterExtensionSize {
+		return entities.ErrInvalidInput.Errorf("invalid encryption extension: %d bytes", len(value))
+	}
+	counter := binary.LittleEndian.Uint64(value)
+	if counter <= c.received {
+		return entities.ErrInvalidInput.Errorf("encryption counter %d was already used", counter)
+	}
+
+	content, err := c.receive.Open(nil, frameNonce(counter), request.content, additionalData(request.requestID, []byte(request.method)))
+	if err != nil {
+		return entities.ErrInvalidInput.Errorf("frame failed to decrypt")
+	}
+	c.received = counter
+	request.content = content
+	return nil
+}
+
+// seal encrypts the content of a server frame with the ID id. It returns the
+// ciphertext and the extension area that has to go with it.
+func (c *frameCipher) seal(id, content []byte) ([]byte, []byte) {
+	counter := atomic.AddUint64(&c.sent, 1)
+
+	extension := make([]byte, 0, extensionHeaderSize+counterExtensionSize)
+	extension = append(extension, extensionEncryption)
+	extension = binary.LittleEndian.AppendUint16(extension, counterExtensionSize)
+	extension = binary.LittleEndian.AppendUint64(extension, counter)
+
+	return c.send.Seal(nil, frameNonce(counter), content, additionalData(id)), extension
+}
+
+// additionalData authenticates fields along with frame content, each after
+// its little endian uint16 length, so no two lists of fields run together
+func additionalData(fields ...[]byte) []byte {
+	size := 0
+	for _, field := range fields {
+		size += 2 + len(field)
+	}
+	data := make([]byte, 0, size)
+	for _, field := range fields {
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(field)))
+		data = append(data, field...)
+	}
+	return data
+}
+
+// frameNonce is the AEAD nonce of a frame: four zero bytes and the counter
+func frameNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[chacha20poly1305.NonceSize-counterExtensionSize:], counter)
+	return nonce
+}
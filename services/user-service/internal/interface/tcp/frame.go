@@ -0,0 +1,294 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"slices"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// Frame layout after the magic bytes and the version byte:
+//
+//	v1: [Request ID 16][Method Len 1][Method][Content Len 4][Content]
+//	v2: [Request ID 16][Method Len 1][Method][API Key Len 1][API Key][Content Len 4][Content]
+//	v3: [Request ID 16][Flags 1][Method Len 1][Method][API Key Len 1][API Key]
+//	    [Extensions Len 2][Extensions][Content Len 4][Content]
+//
+// The v3 extension area is a run of [Type 1][Length 2][Value] entries.
+// Version 1 and 2 requests are answered in the v1 layout; v3 responses carry
+// [Request ID 16][Flags 1][Extensions Len 2][Extensions][Content Len 4][Content].
+const (
+	protocolVersion3 = 0x03 // Version 3, adds the flags byte and header extensions
+
+	flagsSize           = 1 // Flags (version 3 only)
+	extensionsLenSize   = 2 // Extension area length (version 3 only)
+	extensionHeaderSize = 3 // Extension type and value length
+)
+
+// v3ResponseOverhead is the size of a version 3 server frame without
+// extensions and content
+const v3ResponseOverhead = headerSize + versionSize + uuidSize + flagsSize + extensionsLenSize + contentLenSize
+
+// continuationExtension is the extension area of every response chunk but
+// the last
+var continuationExtension = []byte{extensionContinuation, 1, 0, 1}
+
+// frameFlags is the version 3 flags byte:
+// bits 0-1 compression, bits 2-3 payload encoding, bits 4-5 priority,
+// bit 6 marks server push frames and is zero in requests, bit 7 marks
+// heartbeat frames.
+type frameFlags byte
+
+const (
+	flagsCompressionMask frameFlags = 0x03
+	flagsEncodingMask    frameFlags = 0x0C
+	flagsPriorityMask    frameFlags = 0x30
+	flagsPush            frameFlags = 0x40
+	flagsHeartbeat       frameFlags = 0x80
+)
+
+const (
+	compressionNone byte = 0
+
+	encodingJSON byte = 0
+
+	priorityNormal byte = 0
+	priorityLow    byte = 1 // Dropped first when the server is busy
+	priorityHigh   byte = 2 // Served ahead of queued normal and low priority messages
+)
+
+// Header extension types
+const (
+	// extensionAcceptCompression lists the compression codes the client can
+	// read in responses, one byte each in order of preference
+	extensionAcceptCompression byte = 0x01
+	// extensionContinuation marks a response chunk that more chunks of the
+	// same response follow, its value is a single 1 byte
+	extensionContinuation byte = 0x02
+	// extensionTimeout is how many milliseconds the client waits for the
+	// response, see requestDeadline
+	extensionTimeout byte = 0x03
+	// extensionTraceID carries the 16 byte trace ID the request belongs to,
+	// see requestTraceID
+	extensionTraceID byte = 0x04
+	// extensionIdempotencyKey makes retries of the request return the first
+	// result, see idempotencyKey
+	extensionIdempotencyKey byte = 0x05
+	// extensionEncryption marks encrypted content and carries the counter
+	// its nonce is made of, see frameCipher
+	extensionEncryption byte = 0x06
+	// extensionReplayNonce carries the time a request was made and a nonce
+	// that must not be seen twice, see checkReplay
+	extensionReplayNonce byte = 0x07
+)
+
+func (f frameFlags) compression() byte {
+	return byte(f & flagsCompressionMask)
+}
+
+func (f frameFlags) encoding() byte {
+	return byte(f&flagsEncodingMask) >> 2
+}
+
+func (f frameFlags) priority() byte {
+	return byte(f&flagsPriorityMask) >> 4
+}
+
+// frame is a decoded request of any protocol version. Fields a version does
+// not carry are left zero.
+type frame struct {
+	version    byte
+	requestID  []byte
+	flags      frameFlags
+	method     string
+	apiKey     string
+	extensions map[byte][]byte
+	content    []byte
+	// responseLimit is the largest response frame the client agreed to in
+	// its hello, zero without a hello
+	responseLimit int
+	// traceID is the client's trace ID or one made up by the reader
+	traceID []byte
+	// idempotencyKey is the client's idempotency key, "" without one
+	idempotencyKey string
+	// cipher encrypts the response when the connection negotiated encryption
+	cipher *frameCipher
+}
+
+// validate rejects flag values this server does not implement; encodings are
+// checked against the handler's codecs. Unknown extension types are ignored
+// so clients can send them ahead of support.
+func (f *frame) validate() error {
+	if f.flags&flagsPush != 0 {
+		return entities.ErrInvalidInput.Errorf("unsupported frame flags: 0x%02x", byte(f.flags))
+	}
+	if !compressionSupported(f.flags.compression()) {
+		return entities.ErrInvalidInput.Errorf("unsupported compression: %d", f.flags.compression())
+	}
+	if f.flags.priority() > priorityHigh {
+		return entities.ErrInvalidInput.Errorf("unsupported priority: %d", f.flags.priority())
+	}
+	return nil
+}
+
+// readFrameHeader reads the next frame from r up to its content. It reads
+// the fixed header first and then exactly the lengths it announces, so no
+// more than one frame is ever held in memory. It returns the frame without
+// its content, the size of what was read and the announced content length,
+// which readFrameContent reads.
+func readFrameHeader(r *bufio.Reader) (*frame, int, int, error) {
+	header := make([]byte, headerSize+versionSize+uuidSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Verify magic bytes
+	if header[0] != magicByte1 || header[1] != magicByte2 {
+		return nil, 0, 0, entities.ErrInvalidInput.Errorf("invalid magic bytes")
+	}
+
+	// Verify protocol version
+	f := &frame{version: header[2], requestID: header[headerSize+versionSize:]}
+	if f.version != protocolVersion && f.version != protocolVersion2 && f.version != protocolVersion3 {
+		return nil, 0, 0, entities.ErrInvalidInput.Errorf("unsupported protocol version: %d", f.version)
+	}
+	size := len(header)
+
+	if f.version == protocolVersion3 {
+		flags, err := r.ReadByte()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		f.flags = frameFlags(flags)
+		size += flagsSize
+	}
+
+	// Method name
+	method, err := readShortField(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	f.method = string(method)
+	size += methodLenSize + len(method)
+
+	// Versions 2 and 3 carry a length-prefixed API key after the method name
+	if f.version >= protocolVersion2 {
+		apiKey, err := readShortField(r)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		f.apiKey = string(apiKey)
+		size += apiKeyLenSize + len(apiKey)
+	}
+
+	if f.version == protocolVersion3 {
+		var lenBuf [extensionsLenSize]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			return nil, 0, 0, err
+		}
+		extensionArea := make([]byte, binary.LittleEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(r, extensionArea); err != nil {
+			return nil, 0, 0, err
+		}
+		if f.extensions, err = parseExtensions(extensionArea); err != nil {
+			return nil, 0, 0, err
+		}
+		size += extensionsLenSize + len(extensionArea)
+	}
+
+	// Content
+	var lenBuf [contentLenSize]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, 0, 0, err
+	}
+	contentLen := int(binary.LittleEndian.Uint32(lenBuf[:]))
+	if contentLen > maxBufferSize {
+		return nil, 0, 0, entities.ErrPayloadTooLarge.Errorf("content length %d exceeds the %d byte limit", contentLen, maxBufferSize)
+	}
+	size += contentLenSize
+
+	return f, size, contentLen, nil
+}
+
+// readFrameContent reads the contentLen bytes of content that follow the
+// header of f. The content is the only allocation sized by the client.
+func readFrameContent(r *bufio.Reader, f *frame, contentLen int) error {
+	f.content = make([]byte, contentLen)
+	_, err := io.ReadFull(r, f.content)
+	return err
+}
+
+// readShortField reads a field prefixed with a one byte length
+func readShortField(r *bufio.Reader) ([]byte, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// appendV3Frame appends a version 3 server frame to dst. extensions is an
+// encoded extension area, nil for none.
+func appendV3Frame(dst, id []byte, flags frameFlags, extensions, content []byte) []byte {
+	dst = append(dst, magicByte1, magicByte2, protocolVersion3)
+	dst = append(dst, id...)
+	dst = append(dst, byte(flags))
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(len(extensions)))
+	dst = append(dst, extensions...)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(content)))
+	return append(dst, content...)
+}
+
+// appendV3Chunks appends content as one version 3 frame, or as a run of
+// frames of at most limit bytes when it does not fit in one. Every chunk
+// carries extensions, and all but the last the continuation extension too;
+// flags describe the content as a whole, which the client reassembles before
+// decrypting, decompressing and decoding.
+func appendV3Chunks(dst, id []byte, flags frameFlags, extensions, content []byte, limit int) []byte {
+	overhead := v3ResponseOverhead + len(extensions)
+	if overhead+len(content) <= limit {
+		return appendV3Frame(dst, id, flags, extensions, content)
+	}
+
+	chunkExtensions := append(append([]byte{}, extensions...), continuationExtension...)
+	chunkSize := limit - overhead - len(continuationExtension)
+	chunks := (len(content) + chunkSize - 1) / chunkSize
+	dst = slices.Grow(dst, len(content)+chunks*(overhead+len(continuationExtension)))
+	for len(content) > chunkSize {
+		dst = appendV3Frame(dst, id, flags, chunkExtensions, content[:chunkSize])
+		content = content[chunkSize:]
+	}
+	return appendV3Frame(dst, id, flags, extensions, content)
+}
+
+// parseExtensions splits a version 3 extension area into its entries
+func parseExtensions(area []byte) (map[byte][]byte, error) {
+	if len(area) == 0 {
+		return nil, nil
+	}
+
+	extensions := make(map[byte][]byte)
+	for offset := 0; offset < len(area); {
+		if len(area) < offset+extensionHeaderSize {
+			return nil, entities.ErrInvalidInput.Errorf("truncated header extension")
+		}
+		extensionType := area[offset]
+		valueLen := int(binary.LittleEndian.Uint16(area[offset+1 : offset+extensionHeaderSize]))
+		offset += extensionHeaderSize
+		if len(area) < offset+valueLen {
+			return nil, entities.ErrInvalidInput.Errorf("header extension %d overruns the extension area", extensionType)
+		}
+		if _, exists := extensions[extensionType]; exists {
+			return nil, entities.ErrInvalidInput.Errorf("duplicate header extension %d", extensionType)
+		}
+		extensions[extensionType] = area[offset : offset+valueLen]
+		offset += valueLen
+	}
+	return extensions, nil
+}
@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"context"
+
+	"user-service-new/internal/infrastructure"
+)
+
+// EnableHealthChecks makes the health method report the dependencies checked
+// by checker
+func (h *TCPHandler) EnableHealthChecks(checker *infrastructure.HealthChecker) {
+	h.healthChecker = checker
+}
+
+// handleHealth reports the status of the server and of each dependency.
+// Check errors are left out, they are for the health port's operators.
+func (h *TCPHandler) handleHealth(ctx context.Context, content []byte) (interface{}, error) {
+	report := infrastructure.HealthReport{Status: infrastructure.HealthUp}
+	if h.healthChecker != nil {
+		report = h.healthChecker.Check(ctx)
+		for i := range report.Components {
+			report.Components[i].Error = ""
+		}
+	}
+
+	select {
+	case <-h.draining:
+		report.Status = "draining"
+	default:
+	}
+
+	return struct {
+		Status     string                           `json:"status"`
+		Health     string                           `json:"health"`
+		Components []infrastructure.ComponentHealth `json:"components"`
+	}{
+		Status:     "success",
+		Health:     report.Status,
+		Components: report.Components,
+	}, nil
+}
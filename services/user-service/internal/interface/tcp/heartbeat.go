@@ -0,0 +1,75 @@
+package tcp
+
+import (
+	"bytes"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// connectionState tracks the liveness and hello of a connection. Only the
+// goroutine reading the connection touches it.
+type connectionState struct {
+	lastActivity time.Time
+	// speaksV3 is set once the client sent a version 3 frame and can
+	// therefore answer heartbeats
+	speaksV3 bool
+	// pingID identifies the unanswered heartbeat the server sent, if any
+	pingID []byte
+	// handshake is what the connection's hello negotiated
+	handshake handshakeState
+}
+
+// nextReadDeadline is when the reader has to wake up to send a heartbeat or
+// reap the connection. Zero means never.
+func (h *TCPHandler) nextReadDeadline(state *connectionState) time.Time {
+	var deadline time.Time
+	if h.idleTimeout > 0 {
+		deadline = state.lastActivity.Add(h.idleTimeout)
+	}
+	if h.heartbeatInterval > 0 && state.speaksV3 && state.pingID == nil {
+		heartbeat := state.lastActivity.Add(h.heartbeatInterval)
+		if deadline.IsZero() || heartbeat.Before(deadline) {
+			deadline = heartbeat
+		}
+	}
+	return deadline
+}
+
+// checkIdle runs when a read times out. It sends a heartbeat to a quiet
+// version 3 client and reports false once the connection has been idle for
+// idleTimeout, so the caller closes it.
+func (h *TCPHandler) checkIdle(w *connWriter, state *connectionState) bool {
+	idle := time.Since(state.lastActivity)
+	if h.idleTimeout > 0 && idle >= h.idleTimeout {
+		atomic.AddUint64(&h.metrics.reapedConnections, 1)
+		log.Printf("Closing connection from %s, idle for %v", w.conn.RemoteAddr(), idle.Round(time.Second))
+		return false
+	}
+
+	if h.heartbeatInterval > 0 && state.speaksV3 && state.pingID == nil && idle >= h.heartbeatInterval {
+		id := uuid.New()
+		state.pingID = id[:]
+		if err := w.write(createHeartbeatFrame(state.pingID)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// handleHeartbeat answers a client heartbeat, or settles the server's
+// outstanding one when the frame echoes its ID
+func (h *TCPHandler) handleHeartbeat(w *connWriter, state *connectionState, request *frame) {
+	if state.pingID != nil && bytes.Equal(request.requestID, state.pingID) {
+		state.pingID = nil
+		return
+	}
+	w.write(createHeartbeatFrame(request.requestID))
+}
+
+// createHeartbeatFrame builds an empty version 3 frame with the heartbeat flag
+func createHeartbeatFrame(id []byte) []byte {
+	return appendV3Frame(rentBuffer(), id, flagsHeartbeat, nil, nil)
+}
@@ -0,0 +1,213 @@
+package tcp
+
+import (
+	"encoding/json"
+	"log"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// minFrameSize is the smallest frame limit a hello may ask for, responses
+// beyond the limit are split into chunks that still need room for content
+const minFrameSize = 1024
+
+// encodingNames and compressionNames are the capability names a hello
+// exchanges, mapped to their version 3 flag codes
+var (
+	encodingNames = map[string]byte{
+		"json":     encodingJSON,
+		"msgpack":  encodingMsgPack,
+		"protobuf": encodingProtobuf,
+	}
+	compressionNames = map[string]byte{
+		"gzip": compressionGzip,
+		"zstd": compressionZstd,
+	}
+)
+
+// helloRequest is the JSON content of a hello frame
+type helloRequest struct {
+	Version      int      `json:"version"`
+	MaxFrameSize int      `json:"maxFrameSize"`
+	Encodings    []string `json:"encodings"`
+	Compressions []string `json:"compressions"`
+	Token        string   `json:"token"`
+	// Encryption, KeyID and Nonce ask for encrypted frame content, see
+	// negotiateEncryption
+	Encryption string `json:"encryption"`
+	KeyID      string `json:"keyID"`
+	Nonce      []byte `json:"nonce"`
+}
+
+// helloResponse holds what the connection settled on
+type helloResponse struct {
+	Status            string   `json:"status"`
+	Version           int      `json:"version"`
+	MaxFrameSize      int      `json:"maxFrameSize"`
+	Encodings         []string `json:"encodings"`
+	Compressions      []string `json:"compressions"`
+	HeartbeatInterval int64    `json:"heartbeatInterval"` // Milliseconds, 0 when disabled
+	Caller            string   `json:"caller,omitempty"`
+	Encryption        string   `json:"encryption,omitempty"`
+	Nonce             []byte   `json:"nonce,omitempty"` // The server's part of the session keys
+}
+
+// handshakeState is what a hello negotiated. Before a hello every encoding
+// and compression the server supports is allowed and frames may use the
+// full maxBufferSize.
+type handshakeState struct {
+	done         bool
+	maxFrameSize int
+	encodings    map[byte]bool
+	compressions map[byte]bool
+	// apiKey authenticates frames of the connection that carry none
+	apiKey string
+	// cipher decrypts the frames of the connection once the hello asked for
+	// encryption, and authenticates them as its caller
+	cipher *frameCipher
+}
+
+// allows reports whether request stays within what the hello negotiated
+func (s *handshakeState) allows(request *frame, size int) error {
+	if !s.done {
+		return nil
+	}
+	if size > s.maxFrameSize {
+		return entities.ErrPayloadTooLarge.Errorf("frame of %d bytes exceeds the negotiated %d byte limit", size, s.maxFrameSize)
+	}
+	if !s.encodings[request.flags.encoding()] {
+		return entities.ErrInvalidInput.Errorf("encoding %d was not negotiated", request.flags.encoding())
+	}
+	if compression := request.flags.compression(); compression != compressionNone && !s.compressions[compression] {
+		return entities.ErrInvalidInput.Errorf("compression %d was not negotiated", compression)
+	}
+	return nil
+}
+
+// handleHello answers a hello frame and records what it negotiated in state.
+// It reports false when the client is incompatible or fails to authenticate,
+// after telling it why, so the caller closes the connection.
+func (h *TCPHandler) handleHello(w *connWriter, state *connectionState, request *frame, peer *Caller) bool {
+	if state.handshake.done {
+		h.sendError(w, entities.ErrInvalidInput.Errorf("hello already completed"), request)
+		return true
+	}
+	if request.version != protocolVersion3 {
+		h.sendError(w, entities.ErrInvalidInput.Errorf("hello needs a version 3 frame"), request)
+		return false
+	}
+	if request.flags.encoding() != encodingJSON || request.flags.compression() != compressionNone {
+		h.sendError(w, entities.ErrInvalidInput.Errorf("hello must be uncompressed JSON"), request)
+		return false
+	}
+
+	var hello helloRequest
+	if err := json.Unmarshal(request.content, &hello); err != nil {
+		h.sendError(w, entities.ErrInvalidInput.Errorf("invalid input data: %v", err), request)
+		return false
+	}
+
+	response, negotiated, err := h.negotiate(hello, peer)
+	if err != nil {
+		log.Printf("Rejecting hello from %s: %v", w.conn.RemoteAddr(), err)
+		h.sendError(w, err, request)
+		return false
+	}
+
+	jsonData, _ := json.Marshal(response)
+	if err := w.write(h.createBinaryResponse(request, jsonData)); err != nil {
+		return false
+	}
+
+	state.handshake = negotiated
+	return true
+}
+
+// negotiate settles the capabilities both sides support and authenticates the
+// hello's token. Without a token the client certificate, if any, or the
+// encryption key identifies the caller; helloRequired turns an anonymous
+// hello into an error.
+func (h *TCPHandler) negotiate(hello helloRequest, peer *Caller) (*helloResponse, handshakeState, error) {
+	negotiated := handshakeState{
+		done:         true,
+		maxFrameSize: maxBufferSize,
+		encodings:    make(map[byte]bool),
+		compressions: make(map[byte]bool),
+	}
+
+	if hello.Version < protocolVersion3 {
+		return nil, negotiated, entities.ErrInvalidInput.Errorf("unsupported protocol version: %d, hello needs version %d", hello.Version, protocolVersion3)
+	}
+	if hello.MaxFrameSize < 0 || (hello.MaxFrameSize > 0 && hello.MaxFrameSize < minFrameSize) {
+		return nil, negotiated, entities.ErrInvalidInput.Errorf("invalid maxFrameSize: %d, at least %d bytes are needed", hello.MaxFrameSize, minFrameSize)
+	}
+	if hello.MaxFrameSize > 0 && hello.MaxFrameSize < negotiated.maxFrameSize {
+		negotiated.maxFrameSize = hello.MaxFrameSize
+	}
+
+	response := &helloResponse{
+		Status:            "success",
+		Version:           protocolVersion3,
+		MaxFrameSize:      negotiated.maxFrameSize,
+		Encodings:         []string{},
+		Compressions:      []string{},
+		HeartbeatInterval: h.heartbeatInterval.Milliseconds(),
+	}
+
+	if len(hello.Encodings) == 0 {
+		hello.Encodings = []string{"json"}
+	}
+	for _, name := range hello.Encodings {
+		encoding, known := encodingNames[name]
+		if _, supported := h.codecs[encoding]; known && supported && !negotiated.encodings[encoding] {
+			negotiated.encodings[encoding] = true
+			response.Encodings = append(response.Encodings, name)
+		}
+	}
+	if len(negotiated.encodings) == 0 {
+		return nil, negotiated, entities.ErrInvalidInput.Errorf("no common encoding, the server supports json, msgpack and protobuf")
+	}
+
+	for _, name := range hello.Compressions {
+		compression, known := compressionNames[name]
+		if known && compressionSupported(compression) && !negotiated.compressions[compression] {
+			negotiated.compressions[compression] = true
+			response.Compressions = append(response.Compressions, name)
+		}
+	}
+
+	if hello.Encryption != "" {
+		if len(h.encryptionKeys) == 0 {
+			return nil, negotiated, entities.ErrInvalidInput.Errorf("frame encryption is not enabled")
+		}
+		frameCipher, err := h.negotiateEncryption(hello, response)
+		if err != nil {
+			return nil, negotiated, err
+		}
+		negotiated.cipher = frameCipher
+	}
+
+	if h.helloRequired && h.requireClientCert && peer == nil {
+		return nil, negotiated, entities.ErrUnauthorized.Errorf("unauthorized: client certificate required")
+	}
+	switch {
+	case hello.Token != "":
+		key, err := h.apiKeyService.ValidateAPIKey(hello.Token)
+		if err != nil {
+			return nil, negotiated, entities.ErrUnauthorized.Errorf("unauthorized: %v", err)
+		}
+		negotiated.apiKey = hello.Token
+		response.Caller = key.Name
+		if peer != nil {
+			response.Caller = peer.Name
+		}
+	case peer != nil:
+		response.Caller = peer.Name
+	case negotiated.cipher != nil:
+		response.Caller = negotiated.cipher.caller.Name
+	case h.helloRequired:
+		return nil, negotiated, entities.ErrUnauthorized.Errorf("unauthorized: hello needs a token or a client certificate")
+	}
+
+	return response, negotiated, nil
+}
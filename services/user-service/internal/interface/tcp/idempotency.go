@@ -0,0 +1,42 @@
+package tcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"user-service-new/internal/domain/entities"
+)
+
+// maxIdempotencyKeySize bounds the idempotency key extension
+const maxIdempotencyKeySize = 128
+
+// requestIdempotencyKey returns the idempotency key the client sent with
+// request, "" without one
+func requestIdempotencyKey(request *frame) (string, error) {
+	value, ok := request.extensions[extensionIdempotencyKey]
+	if !ok {
+		return "", nil
+	}
+	if len(value) == 0 || len(value) > maxIdempotencyKeySize {
+		return "", entities.ErrInvalidInput.Errorf("idempotency key must be 1 to %d bytes", maxIdempotencyKeySize)
+	}
+	return string(value), nil
+}
+
+// idempotencyKey is the key a handler passes on to the application layer,
+// "" when the client sent none. The client's key is scoped to the method and
+// the request content, so reusing it cannot return the result of another
+// method or of a different request.
+func idempotencyKey(ctx context.Context, content []byte) string {
+	c := callFromContext(ctx)
+	if c.idempotencyKey == "" {
+		return ""
+	}
+
+	sum := sha256.New()
+	sum.Write([]byte(c.idempotencyKey))
+	sum.Write([]byte{0})
+	sum.Write(content)
+	return "tcp:" + c.method + ":" + hex.EncodeToString(sum.Sum(nil))
+}
@@ -0,0 +1,176 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/application/query"
+	"user-service-new/internal/domain/entities"
+)
+
+// userBoundMethods act on a single user. On a connection bound to a user
+// with "auth" they act on that user, and need no service credentials.
+var userBoundMethods = map[string]bool{
+	"profile":            true,
+	"devices.list":       true,
+	"devices.revoke":     true,
+	"security.history":   true,
+	"events.subscribe":   true,
+	"events.unsubscribe": true,
+}
+
+// boundIdentity is the user a connection authenticated as with "auth"
+type boundIdentity struct {
+	userID    uuid.UUID
+	scopes    []string
+	expiresAt time.Time // Zero when the token carries no expiry
+}
+
+func (i *boundIdentity) expired() bool {
+	return !i.expiresAt.IsZero() && time.Now().After(i.expiresAt)
+}
+
+// identityRegistry holds the bound identity of every authenticated connection
+type identityRegistry struct {
+	mu         sync.RWMutex
+	identities map[net.Conn]*boundIdentity
+}
+
+func newIdentityRegistry() *identityRegistry {
+	return &identityRegistry{identities: make(map[net.Conn]*boundIdentity)}
+}
+
+// bind replaces whatever identity conn was bound to
+func (r *identityRegistry) bind(conn net.Conn, identity *boundIdentity) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.identities[conn] = identity
+}
+
+func (r *identityRegistry) get(conn net.Conn) *boundIdentity {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.identities[conn]
+}
+
+func (r *identityRegistry) removeConnection(conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.identities, conn)
+}
+
+// revokeUser unbinds every connection authenticated as userID
+func (r *identityRegistry) revokeUser(userID uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for conn, identity := range r.identities {
+		if identity.userID == userID {
+			delete(r.identities, conn)
+		}
+	}
+}
+
+func (r *identityRegistry) count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.identities)
+}
+
+// errIdentityExpired is returned for calls on a connection whose token expired
+var errIdentityExpired = entities.ErrUnauthorized.Errorf("connection token expired, send auth again")
+
+// boundIdentity returns the user the calling connection is bound to, nil when
+// it is not bound
+func (h *TCPHandler) boundIdentity(ctx context.Context) (*boundIdentity, error) {
+	connection := connectionFromContext(ctx)
+	if connection == nil {
+		return nil, nil
+	}
+	identity := h.identities.get(connection.conn)
+	if identity != nil && identity.expired() {
+		return nil, errIdentityExpired
+	}
+	return identity, nil
+}
+
+// requestUserID is the user a per-user method acts on: the bound user of the
+// connection, else the userID in the request. A request on a bound connection
+// may repeat its user but not name another one.
+func (h *TCPHandler) requestUserID(ctx context.Context, requested string) (uuid.UUID, error) {
+	identity, err := h.boundIdentity(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if identity != nil {
+		if requested != "" && requested != identity.userID.String() {
+			return uuid.Nil, entities.ErrForbidden.Errorf("userID does not match the authenticated user")
+		}
+		return identity.userID, nil
+	}
+
+	if requested == "" {
+		return uuid.Nil, entities.ErrInvalidInput.Errorf("userID is required")
+	}
+	userID, err := uuid.Parse(requested)
+	if err != nil {
+		return uuid.Nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+	return userID, nil
+}
+
+// handleAuth binds the calling connection to the user of an access token
+func (h *TCPHandler) handleAuth(ctx context.Context, content []byte) (interface{}, error) {
+	var request AuthRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	if request.Token == "" {
+		return nil, entities.ErrInvalidInput.Errorf("token is required")
+	}
+
+	connection := connectionFromContext(ctx)
+	if connection == nil {
+		return nil, entities.ErrInvalidInput.Errorf("auth needs a connection")
+	}
+
+	result, err := h.userService.IntrospectToken(&query.IntrospectTokenQuery{
+		Token:             request.Token,
+		DeviceFingerprint: request.DeviceFingerprint,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in introspecting token: %w", err)
+	}
+	if !result.Active {
+		return nil, entities.ErrUnauthorized.Errorf("unauthorized: token is not active")
+	}
+
+	identity := &boundIdentity{
+		userID: result.UserID,
+		scopes: result.Scopes,
+	}
+	if result.ExpiresAt > 0 {
+		identity.expiresAt = time.Unix(result.ExpiresAt, 0)
+	}
+	h.identities.bind(connection.conn, identity)
+
+	response := struct {
+		Status    string   `json:"status"`
+		UserID    string   `json:"userID"`
+		Scopes    []string `json:"scopes,omitempty"`
+		ExpiresAt int64    `json:"exp,omitempty"`
+	}{
+		Status:    "success",
+		UserID:    result.UserID.String(),
+		Scopes:    result.Scopes,
+		ExpiresAt: result.ExpiresAt,
+	}
+	return response, nil
+}
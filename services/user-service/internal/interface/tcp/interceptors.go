@@ -0,0 +1,163 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+// Use appends interceptors to the pipeline every method call runs through,
+// batch sub-requests included. Interceptors wrap all methods the way the
+// middleware given to Register wraps one; they run after the built-in
+// metrics, panic recovery, logging and authorization, in the order given.
+func (h *TCPHandler) Use(interceptors ...Middleware) {
+	h.methodsMu.Lock()
+	defer h.methodsMu.Unlock()
+	h.interceptors = append(h.interceptors, interceptors...)
+}
+
+// pipeline wraps handler in the built-in interceptors and those added with
+// Use. Metrics are outermost so they count recovered panics as errors.
+func (h *TCPHandler) pipeline(handler MethodHandler) MethodHandler {
+	h.methodsMu.RLock()
+	interceptors := h.interceptors
+	h.methodsMu.RUnlock()
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		handler = interceptors[i](handler)
+	}
+	handler = h.authorizeCalls(handler)
+	handler = h.logCalls(handler)
+	handler = h.recoverPanics(handler)
+	return h.countCalls(handler)
+}
+
+type callKey struct{}
+
+// call describes the method call a handler is serving
+type call struct {
+	method    string // Without the version prefix
+	version   int    // API version of the handler serving it
+	requestID uuid.UUID
+	traceID   [traceIDSize]byte // Shared by the sub-requests of a batch
+	caller    string            // Set by authorizeCalls for privileged methods
+
+	idempotencyKey string // The client's key, see idempotencyKey
+}
+
+func withCall(ctx context.Context, method string, requestID, traceID []byte) context.Context {
+	c := &call{method: method}
+	copy(c.requestID[:], requestID)
+	copy(c.traceID[:], traceID)
+	return context.WithValue(ctx, callKey{}, c)
+}
+
+func callFromContext(ctx context.Context) *call {
+	c, _ := ctx.Value(callKey{}).(*call)
+	if c == nil {
+		return &call{}
+	}
+	return c
+}
+
+// MethodFromContext returns the method a handler or interceptor is serving
+func MethodFromContext(ctx context.Context) string {
+	return callFromContext(ctx).method
+}
+
+// recoverPanics turns a panicking handler into an internal error, so one bad
+// request cannot take the worker, or the server, down with it
+func (h *TCPHandler) recoverPanics(next MethodHandler) MethodHandler {
+	return func(ctx context.Context, content []byte) (result interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				c := callFromContext(ctx)
+				atomic.AddUint64(&h.metrics.handlerPanics, 1)
+				log.Printf("Panic in %s (request %s, trace %x): %v\n%s", c.method, c.requestID, c.traceID, r, debug.Stack())
+				result, err = nil, fmt.Errorf("internal error")
+			}
+		}()
+		return next(ctx, content)
+	}
+}
+
+// countCalls counts method calls and their failures, in total and for each
+// method version with its latencies. Unlike the request counters these
+// include the sub-requests of batches.
+func (h *TCPHandler) countCalls(next MethodHandler) MethodHandler {
+	return func(ctx context.Context, content []byte) (interface{}, error) {
+		atomic.AddUint64(&h.metrics.methodCalls, 1)
+		start := time.Now()
+		result, err := next(ctx, content)
+		if err != nil {
+			atomic.AddUint64(&h.metrics.methodErrors, 1)
+		}
+		c := callFromContext(ctx)
+		if stats := h.methodMetrics.get(versionedMethod(c.method, c.version)); stats != nil {
+			stats.observe(time.Since(start), err != nil)
+		}
+		return result, err
+	}
+}
+
+// logCalls logs calls that fail or take longer than slowCallThreshold.
+// Validation errors are the client's business and are not logged.
+func (h *TCPHandler) logCalls(next MethodHandler) MethodHandler {
+	return func(ctx context.Context, content []byte) (interface{}, error) {
+		start := time.Now()
+		result, err := next(ctx, content)
+		elapsed := time.Since(start)
+
+		c := callFromContext(ctx)
+		caller := c.caller
+		if caller == "" {
+			caller = "anonymous"
+		}
+
+		var validationErr *entities.ValidationError
+		switch {
+		case err != nil && !errors.As(err, &validationErr):
+			log.Printf("TCP %s from %s failed after %v (request %s, trace %x): %v", c.method, caller, elapsed.Round(time.Millisecond), c.requestID, c.traceID, err)
+		case h.slowCallThreshold > 0 && elapsed >= h.slowCallThreshold:
+			log.Printf("TCP %s from %s took %v (request %s, trace %x)", c.method, caller, elapsed.Round(time.Millisecond), c.requestID, c.traceID)
+		}
+		return result, err
+	}
+}
+
+// authorizeCalls authenticates the callers of privileged methods. A
+// connection bound to a user may call the methods acting on that user.
+func (h *TCPHandler) authorizeCalls(next MethodHandler) MethodHandler {
+	return func(ctx context.Context, content []byte) (interface{}, error) {
+		method := MethodFromContext(ctx)
+
+		userBound := false
+		if userBoundMethods[method] {
+			identity, err := h.boundIdentity(ctx)
+			if err != nil {
+				return nil, entities.ErrUnauthorized.Errorf("unauthorized: %v", err)
+			}
+			userBound = identity != nil
+		}
+
+		if privilegedMethods[method] && !userBound {
+			caller, err := h.authorize(ctx, apiKeyFromContext(ctx))
+			if err != nil {
+				return nil, entities.ErrUnauthorized.Errorf("unauthorized: %v", err)
+			}
+			if isAdminMethod(method) && len(h.adminServices) > 0 && !h.adminServices[caller.Name] {
+				return nil, entities.ErrForbidden.Errorf("unauthorized: %s may not use the admin methods", caller.Name)
+			}
+			ctx = withCaller(ctx, caller)
+			callFromContext(ctx).caller = caller.Name
+		}
+		return next(ctx, content)
+	}
+}
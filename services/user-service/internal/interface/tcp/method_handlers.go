@@ -6,36 +6,60 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"user-service-new/internal/application/command"
+	"user-service-new/internal/application/common"
+	"user-service-new/internal/application/query"
+	"user-service-new/internal/domain/entities"
 )
 
 // handleRegister processes registration requests
 func (h *TCPHandler) handleRegister(ctx context.Context, content []byte) (interface{}, error) {
-	var userData struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var userData RegisterRequest
 
 	if err := json.Unmarshal(content, &userData); err != nil {
-		return nil, fmt.Errorf("invalid input data: %v", err)
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
 	}
 
 	// Validate user data
 	if userData.Username == "" || userData.Password == "" || userData.Email == "" {
-		return nil, fmt.Errorf("username, email and password are required")
+		return nil, entities.ErrInvalidInput.Errorf("username, email and password are required")
+	}
+
+	// An invite proves the email address, so the account is created right away
+	if userData.Invite != "" {
+		result, err := h.inviteService.RegisterWithInvite(&command.RegisterWithInviteCommand{
+			Token:                userData.Invite,
+			Username:             userData.Username,
+			Email:                userData.Email,
+			Password:             userData.Password,
+			AcceptedTermsVersion: userData.Terms,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("registration failed: %w", err)
+		}
+
+		return struct {
+			Status string             `json:"status"`
+			User   *common.UserResult `json:"user"`
+		}{
+			Status: "success",
+			User:   result.Result,
+		}, nil
 	}
 
 	// Create command for sending OTP
 	sendOTPCommand := &command.SendOTPCommand{
-		Username: userData.Username,
-		Email:    userData.Email,
-		Password: userData.Password,
+		Username:             userData.Username,
+		Email:                userData.Email,
+		Password:             userData.Password,
+		Channel:              userData.Channel,
+		AcceptedTermsVersion: userData.Terms,
+		IdempotencyKey:       idempotencyKey(ctx, content),
 	}
 
 	// Send OTP to user
 	result, err := h.userService.SendOTP(sendOTPCommand)
 	if err != nil {
-		return nil, fmt.Errorf("registration failed: %v", err)
+		return nil, fmt.Errorf("registration failed: %w", err)
 	}
 
 	return struct {
@@ -47,30 +71,234 @@ func (h *TCPHandler) handleRegister(ctx context.Context, content []byte) (interf
 	}, nil
 }
 
+// handleCreateGuest returns a token for the anonymous account bound to a device
+func (h *TCPHandler) handleCreateGuest(ctx context.Context, content []byte) (interface{}, error) {
+	var request AccountGuestRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.DeviceFingerprint == "" {
+		return nil, entities.ErrInvalidInput.Errorf("deviceFingerprint is required")
+	}
+
+	result, err := h.userService.CreateGuest(&command.CreateGuestCommand{
+		DeviceFingerprint: request.DeviceFingerprint,
+		IPAddress:         request.IPAddress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in creating guest: %w", err)
+	}
+
+	return loginResponse(result), nil
+}
+
+// handleUpgradeAccount starts attaching an email and password to a guest account
+func (h *TCPHandler) handleUpgradeAccount(ctx context.Context, content []byte) (interface{}, error) {
+	var request AccountUpgradeRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.Email == "" || request.Password == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID, email and password are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.UpgradeAccount(&command.UpgradeAccountCommand{
+		UserID:               userID,
+		Username:             request.Username,
+		Email:                request.Email,
+		Password:             request.Password,
+		AcceptedTermsVersion: request.Terms,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in upgrading account: %w", err)
+	}
+
+	return struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: result.Message,
+	}, nil
+}
+
+// handleConfirmAccountUpgrade completes a guest upgrade with the OTP sent to the email
+func (h *TCPHandler) handleConfirmAccountUpgrade(ctx context.Context, content []byte) (interface{}, error) {
+	var request AccountUpgradeConfirmRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.OTP == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and otp are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.ConfirmAccountUpgrade(&command.ConfirmAccountUpgradeCommand{
+		UserID: userID,
+		OTP:    request.OTP,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in confirming account upgrade: %w", err)
+	}
+
+	return struct {
+		Status string             `json:"status"`
+		Token  string             `json:"token"`
+		User   *common.UserResult `json:"user"`
+	}{
+		Status: "success",
+		Token:  result.Token,
+		User:   result.User,
+	}, nil
+}
+
+// handleCreateInvite issues an invite, from a user when inviterID is set and
+// from an operator otherwise
+func (h *TCPHandler) handleCreateInvite(ctx context.Context, content []byte) (interface{}, error) {
+	var request InviteCreateRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.Email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("email is required")
+	}
+
+	createCommand := &command.CreateInviteCommand{Email: request.Email}
+	if request.InviterID != "" {
+		inviterID, err := uuid.Parse(request.InviterID)
+		if err != nil {
+			return nil, entities.ErrInvalidInput.Errorf("invalid inviterID format: %v", err)
+		}
+		createCommand.InviterID = &inviterID
+	}
+
+	result, err := h.inviteService.CreateInvite(createCommand)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating invite: %w", err)
+	}
+
+	return struct {
+		Status    string `json:"status"`
+		InviteID  string `json:"inviteID"`
+		Token     string `json:"token"`
+		ExpiresAt int64  `json:"expiresAt"`
+	}{
+		Status:    "success",
+		InviteID:  result.Id.String(),
+		Token:     result.Token,
+		ExpiresAt: result.ExpiresAt.Unix(),
+	}, nil
+}
+
+// handleResendOTP resends the registration OTP for a pending signup
+func (h *TCPHandler) handleResendOTP(ctx context.Context, content []byte) (interface{}, error) {
+	var request OtpResendRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.Email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("email is required")
+	}
+
+	result, err := h.userService.ResendOTP(&command.ResendOTPCommand{
+		Email: request.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in resending OTP: %w", err)
+	}
+
+	return struct {
+		Status           string `json:"status"`
+		Message          string `json:"message"`
+		RemainingResends int    `json:"remainingResends"`
+	}{
+		Status:           "success",
+		Message:          result.Message,
+		RemainingResends: result.RemainingResends,
+	}, nil
+}
+
 // handleLogin processes login requests
 func (h *TCPHandler) handleLogin(ctx context.Context, content []byte) (interface{}, error) {
-	var credentials struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}
+	var credentials LoginRequest
 
 	if err := json.Unmarshal(content, &credentials); err != nil {
-		return nil, fmt.Errorf("invalid input data: %v", err)
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
 	}
 
 	if credentials.Username == "" || credentials.Password == "" {
-		return nil, fmt.Errorf("missing username or password")
+		return nil, entities.ErrInvalidInput.Errorf("missing username or password")
 	}
 
 	// Create login command
 	loginCommand := &command.LoginUserCommand{
-		Username: credentials.Username,
-		Password: credentials.Password,
+		Username:             credentials.Username,
+		Password:             credentials.Password,
+		RecoveryCode:         credentials.RecoveryCode,
+		DeviceFingerprint:    credentials.DeviceFingerprint,
+		UserAgent:            credentials.UserAgent,
+		IPAddress:            credentials.IPAddress,
+		Country:              credentials.Country,
+		AcceptedTermsVersion: credentials.Terms,
 	}
 
 	result, err := h.userService.LoginUser(loginCommand)
 	if err != nil {
-		return nil, fmt.Errorf("authentication failed: %v", err)
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	return loginResponse(result), nil
+}
+
+// handleVerifyLogin completes a login challenge with the OTP sent to the user
+func (h *TCPHandler) handleVerifyLogin(ctx context.Context, content []byte) (interface{}, error) {
+	var request LoginVerifyRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.ChallengeID == "" || request.OTP == "" {
+		return nil, entities.ErrInvalidInput.Errorf("challengeId and OTP are required")
+	}
+
+	result, err := h.userService.VerifyLogin(&command.VerifyLoginCommand{
+		ChallengeID: request.ChallengeID,
+		OTP:         request.OTP,
+		TrustDevice: request.TrustDevice,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	return loginResponse(result), nil
+}
+
+// loginResponse shapes a login result as either a token or a pending challenge
+func loginResponse(result *command.LoginUserCommandResult) interface{} {
+	if result.ChallengeRequired {
+		return struct {
+			Status      string `json:"status"`
+			ChallengeID string `json:"challengeId"`
+		}{
+			Status:      "challenge",
+			ChallengeID: result.ChallengeID,
+		}
 	}
 
 	return struct {
@@ -81,67 +309,110 @@ func (h *TCPHandler) handleLogin(ctx context.Context, content []byte) (interface
 		Status: "success",
 		Token:  result.Token,
 		User:   result.User,
-	}, nil
+	}
 }
 
 // handleProfile processes profile requests
 func (h *TCPHandler) handleProfile(ctx context.Context, content []byte) (interface{}, error) {
-	var request struct {
-		UserID string `json:"userID"`
-	}
+	var request ProfileRequest
 
 	if err := json.Unmarshal(content, &request); err != nil {
-		return nil, fmt.Errorf("invalid input data: %v", err)
-	}
-
-	if request.UserID == "" {
-		return nil, fmt.Errorf("userID is required")
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
 	}
 
-	// Parse UUID
-	userID, err := uuid.Parse(request.UserID)
+	userID, err := h.requestUserID(ctx, request.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid userID format: %v", err)
+		return nil, err
 	}
 
 	result, err := h.userService.GetProfile(userID)
 	if err != nil {
-		return nil, fmt.Errorf("error in getting profile: %v", err)
+		return nil, fmt.Errorf("error in getting profile: %w", err)
 	}
 
+	etag := result.Result.ETag()
+	if common.ETagMatches(request.IfNoneMatch, etag) {
+		return notModified(etag), nil
+	}
 	return struct {
 		Status string `json:"status"`
 		User   interface{} `json:"user"`
+		ETag   string `json:"etag"`
 	}{
 		Status: "success",
 		User:   result.Result,
+		ETag:   etag,
+	}, nil
+}
+
+// notModified answers a conditional read whose ifNoneMatch names the
+// current version, without the payload the client already has
+func notModified(etag string) interface{} {
+	return struct {
+		Status string `json:"status"`
+		ETag   string `json:"etag"`
+	}{
+		Status: "not_modified",
+		ETag:   etag,
+	}
+}
+
+// handleProfileV2 serves version 2 of profile requests, which returns the
+// public profile and the account details of the user apart
+func (h *TCPHandler) handleProfileV2(ctx context.Context, content []byte) (interface{}, error) {
+	var request ProfileV2Request
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	userID, err := h.requestUserID(ctx, request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := h.userService.GetProfile(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error in getting profile: %w", err)
+	}
+
+	etag := result.Result.ETag()
+	if common.ETagMatches(request.IfNoneMatch, etag) {
+		return notModified(etag), nil
+	}
+	return struct {
+		Status string               `json:"status"`
+		User   *common.UserResultV2 `json:"user"`
+		ETag   string               `json:"etag"`
+	}{
+		Status: "success",
+		User:   common.NewUserResultV2(result.Result),
+		ETag:   etag,
 	}, nil
 }
 
 // handleEmailOTP processes OTP verification requests
 func (h *TCPHandler) handleEmailOTP(ctx context.Context, content []byte) (interface{}, error) {
-	var credentials struct {
-		Email string `json:"email"`
-		OTP   string `json:"otp"`
-	}
+	var credentials VerifyRequest
 
 	if err := json.Unmarshal(content, &credentials); err != nil {
-		return nil, fmt.Errorf("invalid input data: %v", err)
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
 	}
 
 	if credentials.Email == "" || credentials.OTP == "" {
-		return nil, fmt.Errorf("email and OTP are required")
+		return nil, entities.ErrInvalidInput.Errorf("email and OTP are required")
 	}
 
 	// Create verify OTP command
 	verifyOTPCommand := &command.VerifyOTPCommand{
-		Email: credentials.Email,
-		OTP:   credentials.OTP,
+		Email:          credentials.Email,
+		OTP:            credentials.OTP,
+		IdempotencyKey: idempotencyKey(ctx, content),
 	}
 
 	result, err := h.userService.VerifyOTP(verifyOTPCommand)
 	if err != nil {
-		return nil, fmt.Errorf("error in verifying OTP: %v", err)
+		return nil, fmt.Errorf("error in verifying OTP: %w", err)
 	}
 
 	return struct {
@@ -152,3 +423,588 @@ func (h *TCPHandler) handleEmailOTP(ctx context.Context, content []byte) (interf
 		User:   result.Result,
 	}, nil
 }
+
+// handleRotateAPIKey replaces the API key presented on the frame with a new one
+func (h *TCPHandler) handleRotateAPIKey(ctx context.Context, content []byte) (interface{}, error) {
+	caller := callerFromContext(ctx)
+	if caller == nil || caller.APIKey == nil {
+		return nil, entities.ErrUnauthorized.Errorf("unauthorized: api key required")
+	}
+
+	result, err := h.apiKeyService.RotateAPIKey(&command.RotateAPIKeyCommand{Id: caller.APIKey.Id})
+	if err != nil {
+		return nil, fmt.Errorf("error in rotating api key: %w", err)
+	}
+
+	return struct {
+		Status string      `json:"status"`
+		APIKey interface{} `json:"apiKey"`
+	}{
+		Status: "success",
+		APIKey: result,
+	}, nil
+}
+
+// handleRegenerateRecoveryCodes replaces a user's recovery codes with a fresh set
+func (h *TCPHandler) handleRegenerateRecoveryCodes(ctx context.Context, content []byte) (interface{}, error) {
+	var request RecoveryRegenerateRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.Password == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and password are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.RegenerateRecoveryCodes(&command.RegenerateRecoveryCodesCommand{
+		UserID:   userID,
+		Password: request.Password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in regenerating recovery codes: %w", err)
+	}
+
+	return struct {
+		Status string   `json:"status"`
+		Codes  []string `json:"codes"`
+	}{
+		Status: "success",
+		Codes:  result.Codes,
+	}, nil
+}
+
+// handleChangeEmail starts an email change by sending an OTP to the new address
+func (h *TCPHandler) handleChangeEmail(ctx context.Context, content []byte) (interface{}, error) {
+	var request EmailChangeRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.Email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and email are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.ChangeEmail(&command.ChangeEmailCommand{
+		UserID:   userID,
+		NewEmail: request.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in changing email: %w", err)
+	}
+
+	return struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: result.Message,
+	}, nil
+}
+
+// handleConfirmEmailChange completes an email change with the OTP sent to the new address
+func (h *TCPHandler) handleConfirmEmailChange(ctx context.Context, content []byte) (interface{}, error) {
+	var request EmailConfirmRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.OTP == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and OTP are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.ConfirmEmailChange(&command.ConfirmEmailChangeCommand{
+		UserID: userID,
+		OTP:    request.OTP,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in confirming email change: %w", err)
+	}
+
+	return struct {
+		Status string      `json:"status"`
+		User   interface{} `json:"user"`
+	}{
+		Status: "success",
+		User:   result.Result,
+	}, nil
+}
+
+// handleListEmails returns the user's primary and secondary email addresses
+func (h *TCPHandler) handleListEmails(ctx context.Context, content []byte) (interface{}, error) {
+	var request EmailsListRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID is required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.ListEmails(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error in listing emails: %w", err)
+	}
+
+	return emailsResponse(result), nil
+}
+
+// handleAddEmail sends an OTP to a secondary address the user wants to attach
+func (h *TCPHandler) handleAddEmail(ctx context.Context, content []byte) (interface{}, error) {
+	var request EmailsAddRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.Email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and email are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.AddEmail(&command.AddEmailCommand{
+		UserID: userID,
+		Email:  request.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in adding email: %w", err)
+	}
+
+	return struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: result.Message,
+	}, nil
+}
+
+// handleConfirmEmailAddition attaches the pending secondary address with the OTP sent to it
+func (h *TCPHandler) handleConfirmEmailAddition(ctx context.Context, content []byte) (interface{}, error) {
+	var request EmailsConfirmRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.OTP == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and OTP are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.ConfirmEmailAddition(&command.ConfirmEmailAdditionCommand{
+		UserID: userID,
+		OTP:    request.OTP,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in confirming email: %w", err)
+	}
+
+	return emailsResponse(result), nil
+}
+
+// handleSetPrimaryEmail promotes a secondary address to primary
+func (h *TCPHandler) handleSetPrimaryEmail(ctx context.Context, content []byte) (interface{}, error) {
+	var request EmailsPrimaryRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.Email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and email are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.SetPrimaryEmail(&command.SetPrimaryEmailCommand{
+		UserID: userID,
+		Email:  request.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in setting primary email: %w", err)
+	}
+
+	return emailsResponse(result), nil
+}
+
+// handleRemoveEmail detaches a secondary address
+func (h *TCPHandler) handleRemoveEmail(ctx context.Context, content []byte) (interface{}, error) {
+	var request EmailsRemoveRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.Email == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and email are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.RemoveEmail(&command.RemoveEmailCommand{
+		UserID: userID,
+		Email:  request.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in removing email: %w", err)
+	}
+
+	return struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: result.Message,
+	}, nil
+}
+
+func emailsResponse(result *query.UserEmailQueryListResult) interface{} {
+	return struct {
+		Status string      `json:"status"`
+		Emails interface{} `json:"emails"`
+	}{
+		Status: "success",
+		Emails: result.Result,
+	}
+}
+
+// handleUpdateProfile processes partial profile updates
+func (h *TCPHandler) handleUpdateProfile(ctx context.Context, content []byte) (interface{}, error) {
+	var request ProfileUpdateRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID is required")
+	}
+	if request.Username == nil && request.Email == nil && request.DisplayName == nil && request.Bio == nil {
+		return nil, entities.ErrInvalidInput.Errorf("at least one of username, email, displayName or bio is required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.UpdateProfile(&command.UpdateProfileCommand{
+		UserID:      userID,
+		Username:    request.Username,
+		Email:       request.Email,
+		DisplayName: request.DisplayName,
+		Bio:         request.Bio,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in updating profile: %w", err)
+	}
+
+	return struct {
+		Status             string             `json:"status"`
+		User               *common.UserResult `json:"user"`
+		EmailChangePending bool               `json:"emailChangePending"`
+	}{
+		Status:             "success",
+		User:               result.Result,
+		EmailChangePending: result.EmailChangePending,
+	}, nil
+}
+
+// handleUploadAvatar stores a new avatar image sent base64 encoded
+func (h *TCPHandler) handleUploadAvatar(ctx context.Context, content []byte) (interface{}, error) {
+	var request ProfileAvatarRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || len(request.Image) == 0 {
+		return nil, entities.ErrInvalidInput.Errorf("userID and image are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.avatarService.UploadAvatar(&command.UploadAvatarCommand{
+		UserID: userID,
+		Image:  request.Image,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in uploading avatar: %w", err)
+	}
+
+	return struct {
+		Status    string            `json:"status"`
+		AvatarURL string            `json:"avatarURL"`
+		Variants  map[string]string `json:"variants"`
+	}{
+		Status:    "success",
+		AvatarURL: result.AvatarURL,
+		Variants:  result.Variants,
+	}, nil
+}
+
+// handleListDevices returns the devices a user has logged in from
+func (h *TCPHandler) handleListDevices(ctx context.Context, content []byte) (interface{}, error) {
+	var request DevicesListRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	userID, err := h.requestUserID(ctx, request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := h.userService.ListDevices(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error in listing devices: %w", err)
+	}
+
+	return struct {
+		Status  string      `json:"status"`
+		Devices interface{} `json:"devices"`
+	}{
+		Status:  "success",
+		Devices: result.Result,
+	}, nil
+}
+
+// handleLoginHistory returns the user's recent successful and failed logins
+func (h *TCPHandler) handleLoginHistory(ctx context.Context, content []byte) (interface{}, error) {
+	var request SecurityHistoryRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	userID, err := h.requestUserID(ctx, request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := h.userService.GetLoginHistory(&query.LoginHistoryQuery{
+		UserID: userID,
+		Limit:  request.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in listing login history: %w", err)
+	}
+
+	return struct {
+		Status string      `json:"status"`
+		Events interface{} `json:"events"`
+	}{
+		Status: "success",
+		Events: result.Result,
+	}, nil
+}
+
+// handleRevokeDevice forgets a device so its next login is challenged again
+func (h *TCPHandler) handleRevokeDevice(ctx context.Context, content []byte) (interface{}, error) {
+	var request DevicesRevokeRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.DeviceID == "" {
+		return nil, entities.ErrInvalidInput.Errorf("deviceID is required")
+	}
+
+	userID, err := h.requestUserID(ctx, request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	deviceID, err := uuid.Parse(request.DeviceID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid deviceID format: %v", err)
+	}
+
+	result, err := h.userService.RevokeDevice(&command.RevokeDeviceCommand{
+		UserID:   userID,
+		DeviceID: deviceID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in revoking device: %w", err)
+	}
+
+	return struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: result.Message,
+	}, nil
+}
+
+// handleJWKS returns the public keys tokens are signed with
+func (h *TCPHandler) handleJWKS(ctx context.Context, content []byte) (interface{}, error) {
+	result, err := h.userService.GetJWKS()
+	if err != nil {
+		return nil, fmt.Errorf("error in getting JWKS: %w", err)
+	}
+
+	return struct {
+		Status string              `json:"status"`
+		Keys   []*common.JWKResult `json:"keys"`
+	}{
+		Status: "success",
+		Keys:   result.Keys,
+	}, nil
+}
+
+// handleIntrospectToken tells a calling service whether a user token is still valid
+func (h *TCPHandler) handleIntrospectToken(ctx context.Context, content []byte) (interface{}, error) {
+	var request TokenIntrospectRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	if request.Token == "" {
+		return nil, entities.ErrInvalidInput.Errorf("token is required")
+	}
+
+	result, err := h.userService.IntrospectToken(&query.IntrospectTokenQuery{
+		Token:             request.Token,
+		DeviceFingerprint: request.DeviceFingerprint,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in introspecting token: %w", err)
+	}
+
+	response := struct {
+		Status      string   `json:"status"`
+		Active      bool     `json:"active"`
+		Account     string   `json:"accountStatus,omitempty"`
+		UserID      string   `json:"userID,omitempty"`
+		Scopes      []string `json:"scopes,omitempty"`
+		TokenType   string   `json:"tokenType,omitempty"`
+		DeviceBound bool     `json:"deviceBound,omitempty"`
+		IssuedAt    int64    `json:"iat,omitempty"`
+		ExpiresAt   int64    `json:"exp,omitempty"`
+		Revoked     bool     `json:"revoked"`
+	}{
+		Status:      "success",
+		Active:      result.Active,
+		Account:     result.Status,
+		Scopes:      result.Scopes,
+		TokenType:   result.TokenType,
+		DeviceBound: result.DeviceBound,
+		IssuedAt:    result.IssuedAt,
+		ExpiresAt:   result.ExpiresAt,
+		Revoked:     result.Revoked,
+	}
+	if result.UserID != uuid.Nil {
+		response.UserID = result.UserID.String()
+	}
+
+	return response, nil
+}
+
+// handleChangeUserStatus suspends, bans or reinstates a user
+func (h *TCPHandler) handleChangeUserStatus(ctx context.Context, content []byte) (interface{}, error) {
+	var request AdminUserStatusRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid request format: %v", err)
+	}
+
+	if request.UserID == "" || request.Status == "" {
+		return nil, entities.ErrInvalidInput.Errorf("userID and status are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.ChangeUserStatus(&command.ChangeUserStatusCommand{
+		UserID:    userID,
+		Status:    request.Status,
+		Reason:    request.Reason,
+		ChangedBy: callerName(ctx),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in changing user status: %w", err)
+	}
+
+	return struct {
+		Status string             `json:"status"`
+		User   *common.UserResult `json:"user"`
+	}{
+		Status: "success",
+		User:   result.User,
+	}, nil
+}
+
+// handleAcceptTerms records that a user accepted the published terms of service
+func (h *TCPHandler) handleAcceptTerms(ctx context.Context, content []byte) (interface{}, error) {
+	var request TermsAcceptRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	if request.UserID == "" || request.Version == 0 {
+		return nil, entities.ErrInvalidInput.Errorf("userID and version are required")
+	}
+
+	userID, err := uuid.Parse(request.UserID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+	}
+
+	result, err := h.userService.AcceptTerms(&command.AcceptTermsCommand{
+		UserID:  userID,
+		Version: request.Version,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in accepting terms: %w", err)
+	}
+
+	return struct {
+		Status       string `json:"status"`
+		TermsVersion int    `json:"termsVersion"`
+	}{
+		Status:       "success",
+		TermsVersion: result.TermsVersion,
+	}, nil
+}
@@ -0,0 +1,149 @@
+package tcp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the call latency histogram. Slower
+// calls fall into a last, unbounded bucket.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// methodStats counts the calls of one method and how long they took
+type methodStats struct {
+	calls   uint64
+	errors  uint64
+	maxNano int64    // Slowest call, reported for percentiles in the last bucket
+	buckets []uint64 // One per latencyBuckets entry plus the unbounded one
+}
+
+func newMethodStats() *methodStats {
+	return &methodStats{buckets: make([]uint64, len(latencyBuckets)+1)}
+}
+
+func (s *methodStats) observe(elapsed time.Duration, failed bool) {
+	atomic.AddUint64(&s.calls, 1)
+	if failed {
+		atomic.AddUint64(&s.errors, 1)
+	}
+
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if elapsed <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddUint64(&s.buckets[bucket], 1)
+
+	for {
+		max := atomic.LoadInt64(&s.maxNano)
+		if int64(elapsed) <= max || atomic.CompareAndSwapInt64(&s.maxNano, max, int64(elapsed)) {
+			break
+		}
+	}
+}
+
+// percentile estimates the latency q of the calls are faster than, as the
+// upper bound of the bucket holding that call
+func (s *methodStats) percentile(counts []uint64, total uint64, q float64) time.Duration {
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(q*float64(total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, count := range counts {
+		seen += count
+		if seen >= rank && i < len(latencyBuckets) {
+			return latencyBuckets[i]
+		}
+	}
+	return time.Duration(atomic.LoadInt64(&s.maxNano))
+}
+
+// snapshot reports the counters and latency percentiles in milliseconds
+func (s *methodStats) snapshot() map[string]interface{} {
+	counts := make([]uint64, len(s.buckets))
+	var total uint64
+	for i := range s.buckets {
+		counts[i] = atomic.LoadUint64(&s.buckets[i])
+		total += counts[i]
+	}
+
+	calls := atomic.LoadUint64(&s.calls)
+	errors := atomic.LoadUint64(&s.errors)
+	errorRate := 0.0
+	if calls > 0 {
+		errorRate = float64(errors) / float64(calls)
+	}
+
+	return map[string]interface{}{
+		"calls":     calls,
+		"errors":    errors,
+		"errorRate": errorRate,
+		"p50Ms":     durationMillis(s.percentile(counts, total, 0.50)),
+		"p95Ms":     durationMillis(s.percentile(counts, total, 0.95)),
+		"p99Ms":     durationMillis(s.percentile(counts, total, 0.99)),
+	}
+}
+
+// methodMetrics holds the statistics of every registered method. Unknown
+// methods are not tracked, so clients cannot grow it.
+type methodMetrics struct {
+	mu      sync.RWMutex
+	methods map[string]*methodStats
+}
+
+func newMethodMetrics() *methodMetrics {
+	return &methodMetrics{methods: make(map[string]*methodStats)}
+}
+
+// add starts tracking method, Register calls it
+func (m *methodMetrics) add(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.methods[method] = newMethodStats()
+}
+
+func (m *methodMetrics) get(method string) *methodStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.methods[method]
+}
+
+// snapshot reports the methods that have been called
+func (m *methodMetrics) snapshot() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	methods := make(map[string]interface{})
+	for method, stats := range m.methods {
+		if atomic.LoadUint64(&stats.calls) > 0 {
+			methods[method] = stats.snapshot()
+		}
+	}
+	return methods
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
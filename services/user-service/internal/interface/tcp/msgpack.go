@@ -0,0 +1,293 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// maxMsgpackDepth bounds the nesting of decoded MessagePack values
+const maxMsgpackDepth = 32
+
+var errMsgpackTruncated = errors.New("truncated MessagePack value")
+
+// msgpackDecoder reads the MessagePack subset that maps onto JSON: nil,
+// booleans, numbers, strings, arrays and maps with string keys. Binary
+// values are read as strings, extension types are rejected.
+type msgpackDecoder struct {
+	data []byte
+}
+
+func (d *msgpackDecoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, errMsgpackTruncated
+	}
+	value := d.data[:n]
+	d.data = d.data[n:]
+	return value, nil
+}
+
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.take(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("MessagePack value is nested too deeply")
+	}
+	head, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+	b := head[0]
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0xa0 && b <= 0xbf:
+		return d.str(int(b & 0x1f))
+	case b >= 0x90 && b <= 0x9f:
+		return d.array(int(b&0x0f), depth)
+	case b >= 0x80 && b <= 0x8f:
+		return d.object(int(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		value, err := d.uint(1 << (b - 0xcc))
+		return value, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		value, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend from the encoded width
+		shift := 64 - 8*size
+		return int64(value<<shift) >> shift, nil
+	case 0xca:
+		value, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(value))), err
+	case 0xcb:
+		value, err := d.uint(8)
+		return math.Float64frombits(value), err
+	case 0xd9, 0xc4:
+		return d.sizedStr(1)
+	case 0xda, 0xc5:
+		return d.sizedStr(2)
+	case 0xdb, 0xc6:
+		return d.sizedStr(4)
+	case 0xdc, 0xdd:
+		size, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(size), depth)
+	case 0xde, 0xdf:
+		size, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(int(size), depth)
+	}
+	return nil, fmt.Errorf("unsupported MessagePack type 0x%02x", b)
+}
+
+func (d *msgpackDecoder) sizedStr(lengthSize int) (interface{}, error) {
+	length, err := d.uint(lengthSize)
+	if err != nil {
+		return nil, err
+	}
+	return d.str(int(length))
+}
+
+func (d *msgpackDecoder) str(length int) (interface{}, error) {
+	b, err := d.take(length)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) array(length, depth int) (interface{}, error) {
+	// Every element takes at least a byte, longer claims are bogus
+	if length > len(d.data) {
+		return nil, errMsgpackTruncated
+	}
+	values := make([]interface{}, 0, length)
+	for i := 0; i < length; i++ {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (d *msgpackDecoder) object(length, depth int) (interface{}, error) {
+	if length > len(d.data)/2 {
+		return nil, errMsgpackTruncated
+	}
+	values := make(map[string]interface{}, length)
+	for i := 0; i < length; i++ {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, errors.New("MessagePack map keys must be strings")
+		}
+		if values[name], err = d.decode(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// msgpackToJSON converts a MessagePack document to JSON
+func msgpackToJSON(data []byte) ([]byte, error) {
+	d := &msgpackDecoder{data: data}
+	value, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.data) != 0 {
+		return nil, errors.New("trailing data after MessagePack value")
+	}
+	return json.Marshal(value)
+}
+
+// jsonToMsgpack converts a JSON document to MessagePack. Integral numbers
+// become integers, everything else a float64.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := unmarshalJSONNumbers(data, &value); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(make([]byte, 0, len(data)), value)
+}
+
+func appendMsgpack(b []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			b = append(b, 0xcf)
+			return binary.BigEndian.AppendUint64(b, u), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackStr(b, v), nil
+	case []interface{}:
+		b = appendMsgpackLength(b, len(v), 0x90, 0xdc)
+		for _, element := range v {
+			var err error
+			if b, err = appendMsgpack(b, element); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		// Sorted keys keep the encoding stable between calls
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b = appendMsgpackLength(b, len(v), 0x80, 0xde)
+		for _, key := range keys {
+			b = appendMsgpackStr(b, key)
+			var err error
+			if b, err = appendMsgpack(b, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as MessagePack", value)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(b, byte(i))
+	case i >= -32 && i < 0:
+		return append(b, byte(int8(i)))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b = append(b, 0xd2)
+		return binary.BigEndian.AppendUint32(b, uint32(int32(i)))
+	}
+	b = append(b, 0xd3)
+	return binary.BigEndian.AppendUint64(b, uint64(i))
+}
+
+func appendMsgpackStr(b []byte, s string) []byte {
+	switch {
+	case len(s) <= 31:
+		b = append(b, 0xa0|byte(len(s)))
+	case len(s) <= math.MaxUint8:
+		b = append(b, 0xd9, byte(len(s)))
+	case len(s) <= math.MaxUint16:
+		b = append(b, 0xda)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackLength writes an array or map header: the fix form below 16
+// entries, else the 16 or 32 bit form that follows wide in the type table
+func appendMsgpackLength(b []byte, length int, fix, wide byte) []byte {
+	switch {
+	case length <= 15:
+		return append(b, fix|byte(length))
+	case length <= math.MaxUint16:
+		b = append(b, wide)
+		return binary.BigEndian.AppendUint16(b, uint16(length))
+	}
+	b = append(b, wide+1)
+	return binary.BigEndian.AppendUint32(b, uint32(length))
+}
@@ -0,0 +1,484 @@
+package pb
+
+// decodeFields calls set for every field of data. set reports false for
+// fields the message does not declare, which are skipped.
+func decodeFields(data []byte, set func(d *decoder, field, wireType int) (bool, error)) error {
+	d := &decoder{data: data}
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		known, err := set(d, field, wireType)
+		if err != nil {
+			return err
+		}
+		if !known {
+			if err := d.skip(wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func stringField(d *decoder, wireType int, target *string) (bool, error) {
+	value, _, err := d.field(wireType, wireBytes)
+	*target = string(value)
+	return true, err
+}
+
+func boolField(d *decoder, wireType int, target *bool) (bool, error) {
+	_, value, err := d.field(wireType, wireVarint)
+	*target = value != 0
+	return true, err
+}
+
+func int64Field(d *decoder, wireType int, target *int64) (bool, error) {
+	_, value, err := d.field(wireType, wireVarint)
+	*target = int64(value)
+	return true, err
+}
+
+func messageField(d *decoder, wireType int, target Message) (bool, error) {
+	value, _, err := d.field(wireType, wireBytes)
+	if err != nil {
+		return true, err
+	}
+	return true, target.Unmarshal(value)
+}
+
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (m *PolicyViolation) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Code)
+	b = appendString(b, 2, m.Message)
+	return b
+}
+
+func (m *PolicyViolation) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.Code)
+		case 2:
+			return stringField(d, wireType, &m.Message)
+		}
+		return false, nil
+	})
+}
+
+type ValidationError struct {
+	Field      string             `json:"field"`
+	Violations []*PolicyViolation `json:"violations"`
+}
+
+func (m *ValidationError) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Field)
+	for _, violation := range m.Violations {
+		b = appendMessage(b, 2, violation)
+	}
+	return b
+}
+
+func (m *ValidationError) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.Field)
+		case 2:
+			violation := &PolicyViolation{}
+			m.Violations = append(m.Violations, violation)
+			return messageField(d, wireType, violation)
+		}
+		return false, nil
+	})
+}
+
+type User struct {
+	Id           string `json:"id"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	IsVerified   bool   `json:"is_verified"`
+	Status       string `json:"status"`
+	DisplayName  string `json:"display_name,omitempty"`
+	Bio          string `json:"bio,omitempty"`
+	AvatarURL    string `json:"avatar_url,omitempty"`
+	InvitedBy    string `json:"invited_by,omitempty"`
+	TermsVersion int64  `json:"terms_version,omitempty"`
+	IsGuest      bool   `json:"is_guest,omitempty"`
+}
+
+func (m *User) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Id)
+	b = appendString(b, 2, m.CreatedAt)
+	b = appendString(b, 3, m.UpdatedAt)
+	b = appendString(b, 4, m.Username)
+	b = appendString(b, 5, m.Email)
+	b = appendBool(b, 6, m.IsVerified)
+	b = appendString(b, 7, m.Status)
+	b = appendString(b, 8, m.DisplayName)
+	b = appendString(b, 9, m.Bio)
+	b = appendString(b, 10, m.AvatarURL)
+	b = appendString(b, 11, m.InvitedBy)
+	b = appendInt64(b, 12, m.TermsVersion)
+	b = appendBool(b, 13, m.IsGuest)
+	return b
+}
+
+func (m *User) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.Id)
+		case 2:
+			return stringField(d, wireType, &m.CreatedAt)
+		case 3:
+			return stringField(d, wireType, &m.UpdatedAt)
+		case 4:
+			return stringField(d, wireType, &m.Username)
+		case 5:
+			return stringField(d, wireType, &m.Email)
+		case 6:
+			return boolField(d, wireType, &m.IsVerified)
+		case 7:
+			return stringField(d, wireType, &m.Status)
+		case 8:
+			return stringField(d, wireType, &m.DisplayName)
+		case 9:
+			return stringField(d, wireType, &m.Bio)
+		case 10:
+			return stringField(d, wireType, &m.AvatarURL)
+		case 11:
+			return stringField(d, wireType, &m.InvitedBy)
+		case 12:
+			return int64Field(d, wireType, &m.TermsVersion)
+		case 13:
+			return boolField(d, wireType, &m.IsGuest)
+		}
+		return false, nil
+	})
+}
+
+// Response carries the fields every response message starts with
+type Response struct {
+	Status  string             `json:"status"`
+	Message string             `json:"message,omitempty"`
+	Errors  []*ValidationError `json:"errors,omitempty"`
+	Code    string             `json:"code,omitempty"` // Field 15, set on errors
+}
+
+func (m *Response) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Status)
+	b = appendString(b, 2, m.Message)
+	for _, validationErr := range m.Errors {
+		b = appendMessage(b, 3, validationErr)
+	}
+	b = appendString(b, 15, m.Code)
+	return b
+}
+
+func (m *Response) field(d *decoder, field, wireType int) (bool, error) {
+	switch field {
+	case 1:
+		return stringField(d, wireType, &m.Status)
+	case 2:
+		return stringField(d, wireType, &m.Message)
+	case 3:
+		validationErr := &ValidationError{}
+		m.Errors = append(m.Errors, validationErr)
+		return messageField(d, wireType, validationErr)
+	case 15:
+		return stringField(d, wireType, &m.Code)
+	}
+	return false, nil
+}
+
+type RegisterRequest struct {
+	Username             string `json:"username"`
+	Email                string `json:"email"`
+	Password             string `json:"password"`
+	Channel              string `json:"channel"`
+	InviteToken          string `json:"inviteToken"`
+	AcceptedTermsVersion int64  `json:"acceptedTermsVersion"`
+}
+
+func (m *RegisterRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Username)
+	b = appendString(b, 2, m.Email)
+	b = appendString(b, 3, m.Password)
+	b = appendString(b, 4, m.Channel)
+	b = appendString(b, 6, m.InviteToken)
+	b = appendInt64(b, 7, m.AcceptedTermsVersion)
+	return b
+}
+
+func (m *RegisterRequest) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.Username)
+		case 2:
+			return stringField(d, wireType, &m.Email)
+		case 3:
+			return stringField(d, wireType, &m.Password)
+		case 4:
+			return stringField(d, wireType, &m.Channel)
+		case 6:
+			return stringField(d, wireType, &m.InviteToken)
+		case 7:
+			return int64Field(d, wireType, &m.AcceptedTermsVersion)
+		}
+		return false, nil
+	})
+}
+
+type RegisterResponse struct {
+	Response
+	User *User `json:"user,omitempty"`
+}
+
+func (m *RegisterResponse) Marshal() []byte {
+	b := m.Response.marshal(nil)
+	if m.User != nil {
+		b = appendMessage(b, 4, m.User)
+	}
+	return b
+}
+
+func (m *RegisterResponse) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		if field == 4 {
+			m.User = &User{}
+			return messageField(d, wireType, m.User)
+		}
+		return m.Response.field(d, field, wireType)
+	})
+}
+
+type LoginRequest struct {
+	Username             string `json:"username"`
+	Password             string `json:"password"`
+	RecoveryCode         string `json:"recoveryCode"`
+	DeviceFingerprint    string `json:"deviceFingerprint"`
+	UserAgent            string `json:"userAgent"`
+	IPAddress            string `json:"ipAddress"`
+	Country              string `json:"country"`
+	AcceptedTermsVersion int64  `json:"acceptedTermsVersion"`
+}
+
+func (m *LoginRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Username)
+	b = appendString(b, 2, m.Password)
+	b = appendString(b, 3, m.RecoveryCode)
+	b = appendString(b, 4, m.DeviceFingerprint)
+	b = appendString(b, 5, m.UserAgent)
+	b = appendString(b, 6, m.IPAddress)
+	b = appendString(b, 7, m.Country)
+	b = appendInt64(b, 8, m.AcceptedTermsVersion)
+	return b
+}
+
+func (m *LoginRequest) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.Username)
+		case 2:
+			return stringField(d, wireType, &m.Password)
+		case 3:
+			return stringField(d, wireType, &m.RecoveryCode)
+		case 4:
+			return stringField(d, wireType, &m.DeviceFingerprint)
+		case 5:
+			return stringField(d, wireType, &m.UserAgent)
+		case 6:
+			return stringField(d, wireType, &m.IPAddress)
+		case 7:
+			return stringField(d, wireType, &m.Country)
+		case 8:
+			return int64Field(d, wireType, &m.AcceptedTermsVersion)
+		}
+		return false, nil
+	})
+}
+
+type LoginResponse struct {
+	Response
+	Token       string `json:"token,omitempty"`
+	ChallengeID string `json:"challengeId,omitempty"`
+	User        *User  `json:"user,omitempty"`
+}
+
+func (m *LoginResponse) Marshal() []byte {
+	b := m.Response.marshal(nil)
+	b = appendString(b, 4, m.Token)
+	b = appendString(b, 5, m.ChallengeID)
+	if m.User != nil {
+		b = appendMessage(b, 6, m.User)
+	}
+	return b
+}
+
+func (m *LoginResponse) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 4:
+			return stringField(d, wireType, &m.Token)
+		case 5:
+			return stringField(d, wireType, &m.ChallengeID)
+		case 6:
+			m.User = &User{}
+			return messageField(d, wireType, m.User)
+		}
+		return m.Response.field(d, field, wireType)
+	})
+}
+
+type ProfileRequest struct {
+	UserID      string `json:"userID"`
+	IfNoneMatch string `json:"ifNoneMatch"`
+}
+
+func (m *ProfileRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.UserID)
+	b = appendString(b, 2, m.IfNoneMatch)
+	return b
+}
+
+func (m *ProfileRequest) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.UserID)
+		case 2:
+			return stringField(d, wireType, &m.IfNoneMatch)
+		}
+		return false, nil
+	})
+}
+
+type ProfileResponse struct {
+	Response
+	User *User  `json:"user,omitempty"`
+	ETag string `json:"etag"`
+}
+
+func (m *ProfileResponse) Marshal() []byte {
+	b := m.Response.marshal(nil)
+	if m.User != nil {
+		b = appendMessage(b, 4, m.User)
+	}
+	b = appendString(b, 5, m.ETag)
+	return b
+}
+
+func (m *ProfileResponse) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 4:
+			m.User = &User{}
+			return messageField(d, wireType, m.User)
+		case 5:
+			return stringField(d, wireType, &m.ETag)
+		}
+		return m.Response.field(d, field, wireType)
+	})
+}
+
+type VerifyOTPRequest struct {
+	Email string `json:"email"`
+	OTP   string `json:"otp"`
+}
+
+func (m *VerifyOTPRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Email)
+	b = appendString(b, 2, m.OTP)
+	return b
+}
+
+func (m *VerifyOTPRequest) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.Email)
+		case 2:
+			return stringField(d, wireType, &m.OTP)
+		}
+		return false, nil
+	})
+}
+
+type VerifyOTPResponse struct {
+	Response
+	User *User `json:"user,omitempty"`
+}
+
+func (m *VerifyOTPResponse) Marshal() []byte {
+	b := m.Response.marshal(nil)
+	if m.User != nil {
+		b = appendMessage(b, 4, m.User)
+	}
+	return b
+}
+
+func (m *VerifyOTPResponse) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		if field == 4 {
+			m.User = &User{}
+			return messageField(d, wireType, m.User)
+		}
+		return m.Response.field(d, field, wireType)
+	})
+}
+
+type SendOTPRequest struct {
+	Email string `json:"email"`
+}
+
+func (m *SendOTPRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Email)
+	return b
+}
+
+func (m *SendOTPRequest) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, wireType, &m.Email)
+		}
+		return false, nil
+	})
+}
+
+type SendOTPResponse struct {
+	Response
+	RemainingResends int64 `json:"remainingResends,omitempty"`
+}
+
+func (m *SendOTPResponse) Marshal() []byte {
+	b := m.Response.marshal(nil)
+	return appendInt64(b, 4, m.RemainingResends)
+}
+
+func (m *SendOTPResponse) Unmarshal(data []byte) error {
+	return decodeFields(data, func(d *decoder, field, wireType int) (bool, error) {
+		if field == 4 {
+			return int64Field(d, wireType, &m.RemainingResends)
+		}
+		return m.Response.field(d, field, wireType)
+	})
+}
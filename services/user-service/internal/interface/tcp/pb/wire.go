@@ -0,0 +1,146 @@
+// Package pb holds the protobuf messages of the TCP protocol described in
+// user_service.proto. The protobuf runtime is not a dependency of this
+// service, so the messages encode the wire format themselves.
+package pb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Message is a protobuf message that can be encoded and decoded. The json
+// tags of each message match the JSON payload of its method.
+type Message interface {
+	Marshal() []byte
+	Unmarshal(data []byte) error
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendBytes(b []byte, field int, value []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// Scalars with their zero value are left out, as proto3 does
+
+func appendString(b []byte, field int, value string) []byte {
+	if value == "" {
+		return b
+	}
+	return appendBytes(b, field, []byte(value))
+}
+
+func appendBool(b []byte, field int, value bool) []byte {
+	if !value {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return append(b, 1)
+}
+
+func appendInt64(b []byte, field int, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(value))
+}
+
+func appendMessage(b []byte, field int, message Message) []byte {
+	return appendBytes(b, field, message.Marshal())
+}
+
+// decoder walks the fields of an encoded message
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) done() bool {
+	return len(d.data) == 0
+}
+
+// next reads the tag of the next field
+func (d *decoder) next() (int, int, error) {
+	tag, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	field := int(tag >> 3)
+	if field == 0 {
+		return 0, 0, errors.New("invalid protobuf field number 0")
+	}
+	return field, int(tag & 7), nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	value, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.data = d.data[n:]
+	return value, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	length, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(d.data)) {
+		return nil, errTruncated
+	}
+	value := d.data[:length]
+	d.data = d.data[length:]
+	return value, nil
+}
+
+// skip passes over a field this message does not know
+func (d *decoder) skip(wireType int) error {
+	switch wireType {
+	case wireVarint:
+		_, err := d.varint()
+		return err
+	case wireBytes:
+		_, err := d.bytes()
+		return err
+	case wireFixed64, wireFixed32:
+		size := 8
+		if wireType == wireFixed32 {
+			size = 4
+		}
+		if len(d.data) < size {
+			return errTruncated
+		}
+		d.data = d.data[size:]
+		return nil
+	}
+	return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+}
+
+// field reads the value of a known field, checking it has the wire type its
+// declaration gives it
+func (d *decoder) field(wireType, want int) ([]byte, uint64, error) {
+	if wireType != want {
+		return nil, 0, fmt.Errorf("protobuf wire type %d where %d was expected", wireType, want)
+	}
+	if wireType == wireBytes {
+		value, err := d.bytes()
+		return value, 0, err
+	}
+	value, err := d.varint()
+	return nil, value, err
+}
@@ -0,0 +1,355 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/infrastructure"
+)
+
+const (
+	// maxSubscriptionsPerConnection caps events.subscribe calls per connection
+	maxSubscriptionsPerConnection = 16
+	// eventBufferSize is how many events may wait for delivery to clients
+	eventBufferSize = 1024
+)
+
+// pushEventTypes are the events clients can subscribe to
+var pushEventTypes = []string{
+	entities.UserEventTokenRevoked,
+	entities.UserEventProfileChanged,
+	entities.UserEventLoginSucceeded,
+	entities.UserEventOTPExpiring,
+}
+
+// pushSubscription delivers events of some types, optionally for some users
+// only, to the connection that subscribed
+type pushSubscription struct {
+	id      uuid.UUID
+	conn    net.Conn
+	writer  *connWriter
+	cipher  *frameCipher // Encrypts the pushes of an encrypted connection
+	events  map[string]bool
+	userIDs map[uuid.UUID]bool // Empty for every user
+	// boundUser is the user the connection was bound to when it subscribed,
+	// uuid.Nil for services. Such a subscription only gets that user's events
+	// and ends when the connection stops being bound to the user.
+	boundUser uuid.UUID
+}
+
+func (s *pushSubscription) matches(event entities.UserEvent) bool {
+	if !s.events[event.Type] {
+		return false
+	}
+	return len(s.userIDs) == 0 || s.userIDs[event.UserId]
+}
+
+// pushRegistry holds the subscriptions of every open connection, indexed by
+// the users they follow so an event only visits its user's subscriptions and
+// those following everyone
+type pushRegistry struct {
+	mu            sync.RWMutex
+	subscriptions map[uuid.UUID]*pushSubscription
+	byUser        map[uuid.UUID]map[uuid.UUID]*pushSubscription
+	allUsers      map[uuid.UUID]*pushSubscription
+}
+
+func newPushRegistry() *pushRegistry {
+	return &pushRegistry{
+		subscriptions: make(map[uuid.UUID]*pushSubscription),
+		byUser:        make(map[uuid.UUID]map[uuid.UUID]*pushSubscription),
+		allUsers:      make(map[uuid.UUID]*pushSubscription),
+	}
+}
+
+func (r *pushRegistry) add(subscription *pushSubscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, existing := range r.subscriptions {
+		if existing.conn == subscription.conn {
+			count++
+		}
+	}
+	if count >= maxSubscriptionsPerConnection {
+		return entities.ErrRateLimited.Errorf("at most %d subscriptions are allowed per connection", maxSubscriptionsPerConnection)
+	}
+
+	r.subscriptions[subscription.id] = subscription
+	if len(subscription.userIDs) == 0 {
+		r.allUsers[subscription.id] = subscription
+	}
+	for userID := range subscription.userIDs {
+		if r.byUser[userID] == nil {
+			r.byUser[userID] = make(map[uuid.UUID]*pushSubscription)
+		}
+		r.byUser[userID][subscription.id] = subscription
+	}
+	return nil
+}
+
+// delete drops subscription from the indexes, with r.mu held
+func (r *pushRegistry) delete(subscription *pushSubscription) {
+	delete(r.subscriptions, subscription.id)
+	delete(r.allUsers, subscription.id)
+	for userID := range subscription.userIDs {
+		delete(r.byUser[userID], subscription.id)
+		if len(r.byUser[userID]) == 0 {
+			delete(r.byUser, userID)
+		}
+	}
+}
+
+// remove drops a subscription made on conn, reporting whether there was one
+func (r *pushRegistry) remove(conn net.Conn, id uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscription, ok := r.subscriptions[id]
+	if !ok || subscription.conn != conn {
+		return false
+	}
+	r.delete(subscription)
+	return true
+}
+
+func (r *pushRegistry) removeConnection(conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, subscription := range r.subscriptions {
+		if subscription.conn == conn {
+			r.delete(subscription)
+		}
+	}
+}
+
+// removeBoundUser drops the subscriptions connections made while bound to
+// userID
+func (r *pushRegistry) removeBoundUser(userID uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, subscription := range r.byUser[userID] {
+		if subscription.boundUser == userID {
+			r.delete(subscription)
+		}
+	}
+}
+
+func (r *pushRegistry) matching(event entities.UserEvent) []*pushSubscription {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*pushSubscription
+	for _, subscription := range r.byUser[event.UserId] {
+		if subscription.matches(event) {
+			matches = append(matches, subscription)
+		}
+	}
+	for _, subscription := range r.allUsers {
+		if subscription.matches(event) {
+			matches = append(matches, subscription)
+		}
+	}
+	return matches
+}
+
+func (r *pushRegistry) count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.subscriptions)
+}
+
+// clientConnection is the connection a request arrived on and the protocol
+// version the client spoke, for methods that write to it later
+type clientConnection struct {
+	conn    net.Conn
+	writer  *connWriter
+	version byte
+	cipher  *frameCipher // Set when the connection encrypts its frames
+}
+
+type connectionKey struct{}
+
+func withConnection(ctx context.Context, w *connWriter, version byte, cipher *frameCipher) context.Context {
+	return context.WithValue(ctx, connectionKey{}, &clientConnection{conn: w.conn, writer: w, version: version, cipher: cipher})
+}
+
+func connectionFromContext(ctx context.Context) *clientConnection {
+	connection, _ := ctx.Value(connectionKey{}).(*clientConnection)
+	return connection
+}
+
+// forwardEvents pushes the events published on the bus to the subscribed
+// connections, and unbinds connections from users whose tokens were revoked,
+// until the server stops
+func (h *TCPHandler) forwardEvents(subscription *infrastructure.EventSubscription) {
+	defer h.wg.Done()
+	defer subscription.Close()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case event := <-subscription.Events():
+			for _, pushSub := range h.subscriptions.matching(event) {
+				if pushSub.boundUser != uuid.Nil && !h.stillBound(pushSub) {
+					h.subscriptions.remove(pushSub.conn, pushSub.id)
+					continue
+				}
+				h.push(pushSub, event)
+			}
+			// Connections authenticated with a revoked token lose their user,
+			// and the subscriptions they made as the user, once told
+			if event.Type == entities.UserEventTokenRevoked {
+				h.identities.revokeUser(event.UserId)
+				h.subscriptions.removeBoundUser(event.UserId)
+			}
+		}
+	}
+}
+
+// stillBound reports whether the connection of a subscription made by a user
+// is still bound to that user, with an unexpired token
+func (h *TCPHandler) stillBound(subscription *pushSubscription) bool {
+	identity := h.identities.get(subscription.conn)
+	return identity != nil && identity.userID == subscription.boundUser && !identity.expired()
+}
+
+// push writes event to the subscription's connection as a push frame
+func (h *TCPHandler) push(subscription *pushSubscription, event entities.UserEvent) {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling %s event: %v", event.Type, err)
+		return
+	}
+
+	frame := createPushFrame(subscription.id, jsonData, subscription.cipher)
+
+	// A client too slow to take its events loses its subscriptions, and its
+	// connection, instead of delaying everyone else's events
+	if err := subscription.writer.write(frame); err != nil {
+		log.Printf("Error pushing %s event to %s, dropping its subscriptions: %v", event.Type, subscription.conn.RemoteAddr(), err)
+		h.subscriptions.removeConnection(subscription.conn)
+	}
+}
+
+// createPushFrame builds a version 3 frame with the push flag set. It carries
+// the subscription ID where responses carry the request ID, and is encrypted
+// with cipher unless that is nil.
+func createPushFrame(subscriptionID uuid.UUID, jsonData []byte, cipher *frameCipher) []byte {
+	var extensions []byte
+	if cipher != nil {
+		jsonData, extensions = cipher.seal(subscriptionID[:], jsonData)
+	}
+	return appendV3Frame(rentBuffer(), subscriptionID[:], flagsPush, extensions, jsonData)
+}
+
+// handleSubscribe starts pushing events to the calling connection
+func (h *TCPHandler) handleSubscribe(ctx context.Context, content []byte) (interface{}, error) {
+	var request EventsSubscribeRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	connection := connectionFromContext(ctx)
+	if connection == nil {
+		return nil, entities.ErrInvalidInput.Errorf("subscriptions need a connection")
+	}
+	if connection.version != protocolVersion3 {
+		return nil, entities.ErrInvalidInput.Errorf("subscriptions need protocol version 3, pushes are version 3 frames")
+	}
+
+	// A connection bound to a user follows that user, and no one else
+	identity, err := h.boundIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		for _, rawUserID := range request.UserIDs {
+			if rawUserID != identity.userID.String() {
+				return nil, entities.ErrForbidden.Errorf("userIDs may only name the authenticated user")
+			}
+		}
+		request.UserIDs = []string{identity.userID.String()}
+	}
+
+	if len(request.Events) == 0 {
+		request.Events = pushEventTypes
+	}
+	subscription := &pushSubscription{
+		id:      uuid.New(),
+		conn:    connection.conn,
+		writer:  connection.writer,
+		cipher:  connection.cipher,
+		events:  make(map[string]bool),
+		userIDs: make(map[uuid.UUID]bool),
+	}
+	if identity != nil {
+		subscription.boundUser = identity.userID
+	}
+	for _, eventType := range request.Events {
+		known := false
+		for _, pushEventType := range pushEventTypes {
+			known = known || pushEventType == eventType
+		}
+		if !known {
+			return nil, entities.ErrInvalidInput.Errorf("unknown event type: %s", eventType)
+		}
+		subscription.events[eventType] = true
+	}
+	for _, rawUserID := range request.UserIDs {
+		userID, err := uuid.Parse(rawUserID)
+		if err != nil {
+			return nil, entities.ErrInvalidInput.Errorf("invalid userID format: %v", err)
+		}
+		subscription.userIDs[userID] = true
+	}
+
+	if err := h.subscriptions.add(subscription); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Status         string   `json:"status"`
+		SubscriptionID string   `json:"subscriptionID"`
+		Events         []string `json:"events"`
+	}{
+		Status:         "success",
+		SubscriptionID: subscription.id.String(),
+		Events:         request.Events,
+	}, nil
+}
+
+// handleUnsubscribe stops a subscription made on the calling connection
+func (h *TCPHandler) handleUnsubscribe(ctx context.Context, content []byte) (interface{}, error) {
+	var request EventsUnsubscribeRequest
+
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid input data: %v", err)
+	}
+
+	subscriptionID, err := uuid.Parse(request.SubscriptionID)
+	if err != nil {
+		return nil, entities.ErrInvalidInput.Errorf("invalid subscriptionID format: %v", err)
+	}
+
+	connection := connectionFromContext(ctx)
+	if connection == nil || !h.subscriptions.remove(connection.conn, subscriptionID) {
+		return nil, entities.ErrNotFound.Errorf("subscription not found")
+	}
+
+	return struct {
+		Status string `json:"status"`
+	}{
+		Status: "success",
+	}, nil
+}
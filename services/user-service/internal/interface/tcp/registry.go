@@ -0,0 +1,128 @@
+package tcp
+
+//go:generate go run ../../../cmd/tcpgen -schema methods.json -server methods_gen.go -client client/client_gen.go
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+// MethodHandler serves one method. It gets the request content as JSON,
+// whatever encoding the frame used, and returns the value to answer with.
+type MethodHandler func(ctx context.Context, content []byte) (interface{}, error)
+
+// Middleware wraps the handler of a method, e.g. to check or log its requests
+type Middleware func(MethodHandler) MethodHandler
+
+// maxAPIVersion is the newest version of the method payloads. A request
+// picks the version by prefixing the method name, "v2/profile" for
+// instance, like the /v2 paths of the REST API. Unprefixed names are
+// version 1, and a method without a handler for the version asked for is
+// served by its newest earlier one, so clients can move every call to v2
+// while only some methods changed shape.
+const maxAPIVersion = 2
+
+// splitMethod splits a method name into its unversioned name and version
+func splitMethod(method string) (string, int, error) {
+	prefix, name, versioned := strings.Cut(method, "/")
+	if !versioned {
+		return method, 1, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(prefix, "v"))
+	if !strings.HasPrefix(prefix, "v") || err != nil || version < 1 || name == "" {
+		return "", 0, entities.ErrInvalidInput.Errorf("invalid versioned method name: %s", method)
+	}
+	if version > maxAPIVersion {
+		return "", 0, entities.ErrNotFound.Errorf("unsupported API version %d, the server supports up to %d", version, maxAPIVersion)
+	}
+	return name, version, nil
+}
+
+// methodName is method without its version prefix
+func methodName(method string) string {
+	name, _, err := splitMethod(method)
+	if err != nil {
+		return method
+	}
+	return name
+}
+
+// versionedMethod is the registered name of version of method
+func versionedMethod(method string, version int) string {
+	if version <= 1 {
+		return method
+	}
+	return "v" + strconv.Itoa(version) + "/" + method
+}
+
+// Register serves method with handler, wrapped in middleware with the first
+// one outermost. Methods listed in privilegedMethods are authorized before
+// the middleware runs, whatever their version. Registering a method twice
+// panics.
+func (h *TCPHandler) Register(method string, handler MethodHandler, middleware ...Middleware) {
+	if method == "" || len(method) > 255 {
+		panic(fmt.Sprintf("tcp: invalid method name %q", method))
+	}
+	name, version, err := splitMethod(method)
+	if err != nil {
+		panic(fmt.Sprintf("tcp: invalid method name %q: %v", method, err))
+	}
+	if handler == nil {
+		panic("tcp: nil handler for " + method)
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	method = versionedMethod(name, version)
+	h.methodsMu.Lock()
+	defer h.methodsMu.Unlock()
+	if _, exists := h.methods[method]; exists {
+		panic("tcp: method registered twice: " + method)
+	}
+	h.methods[method] = handler
+	h.methodMetrics.add(method)
+}
+
+// lookupMethod finds the handler of version of method, or of its newest
+// earlier version, and returns the version it serves
+func (h *TCPHandler) lookupMethod(method string, version int) (MethodHandler, int, bool) {
+	h.methodsMu.RLock()
+	defer h.methodsMu.RUnlock()
+	for ; version >= 1; version-- {
+		if handler, ok := h.methods[versionedMethod(method, version)]; ok {
+			return handler, version, true
+		}
+	}
+	return nil, 0, false
+}
+
+// Call runs method as if it had arrived in a frame carrying apiKey, for the
+// other transports that serve the TCP methods. It goes through the same
+// interceptors, gets its own request and trace IDs, and has handlerTimeout
+// unless ctx ends sooner.
+func (h *TCPHandler) Call(ctx context.Context, method, apiKey string, content []byte) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+	defer cancel()
+
+	requestID, traceID := uuid.New(), uuid.New()
+	ctx = withCall(ctx, method, requestID[:], traceID[:])
+	return h.dispatch(ctx, method, apiKey, content)
+}
+
+// handlePing answers a liveness check with the server time in milliseconds
+func (h *TCPHandler) handlePing(ctx context.Context, content []byte) (interface{}, error) {
+	return struct {
+		Status string `json:"status"`
+		Pong   int64  `json:"pong"`
+	}{
+		Status: "success",
+		Pong:   time.Now().UnixNano() / int64(time.Millisecond),
+	}, nil
+}
@@ -0,0 +1,108 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"user-service-new/internal/domain/entities"
+)
+
+const (
+	// replayNonceSize is the size of the random part of the replay extension
+	replayNonceSize = 16
+	// replayExtensionSize is the size of the replay extension value: the
+	// little endian Unix time in milliseconds, then the nonce
+	replayExtensionSize = 8 + replayNonceSize
+	// replayCheckTimeout bounds the Redis call of a replay check
+	replayCheckTimeout = time.Second
+)
+
+// credentialMethods carry credentials, and so do frames carrying an API key.
+// With replay protection required they are refused on connections that are
+// neither encrypted nor TLS.
+var credentialMethods = map[string]bool{
+	"register":     true,
+	"verify":       true,
+	"login":        true,
+	"login.verify": true,
+	"auth":         true,
+}
+
+// NonceStore claims a key for a while and reports false if it was claimed
+// already. RedisService implements it, so every instance shares the nonces.
+type NonceStore interface {
+	AcquireCooldown(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// EnableReplayProtection checks the replay extension of requests against
+// store. A request is accepted once within window of the time it carries; with
+// required set, frames carrying credentials are refused unless they come on an
+// encrypted or TLS connection.
+func (h *TCPHandler) EnableReplayProtection(store NonceStore, window time.Duration, required bool) {
+	h.nonces = store
+	h.replayWindow = window
+	h.replayRequired = required
+}
+
+// checkReplay refuses a request that was seen before or is too old, and with
+// replay protection required one that carries credentials on an unprotected
+// connection. Nothing binds the replay extension to the rest of a frame, so on
+// a plain connection whoever captured a frame can give it a fresh nonce: only
+// the counters of encrypted connections and TLS keep captured frames from
+// being replayed, and the extension merely refuses frames sent twice as is.
+func (h *TCPHandler) checkReplay(w *connWriter, request *frame) error {
+	if h.nonces == nil {
+		return nil
+	}
+	if h.replayRequired && !protectedConnection(w, request) && carriesCredentials(request) {
+		return entities.ErrInvalidInput.Errorf("credentials need an encrypted or TLS connection")
+	}
+	value, ok := request.extensions[extensionReplayNonce]
+	if !ok {
+		return nil
+	}
+	if len(value) != replayExtensionSize {
+		return entities.ErrInvalidInput.Errorf("invalid replay extension: %d bytes", len(value))
+	}
+
+	sent := time.UnixMilli(int64(binary.LittleEndian.Uint64(value)))
+	if age := time.Since(sent); age > h.replayWindow || age < -h.replayWindow {
+		return entities.ErrInvalidInput.Errorf("request time is outside the replay window")
+	}
+
+	// A nonce is kept as long as a frame carrying it could still be in the
+	// window, from its earliest to its latest accepted arrival
+	ctx, cancel := context.WithTimeout(context.Background(), replayCheckTimeout)
+	defer cancel()
+	fresh, err := h.nonces.AcquireCooldown(ctx, "replay:"+hex.EncodeToString(value[8:]), 2*h.replayWindow)
+	if err != nil {
+		log.Printf("Error checking replay nonce: %v", err)
+		return entities.ErrUnavailable.Errorf("replay check unavailable, try again later")
+	}
+	if !fresh {
+		atomic.AddUint64(&h.metrics.replayedRequests, 1)
+		return entities.ErrConflict.Errorf("replayed request")
+	}
+	return nil
+}
+
+// protectedConnection reports whether request came on a connection whose
+// frames cannot be captured and sent again
+func protectedConnection(w *connWriter, request *frame) bool {
+	if request.cipher != nil {
+		return true
+	}
+	_, ok := w.conn.(*tls.Conn)
+	return ok
+}
+
+// carriesCredentials reports whether request carries an API key or a method
+// of credentialMethods
+func carriesCredentials(request *frame) bool {
+	return request.apiKey != "" || credentialMethods[methodName(request.method)]
+}
@@ -0,0 +1,35 @@
+package tcp
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// headerDeadline is when the header of a frame whose first byte has arrived
+// has to be complete, zero when frameHeaderTimeout is disabled
+func (h *TCPHandler) headerDeadline() time.Time {
+	if h.frameHeaderTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(h.frameHeaderTimeout)
+}
+
+// contentDeadline is when contentLen bytes of content have to be complete:
+// frameHeaderTimeout for the first bytes, plus the time the content takes at
+// minReadRate. A disabled minReadRate leaves the content without a deadline.
+func (h *TCPHandler) contentDeadline(contentLen int) time.Time {
+	if h.minReadRate <= 0 {
+		return time.Time{}
+	}
+	transfer := time.Duration(contentLen) * time.Second / time.Duration(h.minReadRate)
+	return time.Now().Add(h.frameHeaderTimeout + transfer)
+}
+
+// closeSlowClient counts a client that failed to send a started frame in
+// time. The caller returns and so closes the connection.
+func (h *TCPHandler) closeSlowClient(conn net.Conn) {
+	atomic.AddUint64(&h.metrics.slowClients, 1)
+	log.Printf("Closing connection from %s, it is sending a frame too slowly", conn.RemoteAddr())
+}
@@ -1,9 +1,11 @@
 package tcp
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +16,8 @@ import (
 	"time"
 
 	"user-service-new/internal/application/interfaces"
+	"user-service-new/internal/domain/entities"
+	"user-service-new/internal/infrastructure"
 	"golang.org/x/time/rate"
 )
 
@@ -22,10 +26,12 @@ const (
 	magicByte1      = 0x55 // 'U'
 	magicByte2      = 0x57 // 'W'
 	protocolVersion = 0x01 // Version 1
+	protocolVersion2 = 0x02 // Version 2, adds the API key header field
 	headerSize      = 2    // Magic bytes
 	versionSize     = 1    // Protocol version
 	uuidSize        = 16   // Request ID
 	methodLenSize   = 1    // Method name length
+	apiKeyLenSize   = 1    // API key length (version 2 only)
 	contentLenSize  = 4    // Content length
 	
 	// Performance settings
@@ -34,6 +40,7 @@ const (
 	rateLimitRequests     = 5000 // Requests per second
 	rateLimitBurst        = 1000 // Burst capacity
 	maxBufferSize         = 10 * 1024 * 1024 // 10MB max buffer size
+	readBufferSize        = 16384            // Buffered reader size per connection
 	
 	// Worker pool settings
 	workerPoolSize       = 100 // Number of worker goroutines
@@ -43,23 +50,61 @@ const (
 
 // Message represents a work item for processing
 type Message struct {
-	conn      net.Conn
-	data      []byte
+	writer    *connWriter // Writes to the connection the request arrived on
+	frame     *frame      // Decoded request
 	timestamp time.Time
+	deadline  time.Time // When the client stops waiting, zero without a timeout extension
+	peer      *Caller // Identity from the connection's client certificate, if any
 }
 
 // TCPHandler manages TCP binary message processing
 type TCPHandler struct {
 	userService       interfaces.UserService
-	bufferPool        sync.Pool // Buffer pool for reuse
+	apiKeyService     interfaces.APIKeyService
+	avatarService     interfaces.AvatarService
+	inviteService     interfaces.InviteService
+	adminService      interfaces.AdminService
+	readerPool        sync.Pool // Buffered connection readers for reuse
 	activeRequests    int32     // Atomic counter for active requests
 	limiter           *rate.Limiter
 	metrics           *Metrics
 	listener          net.Listener
 	done              chan struct{}
+	draining          chan struct{} // Closed when Stop begins, new frames are refused from then on
 	wg                sync.WaitGroup
 	messageQueue      chan Message // Queue for message processing
+	priorityQueue     chan Message // High priority messages, drained before messageQueue
+	batchQueue        chan *batchTask // Sub-requests of batches, offered to idle workers
 	connectionSemaphore chan struct{} // Semaphore for connection limiting
+	ipLimiter           *ipConnectionLimiter // Caps the connections of a single source IP
+	tlsConfig           *tls.Config
+	requireClientCert   bool // Privileged methods need a client certificate, an API key alone is not enough
+	certIdentities      CertificateIdentities
+	codecs              map[byte]payloadCodec // Payload codecs by the encoding in the frame flags
+	encryptionKeys      EncryptionKeys        // Pre-shared keys of the callers that may encrypt frames
+	events              *infrastructure.EventBus
+	subscriptions       *pushRegistry // Push subscriptions of open connections
+	identities          *identityRegistry // Users that connections authenticated as with "auth"
+	heartbeatInterval   time.Duration // Quiet time before a version 3 client is sent a heartbeat
+	idleTimeout         time.Duration // Quiet time before a connection is closed
+	helloRequired       bool          // Connections must open with an authenticated hello
+	methods             map[string]MethodHandler // Registered methods, see Register
+	methodsMu           sync.RWMutex
+	interceptors        []Middleware  // Added with Use, wrap every method call
+	slowCallThreshold   time.Duration // Calls taking longer are logged
+	connections         *connectionSet // Open connections, notified and closed by Stop
+	drainTimeout        time.Duration  // How long Stop waits for queued requests
+	maxRequestTimeout   time.Duration  // Upper bound of the timeouts clients ask for
+	frameHeaderTimeout  time.Duration  // Time to receive a frame header once it has started
+	minReadRate         int            // Bytes per second frame content has to arrive at
+	methodMetrics       *methodMetrics // Calls, errors and latencies of each method
+	nonces              NonceStore     // Replay nonces seen recently, nil without replay protection
+	replayWindow        time.Duration  // How far the time of a request may be off
+	replayRequired      bool           // Frames carrying credentials need the replay extension
+	adminServices       map[string]bool // Callers allowed the admin methods, empty for every authenticated caller
+	healthChecker       *infrastructure.HealthChecker // Dependencies the health method reports, nil for none
+	circuitBreakers     map[string]func() infrastructure.CircuitBreakerStats // Breakers GetMetrics reports, by name
+	cacheMetrics        func() map[string]interface{} // Cache statistics GetMetrics reports, nil for none
 }
 
 // Metrics tracks performance data
@@ -68,18 +113,32 @@ type Metrics struct {
 	successfulRequests uint64
 	failedRequests     uint64
 	totalLatency       int64 // Nanoseconds
+	openConnections    int64
+	reapedConnections  uint64 // Closed for missing heartbeats or idling
+	evictedConnections uint64 // Closed for not reading their responses
+	deniedConnections  uint64 // Refused for exceeding the per-IP cap
+	methodCalls        uint64 // Handler calls, batch sub-requests included
+	methodErrors       uint64
+	handlerPanics      uint64
+	expiredRequests    uint64 // Dropped from the queue past their deadline
+	slowClients        uint64 // Closed for sending a frame too slowly
+	replayedRequests   uint64 // Refused for reusing a replay nonce
 	avgLatency         int64 // Exponential moving average (updated atomically)
 	startTime          time.Time
 }
 
 // NewTCPHandler creates a new TCP binary message handler
-func NewTCPHandler(userService interfaces.UserService) *TCPHandler {
+func NewTCPHandler(userService interfaces.UserService, apiKeyService interfaces.APIKeyService, avatarService interfaces.AvatarService, inviteService interfaces.InviteService, adminService interfaces.AdminService, events *infrastructure.EventBus) *TCPHandler {
 	h := &TCPHandler{
-		userService: userService,
-		bufferPool: sync.Pool{
+		userService:   userService,
+		apiKeyService: apiKeyService,
+		avatarService: avatarService,
+		inviteService: inviteService,
+		adminService:  adminService,
+		readerPool: sync.Pool{
 			New: func() interface{} {
-				// Pre-allocate buffers of 4KB
-				return make([]byte, 0, 4096)
+				// Large enough that most frames arrive in a single read
+				return bufio.NewReaderSize(nil, readBufferSize)
 			},
 		},
 		limiter: rate.NewLimiter(rate.Limit(rateLimitRequests), rateLimitBurst),
@@ -87,13 +146,42 @@ func NewTCPHandler(userService interfaces.UserService) *TCPHandler {
 			startTime: time.Now(),
 		},
 		done:                make(chan struct{}),
+		draining:            make(chan struct{}),
 		messageQueue:        make(chan Message, messageQueueSize),
+		priorityQueue:       make(chan Message, messageQueueSize),
+		batchQueue:          make(chan *batchTask, messageQueueSize),
 		connectionSemaphore: make(chan struct{}, connectionPoolSize),
+		ipLimiter:           newIPConnectionLimiter(infrastructure.GetEnvAsInt("TCP_MAX_CONNECTIONS_PER_IP", connectionPoolSize/4)),
+		codecs:              defaultCodecs(),
+		events:              events,
+		subscriptions:       newPushRegistry(),
+		identities:          newIdentityRegistry(),
+		heartbeatInterval:   infrastructure.GetEnvAsDuration("TCP_HEARTBEAT_INTERVAL", 30*time.Second),
+		idleTimeout:         infrastructure.GetEnvAsDuration("TCP_IDLE_TIMEOUT", 90*time.Second),
+		helloRequired:       infrastructure.GetEnvAsBool("TCP_HELLO_REQUIRED", false),
+		connections:         newConnectionSet(),
+		methods:             make(map[string]MethodHandler),
+		methodMetrics:       newMethodMetrics(),
+		slowCallThreshold:   infrastructure.GetEnvAsDuration("TCP_SLOW_CALL_THRESHOLD", time.Second),
+		drainTimeout:        infrastructure.GetEnvAsDuration("TCP_DRAIN_TIMEOUT", 10*time.Second),
+		maxRequestTimeout:   infrastructure.GetEnvAsDuration("TCP_MAX_REQUEST_TIMEOUT", 30*time.Second),
+		frameHeaderTimeout:  infrastructure.GetEnvAsDuration("TCP_FRAME_HEADER_TIMEOUT", 10*time.Second),
+		minReadRate:         infrastructure.GetEnvAsInt("TCP_MIN_READ_RATE", 16*1024),
 	}
+	h.registerMethods()
 	
 	return h
 }
 
+// EnableTLS makes Start serve TLS. When config verifies client certificates,
+// a certificate that maps to a service identity authenticates privileged
+// methods; with requireClientCert an API key alone no longer does.
+func (h *TCPHandler) EnableTLS(config *tls.Config, requireClientCert bool, identities CertificateIdentities) {
+	h.tlsConfig = config
+	h.requireClientCert = requireClientCert
+	h.certIdentities = identities
+}
+
 // GetMetrics returns current metrics - lock-free implementation
 func (h *TCPHandler) GetMetrics() map[string]interface{} {
 	uptime := time.Since(h.metrics.startTime)
@@ -110,7 +198,22 @@ func (h *TCPHandler) GetMetrics() map[string]interface{} {
 		"activeRequests":     atomic.LoadInt32(&h.activeRequests),
 		"uptimeSeconds":      uptime.Seconds(),
 		"requestsPerSecond":  float64(totalReqs) / uptime.Seconds(),
-		"queueDepth":         len(h.messageQueue),
+		"queueDepth":         len(h.messageQueue) + len(h.priorityQueue),
+		"pushSubscriptions":  h.subscriptions.count(),
+		"boundConnections":   h.identities.count(),
+		"openConnections":    atomic.LoadInt64(&h.metrics.openConnections),
+		"reapedConnections":  atomic.LoadUint64(&h.metrics.reapedConnections),
+		"evictedConnections": atomic.LoadUint64(&h.metrics.evictedConnections),
+		"deniedConnections":  atomic.LoadUint64(&h.metrics.deniedConnections),
+		"methodCalls":        atomic.LoadUint64(&h.metrics.methodCalls),
+		"methodErrors":       atomic.LoadUint64(&h.metrics.methodErrors),
+		"handlerPanics":      atomic.LoadUint64(&h.metrics.handlerPanics),
+		"expiredRequests":    atomic.LoadUint64(&h.metrics.expiredRequests),
+		"slowClients":        atomic.LoadUint64(&h.metrics.slowClients),
+		"replayedRequests":   atomic.LoadUint64(&h.metrics.replayedRequests),
+		"methods":            h.methodMetrics.snapshot(),
+		"circuitBreakers":    h.circuitBreakerStats(),
+		"caches":             h.cacheStats(),
 	}
 }
 
@@ -121,6 +224,9 @@ func (h *TCPHandler) Start(address string) error {
 	if err != nil {
 		return fmt.Errorf("failed to start TCP listener: %v", err)
 	}
+	if h.tlsConfig != nil {
+		h.listener = tls.NewListener(h.listener, h.tlsConfig)
+	}
 	
 	log.Printf("TCP server listening on %s", address)
 	
@@ -135,6 +241,10 @@ func (h *TCPHandler) Start(address string) error {
 		go h.startWorker()
 	}
 	
+	// Forward user events to subscribed connections
+	h.wg.Add(1)
+	go h.forwardEvents(h.events.Subscribe(eventBufferSize))
+	
 	// Start multiple acceptors for better performance under high connection load
 	acceptorCount := runtime.GOMAXPROCS(0)
 	for i := 0; i < acceptorCount; i++ {
@@ -145,9 +255,11 @@ func (h *TCPHandler) Start(address string) error {
 	return nil
 }
 
-// Stop stops the TCP server
+// Stop stops the TCP server. It stops accepting connections and frames, sends
+// every client a GOAWAY frame, lets the queued requests finish for up to
+// drainTimeout and then closes the connections.
 func (h *TCPHandler) Stop() error {
-	close(h.done)
+	close(h.draining)
 	
 	if h.listener != nil {
 		if err := h.listener.Close(); err != nil {
@@ -155,8 +267,13 @@ func (h *TCPHandler) Stop() error {
 		}
 	}
 	
+	h.drain()
+	close(h.done)
+	h.connections.closeAll()
+	
 	h.wg.Wait()
 	close(h.messageQueue)
+	close(h.priorityQueue)
 	log.Println("TCP server stopped")
 	return nil
 }
@@ -173,6 +290,9 @@ func (h *TCPHandler) acceptConnections() {
 			conn, err := h.listener.Accept()
 			if err != nil {
 				<-h.connectionSemaphore // Release on error
+				if h.isDraining() {
+					return
+				}
 				select {
 				case <-h.done:
 					return
@@ -197,161 +317,327 @@ func (h *TCPHandler) acceptConnections() {
 func (h *TCPHandler) handleConnection(conn net.Conn) {
 	defer conn.Close()
 	
+	ip := remoteIP(conn.RemoteAddr())
+	if !h.ipLimiter.acquire(ip) {
+		h.denyConnection(conn, ip)
+		return
+	}
+	defer h.ipLimiter.release(ip)
+	
+	defer h.subscriptions.removeConnection(conn)
+	defer h.identities.removeConnection(conn)
+	atomic.AddInt64(&h.metrics.openConnections, 1)
+	defer atomic.AddInt64(&h.metrics.openConnections, -1)
+	
 	// TCP_NODELAY disables Nagle's algorithm for better latency
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
+	rawConn := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		rawConn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := rawConn.(*net.TCPConn); ok {
 		tcpConn.SetNoDelay(true)
 	}
+
+	peer, err := h.handshake(conn)
+	if err != nil {
+		log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
 	
-	// Set connection timeout
-	conn.SetDeadline(time.Now().Add(time.Minute * 10))
+	// Clear the handshake deadline, idle connections are reaped by checkIdle
+	conn.SetDeadline(time.Time{})
+	state := &connectionState{lastActivity: time.Now()}
 	
-	// Get buffer from pool
-	buffer := h.bufferPool.Get().([]byte)
-	buffer = buffer[:0] // Reset length while keeping capacity
-	defer h.bufferPool.Put(buffer)
+	// All writes go through the writer, it flushes before the connection closes
+	writer := h.newConnWriter(conn)
+	defer writer.close()
+	h.connections.add(writer)
+	defer h.connections.remove(conn)
 	
-	// Temporary buffer for reading - allocate once
-	readBuffer := make([]byte, 16384) // Increased read buffer for fewer syscalls
+	reader := h.readerPool.Get().(*bufio.Reader)
+	reader.Reset(conn)
+	defer func() {
+		reader.Reset(nil)
+		h.readerPool.Put(reader)
+	}()
 	
 	for {
 		select {
 		case <-h.done:
 			return
 		default:
-			// Update read deadline for each read attempt
-			conn.SetReadDeadline(time.Now().Add(time.Second * 60))
-			
-			n, err := conn.Read(readBuffer)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Error reading from connection: %v", err)
+		}
+		
+		// Wait for the next frame. Timing out here is harmless, nothing of
+		// a frame has been read yet, so wake up in time to send a heartbeat
+		// or reap the connection.
+		conn.SetReadDeadline(h.nextReadDeadline(state))
+		if _, err := reader.Peek(1); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if h.checkIdle(writer, state) {
+					continue
 				}
 				return
 			}
-			
-			if n == 0 {
-				continue
+			// Stop closes the connections it has drained
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error reading from connection: %v", err)
 			}
-			
-			// Append data to buffer
-			buffer = append(buffer, readBuffer[:n]...)
-			
-			// Check buffer size to prevent memory attacks
-			if len(buffer) > maxBufferSize {
-				log.Printf("Buffer size exceeded for client %s", conn.RemoteAddr())
-				return
-			}
-			
-			// Process complete messages
-			var processed int
-			for processed < len(buffer) {
-				msgSize, complete, err := h.checkMessageComplete(buffer[processed:])
-				if err != nil {
-					log.Printf("Error checking message: %v", err)
-					return
-				}
-				
-				if !complete {
-					break
-				}
-				
-				// Copy message data to avoid race conditions when multiple messages 
-				// are processed from the same buffer
-				msgData := make([]byte, msgSize)
-				copy(msgData, buffer[processed:processed+msgSize])
-				processed += msgSize
-				
-				// Apply rate limiting here to avoid queueing unnecessary messages
-				if !h.limiter.Allow() {
-					h.sendError(conn, "Rate limit exceeded", extractRequestID(msgData))
-					continue
-				}
-				
-				// Check if we can handle more requests
-				if atomic.LoadInt32(&h.activeRequests) > maxConcurrentRequests {
-					h.sendError(conn, "Server overloaded", extractRequestID(msgData))
-					continue
-				}
-				
-				// Send message to worker pool
-				select {
-				case h.messageQueue <- Message{
-					conn:      conn,
-					data:      msgData,
-					timestamp: time.Now(),
-				}:
-					// Message queued successfully
-				default:
-					// Queue is full, send error to client
-					h.sendError(conn, "Server busy, try again later", extractRequestID(msgData))
-				}
-			}
-			
-			// Keep unprocessed data in buffer
-			if processed > 0 {
-				if processed < len(buffer) {
-					// Use copy to avoid memory leaks from large buffers
-					copy(buffer, buffer[processed:])
-					buffer = buffer[:len(buffer)-processed]
-				} else {
-					buffer = buffer[:0] // Reset buffer but keep capacity
-				}
+			return
+		}
+		
+		// A frame that has started has to keep arriving, a client trickling
+		// it in holds the connection and its buffer for nothing
+		conn.SetReadDeadline(h.headerDeadline())
+		frame, size, contentLen, err := readFrameHeader(reader)
+		if err == nil {
+			conn.SetReadDeadline(h.contentDeadline(contentLen))
+			err = readFrameContent(reader, frame, contentLen)
+			size += contentLen
+		}
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				h.closeSlowClient(conn)
+			case err != io.EOF && !errors.Is(err, net.ErrClosed):
+				log.Printf("Error reading frame from %s: %v", conn.RemoteAddr(), err)
 			}
+			return
+		}
+		state.lastActivity = time.Now()
+		
+		if !h.handleFrame(writer, state, peer, frame, size) {
+			return
 		}
 	}
 }
 
+// handleFrame answers heartbeats and hellos and queues every other frame for
+// the workers. It reports false when the connection has to be closed.
+func (h *TCPHandler) handleFrame(writer *connWriter, state *connectionState, peer *Caller, frame *frame, size int) bool {
+	if frame.version == protocolVersion3 && !state.speaksV3 {
+		state.speaksV3 = true
+		h.connections.markV3(writer.conn)
+	}
+	
+	// Heartbeats are answered right here, they never reach the workers
+	if frame.flags&flagsHeartbeat != 0 {
+		h.handleHeartbeat(writer, state, frame)
+		return true
+	}
+	
+	// Once Stop has begun only the queued requests are finished
+	if h.isDraining() {
+		h.sendError(writer, entities.ErrUnavailable.Errorf("Server shutting down"), frame)
+		return true
+	}
+	
+	// The hello is answered here as well, it sets up the rest of the connection
+	if frame.method == "hello" {
+		return h.handleHello(writer, state, frame, peer)
+	}
+	if h.helloRequired && !state.handshake.done {
+		h.sendError(writer, entities.ErrUnauthorized.Errorf("hello required before other methods"), frame)
+		return false
+	}
+	if err := state.handshake.allows(frame, size); err != nil {
+		h.sendError(writer, err, frame)
+		return true
+	}
+	if frame.apiKey == "" {
+		frame.apiKey = state.handshake.apiKey
+	}
+	frame.responseLimit = state.handshake.maxFrameSize
+	
+	// An encrypted connection authenticates its frames by the key they were
+	// encrypted with, one that fails to decrypt is closed
+	if state.handshake.cipher != nil {
+		if err := state.handshake.cipher.open(frame); err != nil {
+			log.Printf("Closing connection from %s: %v", writer.conn.RemoteAddr(), err)
+			h.sendError(writer, err, frame)
+			return false
+		}
+		frame.cipher = state.handshake.cipher
+		peer = state.handshake.cipher.caller
+	}
+	
+	received := time.Now()
+	deadline, err := h.requestDeadline(frame, received)
+	if err != nil {
+		h.sendError(writer, err, frame)
+		return true
+	}
+	if frame.traceID, err = requestTraceID(frame); err != nil {
+		h.sendError(writer, err, frame)
+		return true
+	}
+	if frame.idempotencyKey, err = requestIdempotencyKey(frame); err != nil {
+		h.sendError(writer, err, frame)
+		return true
+	}
+	
+	// Apply rate limiting here to avoid queueing unnecessary messages
+	if !h.limiter.Allow() {
+		// The time until the bucket holds a token again is the retry hint
+		var wait time.Duration
+		if reservation := h.limiter.Reserve(); reservation.OK() {
+			wait = reservation.Delay()
+			reservation.Cancel()
+		}
+		h.sendError(writer, entities.NewRateLimitError("Rate limit exceeded", entities.RateLimit{Limit: h.limiter.Burst(), ResetAfter: wait}), frame)
+		return true
+	}
+	
+	// Check if we can handle more requests
+	if atomic.LoadInt32(&h.activeRequests) > maxConcurrentRequests {
+		h.sendError(writer, entities.ErrUnavailable.Errorf("Server overloaded"), frame)
+		return true
+	}
+	
+	// Low priority messages give way once the queue is half full
+	queue := h.messageQueue
+	switch frame.flags.priority() {
+	case priorityHigh:
+		queue = h.priorityQueue
+	case priorityLow:
+		if len(h.messageQueue) > messageQueueSize/2 {
+			h.sendError(writer, entities.ErrUnavailable.Errorf("Server busy, try again later"), frame)
+			return true
+		}
+	}
+	
+	// Send message to worker pool
+	select {
+	case queue <- Message{
+		writer:    writer,
+		frame:     frame,
+		timestamp: received,
+		deadline:  deadline,
+		peer:      peer,
+	}:
+		// Message queued successfully
+	default:
+		// Queue is full, send error to client
+		h.sendError(writer, entities.ErrUnavailable.Errorf("Server busy, try again later"), frame)
+	}
+	
+	return true
+}
+
+// handshake completes the TLS handshake of conn and resolves the service
+// identity of a verified client certificate. Plain connections have no peer.
+func (h *TCPHandler) handshake(conn net.Conn) (*Caller, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	peer, ok := h.certIdentities.Resolve(state.VerifiedChains[0][0])
+	if !ok {
+		log.Printf("Client certificate from %s does not map to a service identity", conn.RemoteAddr())
+		return nil, nil
+	}
+	return peer, nil
+}
+
 // startWorker runs a worker goroutine that processes messages from the queue
 func (h *TCPHandler) startWorker() {
 	defer h.wg.Done()
 	
 	for {
+		// Batch sub-requests and high priority messages are taken first
+		// whenever any are waiting
+		select {
+		case task := <-h.batchQueue:
+			task.tryRun()
+			continue
+		case msg := <-h.priorityQueue:
+			h.processMessage(msg)
+			continue
+		default:
+		}
+
 		select {
 		case <-h.done:
 			return
+		case task := <-h.batchQueue:
+			task.tryRun()
+		case msg := <-h.priorityQueue:
+			h.processMessage(msg)
 		case msg, ok := <-h.messageQueue:
 			if !ok {
 				return // Channel closed
 			}
-			
-			// Track active requests
-			atomic.AddInt32(&h.activeRequests, 1)
-			atomic.AddUint64(&h.metrics.totalRequests, 1)
-			
-			startTime := time.Now()
-			
-			// Process the message with a timeout context
-			ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
-			requestID, response, err := h.handleBinaryMessage(ctx, msg.data)
-			cancel()
-			
-			if err != nil {
-				h.sendError(msg.conn, err.Error(), requestID)
-				atomic.AddUint64(&h.metrics.failedRequests, 1)
-			} else {
-				// Update metrics for successful request - lock-free
-				atomic.AddUint64(&h.metrics.successfulRequests, 1)
-				
-				// Update latency metrics with exponential moving average
-				latency := time.Since(startTime).Nanoseconds()
-				h.updateAvgLatency(latency)
-				
-				// Set write deadline
-				msg.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
-				
-				// Send response
-				_, err = msg.conn.Write(response)
-				if err != nil {
-					log.Printf("Error writing response: %v", err)
-				}
-			}
-			
-			// Decrement active requests
-			atomic.AddInt32(&h.activeRequests, -1)
+			h.processMessage(msg)
 		}
 	}
 }
 
+// processMessage handles a queued message and writes its response
+func (h *TCPHandler) processMessage(msg Message) {
+	// The client stopped waiting for a request that expired in the queue,
+	// running it would only take a worker from requests still wanted
+	if !msg.deadline.IsZero() && !time.Now().Before(msg.deadline) {
+		atomic.AddUint64(&h.metrics.expiredRequests, 1)
+		return
+	}
+	
+	// Captured frames are refused before they can log anyone in again
+	if err := h.checkReplay(msg.writer, msg.frame); err != nil {
+		h.sendError(msg.writer, err, msg.frame)
+		return
+	}
+	
+	// Track active requests
+	atomic.AddInt32(&h.activeRequests, 1)
+	atomic.AddUint64(&h.metrics.totalRequests, 1)
+	
+	startTime := time.Now()
+	
+	// Process the message with a timeout context
+	deadline := msg.deadline
+	if deadline.IsZero() {
+		deadline = startTime.Add(handlerTimeout)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	ctx = withPeer(ctx, msg.peer)
+	ctx = withConnection(ctx, msg.writer, msg.frame.version, msg.frame.cipher)
+	ctx = withCall(ctx, msg.frame.method, msg.frame.requestID, msg.frame.traceID)
+	callFromContext(ctx).idempotencyKey = msg.frame.idempotencyKey
+	response, err := h.handleBinaryMessage(ctx, msg.frame)
+	cancel()
+	
+	if err != nil {
+		h.sendError(msg.writer, err, msg.frame)
+		atomic.AddUint64(&h.metrics.failedRequests, 1)
+	} else {
+		// Update metrics for successful request - lock-free
+		atomic.AddUint64(&h.metrics.successfulRequests, 1)
+		
+		// Update latency metrics with exponential moving average
+		latency := time.Since(startTime).Nanoseconds()
+		h.updateAvgLatency(latency)
+		
+		// Send response, the connection's writer logs failures
+		msg.writer.write(response)
+	}
+	
+	// Decrement active requests
+	atomic.AddInt32(&h.activeRequests, -1)
+}
+
 // updateAvgLatency updates the average latency using a lock-free exponential moving average
 func (h *TCPHandler) updateAvgLatency(newLatency int64) {
 	const alpha = 0.05 // Smoothing factor
@@ -366,179 +652,130 @@ func (h *TCPHandler) updateAvgLatency(newLatency int64) {
 	}
 }
 
-// extractRequestID gets the request ID from a message
-func extractRequestID(data []byte) []byte {
-	if len(data) < headerSize+versionSize+uuidSize {
-		return nil
-	}
-	offset := headerSize + versionSize
-	return data[offset : offset+uuidSize]
+// sendError sends the error response for err, with its code and, for a
+// validation error, the failed rules
+func (h *TCPHandler) sendError(w *connWriter, err error, request *frame) {
+	response, _ := h.buildResponse(request, errorBody(err))
+
+	w.write(response)
 }
 
-// checkMessageComplete checks if a complete message is available in the buffer
-func (h *TCPHandler) checkMessageComplete(buffer []byte) (int, bool, error) {
-	// Check minimum header size
-	if len(buffer) < headerSize+versionSize+uuidSize+methodLenSize {
-		return 0, false, nil
-	}
-	
-	// Verify magic bytes
-	if buffer[0] != magicByte1 || buffer[1] != magicByte2 {
-		return 0, false, fmt.Errorf("invalid magic bytes")
-	}
-	
-	// Verify protocol version
-	if buffer[2] != protocolVersion {
-		return 0, false, fmt.Errorf("unsupported protocol version: %d", buffer[2])
-	}
-	
-	// Method length is at offset headerSize+versionSize+uuidSize
-	offset := headerSize + versionSize + uuidSize
-	methodLen := int(buffer[offset])
-	offset += methodLenSize
-	
-	// Check if we have enough bytes for the method name
-	if len(buffer) < offset+methodLen {
-		return 0, false, nil
-	}
-	
-	// Move offset past method name
-	offset += methodLen
-	
-	// Check if we have enough bytes for content length
-	if len(buffer) < offset+contentLenSize {
-		return 0, false, nil
-	}
-	
-	// Extract content length
-	contentLen := binary.LittleEndian.Uint32(buffer[offset:offset+contentLenSize])
-	offset += contentLenSize
-	
-	// Calculate total message size
-	totalSize := offset + int(contentLen)
-	
-	// Check if the buffer contains the complete message
-	if len(buffer) < totalSize {
-		return 0, false, nil
+// createBinaryResponse frames jsonData as the answer to request. Version 3
+// requests get a version 3 frame in the request's encoding, compressed when
+// the client accepts it and the payload is large enough, and split into
+// chunks beyond the client's frame limit; everything else the version 1
+// layout. A nil request gets an empty request ID. Should encoding
+// fail the response stays JSON, which the flags tell the client. The frame
+// is built in a buffer rented from bufferPool.
+func (h *TCPHandler) createBinaryResponse(request *frame, jsonData []byte) []byte {
+	version := byte(protocolVersion)
+	requestID := make([]byte, uuidSize)
+	if request != nil {
+		requestID = request.requestID
+		if request.version == protocolVersion3 {
+			version = protocolVersion3
+		}
 	}
-	
-	return totalSize, true, nil
-}
 
-func (h *TCPHandler) sendError(conn net.Conn, errMsg string, requestID []byte) {
-	// Check if the requestID is valid, if not use an empty one
-	if requestID == nil {
-		requestID = make([]byte, uuidSize)
+	var flags frameFlags
+	if request != nil && request.flags.encoding() != encodingJSON {
+		if codec, ok := h.codecs[request.flags.encoding()]; ok {
+			if encoded, err := codec.encodeResponse(request.method, jsonData); err != nil {
+				log.Printf("Error encoding %s response: %v", request.method, err)
+			} else {
+				jsonData = encoded
+				flags |= frameFlags(request.flags.encoding() << 2)
+			}
+		}
 	}
-	
-	errorData := map[string]string{
-		"status":  "error",
-		"message": errMsg,
+	if compression := responseCompression(request); compression != compressionNone && len(jsonData) >= compressionMinSize {
+		if compressed, err := compressPayload(compression, jsonData); err != nil {
+			log.Printf("Error compressing response: %v", err)
+		} else {
+			jsonData = compressed
+			flags |= frameFlags(compression)
+		}
 	}
-	
-	jsonData, _ := json.Marshal(errorData)
 
-	response := h.createBinaryResponse(requestID, jsonData)
-	
-	// Set write deadline
-	conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
-	
-	// Send error response
-	_, err := conn.Write(response)
-	if err != nil {
-		log.Printf("Error writing error response: %v", err)
+	if version == protocolVersion3 {
+		limit := maxBufferSize
+		if request.responseLimit > 0 {
+			limit = request.responseLimit
+		}
+		var extensions []byte
+		if request.cipher != nil {
+			jsonData, extensions = request.cipher.seal(requestID, jsonData)
+		}
+		return appendV3Chunks(rentBuffer(), requestID, flags, extensions, jsonData, limit)
 	}
-}
 
-func (h *TCPHandler) createBinaryResponse(requestID []byte, jsonData []byte) []byte {
-	responseLen := headerSize + versionSize + uuidSize + contentLenSize + len(jsonData)
-	response := make([]byte, responseLen)
+	response := rentBuffer()
 
-	// Add magic bytes
-	response[0] = magicByte1
-	response[1] = magicByte2
-	
-	// Add protocol version
-	response[2] = protocolVersion
+	// Add magic bytes and protocol version
+	response = append(response, magicByte1, magicByte2, version)
 
 	// Add request ID
-	copy(response[headerSize+versionSize:], requestID)
+	response = append(response, requestID...)
 
 	// Add content length
-	binary.LittleEndian.PutUint32(response[headerSize+versionSize+uuidSize:], uint32(len(jsonData)))
+	response = binary.LittleEndian.AppendUint32(response, uint32(len(jsonData)))
 
 	// Add content
-	copy(response[headerSize+versionSize+uuidSize+contentLenSize:], jsonData)
+	response = append(response, jsonData...)
 
 	return response
 }
 
-// handleBinaryMessage processes a binary message
-func (h *TCPHandler) handleBinaryMessage(ctx context.Context, data []byte) ([]byte, []byte, error) {
-	// Check minimum message size
-	minSize := headerSize + versionSize + uuidSize + methodLenSize
-	if len(data) < minSize {
-		return nil, nil, fmt.Errorf("message too short: got %d bytes, expected at least %d bytes", len(data), minSize)
+// handleBinaryMessage processes a decoded request and returns the framed response
+func (h *TCPHandler) handleBinaryMessage(ctx context.Context, request *frame) ([]byte, error) {
+	if err := request.validate(); err != nil {
+		return nil, err
 	}
-	
-	// Extract request ID
-	offset := headerSize + versionSize
-	requestID := data[offset : offset+uuidSize]
-	offset += uuidSize
-
-	// Extract method length
-	methodLen := int(data[offset])
-	offset += methodLenSize
-
-	// Extract method name
-	method := string(data[offset : offset+methodLen])
-	offset += methodLen
-
-	// Extract content length
-	contentLen := binary.LittleEndian.Uint32(data[offset : offset+contentLenSize])
-	offset += contentLenSize
-
-	// Extract content
-	content := data[offset : offset+int(contentLen)]
-
-	var result interface{}
-	var err error
-
-	// Handle methods
-	switch method {
-	case "register":
-		result, err = h.handleRegister(ctx, content)
-	case "verify":
-		result, err = h.handleEmailOTP(ctx, content)		
-	case "login":
-		result, err = h.handleLogin(ctx, content)
-	case "profile":
-		result, err = h.handleProfile(ctx, content)	
-	case "ping":
-		// Fast path for ping - no need for map allocation
-		result = struct {
-			Status string `json:"status"`
-			Pong   int64  `json:"pong"`
-		}{
-			Status: "success",
-			Pong:   time.Now().UnixNano() / int64(time.Millisecond),
-		}
-	default:
-		return requestID, nil, fmt.Errorf("unknown method: %s", method)
+	method := request.method
+	content, err := decompressPayload(request.flags.compression(), request.content)
+	if err != nil {
+		return nil, err
+	}
+	codec, ok := h.codecs[request.flags.encoding()]
+	if !ok {
+		return nil, entities.ErrInvalidInput.Errorf("unsupported encoding: %d", request.flags.encoding())
+	}
+	if content, err = codec.decodeRequest(method, content); err != nil {
+		return nil, err
 	}
 
+	result, err := h.dispatch(ctx, method, request.apiKey, content)
 	if err != nil {
-		return requestID, nil, err
+		return nil, err
 	}
 
-	// Marshal response
-	jsonData, err := json.Marshal(result)
+	// Create response with same binary format
+	response, err := h.buildResponse(request, result)
 	if err != nil {
-		return requestID, nil, fmt.Errorf("error marshaling response: %v", err)
+		return nil, fmt.Errorf("error marshaling response: %v", err)
 	}
 
-	// Create response with same binary format
-	response := h.createBinaryResponse(requestID, jsonData)
+	return response, nil
+}
+
+// dispatch runs the registered handler of method on content, through the
+// interceptor pipeline. Interceptors see the method without its version
+// prefix, so every version is authorized alike.
+func (h *TCPHandler) dispatch(ctx context.Context, method, apiKey string, content []byte) (interface{}, error) {
+	name, version, err := splitMethod(method)
+	if err != nil {
+		return nil, err
+	}
+	handler, version, ok := h.lookupMethod(name, version)
+	if !ok {
+		return nil, entities.ErrNotFound.Errorf("unknown method: %s", method)
+	}
 
-	return requestID, response, nil
+	// Sub-requests of a batch keep the request ID, trace ID and idempotency
+	// key of the batch frame
+	parent := callFromContext(ctx)
+	ctx = withCall(ctx, name, parent.requestID[:], parent.traceID[:])
+	callFromContext(ctx).idempotencyKey = parent.idempotencyKey
+	callFromContext(ctx).version = version
+	return h.pipeline(handler)(withAPIKey(ctx, apiKey), content)
 }
@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+	"user-service-new/internal/domain/entities"
+)
+
+// traceIDSize is the size of a trace ID, the same as in W3C trace context
+const traceIDSize = 16
+
+var zeroTraceID [traceIDSize]byte
+
+// requestTraceID returns the trace ID the client sent with request, or a new
+// one so that the request can still be followed through the logs
+func requestTraceID(request *frame) ([]byte, error) {
+	value, ok := request.extensions[extensionTraceID]
+	if !ok {
+		id := uuid.New()
+		return id[:], nil
+	}
+	if len(value) != traceIDSize {
+		return nil, entities.ErrInvalidInput.Errorf("invalid trace ID extension: %d bytes", len(value))
+	}
+	if string(value) == string(zeroTraceID[:]) {
+		return nil, entities.ErrInvalidInput.Errorf("trace ID must not be zero")
+	}
+	return value, nil
+}
+
+// TraceIDFromContext returns the trace ID of the request a handler or
+// interceptor is serving as lowercase hex, or "" outside a request
+func TraceIDFromContext(ctx context.Context) string {
+	c := callFromContext(ctx)
+	if c.traceID == zeroTraceID {
+		return ""
+	}
+	return hex.EncodeToString(c.traceID[:])
+}
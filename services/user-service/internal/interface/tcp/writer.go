@@ -0,0 +1,133 @@
+package tcp
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// writeQueueSize is how many frames may wait for a client to read them
+	// before it is evicted as a slow consumer
+	writeQueueSize = 256
+	// writeTimeout bounds writing a single frame
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	errSlowConsumer = errors.New("client is not reading its responses")
+	errWriterClosed = errors.New("connection closed")
+)
+
+// connWriter is the only goroutine writing to a connection. Workers, pushes
+// and the reader queue frames with write, which never blocks: a client whose
+// queue fills up is evicted instead of stalling the worker that answers it.
+type connWriter struct {
+	conn      net.Conn
+	queue     chan []byte
+	closing   chan struct{} // Closed by close, the queued frames are flushed
+	done      chan struct{} // Closed when run returns
+	closeOnce sync.Once
+	failed    int32   // Atomic, set once a write failed or the client was evicted
+	evictions *uint64 // Metrics counter of evicted clients
+}
+
+func (h *TCPHandler) newConnWriter(conn net.Conn) *connWriter {
+	w := &connWriter{
+		conn:      conn,
+		queue:     make(chan []byte, writeQueueSize),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+		evictions: &h.metrics.evictedConnections,
+	}
+	go w.run()
+	return w
+}
+
+// write queues frame for the client. The frame belongs to the writer from
+// then on, which returns it to bufferPool once it is written.
+func (w *connWriter) write(frame []byte) error {
+	if atomic.LoadInt32(&w.failed) == 1 {
+		returnBuffer(frame)
+		return errWriterClosed
+	}
+	select {
+	case <-w.closing:
+		returnBuffer(frame)
+		return errWriterClosed
+	default:
+	}
+
+	select {
+	case w.queue <- frame:
+		return nil
+	default:
+		returnBuffer(frame)
+		w.evict()
+		return errSlowConsumer
+	}
+}
+
+// evict closes the connection of a client that stopped reading, its reader
+// then tears the connection down
+func (w *connWriter) evict() {
+	if atomic.CompareAndSwapInt32(&w.failed, 0, 1) {
+		atomic.AddUint64(w.evictions, 1)
+		log.Printf("Closing connection from %s, %d frames are waiting for it to read", w.conn.RemoteAddr(), len(w.queue))
+		w.conn.Close()
+	}
+}
+
+// close stops taking frames and returns once the queued ones are written
+func (w *connWriter) close() {
+	w.closeOnce.Do(func() { close(w.closing) })
+	<-w.done
+}
+
+func (w *connWriter) run() {
+	defer close(w.done)
+
+	for {
+		select {
+		case frame := <-w.queue:
+			if !w.send(frame) {
+				return
+			}
+		case <-w.closing:
+			for {
+				select {
+				case frame := <-w.queue:
+					if !w.send(frame) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send writes one frame. A failed write closes the connection, what is still
+// queued is dropped.
+func (w *connWriter) send(frame []byte) bool {
+	if atomic.LoadInt32(&w.failed) == 1 {
+		return false
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := w.conn.Write(frame)
+	returnBuffer(frame)
+	if err != nil {
+		atomic.StoreInt32(&w.failed, 1)
+		if !errors.Is(err, net.ErrClosed) {
+			log.Printf("Error writing to %s: %v", w.conn.RemoteAddr(), err)
+		}
+		w.conn.Close()
+		return false
+	}
+	return true
+}